@@ -32,21 +32,21 @@ func main() {
 	fmt.Println("===========================================")
 
 	// 1. Connect WebSockets
-	aliceConn, _, err := websocket.DefaultDialer.Dial(WSURL+"?token="+AliceToken, nil)
+	aliceConn, _, err := dialWS(AliceToken)
 	if err != nil {
 		log.Fatalf("❌ Failed to connect Alice: %v", err)
 	}
 	defer aliceConn.Close()
 	fmt.Println("✅ Alice Connected")
 
-	bobConn, _, err := websocket.DefaultDialer.Dial(WSURL+"?token="+BobToken, nil)
+	bobConn, _, err := dialWS(BobToken)
 	if err != nil {
 		log.Fatalf("❌ Failed to connect Bob: %v", err)
 	}
 	defer bobConn.Close()
 	fmt.Println("✅ Bob Connected")
 
-	charlieConn, _, err := websocket.DefaultDialer.Dial(WSURL+"?token="+CharlieToken, nil)
+	charlieConn, _, err := dialWS(CharlieToken)
 	if err != nil {
 		log.Fatalf("❌ Failed to connect Charlie: %v", err)
 	}
@@ -193,3 +193,9 @@ func fetchAPI(token, path string) ([]byte, error) {
 
 	return io.ReadAll(resp.Body)
 }
+
+// dialWS connects with the access token passed as a Sec-WebSocket-Protocol entry
+func dialWS(token string) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{Subprotocols: []string{"chat.v1.json", "bearer." + token}}
+	return dialer.Dial(WSURL, nil)
+}
@@ -7,16 +7,21 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"chat-app/internal/config"
 	"chat-app/internal/database"
 	"chat-app/internal/handlers"
+	"chat-app/internal/mailer"
 	"chat-app/internal/middleware"
 	"chat-app/internal/models"
 	"chat-app/internal/repository"
 	"chat-app/internal/service"
+	"chat-app/internal/storage"
 	"chat-app/internal/websocket"
 	"chat-app/pkg/jwt"
+	"chat-app/pkg/oidc"
+	"chat-app/pkg/ratelimit"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -28,6 +33,9 @@ func main() {
 		log.Println("No .env file found, using system environment variables")
 	}
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
 
 	// 2. Initialize Database
 	database.InitDB(cfg)
@@ -43,6 +51,12 @@ func main() {
 		&models.MessageReceipt{},
 		&models.Conversation{},
 		&models.RefreshToken{},
+		&models.AccountToken{},
+		&models.RateLimitEntry{},
+		&models.RecoveryCode{},
+		&models.UserIdentity{},
+		&models.Contact{},
+		&models.Draft{},
 	)
 	if err != nil {
 		log.Fatal("Migration failed: ", err)
@@ -57,49 +71,192 @@ func main() {
 	groupRepo := repository.NewGroupRepository(db)
 	receiptRepo := repository.NewMessageReceiptRepository(db)    // [F06]
 	refreshTokenRepo := repository.NewRefreshTokenRepository(db) // [F09]
+	accountTokenRepo := repository.NewAccountTokenRepository(db)
+	recoveryCodeRepo := repository.NewRecoveryCodeRepository(db)
+	identityRepo := repository.NewUserIdentityRepository(db)
+	contactRepo := repository.NewContactRepository(db)
+	draftRepo := repository.NewDraftRepository(db)
 
 	// WebSocket Hub
 	// We create this early because MessageService needs it
-	hub := websocket.NewHub(userRepo, convRepo)
+	hub := websocket.NewHub(userRepo, contactRepo)
+	hub.IdleTimeout = cfg.Presence.IdleTimeout
+	hub.OfflineGrace = cfg.Presence.OfflineGrace
+	hub.PresenceBatchWindow = cfg.Presence.BatchWindow
 	go hub.Run()
 
 	// Services
+	jwtKeys, err := jwt.LoadKeySet(jwt.KeySetConfig{
+		Algorithm:        cfg.JWT.Algorithm,
+		Secret:           cfg.JWT.Secret,
+		PrivateKeyFile:   cfg.JWT.PrivateKeyFile,
+		KeyID:            cfg.JWT.KeyID,
+		PreviousKeyFiles: cfg.JWT.PreviousKeyFiles,
+	})
+	if err != nil {
+		log.Fatal("Failed to load JWT keys: ", err)
+	}
 	jwtService := jwt.NewService(jwt.Config{
-		Secret:     cfg.JWT.Secret,
 		Expiration: cfg.JWT.Expiration,
+		Keys:       jwtKeys,
+		Issuer:     cfg.JWT.Issuer,
+		Audience:   cfg.JWT.Audience,
 	})
-	authService := service.NewAuthService(userRepo, refreshTokenRepo, jwtService)
-	msgService := service.NewMessageService(msgRepo, convRepo, groupRepo, receiptRepo, userRepo, hub) // [F06][F07]
+	// Rate limit state is kept per instance unless shared through the database
+	var rateLimitStore ratelimit.Store
+	if cfg.RateLimit.Store == "database" {
+		rateLimitStore = repository.NewRateLimitStore(db)
+	} else {
+		rateLimitStore = ratelimit.NewMemoryStore()
+	}
+	twoFactorService := service.NewTwoFactorService(userRepo, recoveryCodeRepo, cfg.JWT.TOTPIssuer)
+	authService := service.NewAuthService(userRepo, refreshTokenRepo, jwtService, hub,
+		service.WithLoginGuard(service.NewLoginGuard(rateLimitStore)),
+		service.WithTwoFactor(twoFactorService),
+		service.WithIdentities(identityRepo))
+	var messageLimits service.MessageLimits
+	if n := cfg.RateLimit.MessagesPerMinute; n > 0 {
+		messageLimits.PerUser = ratelimit.PerMinuteBurst(n, cfg.RateLimit.MessageBurst)
+	}
+	if n := cfg.RateLimit.ConversationMessagesPerMinute; n > 0 {
+		messageLimits.PerConversation = ratelimit.PerMinute(n)
+	}
+	messageLimits.TypingInterval = cfg.RateLimit.TypingInterval
+	msgService := service.NewMessageService(msgRepo, convRepo, groupRepo, receiptRepo, userRepo, hub, // [F06][F07]
+		service.WithMessageLimits(rateLimitStore, messageLimits),
+		service.WithContacts(contactRepo))
 
 	groupService := service.NewGroupService(groupRepo)
+	avatarStore, err := storage.NewDiskStore(cfg.Upload.AvatarDir, "/avatars")
+	if err != nil {
+		log.Fatal("Failed to create avatar directory: ", err)
+	}
+	profileService := service.NewProfileService(userRepo, contactRepo, avatarStore, hub)
+	contactService := service.NewContactService(contactRepo, userRepo)
+	draftService := service.NewDraftService(draftRepo, groupRepo)
+	wsTicketService := service.NewWSTicketService(service.DefaultWSTicketTTL)
+
+	var mail mailer.Mailer
+	if cfg.Mail.SMTPHost != "" {
+		mail = mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		})
+	} else {
+		log.Println("SMTP_HOST not set, emails will be logged instead of sent")
+		mail = mailer.NewLogMailer()
+	}
+	accountService := service.NewAccountService(userRepo, accountTokenRepo, mail, authService, service.AccountConfig{
+		BaseURL: cfg.Mail.AppBaseURL,
+	})
+
+	// Single sign-on, when an OpenID provider is configured
+	var oidcService service.OIDCService
+	if cfg.OIDC.IssuerURL != "" {
+		scopes := cfg.OIDC.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{"email", "profile"}
+		}
+		discoverCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oidc.Discover(discoverCtx, oidc.Config{
+			IssuerURL:    cfg.OIDC.IssuerURL,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       scopes,
+		}, nil)
+		cancel()
+		if err != nil {
+			log.Fatal("Failed to discover OIDC provider: ", err)
+		}
+		oidcService = service.NewOIDCService(provider, authService, service.DefaultOIDCLoginTTL)
+	}
 
 	// Handlers
 	authHandler := handlers.NewAuthHandler(authService)
-	wsHandler := handlers.NewWSHandler(hub, authService)
+	authHandler.Accounts = accountService
+	authHandler.TwoFactor = twoFactorService
+	authHandler.OIDC = oidcService
+	authHandler.OIDCReturnURL = cfg.OIDC.ReturnURL
+	wsHandler := handlers.NewWSHandler(hub, authService, wsTicketService)
 	groupHandler := handlers.NewGroupHandler(groupService)
+	profileHandler := handlers.NewProfileHandler(profileService)
+	profileHandler.MaxAvatarBytes = int64(cfg.Upload.AvatarMaxBytes)
+	contactHandler := handlers.NewContactHandler(contactService)
 	chatHandler := handlers.NewChatHandler(convRepo, msgRepo, userRepo, groupRepo, msgService)
+	draftHandler := handlers.NewDraftHandler(draftService, hub)
 
 	// INJECT MessageService into Hub/Client factory if needed?
 	// Actually, the new handlers.WSHandler logic just passes the hub.
 	// But the Client needs the msgService.
 	// Clients are created in wsHandler.ServeWS. We need to pass msgService to wsHandler.
 	wsHandler.MsgService = msgService
+	wsHandler.Drafts = draftService
 
 	// 5. Server Setup
 	// Using gin.New() for explicit middleware control as per specs/03_Technical_Specification.md
 	r := gin.New()
+	// Client IPs (rate limits, login backoff, sessions) only come from
+	// X-Forwarded-For when the request arrives through a trusted proxy
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatal("Invalid trusted proxies: ", err)
+	}
 	r.Use(gin.Recovery())                // Panic recovery
 	r.Use(middleware.LoggerMiddleware()) // Custom request logging [F00]
 	r.Use(middleware.CORSMiddleware())   // CORS headers [F00]
 
+	// Rate limit /auth/* per client IP
+	var authRateLimit gin.HandlerFunc = func(c *gin.Context) { c.Next() }
+	if cfg.RateLimit.AuthPerMinute > 0 {
+		authLimiter := ratelimit.NewLimiter(rateLimitStore, "auth", ratelimit.PerMinute(cfg.RateLimit.AuthPerMinute))
+		authRateLimit = middleware.RateLimit(authLimiter, middleware.ClientIPKey)
+	}
+
 	// Routes
 	// Public routes (no auth required)
 	authRoutes := r.Group("/auth")
+	authRoutes.Use(authRateLimit)
 	{
 		authRoutes.POST("/register", authHandler.Register)
 		authRoutes.POST("/login", authHandler.Login)
 		authRoutes.POST("/refresh", authHandler.Refresh)
 		authRoutes.POST("/logout", authHandler.Logout)
+		authRoutes.POST("/logout-all", middleware.AuthMiddleware(jwtService), authHandler.LogoutAll)
+		authRoutes.POST("/verify", authHandler.VerifyEmail)
+		authRoutes.POST("/verify/resend", middleware.AuthMiddleware(jwtService), authHandler.ResendVerification)
+		authRoutes.POST("/forgot-password", authHandler.ForgotPassword)
+		authRoutes.POST("/reset-password", authHandler.ResetPassword)
+		authRoutes.POST("/change-password", middleware.AuthMiddleware(jwtService), authHandler.ChangePassword)
+	}
+
+	// Two-factor authentication
+	twoFactorRoutes := authRoutes.Group("/2fa")
+	{
+		twoFactorRoutes.POST("/login", authHandler.LoginTwoFactor)
+		twoFactorRoutes.POST("/setup", middleware.AuthMiddleware(jwtService), authHandler.SetupTwoFactor)
+		twoFactorRoutes.POST("/verify", middleware.AuthMiddleware(jwtService), authHandler.VerifyTwoFactor)
+		twoFactorRoutes.POST("/disable", middleware.AuthMiddleware(jwtService), authHandler.DisableTwoFactor)
+	}
+
+	// Single sign-on
+	if oidcService != nil {
+		oidcRoutes := authRoutes.Group("/oidc")
+		{
+			oidcRoutes.GET("/login", authHandler.OIDCLogin)
+			oidcRoutes.GET("/callback", authHandler.OIDCCallback)
+		}
+	}
+
+	// Session Routes (protected)
+	sessionRoutes := r.Group("/auth/sessions")
+	sessionRoutes.Use(authRateLimit, middleware.AuthMiddleware(jwtService))
+	{
+		sessionRoutes.GET("", authHandler.ListSessions)
+		sessionRoutes.DELETE("/:id", authHandler.RevokeSession)
+		sessionRoutes.POST("/revoke-others", authHandler.RevokeOtherSessions)
 	}
 
 	// Protected routes (require JWT auth)
@@ -108,11 +265,32 @@ func main() {
 	chatRoutes.Use(middleware.AuthMiddleware(jwtService)) // [F00] Auth Middleware
 	{
 		chatRoutes.GET("/conversations", chatHandler.GetConversations)
+		chatRoutes.PATCH("/conversations/:type/:target_id", chatHandler.UpdateConversation)
+		chatRoutes.PUT("/conversations/:type/:target_id/draft", draftHandler.SaveDraft)
 		chatRoutes.GET("/messages", chatHandler.GetMessages)
 		chatRoutes.POST("/messages/:id/read", chatHandler.MarkRead)
 		chatRoutes.GET("/messages/:id/receipts", chatHandler.GetReceipts)
-		chatRoutes.GET("/users", authHandler.SearchUsers)
-		chatRoutes.GET("/users/:id", authHandler.GetUser)
+		chatRoutes.GET("/users", profileHandler.SearchUsers)
+		chatRoutes.GET("/users/:id", profileHandler.GetUser)
+		chatRoutes.GET("/me", profileHandler.GetMe)
+		chatRoutes.GET("/me/unread", chatHandler.GetUnread)
+		chatRoutes.PATCH("/me", profileHandler.UpdateMe)
+		chatRoutes.PATCH("/me/privacy", profileHandler.UpdatePrivacy)
+		chatRoutes.DELETE("/me", authHandler.DeleteAccount)
+		chatRoutes.PUT("/me/avatar", profileHandler.UploadAvatar)
+		chatRoutes.DELETE("/me/avatar", profileHandler.DeleteAvatar)
+	}
+
+	// Contact Routes (protected)
+	contactRoutes := r.Group("/contacts")
+	contactRoutes.Use(middleware.AuthMiddleware(jwtService))
+	{
+		contactRoutes.GET("", contactHandler.ListContacts)
+		contactRoutes.GET("/requests", contactHandler.ListRequests)
+		contactRoutes.POST("/requests", contactHandler.SendRequest)
+		contactRoutes.POST("/requests/:id/accept", contactHandler.AcceptRequest)
+		contactRoutes.POST("/requests/:id/decline", contactHandler.DeclineRequest)
+		contactRoutes.DELETE("/:userId", contactHandler.RemoveContact)
 	}
 
 	// Group Routes (protected)
@@ -123,8 +301,18 @@ func main() {
 		groupRoutes.POST("/:id/members", groupHandler.AddMember)
 	}
 
-	// WebSocket Route
+	// WebSocket Routes
 	r.GET("/ws", wsHandler.ServeWS)
+	r.POST("/ws/ticket", middleware.AuthMiddleware(jwtService), wsHandler.IssueTicket)
+
+	// Uploaded avatars
+	r.Static("/avatars", cfg.Upload.AvatarDir)
+
+	// Public signing keys, for services verifying our access tokens
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(200, jwtService.JWKS())
+	})
 
 	// Health Check
 	r.GET("/health", func(c *gin.Context) {
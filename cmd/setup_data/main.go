@@ -7,7 +7,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -170,12 +169,9 @@ func sendGroupMessage(token, groupID, content string) {
 var conns = make(map[string]*websocket.Conn)
 
 func connectWS(token, name string) (*websocket.Conn, error) {
-	u, _ := url.Parse(wsURL)
-	q := u.Query()
-	q.Set("token", token)
-	u.RawQuery = q.Encode()
-
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	// The access token travels as a subprotocol so it never appears in the URL
+	dialer := websocket.Dialer{Subprotocols: []string{"chat.v1.json", "bearer." + token}}
+	c, _, err := dialer.Dial(wsURL, nil)
 	if err != nil {
 		log.Printf("❌ %s failed to connect to WS: %v", name, err)
 		return nil, err
@@ -0,0 +1,20 @@
+// Command wsschema writes the JSON Schema of the WebSocket protocol.
+//
+//	go run ./cmd/wsschema > specs/ws_protocol.schema.json
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"chat-app/internal/protocol"
+)
+
+func main() {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(protocol.Schema()); err != nil {
+		log.Fatal("Failed to write schema: ", err)
+	}
+}
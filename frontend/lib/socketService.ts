@@ -1,3 +1,4 @@
+import axios from 'axios';
 import { WSIncomingEvent, WSOutgoingEvent } from '@/types';
 
 type EventHandler<T = any> = (payload: T) => void;
@@ -34,10 +35,12 @@ class SocketService {
 
     const apiUrl = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080';
     let wsUrl = apiUrl.replace('http', 'ws');
-    wsUrl = `${wsUrl}/ws?token=${token}`;
+    wsUrl = `${wsUrl}/ws`;
 
+    // The token is offered as a subprotocol so it never appears in URLs or access logs.
+    // The server only ever selects the codec entry.
     console.log('Connecting to WebSocket:', wsUrl);
-    this.socket = new WebSocket(wsUrl);
+    this.socket = new WebSocket(wsUrl, ['chat.v1.json', `bearer.${token}`]);
 
     this.socket.onopen = () => {
       console.log('WebSocket Connected');
@@ -49,6 +52,9 @@ class SocketService {
       try {
         const data = JSON.parse(event.data) as WSIncomingEvent;
         // console.log('WS Received:', data);
+        if (data.type === 'reauth_required') {
+          this.reauthenticate();
+        }
         this.emit(data.type, data.payload);
       } catch (err) {
         console.error('Failed to parse WS message:', err);
@@ -117,6 +123,27 @@ class SocketService {
     }
   }
 
+  // The server closes the socket when the access token it was opened with expires.
+  // Refresh the token and hand it over in-band before that happens.
+  private async reauthenticate() {
+    try {
+      const response = await axios.post(
+        `${process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080'}/auth/refresh`,
+        {},
+        { withCredentials: true }
+      );
+      const { token } = response.data;
+      if (!token) return;
+
+      this.token = token;
+      localStorage.setItem('token', token);
+      window.dispatchEvent(new CustomEvent('auth:token-refreshed', { detail: token }));
+      this.send({ type: 'reauth', payload: { token } });
+    } catch (err) {
+      console.error('WebSocket reauth failed:', err);
+    }
+  }
+
   private attemptReconnect() {
     if (this.reconnectAttempts >= this.maxReconnectAttempts) {
       console.warn('Max reconnect attempts reached');
@@ -64,7 +64,8 @@ export type WSOutgoingEvent =
   | { type: 'typing_start'; payload: { conversation_type: 'DM' | 'GROUP'; target_id: string } }
   | { type: 'typing_stop'; payload: { conversation_type: 'DM' | 'GROUP'; target_id: string } }
   | { type: 'message_delivered'; payload: { message_id: string } }
-  | { type: 'set_active_conversation'; payload: { conversation_type: 'DM' | 'GROUP'; target_id: string | null } };
+  | { type: 'set_active_conversation'; payload: { conversation_type: 'DM' | 'GROUP'; target_id: string | null } }
+  | { type: 'reauth'; payload: { token: string } };
 
 export type WSIncomingEvent =
   | { type: 'new_message'; payload: Message }
@@ -74,4 +75,6 @@ export type WSIncomingEvent =
   | { type: 'receipt_update'; payload: { message_id: string; user_id: string; status: string; updated_at: string } }
   | { type: 'user_online'; payload: { user_id: string } }
   | { type: 'user_offline'; payload: { user_id: string } }
-  | { type: 'conversation_created'; payload: Conversation };
+  | { type: 'conversation_created'; payload: Conversation }
+  | { type: 'reauth_required'; payload: { expires_at: string } }
+  | { type: 'authenticated'; payload: { user_id: string; expires_at: string } };
@@ -1,16 +1,30 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// DefaultJWTSecret is the insecure fallback used when JWT_SECRET is unset.
+// It is only accepted in development.
+const DefaultJWTSecret = "secret"
+
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	Timeout  TimeoutConfig
+	Env       string // APP_ENV: "development" relaxes safety checks
+	Server    ServerConfig
+	Database  DatabaseConfig
+	JWT       JWTConfig
+	Mail      MailConfig
+	OIDC      OIDCConfig
+	Upload    UploadConfig
+	RateLimit RateLimitConfig
+	Presence  PresenceConfig
+	Timeout   TimeoutConfig
 }
 
 type ServerConfig struct {
@@ -18,6 +32,7 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	ShutdownTimeout time.Duration
+	TrustedProxies  []string // IPs and CIDR ranges whose X-Forwarded-For is believed; none by default
 }
 
 type DatabaseConfig struct {
@@ -32,8 +47,56 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	Secret     string
-	Expiration time.Duration
+	Algorithm        string // HS256, RS256 or EdDSA
+	Secret           string // HS256 only
+	PrivateKeyFile   string // RS256/EdDSA signing key (PEM)
+	KeyID            string // Optional kid of the signing key
+	PreviousKeyFiles []string
+	Issuer           string // iss claim of access tokens
+	Audience         string // aud claim of access tokens
+	Expiration       time.Duration
+	TOTPIssuer       string // Account issuer shown by authenticator apps
+}
+
+type MailConfig struct {
+	AppBaseURL   string // Frontend URL used in emailed links
+	SMTPHost     string // Empty logs emails instead of sending them
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+}
+
+// OIDCConfig configures single sign-on; an empty IssuerURL disables it.
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // This server's /auth/oidc/callback, as registered with the provider
+	Scopes       []string // Requested in addition to "openid"
+	ReturnURL    string   // Frontend URL the browser lands on after signing in
+}
+
+type UploadConfig struct {
+	AvatarDir      string // Served at /avatars
+	AvatarMaxBytes int
+}
+
+type RateLimitConfig struct {
+	Store         string // "memory" (per instance) or "database" (shared by all instances)
+	AuthPerMinute int    // Requests per client IP to /auth/*; 0 disables the limit
+
+	// WebSocket flood control; 0 disables a limit
+	MessagesPerMinute             int // Per sender
+	MessageBurst                  int
+	ConversationMessagesPerMinute int           // Per conversation, all senders together
+	TypingInterval                time.Duration // Per user and conversation
+}
+
+type PresenceConfig struct {
+	IdleTimeout  time.Duration // Inactivity after which a connected user is shown away; 0 disables
+	OfflineGrace time.Duration // How long a user may be disconnected before going offline
+	BatchWindow  time.Duration // Presence changes sent to a contact within this window are coalesced
 }
 
 type TimeoutConfig struct {
@@ -44,11 +107,13 @@ type TimeoutConfig struct {
 
 func Load() *Config {
 	return &Config{
+		Env: getEnv("APP_ENV", "production"),
 		Server: ServerConfig{
 			Port:            getEnv("SERVER_PORT", "8080"),
 			ReadTimeout:     getEnvDuration("SERVER_READ_TIMEOUT", 30*time.Second),
 			WriteTimeout:    getEnvDuration("SERVER_WRITE_TIMEOUT", 30*time.Second),
 			ShutdownTimeout: getEnvDuration("SERVER_SHUTDOWN_TIMEOUT", 10*time.Second),
+			TrustedProxies:  getEnvList("SERVER_TRUSTED_PROXIES"),
 		},
 		Database: DatabaseConfig{
 			Host:            getEnv("DB_HOST", "localhost"),
@@ -61,8 +126,49 @@ func Load() *Config {
 			ConnMaxLifetime: getEnvDuration("DB_CONN_MAX_LIFETIME", 1*time.Hour),
 		},
 		JWT: JWTConfig{
-			Secret:     getEnv("JWT_SECRET", "secret"),
-			Expiration: getEnvDuration("JWT_EXPIRATION", 15*time.Minute),
+			Algorithm:        normalizeAlgorithm(getEnv("JWT_ALGORITHM", "HS256")),
+			Secret:           getEnv("JWT_SECRET", DefaultJWTSecret),
+			PrivateKeyFile:   getEnv("JWT_PRIVATE_KEY_FILE", ""),
+			KeyID:            getEnv("JWT_KEY_ID", ""),
+			PreviousKeyFiles: getEnvList("JWT_PREVIOUS_KEY_FILES"),
+			Issuer:           getEnv("JWT_ISSUER", "chat-app"),
+			Audience:         getEnv("JWT_AUDIENCE", "chat-app"),
+			Expiration:       getEnvDuration("JWT_EXPIRATION", 15*time.Minute),
+			TOTPIssuer:       getEnv("TOTP_ISSUER", "Chat App"),
+		},
+		Mail: MailConfig{
+			AppBaseURL:   getEnv("APP_BASE_URL", "http://localhost:3000"),
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     getEnv("SMTP_PORT", "587"),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			From:         getEnv("MAIL_FROM", "Chat App <noreply@localhost>"),
+		},
+		OIDC: OIDCConfig{
+			IssuerURL:    getEnv("OIDC_ISSUER_URL", ""),
+			ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+			ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+			RedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback"),
+			Scopes:       getEnvList("OIDC_SCOPES"),
+			ReturnURL:    getEnv("OIDC_RETURN_URL", getEnv("APP_BASE_URL", "http://localhost:3000")),
+		},
+		Upload: UploadConfig{
+			AvatarDir:      getEnv("AVATAR_DIR", "./uploads/avatars"),
+			AvatarMaxBytes: getEnvInt("AVATAR_MAX_BYTES", 2<<20),
+		},
+		RateLimit: RateLimitConfig{
+			Store:         getEnv("RATE_LIMIT_STORE", "memory"),
+			AuthPerMinute: getEnvInt("RATE_LIMIT_AUTH_PER_MINUTE", 30),
+
+			MessagesPerMinute:             getEnvInt("RATE_LIMIT_MESSAGES_PER_MINUTE", 60),
+			MessageBurst:                  getEnvInt("RATE_LIMIT_MESSAGE_BURST", 10),
+			ConversationMessagesPerMinute: getEnvInt("RATE_LIMIT_CONVERSATION_MESSAGES_PER_MINUTE", 300),
+			TypingInterval:                getEnvDuration("RATE_LIMIT_TYPING_INTERVAL", 2*time.Second),
+		},
+		Presence: PresenceConfig{
+			IdleTimeout:  getEnvDuration("PRESENCE_IDLE_TIMEOUT", 5*time.Minute),
+			OfflineGrace: getEnvDuration("PRESENCE_OFFLINE_GRACE", 10*time.Second),
+			BatchWindow:  getEnvDuration("PRESENCE_BATCH_WINDOW", 500*time.Millisecond),
 		},
 		Timeout: TimeoutConfig{
 			HTTP:          getEnvDuration("TIMEOUT_HTTP", 30*time.Second),
@@ -72,6 +178,41 @@ func Load() *Config {
 	}
 }
 
+// IsDevelopment reports whether the server runs in dev mode (APP_ENV=development).
+func (c *Config) IsDevelopment() bool {
+	return c.Env == "development"
+}
+
+// Validate refuses configurations that are unsafe outside development.
+func (c *Config) Validate() error {
+	if c.RateLimit.Store != "memory" && c.RateLimit.Store != "database" {
+		return errors.New("RATE_LIMIT_STORE must be memory or database")
+	}
+	switch c.JWT.Algorithm {
+	case "HS256", "RS256", "EdDSA":
+	default:
+		return errors.New("JWT_ALGORITHM must be HS256, RS256 or EdDSA")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("SERVER_TRUSTED_PROXIES: %q is not an IP address or CIDR range", proxy)
+		}
+	}
+	if c.OIDC.IssuerURL != "" && c.OIDC.ClientID == "" {
+		return errors.New("OIDC_CLIENT_ID must be set when OIDC_ISSUER_URL is")
+	}
+	if c.IsDevelopment() {
+		return nil
+	}
+	if c.JWT.Algorithm == "HS256" && (c.JWT.Secret == "" || c.JWT.Secret == DefaultJWTSecret) {
+		return errors.New("JWT_SECRET must be set to a strong value (or use JWT_ALGORITHM=RS256/EdDSA); the default is only allowed with APP_ENV=development")
+	}
+	if c.Mail.SMTPHost == "" {
+		return errors.New("SMTP_HOST must be set; logging emails (and their links) is only allowed with APP_ENV=development")
+	}
+	return nil
+}
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -79,6 +220,20 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// normalizeAlgorithm spells a JWT algorithm the way jwt.LoadKeySet expects,
+// whatever its case, defaulting to HS256. Unknown algorithms are returned unchanged.
+func normalizeAlgorithm(alg string) string {
+	if alg == "" {
+		return "HS256"
+	}
+	for _, known := range []string{"HS256", "RS256", "EdDSA"} {
+		if strings.EqualFold(alg, known) {
+			return known
+		}
+	}
+	return alg
+}
+
 func getEnvDuration(key string, fallback time.Duration) time.Duration {
 	if value, exists := os.LookupEnv(key); exists {
 		if d, err := time.ParseDuration(value); err == nil {
@@ -96,3 +251,14 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+// getEnvList reads a comma-separated list, skipping empty entries.
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
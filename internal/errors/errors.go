@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type AppError struct {
 	Code    string `json:"code"`
@@ -14,11 +17,47 @@ func (e *AppError) Error() string {
 
 // Predefined Errors
 var (
-	ErrInvalidCredentials = &AppError{Code: "AUTH_INVALID_CREDENTIALS", Message: "Email or password is incorrect", Status: 401}
-	ErrEmailExists        = &AppError{Code: "AUTH_EMAIL_EXISTS", Message: "Email already registered", Status: 409}
-	ErrUnauthorized       = &AppError{Code: "AUTH_UNAUTHORIZED", Message: "Authentication required", Status: 401}
-	ErrForbidden          = &AppError{Code: "AUTH_FORBIDDEN", Message: "You don't have permission", Status: 403}
-	ErrNotFound           = &AppError{Code: "RESOURCE_NOT_FOUND", Message: "User not found", Status: 404}
-	ErrValidation         = &AppError{Code: "VALIDATION_ERROR", Message: "Invalid input", Status: 400}
-	ErrInternalServer     = &AppError{Code: "INTERNAL_SERVER_ERROR", Message: "An unexpected error occurred", Status: 500}
+	ErrInvalidCredentials  = &AppError{Code: "AUTH_INVALID_CREDENTIALS", Message: "Email or password is incorrect", Status: 401}
+	ErrIncorrectPassword   = &AppError{Code: "AUTH_INCORRECT_PASSWORD", Message: "Current password is incorrect", Status: 403}
+	ErrEmailExists         = &AppError{Code: "AUTH_EMAIL_EXISTS", Message: "Email already registered", Status: 409}
+	ErrUnauthorized        = &AppError{Code: "AUTH_UNAUTHORIZED", Message: "Authentication required", Status: 401}
+	ErrForbidden           = &AppError{Code: "AUTH_FORBIDDEN", Message: "You don't have permission", Status: 403}
+	ErrNotFound            = &AppError{Code: "RESOURCE_NOT_FOUND", Message: "User not found", Status: 404}
+	ErrSessionNotFound     = &AppError{Code: "SESSION_NOT_FOUND", Message: "Session not found", Status: 404}
+	ErrInvalidAccountToken = &AppError{Code: "AUTH_INVALID_LINK", Message: "This link is invalid or has expired", Status: 400}
+	ErrInvalidChallenge    = &AppError{Code: "AUTH_INVALID_CHALLENGE", Message: "Login challenge is invalid or has expired, please sign in again", Status: 401}
+	ErrInvalidTwoFactor    = &AppError{Code: "AUTH_INVALID_2FA_CODE", Message: "Authentication code is incorrect", Status: 401}
+	ErrTwoFactorEnabled    = &AppError{Code: "AUTH_2FA_ALREADY_ENABLED", Message: "Two-factor authentication is already enabled", Status: 409}
+	ErrTwoFactorNotSetUp   = &AppError{Code: "AUTH_2FA_NOT_SET_UP", Message: "Set up two-factor authentication first", Status: 400}
+	ErrSSOEmailUnverified  = &AppError{Code: "AUTH_SSO_EMAIL_UNVERIFIED", Message: "Your identity provider did not confirm your email address", Status: 403}
+	ErrInvalidAvatar       = &AppError{Code: "PROFILE_INVALID_AVATAR", Message: "Avatar must be a PNG, JPEG, GIF or WebP image", Status: 400}
+	ErrAvatarTooLarge      = &AppError{Code: "PROFILE_AVATAR_TOO_LARGE", Message: "Avatar image is too large", Status: 413}
+	ErrContactNotFound     = &AppError{Code: "CONTACT_NOT_FOUND", Message: "Contact or contact request not found", Status: 404}
+	ErrAlreadyContacts     = &AppError{Code: "CONTACT_EXISTS", Message: "You are already contacts or a request is pending", Status: 409}
+	ErrDMNotAllowed        = &AppError{Code: "DM_NOT_ALLOWED", Message: "This user only accepts messages from their contacts", Status: 403}
+	ErrTooManyRequests     = &AppError{Code: "RATE_LIMITED", Message: "Too many requests, please try again later", Status: 429}
+	ErrValidation          = &AppError{Code: "VALIDATION_ERROR", Message: "Invalid input", Status: 400}
+	ErrInternalServer      = &AppError{Code: "INTERNAL_SERVER_ERROR", Message: "An unexpected error occurred", Status: 500}
 )
+
+// RateLimitError is ErrTooManyRequests with the time until the client may retry.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s (retry after %s)", ErrTooManyRequests.Error(), e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrTooManyRequests
+}
+
+// RetryAfterSeconds is the Retry-After header value, rounded up to whole seconds.
+func (e *RateLimitError) RetryAfterSeconds() int {
+	secs := int((e.RetryAfter + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
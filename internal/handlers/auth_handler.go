@@ -2,7 +2,11 @@ package handlers
 
 import (
 	"context"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"chat-app/internal/errors"
@@ -15,6 +19,18 @@ import (
 
 type AuthHandler struct {
 	service service.AuthService
+
+	// Accounts sends verification and password reset emails. Optional: without it
+	// no verification email is sent on registration and the account endpoints fail.
+	Accounts service.AccountService
+
+	// TwoFactor manages TOTP enrollment. Required by the /auth/2fa endpoints.
+	TwoFactor service.TwoFactorService
+
+	// OIDC runs single sign-on through an OpenID provider. Required by the
+	// /auth/oidc endpoints, which redirect the browser back to OIDCReturnURL.
+	OIDC          service.OIDCService
+	OIDCReturnURL string
 }
 
 func NewAuthHandler(service service.AuthService) *AuthHandler {
@@ -36,6 +52,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
+	ctx = service.WithClientInfo(ctx, clientInfo(c))
 
 	accessToken, refreshToken, user, err := h.service.Register(ctx, req.Username, req.Email, req.Password)
 	if err != nil {
@@ -43,6 +60,12 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	if h.Accounts != nil {
+		if err := h.Accounts.SendVerification(ctx, user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
+	}
+
 	h.setRefreshTokenCookie(c, refreshToken)
 
 	c.JSON(http.StatusCreated, gin.H{
@@ -65,8 +88,18 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
+	ctx = service.WithClientInfo(ctx, clientInfo(c))
 
 	accessToken, refreshToken, user, err := h.service.Login(ctx, req.Email, req.Password)
+	if challenge, ok := err.(*service.TwoFactorRequiredError); ok {
+		// The password was right; the client now asks for a code and calls /auth/2fa/login
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challenge.ChallengeToken,
+			"expires_at":          challenge.ExpiresAt,
+		})
+		return
+	}
 	if err != nil {
 		h.handleError(c, err)
 		return
@@ -89,6 +122,7 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
+	ctx = service.WithClientInfo(ctx, clientInfo(c))
 
 	accessToken, newRefreshToken, err := h.service.Refresh(ctx, refreshToken)
 	if err != nil {
@@ -108,65 +142,511 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 	})
 }
 
+// Logout ends the session of the refresh cookie. An access token sent in the
+// Authorization header is denylisted as well, so it stops working right away.
 func (h *AuthHandler) Logout(c *gin.Context) {
-	refreshToken, err := c.Cookie("refresh_token")
-	if err == nil && refreshToken != "" {
+	refreshToken, _ := c.Cookie("refresh_token")
+	accessToken := bearerToken(c)
+	if refreshToken != "" || accessToken != "" {
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 		defer cancel()
-		_ = h.service.Logout(ctx, refreshToken)
+		_ = h.service.Logout(ctx, refreshToken, accessToken)
 	}
 
 	h.clearRefreshTokenCookie(c)
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
-func (h *AuthHandler) SearchUsers(c *gin.Context) {
+// LogoutAll revokes every session of the current user and closes their WebSocket connections.
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.service.LogoutAll(ctx, userID); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	h.clearRefreshTokenCookie(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+// ListSessions handles GET /auth/sessions
+// Returns the signed-in devices of the current user.
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	// The refresh cookie identifies the current session
+	refreshToken, _ := c.Cookie("refresh_token")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	sessions, err := h.service.ListSessions(ctx, userID, refreshToken)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession handles DELETE /auth/sessions/:id
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.service.RevokeSession(ctx, userID, sessionID); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions handles POST /auth/sessions/revoke-others
+// Signs out every device except the one making the request.
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	refreshToken, err := c.Cookie("refresh_token")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token required to identify the current session"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.service.RevokeOtherSessions(ctx, userID, refreshToken); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked"})
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+// ChangePassword handles POST /auth/change-password
+// Every other session is signed out; this one gets a new refresh cookie.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	ctx = service.WithClientInfo(ctx, clientInfo(c))
+
+	refreshToken, err := h.service.ChangePassword(ctx, userID, middleware.GetSessionIDFromContext(c), req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	h.setRefreshTokenCookie(c, refreshToken)
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// DeleteAccount handles DELETE /me
+// Requires the password. The account is anonymized rather than removed so that
+// peers keep their history.
+func (h *AuthHandler) DeleteAccount(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := h.service.DeleteAccount(ctx, userID, req.Password); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	h.clearRefreshTokenCookie(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+type TokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// VerifyEmail handles POST /auth/verify
+// Redeems the token of a verification link.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Accounts.VerifyEmail(ctx, req.Token); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerification handles POST /auth/verify/resend
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
 	userID := middleware.GetUserIDFromContext(c)
 	if userID == uuid.Nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := h.Accounts.ResendVerification(ctx, userID); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ForgotPassword handles POST /auth/forgot-password
+// The response is the same whether or not the email is registered.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := h.Accounts.ForgotPassword(ctx, req.Email); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email is registered, a reset link has been sent"})
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// ResetPassword handles POST /auth/reset-password
+// Sets a new password from a reset link and signs the user out everywhere.
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := h.Accounts.ResetPassword(ctx, req.Token, req.Password); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	h.clearRefreshTokenCookie(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header, or "".
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return token
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"` // TOTP or recovery code
+}
+
+// LoginTwoFactor handles POST /auth/2fa/login
+// Second step of a login for accounts with two-factor authentication.
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	ctx = service.WithClientInfo(ctx, clientInfo(c))
+
+	accessToken, refreshToken, user, err := h.service.LoginTwoFactor(ctx, req.ChallengeToken, req.Code)
+	if err != nil {
+		h.handleError(c, err)
 		return
 	}
 
-	query := c.Query("q")
+	h.setRefreshTokenCookie(c, refreshToken)
+
+	c.JSON(http.StatusOK, gin.H{
+		"token": accessToken,
+		"user":  user,
+	})
+}
+
+// SetupTwoFactor handles POST /auth/2fa/setup
+// Returns a new TOTP secret and its otpauth:// URI. 2FA is enabled by /auth/2fa/verify.
+func (h *AuthHandler) SetupTwoFactor(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
-	users, err := h.service.SearchUsers(ctx, query, userID)
+	setup, err := h.TwoFactor.Setup(ctx, userID)
 	if err != nil {
 		h.handleError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, setup)
 }
 
-func (h *AuthHandler) GetUser(c *gin.Context) {
-	idParam := c.Param("id")
-	userID, err := uuid.Parse(idParam)
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// VerifyTwoFactor handles POST /auth/2fa/verify
+// Enables 2FA with a first code from the authenticator app and returns the recovery codes.
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	codes, err := h.TwoFactor.Enable(ctx, userID, req.Code)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// DisableTwoFactor handles POST /auth/2fa/disable
+// Requires the password.
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
-	user, err := h.service.GetUser(ctx, userID)
+	if err := h.TwoFactor.Disable(ctx, userID, req.Password); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+const oidcStateCookie = "oidc_state"
+
+// OIDCLogin handles GET /auth/oidc/login
+// Redirects the browser to the identity provider. The state is also kept in a
+// cookie, binding the callback to the browser that started the login.
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	authURL, state, err := h.OIDC.Begin()
 	if err != nil {
 		h.handleError(c, err)
 		return
 	}
 
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		MaxAge:   int(service.DefaultOIDCLoginTTL.Seconds()),
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		Secure:   gin.Mode() == gin.ReleaseMode,
+		SameSite: http.SameSiteLaxMode, // Sent on the top-level redirect back from the provider
+	})
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback handles GET /auth/oidc/callback
+// Completes the login, sets the refresh token cookie and redirects to the app,
+// which obtains an access token from /auth/refresh. Failures redirect with ?error=<code>,
+// accounts with 2FA with a challenge in the fragment.
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		Secure:   gin.Mode() == gin.ReleaseMode,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// 1. The provider reports errors such as a denied consent in the query
+	if c.Query("error") != "" || state == "" || cookie != state {
+		h.redirectOIDCError(c, errors.ErrUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	ctx = service.WithClientInfo(ctx, clientInfo(c))
+
+	// 2. Redeem the code and sign in
+	_, refreshToken, _, err := h.OIDC.Complete(ctx, state, c.Query("code"))
+	if challenge, ok := err.(*service.TwoFactorRequiredError); ok {
+		// The app asks for a code and calls /auth/2fa/login. The challenge goes
+		// in the fragment, which browsers don't send to servers.
+		h.redirectOIDC(c, url.Values{
+			"two_factor_required": {"true"},
+			"challenge_token":     {challenge.ChallengeToken},
+		}, true)
+		return
+	}
+	if err != nil {
+		h.redirectOIDCError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	h.setRefreshTokenCookie(c, refreshToken)
+	c.Redirect(http.StatusFound, h.OIDCReturnURL)
+}
+
+func (h *AuthHandler) redirectOIDCError(c *gin.Context, err error) {
+	code := errors.ErrInternalServer.Code
+	if appErr, ok := err.(*errors.AppError); ok {
+		code = appErr.Code
+	} else {
+		log.Printf("OIDC login failed: %v", err)
+	}
+
+	h.redirectOIDC(c, url.Values{"error": {code}}, false)
+}
+
+// redirectOIDC redirects to the app with the values added to the query, or
+// set as the fragment.
+func (h *AuthHandler) redirectOIDC(c *gin.Context, values url.Values, inFragment bool) {
+	target, err := url.Parse(h.OIDCReturnURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	if inFragment {
+		target.Fragment = values.Encode()
+	} else {
+		q := target.Query()
+		for key, value := range values {
+			q[key] = value
+		}
+		target.RawQuery = q.Encode()
+	}
+	c.Redirect(http.StatusFound, target.String())
 }
 
 func (h *AuthHandler) handleError(c *gin.Context, err error) {
+	respondError(c, err)
+}
+
+// respondError writes err as a JSON error response. AppErrors keep their status
+// and code; anything else is an internal error.
+func respondError(c *gin.Context, err error) {
+	if rlErr, ok := err.(*errors.RateLimitError); ok {
+		c.Header("Retry-After", strconv.Itoa(rlErr.RetryAfterSeconds()))
+		err = errors.ErrTooManyRequests
+	}
 	if appErr, ok := err.(*errors.AppError); ok {
 		c.JSON(appErr.Status, gin.H{
 			"error": gin.H{
@@ -181,6 +661,14 @@ func (h *AuthHandler) handleError(c *gin.Context, err error) {
 
 // Helpers
 
+// clientInfo describes the device making the request, for session listings.
+func clientInfo(c *gin.Context) service.ClientInfo {
+	return service.ClientInfo{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
 func (h *AuthHandler) setRefreshTokenCookie(c *gin.Context, token string) {
 	// Determine if we're in production (Secure flag should be true for HTTPS)
 	isProduction := gin.Mode() == gin.ReleaseMode
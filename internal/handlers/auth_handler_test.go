@@ -6,15 +6,19 @@ import (
 	"chat-app/internal/errors"
 	"chat-app/internal/handlers"
 	"chat-app/internal/models"
+	"chat-app/internal/service"
+	"chat-app/pkg/jwt"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockAuthService
@@ -43,8 +47,41 @@ func (m *MockAuthService) Refresh(ctx context.Context, refreshToken string) (str
 	return args.String(0), args.String(1), args.Error(2)
 }
 
-func (m *MockAuthService) Logout(ctx context.Context, refreshToken string) error {
-	args := m.Called(ctx, refreshToken)
+func (m *MockAuthService) Logout(ctx context.Context, refreshToken, accessToken string) error {
+	args := m.Called(ctx, refreshToken, accessToken)
+	return args.Error(0)
+}
+
+func (m *MockAuthService) LogoutAll(ctx context.Context, userID uuid.UUID) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockAuthService) ListSessions(ctx context.Context, userID uuid.UUID, currentRefreshToken string) ([]service.Session, error) {
+	args := m.Called(ctx, userID, currentRefreshToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]service.Session), args.Error(1)
+}
+
+func (m *MockAuthService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	args := m.Called(ctx, userID, sessionID)
+	return args.Error(0)
+}
+
+func (m *MockAuthService) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, currentRefreshToken string) error {
+	args := m.Called(ctx, userID, currentRefreshToken)
+	return args.Error(0)
+}
+
+func (m *MockAuthService) ChangePassword(ctx context.Context, userID, sessionID uuid.UUID, currentPassword, newPassword string) (string, error) {
+	args := m.Called(ctx, userID, sessionID, currentPassword, newPassword)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockAuthService) DeleteAccount(ctx context.Context, userID uuid.UUID, password string) error {
+	args := m.Called(ctx, userID, password)
 	return args.Error(0)
 }
 
@@ -53,20 +90,77 @@ func (m *MockAuthService) ValidateToken(tokenString string) (uuid.UUID, error) {
 	return args.Get(0).(uuid.UUID), args.Error(1)
 }
 
-func (m *MockAuthService) SearchUsers(ctx context.Context, query string, excludeUserID uuid.UUID) ([]models.User, error) {
-	args := m.Called(ctx, query, excludeUserID)
+func (m *MockAuthService) ParseToken(tokenString string) (*jwt.Claims, error) {
+	args := m.Called(tokenString)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).([]models.User), args.Error(1)
+	return args.Get(0).(*jwt.Claims), args.Error(1)
 }
 
-func (m *MockAuthService) GetUser(ctx context.Context, id uuid.UUID) (*models.User, error) {
-	args := m.Called(ctx, id)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
+
+func (m *MockAuthService) LoginTwoFactor(ctx context.Context, challengeToken, code string) (string, string, *models.User, error) {
+	args := m.Called(ctx, challengeToken, code)
+	if args.Get(2) == nil {
+		return args.String(0), args.String(1), nil, args.Error(3)
+	}
+	return args.String(0), args.String(1), args.Get(2).(*models.User), args.Error(3)
+}
+
+func (m *MockAuthService) LoginExternal(ctx context.Context, identity service.ExternalIdentity) (string, string, *models.User, error) {
+	args := m.Called(ctx, identity)
+	if args.Get(2) == nil {
+		return args.String(0), args.String(1), nil, args.Error(3)
+	}
+	return args.String(0), args.String(1), args.Get(2).(*models.User), args.Error(3)
+}
+
+// MockAccountService
+type MockAccountService struct {
+	mock.Mock
+}
+
+func (m *MockAccountService) SendVerification(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockAccountService) ResendVerification(ctx context.Context, userID uuid.UUID) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockAccountService) VerifyEmail(ctx context.Context, token string) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+func (m *MockAccountService) ForgotPassword(ctx context.Context, email string) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
+func (m *MockAccountService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	args := m.Called(ctx, token, newPassword)
+	return args.Error(0)
+}
+
+// MockOIDCService
+type MockOIDCService struct {
+	mock.Mock
+}
+
+func (m *MockOIDCService) Begin() (string, string, error) {
+	args := m.Called()
+	return args.String(0), args.String(1), args.Error(2)
+}
+
+func (m *MockOIDCService) Complete(ctx context.Context, state, code string) (string, string, *models.User, error) {
+	args := m.Called(ctx, state, code)
+	if args.Get(2) == nil {
+		return args.String(0), args.String(1), nil, args.Error(3)
 	}
-	return args.Get(0).(*models.User), args.Error(1)
+	return args.String(0), args.String(1), args.Get(2).(*models.User), args.Error(3)
 }
 
 func setupAuthTest() (*handlers.AuthHandler, *MockAuthService, *gin.Engine) {
@@ -82,7 +176,7 @@ func TestRegister_Success(t *testing.T) {
 	r.POST("/register", handler.Register)
 
 	user := &models.User{Username: "test", Email: "test@example.com"}
-	mockService.On("Register", mock.AnythingOfType("*context.timerCtx"), "test", "test@example.com", "password123").Return("test_token", "refresh_token", user, nil)
+	mockService.On("Register", mock.AnythingOfType("*context.valueCtx"), "test", "test@example.com", "password123").Return("test_token", "refresh_token", user, nil)
 
 	body := `{"username":"test", "email":"test@example.com", "password":"password123"}`
 	req, _ := http.NewRequest("POST", "/register", bytes.NewBufferString(body))
@@ -97,7 +191,7 @@ func TestRegister_DuplicateEmail(t *testing.T) {
 	handler, mockService, r := setupAuthTest()
 	r.POST("/register", handler.Register)
 
-	mockService.On("Register", mock.AnythingOfType("*context.timerCtx"), "test", "existing@example.com", "password123").Return("", "", nil, errors.ErrEmailExists)
+	mockService.On("Register", mock.AnythingOfType("*context.valueCtx"), "test", "existing@example.com", "password123").Return("", "", nil, errors.ErrEmailExists)
 
 	body := `{"username":"test", "email":"existing@example.com", "password":"password123"}`
 	req, _ := http.NewRequest("POST", "/register", bytes.NewBufferString(body))
@@ -125,7 +219,7 @@ func TestLogin_Success(t *testing.T) {
 	r.POST("/login", handler.Login)
 
 	user := &models.User{Email: "test@example.com"}
-	mockService.On("Login", mock.AnythingOfType("*context.timerCtx"), "test@example.com", "password123").Return("valid_token", "refresh_token", user, nil)
+	mockService.On("Login", mock.AnythingOfType("*context.valueCtx"), "test@example.com", "password123").Return("valid_token", "refresh_token", user, nil)
 
 	body := `{"email":"test@example.com", "password":"password123"}`
 	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
@@ -143,7 +237,7 @@ func TestLogin_InvalidCredentials(t *testing.T) {
 	handler, mockService, r := setupAuthTest()
 	r.POST("/login", handler.Login)
 
-	mockService.On("Login", mock.AnythingOfType("*context.timerCtx"), "test@example.com", "wrongpass").Return("", "", nil, errors.ErrInvalidCredentials)
+	mockService.On("Login", mock.AnythingOfType("*context.valueCtx"), "test@example.com", "wrongpass").Return("", "", nil, errors.ErrInvalidCredentials)
 
 	body := `{"email":"test@example.com", "password":"wrongpass"}`
 	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
@@ -152,3 +246,268 @@ func TestLogin_InvalidCredentials(t *testing.T) {
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
+
+func TestLogin_TwoFactorChallenge(t *testing.T) {
+	handler, mockService, r := setupAuthTest()
+	r.POST("/login", handler.Login)
+	r.POST("/2fa/login", handler.LoginTwoFactor)
+
+	mockService.On("Login", mock.AnythingOfType("*context.valueCtx"), "test@example.com", "password123").
+		Return("", "", nil, &service.TwoFactorRequiredError{ChallengeToken: "challenge", ExpiresAt: time.Now().Add(time.Minute)})
+
+	body := `{"email":"test@example.com", "password":"password123"}`
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, true, resp["two_factor_required"])
+	assert.Equal(t, "challenge", resp["challenge_token"])
+	assert.Nil(t, resp["token"])
+	assert.Empty(t, w.Result().Cookies(), "no session before the second step")
+
+	// Second step
+	user := &models.User{Username: "testuser", Email: "test@example.com"}
+	mockService.On("LoginTwoFactor", mock.AnythingOfType("*context.valueCtx"), "challenge", "123456").Return("access_token", "refresh_token", user, nil)
+
+	req, _ = http.NewRequest("POST", "/2fa/login", bytes.NewBufferString(`{"challenge_token":"challenge","code":"123456"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "access_token")
+	require.NotEmpty(t, w.Result().Cookies())
+	assert.Equal(t, "refresh_token", w.Result().Cookies()[0].Value)
+}
+
+func TestLogin_RateLimited(t *testing.T) {
+	handler, mockService, r := setupAuthTest()
+	r.POST("/login", handler.Login)
+
+	mockService.On("Login", mock.AnythingOfType("*context.valueCtx"), "test@example.com", "password123").
+		Return("", "", nil, &errors.RateLimitError{RetryAfter: 1500 * time.Millisecond})
+
+	body := `{"email":"test@example.com", "password":"password123"}`
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), errors.ErrTooManyRequests.Code)
+}
+
+func TestLogoutAll_Success(t *testing.T) {
+	handler, mockService, r := setupAuthTest()
+	userID := uuid.New()
+	r.POST("/auth/logout-all", func(c *gin.Context) { c.Set("userID", userID) }, handler.LogoutAll)
+
+	mockService.On("LogoutAll", mock.AnythingOfType("*context.timerCtx"), userID).Return(nil)
+
+	req, _ := http.NewRequest("POST", "/auth/logout-all", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestListSessions_PassesRefreshCookie(t *testing.T) {
+	handler, mockService, r := setupAuthTest()
+	userID := uuid.New()
+	r.GET("/auth/sessions", func(c *gin.Context) { c.Set("userID", userID) }, handler.ListSessions)
+
+	sessions := []service.Session{{ID: uuid.New(), UserAgent: "Firefox", Current: true}}
+	mockService.On("ListSessions", mock.AnythingOfType("*context.timerCtx"), userID, "raw-refresh").Return(sessions, nil)
+
+	req, _ := http.NewRequest("GET", "/auth/sessions", nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "raw-refresh"})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Len(t, response, 1)
+	assert.Equal(t, true, response[0]["current"])
+}
+
+func TestRevokeSession_NotFound(t *testing.T) {
+	handler, mockService, r := setupAuthTest()
+	userID := uuid.New()
+	sessionID := uuid.New()
+	r.DELETE("/auth/sessions/:id", func(c *gin.Context) { c.Set("userID", userID) }, handler.RevokeSession)
+
+	mockService.On("RevokeSession", mock.AnythingOfType("*context.timerCtx"), userID, sessionID).Return(errors.ErrSessionNotFound)
+
+	req, _ := http.NewRequest("DELETE", "/auth/sessions/"+sessionID.String(), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRegister_SendsVerificationEmail(t *testing.T) {
+	handler, mockService, r := setupAuthTest()
+	accounts := new(MockAccountService)
+	handler.Accounts = accounts
+	r.POST("/register", handler.Register)
+
+	user := &models.User{Username: "test", Email: "test@example.com"}
+	mockService.On("Register", mock.AnythingOfType("*context.valueCtx"), "test", "test@example.com", "password123").Return("test_token", "refresh_token", user, nil)
+	accounts.On("SendVerification", mock.Anything, user).Return(nil)
+
+	body := `{"username":"test", "email":"test@example.com", "password":"password123"}`
+	req, _ := http.NewRequest("POST", "/register", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	accounts.AssertExpectations(t)
+}
+
+func TestVerifyEmail_InvalidLink(t *testing.T) {
+	handler, _, r := setupAuthTest()
+	accounts := new(MockAccountService)
+	handler.Accounts = accounts
+	r.POST("/auth/verify", handler.VerifyEmail)
+
+	accounts.On("VerifyEmail", mock.AnythingOfType("*context.timerCtx"), "expired").Return(errors.ErrInvalidAccountToken)
+
+	req, _ := http.NewRequest("POST", "/auth/verify", bytes.NewBufferString(`{"token":"expired"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), errors.ErrInvalidAccountToken.Code)
+}
+
+func TestResetPassword_Success(t *testing.T) {
+	handler, _, r := setupAuthTest()
+	accounts := new(MockAccountService)
+	handler.Accounts = accounts
+	r.POST("/auth/reset-password", handler.ResetPassword)
+
+	accounts.On("ResetPassword", mock.AnythingOfType("*context.timerCtx"), "reset-token", "new-password").Return(nil)
+
+	req, _ := http.NewRequest("POST", "/auth/reset-password", bytes.NewBufferString(`{"token":"reset-token","password":"new-password"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	accounts.AssertExpectations(t)
+}
+
+func TestChangePassword_SetsNewRefreshCookie(t *testing.T) {
+	handler, mockService, r := setupAuthTest()
+	userID, sessionID := uuid.New(), uuid.New()
+	r.POST("/auth/change-password", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Set("sessionID", sessionID)
+	}, handler.ChangePassword)
+
+	mockService.On("ChangePassword", mock.AnythingOfType("*context.valueCtx"), userID, sessionID, "old-password", "new-password").Return("new-refresh", nil)
+
+	body := `{"current_password":"old-password","new_password":"new-password"}`
+	req, _ := http.NewRequest("POST", "/auth/change-password", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "refresh_token=new-refresh")
+}
+
+func TestDeleteAccount(t *testing.T) {
+	handler, mockService, r := setupAuthTest()
+	userID := uuid.New()
+	r.DELETE("/me", func(c *gin.Context) { c.Set("userID", userID) }, handler.DeleteAccount)
+
+	// The password is required
+	req, _ := http.NewRequest("DELETE", "/me", bytes.NewBufferString(`{}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockService.On("DeleteAccount", mock.AnythingOfType("*context.timerCtx"), userID, "wrong").Return(errors.ErrIncorrectPassword)
+	req, _ = http.NewRequest("DELETE", "/me", bytes.NewBufferString(`{"password":"wrong"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	mockService.On("DeleteAccount", mock.AnythingOfType("*context.timerCtx"), userID, "password").Return(nil)
+	req, _ = http.NewRequest("DELETE", "/me", bytes.NewBufferString(`{"password":"password"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestOIDCLogin_RedirectsWithStateCookie(t *testing.T) {
+	handler, _, r := setupAuthTest()
+	mockOIDC := new(MockOIDCService)
+	handler.OIDC = mockOIDC
+	r.GET("/auth/oidc/login", handler.OIDCLogin)
+
+	mockOIDC.On("Begin").Return("https://idp.example.com/authorize?state=xyz", "xyz", nil)
+
+	req, _ := http.NewRequest("GET", "/auth/oidc/login", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://idp.example.com/authorize?state=xyz", w.Header().Get("Location"))
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "oidc_state=xyz")
+}
+
+func TestOIDCCallback(t *testing.T) {
+	handler, _, r := setupAuthTest()
+	mockOIDC := new(MockOIDCService)
+	handler.OIDC = mockOIDC
+	handler.OIDCReturnURL = "http://localhost:3000/app"
+	r.GET("/auth/oidc/callback", handler.OIDCCallback)
+
+	callback := func(state, code, cookie string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/auth/oidc/callback?state="+state+"&code="+code, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "oidc_state", Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// A state that doesn't match the browser's cookie is refused
+	w := callback("xyz", "code", "other")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "http://localhost:3000/app?error=AUTH_UNAUTHORIZED", w.Header().Get("Location"))
+	mockOIDC.AssertNotCalled(t, "Complete", mock.Anything, mock.Anything, mock.Anything)
+
+	// Service errors are passed on as codes
+	mockOIDC.On("Complete", mock.AnythingOfType("*context.valueCtx"), "xyz", "unverified").Return("", "", nil, errors.ErrSSOEmailUnverified)
+	w = callback("xyz", "unverified", "xyz")
+	assert.Equal(t, "http://localhost:3000/app?error=AUTH_SSO_EMAIL_UNVERIFIED", w.Header().Get("Location"))
+
+	// Accounts with 2FA get the challenge in the fragment, and no session
+	mockOIDC.On("Complete", mock.AnythingOfType("*context.valueCtx"), "xyz", "2fa").Return("", "", nil, &service.TwoFactorRequiredError{ChallengeToken: "challenge"})
+	w = callback("xyz", "2fa", "xyz")
+	assert.Equal(t, "http://localhost:3000/app#challenge_token=challenge&two_factor_required=true", w.Header().Get("Location"))
+	for _, c := range w.Result().Cookies() {
+		assert.NotEqual(t, "refresh_token", c.Name)
+	}
+
+	// Success sets the refresh cookie; the app then calls /auth/refresh
+	user := &models.User{Username: "alice"}
+	mockOIDC.On("Complete", mock.AnythingOfType("*context.valueCtx"), "xyz", "good").Return("access", "raw-refresh", user, nil)
+	w = callback("xyz", "good", "xyz")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "http://localhost:3000/app", w.Header().Get("Location"))
+	found := false
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "refresh_token" {
+			found = c.Value == "raw-refresh"
+		}
+	}
+	assert.True(t, found, "refresh cookie set")
+}
@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"context"
+	stderrors "errors"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"chat-app/internal/errors"
 	"chat-app/internal/middleware"
 	"chat-app/internal/repository"
 	"chat-app/internal/service"
@@ -23,6 +25,9 @@ type ChatHandler struct {
 	msgService service.MessageService
 }
 
+// maxInboxLimit caps the page size of GET /conversations
+const maxInboxLimit = 100
+
 func NewChatHandler(
 	convRepo repository.ConversationRepository,
 	msgRepo repository.MessageRepository,
@@ -41,19 +46,34 @@ func NewChatHandler(
 
 // ConversationResponse is the response structure for conversation list
 type ConversationResponse struct {
-	ID            uuid.UUID `json:"id"`
-	Type          string    `json:"type"`
-	TargetID      uuid.UUID `json:"target_id"`
-	TargetName    string    `json:"target_name"`
-	LastMessage   *string   `json:"last_message"`
-	LastMessageAt string    `json:"last_message_at"`
-	UnreadCount   int       `json:"unread_count"`
-	IsOnline      *bool     `json:"is_online,omitempty"`    // Only for DM conversations
-	MemberCount   int       `json:"member_count,omitempty"` // Only for groups
+	ID            uuid.UUID  `json:"id"`
+	Type          string     `json:"type"`
+	TargetID      uuid.UUID  `json:"target_id"`
+	TargetName    string     `json:"target_name"`
+	LastMessage   *string    `json:"last_message"`
+	LastMessageAt string     `json:"last_message_at"`
+	UnreadCount   int        `json:"unread_count"`
+	MentionCount  int        `json:"mention_count"`
+	IsOnline      *bool      `json:"is_online,omitempty"`    // Only for DM conversations
+	MemberCount   int        `json:"member_count,omitempty"` // Only for groups
+	Muted         bool       `json:"muted"`
+	MutedUntil    *time.Time `json:"muted_until,omitempty"`
+	Archived      bool       `json:"archived"`
+	Pinned        bool       `json:"pinned"`
+	Draft         string     `json:"draft,omitempty"`
+}
+
+// UpdateConversationRequest changes the user's settings for a conversation.
+// Omitted fields are left unchanged.
+type UpdateConversationRequest struct {
+	Muted      *bool      `json:"muted"`
+	MutedUntil *time.Time `json:"muted_until"` // Mutes until this time; implies muted
+	Archived   *bool      `json:"archived"`
+	Pinned     *bool      `json:"pinned"`
 }
 
-// GetConversations handles GET /conversations
-// Returns the inbox (list of conversations) sorted by last_message_at
+// GetConversations handles GET /conversations?limit=<n>&before_id=<uuid>&type=<DM|GROUP>&unread=true&archived=<bool>
+// Returns a page of the inbox (list of conversations), pinned first, then sorted by last_message_at
 func (h *ChatHandler) GetConversations(c *gin.Context) {
 	// 1. Get user ID from AuthMiddleware context
 	userID := middleware.GetUserIDFromContext(c)
@@ -62,65 +82,179 @@ func (h *ChatHandler) GetConversations(c *gin.Context) {
 		return
 	}
 
+	// 2. Parse pagination and filters
+	filter := repository.InboxFilter{Limit: 50} // Default limit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			filter.Limit = min(parsedLimit, maxInboxLimit)
+		}
+	}
+
+	// Parse 'before_id' cursor for pagination (ID of the last conversation of the previous page)
+	if beforeIDStr := c.Query("before_id"); beforeIDStr != "" {
+		parsed, err := uuid.Parse(beforeIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'before_id' format, must be a valid UUID"})
+			return
+		}
+		filter.BeforeID = &parsed
+	}
+
+	if convType := strings.ToUpper(c.Query("type")); convType != "" {
+		if convType != "DM" && convType != "GROUP" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be DM or GROUP"})
+			return
+		}
+		filter.Type = convType
+	}
+
+	if unreadStr := c.Query("unread"); unreadStr != "" {
+		unread, err := strconv.ParseBool(unreadStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unread must be true or false"})
+			return
+		}
+		filter.UnreadOnly = unread
+	}
+
+	if archivedStr := c.Query("archived"); archivedStr != "" {
+		archived, err := strconv.ParseBool(archivedStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "archived must be true or false"})
+			return
+		}
+		filter.Archived = &archived
+	}
+
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
-	// 2. Fetch conversations for this user
-	conversations, err := h.convRepo.FindByUser(ctx, userID)
+	// 3. Fetch the page with target names, member counts and online status
+	entries, err := h.convRepo.FindInbox(ctx, userID, filter)
 	if err != nil {
+		if stderrors.Is(err, repository.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown 'before_id' conversation"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch conversations"})
 		return
 	}
 
-	// 3. Build response with target names
-	response := make([]ConversationResponse, 0, len(conversations))
-	for _, conv := range conversations {
-		targetName := ""
-		memberCount := 0
-		var isOnline *bool
-
-		// Normalize type for internal switching and response
-		upperType := strings.ToUpper(conv.Type)
-
-		switch upperType {
-		case "DM":
-			// Fetch user name and online status
-			user, err := h.userRepo.FindByID(ctx, conv.TargetID)
-			if err == nil {
-				targetName = user.Username
-				isOnline = &user.IsOnline
-			}
-		case "GROUP":
-			// Fetch group name and member count
-			group, err := h.groupRepo.FindByID(ctx, conv.TargetID)
-			if err == nil {
-				targetName = group.Name
-			}
-			// Get member count
-			members, err := h.groupRepo.GetMembers(ctx, conv.TargetID)
-			if err == nil {
-				memberCount = len(members)
-			}
+	// 4. Build response
+	now := time.Now()
+	response := make([]ConversationResponse, 0, len(entries))
+	for _, entry := range entries {
+		lastMsg := entry.LastMessage
+		muted := entry.IsMuted(now)
+		var mutedUntil *time.Time
+		if muted {
+			mutedUntil = entry.MutedUntil
 		}
-
-		lastMsg := conv.LastMessage
 		response = append(response, ConversationResponse{
-			ID:            conv.ID,
-			Type:          upperType,
-			TargetID:      conv.TargetID,
-			TargetName:    targetName,
+			ID:            entry.ID,
+			Type:          strings.ToUpper(entry.Type),
+			TargetID:      entry.TargetID,
+			TargetName:    entry.TargetName,
 			LastMessage:   &lastMsg,
-			LastMessageAt: conv.LastMessageAt.Format("2006-01-02T15:04:05Z07:00"),
-			UnreadCount:   conv.UnreadCount,
-			IsOnline:      isOnline,
-			MemberCount:   memberCount,
+			LastMessageAt: entry.LastMessageAt.Format("2006-01-02T15:04:05Z07:00"),
+			UnreadCount:   entry.UnreadCount,
+			MentionCount:  entry.MentionCount,
+			IsOnline:      entry.OnlineStatus(),
+			MemberCount:   entry.MemberCount,
+			Muted:         muted,
+			MutedUntil:    mutedUntil,
+			Archived:      entry.Archived,
+			Pinned:        entry.Pinned,
+			Draft:         entry.Draft,
 		})
 	}
 
-	// 4. Return response
+	// 5. Return response
 	c.JSON(http.StatusOK, response)
 }
 
+// UpdateConversation handles PATCH /conversations/:type/:target_id
+// Mutes, archives or pins a conversation for the current user
+func (h *ChatHandler) UpdateConversation(c *gin.Context) {
+	// 1. Get user ID from AuthMiddleware context
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	// 2. Parse path parameters and body
+	convType := strings.ToUpper(c.Param("type"))
+	if convType != "DM" && convType != "GROUP" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be DM or GROUP"})
+		return
+	}
+
+	targetID, err := uuid.Parse(c.Param("target_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid target_id"})
+		return
+	}
+
+	var req UpdateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+	if req.MutedUntil != nil && !req.MutedUntil.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "muted_until must be in the future"})
+		return
+	}
+	if req.MutedUntil != nil && req.Muted != nil && !*req.Muted {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "muted_until requires muted"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// 3. Settings belong to an existing conversation
+	conv, err := h.convRepo.FindOne(ctx, userID, convType, targetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch conversation"})
+		return
+	}
+	if conv == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+		return
+	}
+
+	// 4. Apply the changes
+	now := time.Now()
+	wasMuted := conv.IsMuted(now)
+	if req.Muted != nil {
+		conv.Muted = *req.Muted
+		conv.MutedUntil = nil
+	}
+	if req.MutedUntil != nil {
+		conv.Muted = true
+		conv.MutedUntil = req.MutedUntil
+	}
+	if req.Archived != nil {
+		conv.Archived = *req.Archived
+	}
+	if req.Pinned != nil {
+		conv.Pinned = *req.Pinned
+	}
+
+	if err := h.convRepo.UpdateSettings(ctx, conv); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update conversation"})
+		return
+	}
+
+	// Muted conversations don't count towards the badge
+	if conv.IsMuted(now) != wasMuted && conv.UnreadCount > 0 {
+		h.msgService.PushBadge(ctx, userID)
+	}
+
+	c.JSON(http.StatusOK, conv)
+}
+
 // GetMessages handles GET /messages?target_id=<uuid>&type=<DM|GROUP>&limit=<n>
 // Returns message history for a specific conversation
 func (h *ChatHandler) GetMessages(c *gin.Context) {
@@ -192,13 +326,37 @@ func (h *ChatHandler) GetMessages(c *gin.Context) {
 		return
 	}
 
-	// 5. Reset unread count for this conversation
-	_ = h.convRepo.ResetUnread(ctx, userID, msgType, targetID)
+	// 5. Reset unread count for this conversation, updating the badge on the user's devices
+	_ = h.msgService.MarkConversationRead(ctx, userID, msgType, targetID)
 
 	// 6. Return messages
 	c.JSON(http.StatusOK, messages)
 }
 
+// GetUnread handles GET /me/unread
+// Returns the total unread and mention counts with a breakdown per conversation,
+// in the same shape as badge_update events
+func (h *ChatHandler) GetUnread(c *gin.Context) {
+	// 1. Get user ID from AuthMiddleware context
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// 2. Sum the conversations' unread counts
+	badge, err := h.msgService.GetUnreadCounts(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch unread counts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, badge)
+}
+
 // MarkRead handles POST /messages/:id/read
 func (h *ChatHandler) MarkRead(c *gin.Context) {
 	// 1. Get user ID from AuthMiddleware context
@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+	"chat-app/internal/repository"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -31,24 +34,53 @@ func (m *MockConversationRepo) FindByUser(ctx context.Context, userID uuid.UUID)
 	return args.Get(0).([]models.Conversation), args.Error(1)
 }
 
-func (m *MockConversationRepo) IncrementUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, lastMessage string) error {
-	args := m.Called(ctx, userID, convType, targetID, lastMessage)
+func (m *MockConversationRepo) IncrementUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, lastMessage string, mentioned bool) error {
+	args := m.Called(ctx, userID, convType, targetID, lastMessage, mentioned)
 	return args.Error(0)
 }
 
-func (m *MockConversationRepo) ResetUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) error {
+func (m *MockConversationRepo) ResetUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) (bool, error) {
 	args := m.Called(ctx, userID, convType, targetID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockConversationRepo) FindOne(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) (*models.Conversation, error) {
+	args := m.Called(ctx, userID, convType, targetID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Conversation), args.Error(1)
+}
+
+func (m *MockConversationRepo) UpdateSettings(ctx context.Context, conv *models.Conversation) error {
+	args := m.Called(ctx, conv)
 	return args.Error(0)
 }
 
-func (m *MockConversationRepo) FindContactsOfUser(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
-	args := m.Called(ctx, userID)
+func (m *MockConversationRepo) FindMutedUserIDs(ctx context.Context, convType string, targetID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	args := m.Called(ctx, convType, targetID, userIDs)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]uuid.UUID), args.Error(1)
 }
 
+func (m *MockConversationRepo) FindInbox(ctx context.Context, userID uuid.UUID, filter repository.InboxFilter) ([]models.InboxEntry, error) {
+	args := m.Called(ctx, userID, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.InboxEntry), args.Error(1)
+}
+
+func (m *MockConversationRepo) FindUnreadCounts(ctx context.Context, userIDs []uuid.UUID) ([]models.Conversation, error) {
+	args := m.Called(ctx, userIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Conversation), args.Error(1)
+}
+
 type MockMessageRepo struct {
 	mock.Mock
 }
@@ -109,6 +141,59 @@ func (m *MockUserRepo) Search(ctx context.Context, query string, excludeUserID u
 	return args.Get(0).([]models.User), args.Error(1)
 }
 
+func (m *MockUserRepo) UpdatePassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	args := m.Called(ctx, userID, passwordHash)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) MarkEmailVerified(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) DeleteAccount(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) SetTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	args := m.Called(ctx, userID, secret)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) EnableTwoFactor(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	args := m.Called(ctx, userID, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepo) UpdateProfile(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) UpdatePrivacy(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.User), args.Error(1)
+}
+
+func (m *MockUserRepo) UpdatePresence(ctx context.Context, userID uuid.UUID, presence string) error {
+	args := m.Called(ctx, userID, presence)
+	return args.Error(0)
+}
+
 type MockGroupRepo struct {
 	mock.Mock
 }
@@ -195,6 +280,23 @@ func (m *MockMessageService) BroadcastTypingIndicator(ctx context.Context, userI
 	return args.Error(0)
 }
 
+func (m *MockMessageService) GetUnreadCounts(ctx context.Context, userID uuid.UUID) (*protocol.BadgePayload, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*protocol.BadgePayload), args.Error(1)
+}
+
+func (m *MockMessageService) MarkConversationRead(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) error {
+	args := m.Called(ctx, userID, convType, targetID)
+	return args.Error(0)
+}
+
+func (m *MockMessageService) PushBadge(ctx context.Context, userID uuid.UUID) {
+	m.Called(ctx, userID)
+}
+
 // Helper middleware to set userID in context (simulates what AuthMiddleware does)
 func mockAuthMiddleware(userID uuid.UUID) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -223,40 +325,35 @@ func TestGetConversations_Success(t *testing.T) {
 	groupID := uuid.New()
 	now := time.Now()
 
-	conversations := []models.Conversation{
+	entries := []models.InboxEntry{
 		{
-			BaseModel:     models.BaseModel{ID: uuid.New()},
-			UserID:        userID,
-			Type:          "DM",
-			TargetID:      targetUserID,
-			LastMessageAt: now,
-			UnreadCount:   3,
+			Conversation: models.Conversation{
+				BaseModel:     models.BaseModel{ID: uuid.New()},
+				UserID:        userID,
+				Type:          "DM",
+				TargetID:      targetUserID,
+				LastMessageAt: now,
+				UnreadCount:   3,
+			},
+			TargetName:   "Bob",
+			TargetOnline: true,
 		},
 		{
-			BaseModel:     models.BaseModel{ID: uuid.New()},
-			UserID:        userID,
-			Type:          "GROUP",
-			TargetID:      groupID,
-			LastMessageAt: now.Add(-1 * time.Hour),
-			UnreadCount:   0,
+			Conversation: models.Conversation{
+				BaseModel:     models.BaseModel{ID: uuid.New()},
+				UserID:        userID,
+				Type:          "GROUP",
+				TargetID:      groupID,
+				LastMessageAt: now.Add(-1 * time.Hour),
+				UnreadCount:   0,
+			},
+			TargetName:  "Family",
+			MemberCount: 2,
 		},
 	}
 
-	// Mock expectations
-	mockConvRepo.On("FindByUser", mock.AnythingOfType("*context.timerCtx"), userID).Return(conversations, nil)
-	mockUserRepo.On("FindByID", mock.AnythingOfType("*context.timerCtx"), targetUserID).Return(&models.User{
-		BaseModel: models.BaseModel{ID: targetUserID},
-		Username:  "Bob",
-	}, nil)
-	mockGroupRepo.On("FindByID", mock.AnythingOfType("*context.timerCtx"), groupID).Return(&models.Group{
-		BaseModel: models.BaseModel{ID: groupID},
-		Name:      "Family",
-	}, nil)
-	// Mock GetMembers for group member count
-	mockGroupRepo.On("GetMembers", mock.AnythingOfType("*context.timerCtx"), groupID).Return([]models.GroupMember{
-		{GroupID: groupID, UserID: userID, Role: "MEMBER"},
-		{GroupID: groupID, UserID: uuid.New(), Role: "MEMBER"},
-	}, nil)
+	// Mock expectations: one query, no lookups per conversation
+	mockConvRepo.On("FindInbox", mock.AnythingOfType("*context.timerCtx"), userID, repository.InboxFilter{Limit: 50}).Return(entries, nil)
 
 	// Create router with mock auth middleware
 	r := gin.New()
@@ -277,12 +374,54 @@ func TestGetConversations_Success(t *testing.T) {
 	assert.Equal(t, "DM", response[0].Type)
 	assert.Equal(t, "Bob", response[0].TargetName)
 	assert.Equal(t, 3, response[0].UnreadCount)
+	if assert.NotNil(t, response[0].IsOnline) {
+		assert.True(t, *response[0].IsOnline)
+	}
 	assert.Equal(t, "GROUP", response[1].Type)
 	assert.Equal(t, "Family", response[1].TargetName)
+	assert.Equal(t, 2, response[1].MemberCount)
+	assert.Nil(t, response[1].IsOnline)
 
 	mockConvRepo.AssertExpectations(t)
-	mockUserRepo.AssertExpectations(t)
-	mockGroupRepo.AssertExpectations(t)
+	mockUserRepo.AssertNotCalled(t, "FindByID", mock.Anything, mock.Anything)
+	mockGroupRepo.AssertNotCalled(t, "GetMembers", mock.Anything, mock.Anything)
+}
+
+func TestGetConversations_PageAndFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockConvRepo := new(MockConversationRepo)
+	handler := NewChatHandler(mockConvRepo, new(MockMessageRepo), new(MockUserRepo), new(MockGroupRepo), new(MockMessageService))
+
+	userID := uuid.New()
+	beforeID := uuid.New()
+	archived := false
+	mockConvRepo.On("FindInbox", mock.AnythingOfType("*context.timerCtx"), userID, repository.InboxFilter{
+		Type:       "GROUP",
+		UnreadOnly: true,
+		Archived:   &archived,
+		BeforeID:   &beforeID,
+		Limit:      100,
+	}).Return([]models.InboxEntry{}, nil)
+
+	r := gin.New()
+	r.GET("/conversations", mockAuthMiddleware(userID), handler.GetConversations)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/conversations?limit=500&before_id="+beforeID.String()+"&type=group&unread=true&archived=false", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+	mockConvRepo.AssertExpectations(t)
+
+	// Invalid filters are rejected
+	for _, query := range []string{"type=channel", "unread=maybe", "archived=maybe", "before_id=nope"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/conversations?"+query, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
 }
 
 func TestGetConversations_Unauthorized(t *testing.T) {
@@ -343,7 +482,7 @@ func TestGetMessages_DM_Success(t *testing.T) {
 
 	// Mock expectations
 	mockMsgService.On("GetHistory", mock.AnythingOfType("*context.timerCtx"), userID, targetID, mock.Anything, 50, (*uuid.UUID)(nil)).Return(messages, nil)
-	mockConvRepo.On("ResetUnread", mock.AnythingOfType("*context.timerCtx"), userID, "DM", targetID).Return(nil)
+	mockMsgService.On("MarkConversationRead", mock.AnythingOfType("*context.timerCtx"), userID, "DM", targetID).Return(nil)
 
 	// Create router with mock auth middleware
 	r := gin.New()
@@ -394,7 +533,7 @@ func TestGetMessages_Group_Success(t *testing.T) {
 	// Mock expectations
 	mockGroupRepo.On("IsMember", mock.AnythingOfType("*context.timerCtx"), groupID, userID).Return(true, nil)
 	mockMsgService.On("GetHistory", mock.AnythingOfType("*context.timerCtx"), userID, groupID, mock.Anything, 50, (*uuid.UUID)(nil)).Return(messages, nil)
-	mockConvRepo.On("ResetUnread", mock.AnythingOfType("*context.timerCtx"), userID, "GROUP", groupID).Return(nil)
+	mockMsgService.On("MarkConversationRead", mock.AnythingOfType("*context.timerCtx"), userID, "GROUP", groupID).Return(nil)
 
 	// Create router with mock auth middleware
 	r := gin.New()
@@ -529,7 +668,7 @@ func TestGetMessages_CustomLimit(t *testing.T) {
 
 	// Mock expectations - should use limit=20
 	mockMsgService.On("GetHistory", mock.AnythingOfType("*context.timerCtx"), userID, targetID, mock.Anything, 20, (*uuid.UUID)(nil)).Return(messages, nil)
-	mockConvRepo.On("ResetUnread", mock.AnythingOfType("*context.timerCtx"), userID, "DM", targetID).Return(nil)
+	mockMsgService.On("MarkConversationRead", mock.AnythingOfType("*context.timerCtx"), userID, "DM", targetID).Return(nil)
 
 	// Create router with mock auth middleware
 	r := gin.New()
@@ -547,6 +686,55 @@ func TestGetMessages_CustomLimit(t *testing.T) {
 	mockConvRepo.AssertExpectations(t)
 }
 
+func TestGetUnread_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	mockMsgService := new(MockMessageService)
+	handler := NewChatHandler(new(MockConversationRepo), new(MockMessageRepo), new(MockUserRepo), new(MockGroupRepo), mockMsgService)
+
+	userID := uuid.New()
+	groupID := uuid.New()
+	badge := &protocol.BadgePayload{
+		TotalUnread:   4,
+		TotalMentions: 1,
+		Conversations: []protocol.ConversationBadge{
+			{ConversationType: "GROUP", TargetID: groupID, UnreadCount: 4, MentionCount: 1},
+		},
+	}
+	mockMsgService.On("GetUnreadCounts", mock.AnythingOfType("*context.timerCtx"), userID).Return(badge, nil)
+
+	r := gin.New()
+	r.GET("/me/unread", mockAuthMiddleware(userID), handler.GetUnread)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me/unread", nil)
+	r.ServeHTTP(w, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response protocol.BadgePayload
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, *badge, response)
+	mockMsgService.AssertExpectations(t)
+}
+
+func TestGetUnread_Unauthorized(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := NewChatHandler(new(MockConversationRepo), new(MockMessageRepo), new(MockUserRepo), new(MockGroupRepo), new(MockMessageService))
+
+	r := gin.New()
+	r.GET("/me/unread", handler.GetUnread)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me/unread", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
 func TestMarkRead_Success(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -674,3 +862,101 @@ func TestGetReceipts_InvalidID(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
+
+func TestUpdateConversation_MuteAndPin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockConvRepo := new(MockConversationRepo)
+	handler := NewChatHandler(mockConvRepo, new(MockMessageRepo), new(MockUserRepo), new(MockGroupRepo), new(MockMessageService))
+
+	userID := uuid.New()
+	groupID := uuid.New()
+	conv := &models.Conversation{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: userID, Type: "GROUP", TargetID: groupID, Archived: true}
+	mockConvRepo.On("FindOne", mock.AnythingOfType("*context.timerCtx"), userID, "GROUP", groupID).Return(conv, nil)
+	mockConvRepo.On("UpdateSettings", mock.AnythingOfType("*context.timerCtx"), conv).Return(nil)
+
+	r := gin.New()
+	r.PATCH("/conversations/:type/:target_id", mockAuthMiddleware(userID), handler.UpdateConversation)
+
+	until := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/conversations/group/"+groupID.String(), strings.NewReader(`{"muted_until":"`+until+`","pinned":true}`))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, conv.Muted, "muted_until implies muted")
+	assert.NotNil(t, conv.MutedUntil)
+	assert.True(t, conv.Pinned)
+	assert.True(t, conv.Archived, "omitted fields are left unchanged")
+
+	// Unmuting clears the end time
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/conversations/GROUP/"+groupID.String(), strings.NewReader(`{"muted":false}`))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, conv.Muted)
+	assert.Nil(t, conv.MutedUntil)
+	mockConvRepo.AssertNumberOfCalls(t, "UpdateSettings", 2)
+}
+
+func TestUpdateConversation_MutingUpdatesBadge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockConvRepo := new(MockConversationRepo)
+	mockMsgService := new(MockMessageService)
+	handler := NewChatHandler(mockConvRepo, new(MockMessageRepo), new(MockUserRepo), new(MockGroupRepo), mockMsgService)
+
+	userID, targetID := uuid.New(), uuid.New()
+	conv := &models.Conversation{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: userID, Type: "DM", TargetID: targetID, UnreadCount: 3}
+	mockConvRepo.On("FindOne", mock.AnythingOfType("*context.timerCtx"), userID, "DM", targetID).Return(conv, nil)
+	mockConvRepo.On("UpdateSettings", mock.AnythingOfType("*context.timerCtx"), conv).Return(nil)
+	mockMsgService.On("PushBadge", mock.AnythingOfType("*context.timerCtx"), userID).Return()
+
+	r := gin.New()
+	r.PATCH("/conversations/:type/:target_id", mockAuthMiddleware(userID), handler.UpdateConversation)
+
+	// Muting and unmuting change the badge, pinning doesn't
+	for _, body := range []string{`{"muted":true}`, `{"pinned":true}`, `{"muted":false}`} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/conversations/DM/"+targetID.String(), strings.NewReader(body))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	mockMsgService.AssertNumberOfCalls(t, "PushBadge", 2)
+}
+
+func TestUpdateConversation_Errors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockConvRepo := new(MockConversationRepo)
+	handler := NewChatHandler(mockConvRepo, new(MockMessageRepo), new(MockUserRepo), new(MockGroupRepo), new(MockMessageService))
+
+	userID := uuid.New()
+	targetID := uuid.New()
+	mockConvRepo.On("FindOne", mock.AnythingOfType("*context.timerCtx"), userID, "DM", targetID).Return(nil, nil)
+
+	r := gin.New()
+	r.PATCH("/conversations/:type/:target_id", mockAuthMiddleware(userID), handler.UpdateConversation)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int
+	}{
+		{"unknown type", "/conversations/channel/" + targetID.String(), `{"pinned":true}`, http.StatusBadRequest},
+		{"past muted_until", "/conversations/DM/" + targetID.String(), `{"muted_until":"2000-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"no conversation yet", "/conversations/DM/" + targetID.String(), `{"archived":true}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PATCH", tt.path, strings.NewReader(tt.body))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+	mockConvRepo.AssertNotCalled(t, "UpdateSettings", mock.Anything, mock.Anything)
+}
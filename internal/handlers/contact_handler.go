@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"chat-app/internal/errors"
+	"chat-app/internal/middleware"
+	"chat-app/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ContactHandler struct {
+	service service.ContactService
+}
+
+func NewContactHandler(service service.ContactService) *ContactHandler {
+	return &ContactHandler{service: service}
+}
+
+type ContactRequestRequest struct {
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+}
+
+// ListContacts handles GET /contacts
+// Returns the accepted contacts with their online status, as far as they share it.
+func (h *ContactHandler) ListContacts(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	contacts, err := h.service.ListContacts(ctx, userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, contacts)
+}
+
+// ListRequests handles GET /contacts/requests
+// Returns the open requests the user received and sent.
+func (h *ContactHandler) ListRequests(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	requests, err := h.service.ListRequests(ctx, userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// SendRequest handles POST /contacts/requests
+// If the other user already sent a request, it is accepted instead.
+func (h *ContactHandler) SendRequest(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req ContactRequestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	contact, err := h.service.SendRequest(ctx, userID, req.UserID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, contact)
+}
+
+// AcceptRequest handles POST /contacts/requests/:id/accept
+func (h *ContactHandler) AcceptRequest(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	requestID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	contact, err := h.service.AcceptRequest(ctx, userID, requestID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+// DeclineRequest handles POST /contacts/requests/:id/decline
+func (h *ContactHandler) DeclineRequest(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	requestID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.service.DeclineRequest(ctx, userID, requestID); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contact request declined"})
+}
+
+// RemoveContact handles DELETE /contacts/:userId
+// Removes a contact, or cancels a request to or from the user.
+func (h *ContactHandler) RemoveContact(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	otherID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.service.RemoveContact(ctx, userID, otherID); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contact removed"})
+}
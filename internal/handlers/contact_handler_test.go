@@ -0,0 +1,124 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chat-app/internal/errors"
+	"chat-app/internal/handlers"
+	"chat-app/internal/models"
+	"chat-app/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockContactService
+type MockContactService struct {
+	mock.Mock
+}
+
+func (m *MockContactService) SendRequest(ctx context.Context, userID, targetID uuid.UUID) (*models.Contact, error) {
+	args := m.Called(ctx, userID, targetID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Contact), args.Error(1)
+}
+
+func (m *MockContactService) AcceptRequest(ctx context.Context, userID, requestID uuid.UUID) (*models.Contact, error) {
+	args := m.Called(ctx, userID, requestID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Contact), args.Error(1)
+}
+
+func (m *MockContactService) DeclineRequest(ctx context.Context, userID, requestID uuid.UUID) error {
+	args := m.Called(ctx, userID, requestID)
+	return args.Error(0)
+}
+
+func (m *MockContactService) RemoveContact(ctx context.Context, userID, otherID uuid.UUID) error {
+	args := m.Called(ctx, userID, otherID)
+	return args.Error(0)
+}
+
+func (m *MockContactService) ListContacts(ctx context.Context, userID uuid.UUID) ([]models.PublicUser, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.PublicUser), args.Error(1)
+}
+
+func (m *MockContactService) ListRequests(ctx context.Context, userID uuid.UUID) (*service.ContactRequests, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.ContactRequests), args.Error(1)
+}
+
+func setupContactTest() (*handlers.ContactHandler, *MockContactService, *gin.Engine, uuid.UUID) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(MockContactService)
+	handler := handlers.NewContactHandler(mockService)
+	userID := uuid.New()
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userID", userID) })
+	return handler, mockService, r, userID
+}
+
+func TestSendContactRequest(t *testing.T) {
+	handler, mockService, r, userID := setupContactTest()
+	r.POST("/contacts/requests", handler.SendRequest)
+
+	req, _ := http.NewRequest("POST", "/contacts/requests", bytes.NewBufferString(`{}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	targetID := uuid.New()
+	mockService.On("SendRequest", mock.AnythingOfType("*context.timerCtx"), userID, targetID).
+		Return(&models.Contact{UserID: userID, ContactID: targetID, Status: models.ContactPending}, nil)
+	req, _ = http.NewRequest("POST", "/contacts/requests", bytes.NewBufferString(`{"user_id":"`+targetID.String()+`"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"PENDING"`)
+}
+
+func TestAcceptContactRequest_NotFound(t *testing.T) {
+	handler, mockService, r, userID := setupContactTest()
+	r.POST("/contacts/requests/:id/accept", handler.AcceptRequest)
+
+	requestID := uuid.New()
+	mockService.On("AcceptRequest", mock.AnythingOfType("*context.timerCtx"), userID, requestID).Return(nil, errors.ErrContactNotFound)
+
+	req, _ := http.NewRequest("POST", "/contacts/requests/"+requestID.String()+"/accept", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "CONTACT_NOT_FOUND")
+}
+
+func TestListContacts(t *testing.T) {
+	handler, mockService, r, userID := setupContactTest()
+	r.GET("/contacts", handler.ListContacts)
+
+	online := true
+	mockService.On("ListContacts", mock.AnythingOfType("*context.timerCtx"), userID).
+		Return([]models.PublicUser{{ID: uuid.New(), Username: "bob", IsOnline: &online}}, nil)
+
+	req, _ := http.NewRequest("GET", "/contacts", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"is_online":true`)
+}
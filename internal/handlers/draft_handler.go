@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"chat-app/internal/errors"
+	"chat-app/internal/middleware"
+	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+	"chat-app/internal/service"
+	"chat-app/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// DraftSyncer sends a saved draft to the user's connected devices (websocket.Hub).
+type DraftSyncer interface {
+	SyncDraft(draft *models.Draft, except *websocket.Client)
+}
+
+type DraftHandler struct {
+	service service.DraftService
+	syncer  DraftSyncer
+}
+
+func NewDraftHandler(service service.DraftService, syncer DraftSyncer) *DraftHandler {
+	return &DraftHandler{service: service, syncer: syncer}
+}
+
+type SaveDraftRequest struct {
+	Content string `json:"content" binding:"max=4000"`
+}
+
+// SaveDraft handles PUT /conversations/:type/:target_id/draft
+// Saves the message being typed and sends it to the user's connected devices.
+// An empty content clears the draft.
+func (h *DraftHandler) SaveDraft(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	convType := strings.ToUpper(c.Param("type"))
+	if convType != "DM" && convType != "GROUP" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be DM or GROUP"})
+		return
+	}
+
+	targetID, err := uuid.Parse(c.Param("target_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid target_id"})
+		return
+	}
+
+	var req SaveDraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	draft, err := h.service.SaveDraft(ctx, userID, convType, targetID, req.Content)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	h.syncer.SyncDraft(draft, nil)
+
+	c.JSON(http.StatusOK, protocol.DraftPayload{
+		ConversationType: draft.Type,
+		TargetID:         draft.TargetID,
+		Content:          draft.Content,
+		UpdatedAt:        draft.UpdatedAt,
+	})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+	"chat-app/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockDraftService
+type MockDraftService struct {
+	mock.Mock
+}
+
+func (m *MockDraftService) SaveDraft(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, content string) (*models.Draft, error) {
+	args := m.Called(ctx, userID, convType, targetID, content)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Draft), args.Error(1)
+}
+
+func (m *MockDraftService) ListDrafts(ctx context.Context, userID uuid.UUID) ([]models.Draft, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Draft), args.Error(1)
+}
+
+// recordingSyncer records the drafts sent to devices.
+type recordingSyncer struct {
+	drafts []*models.Draft
+}
+
+func (s *recordingSyncer) SyncDraft(draft *models.Draft, except *websocket.Client) {
+	s.drafts = append(s.drafts, draft)
+}
+
+func TestSaveDraft_SyncsDevices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(MockDraftService)
+	syncer := &recordingSyncer{}
+	handler := NewDraftHandler(mockService, syncer)
+
+	userID, targetID := uuid.New(), uuid.New()
+	draft := &models.Draft{BaseModel: models.BaseModel{UpdatedAt: time.Now()}, UserID: userID, Type: "DM", TargetID: targetID, Content: "Hel"}
+	mockService.On("SaveDraft", mock.AnythingOfType("*context.timerCtx"), userID, "DM", targetID, "Hel").Return(draft, nil)
+
+	r := gin.New()
+	r.PUT("/conversations/:type/:target_id/draft", mockAuthMiddleware(userID), handler.SaveDraft)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/conversations/dm/"+targetID.String()+"/draft", strings.NewReader(`{"content":"Hel"}`))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response protocol.DraftPayload
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "Hel", response.Content)
+	assert.Equal(t, []*models.Draft{draft}, syncer.drafts)
+}
+
+func TestSaveDraft_NotGroupMember(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(MockDraftService)
+	syncer := &recordingSyncer{}
+	handler := NewDraftHandler(mockService, syncer)
+
+	userID, groupID := uuid.New(), uuid.New()
+	mockService.On("SaveDraft", mock.AnythingOfType("*context.timerCtx"), userID, "GROUP", groupID, "Hi").Return(nil, errors.ErrForbidden)
+
+	r := gin.New()
+	r.PUT("/conversations/:type/:target_id/draft", mockAuthMiddleware(userID), handler.SaveDraft)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/conversations/GROUP/"+groupID.String()+"/draft", strings.NewReader(`{"content":"Hi"}`))
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, syncer.drafts)
+}
+
+func TestGetConversations_IncludesDrafts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockConvRepo := new(MockConversationRepo)
+	handler := NewChatHandler(mockConvRepo, new(MockMessageRepo), new(MockUserRepo), new(MockGroupRepo), new(MockMessageService))
+
+	userID, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	mockConvRepo.On("FindInbox", mock.AnythingOfType("*context.timerCtx"), userID, mock.Anything).Return([]models.InboxEntry{
+		{Conversation: models.Conversation{UserID: userID, Type: "DM", TargetID: bob}},
+		{Conversation: models.Conversation{UserID: userID, Type: "DM", TargetID: carol}, Draft: "See you"},
+	}, nil)
+
+	r := gin.New()
+	r.GET("/conversations", mockAuthMiddleware(userID), handler.GetConversations)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/conversations", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response []ConversationResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response, 2)
+	assert.Empty(t, response[0].Draft)
+	assert.Equal(t, "See you", response[1].Draft)
+}
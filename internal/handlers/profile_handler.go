@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"time"
+
+	"chat-app/internal/errors"
+	"chat-app/internal/middleware"
+	"chat-app/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// DefaultMaxAvatarBytes is the default size limit of avatar uploads.
+const DefaultMaxAvatarBytes = 2 << 20
+
+type ProfileHandler struct {
+	service service.ProfileService
+
+	// MaxAvatarBytes limits avatar uploads; larger images are refused with 413.
+	MaxAvatarBytes int64
+}
+
+func NewProfileHandler(service service.ProfileService) *ProfileHandler {
+	return &ProfileHandler{service: service, MaxAvatarBytes: DefaultMaxAvatarBytes}
+}
+
+// UpdateProfileRequest changes the fields that are present. An empty status_text
+// clears the status; status_expires_at is only read along with status_text.
+type UpdateProfileRequest struct {
+	DisplayName     *string    `json:"display_name" binding:"omitempty,max=64"`
+	Bio             *string    `json:"bio" binding:"omitempty,max=500"`
+	StatusText      *string    `json:"status_text" binding:"omitempty,max=140"`
+	StatusExpiresAt *time.Time `json:"status_expires_at"`
+}
+
+// UpdatePrivacyRequest changes the settings that are present.
+type UpdatePrivacyRequest struct {
+	EmailVisibility    *string `json:"email_visibility" binding:"omitempty,oneof=everyone contacts nobody"`
+	LastSeenVisibility *string `json:"last_seen_visibility" binding:"omitempty,oneof=everyone contacts nobody"`
+	OnlineVisibility   *string `json:"online_visibility" binding:"omitempty,oneof=everyone contacts nobody"`
+	DMPolicy           *string `json:"dm_policy" binding:"omitempty,oneof=everyone contacts"`
+}
+
+// GetMe handles GET /me
+// Returns the user's own account and profile.
+func (h *ProfileHandler) GetMe(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.service.GetProfile(ctx, userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// SearchUsers handles GET /users?q=
+// Matches usernames and display names, or an exact email its owner made public.
+func (h *ProfileHandler) SearchUsers(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	users, err := h.service.SearchUsers(ctx, userID, c.Query("q"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// GetUser handles GET /users/:id
+// Returns the public profile, with email and presence as the user's privacy settings allow.
+func (h *ProfileHandler) GetUser(c *gin.Context) {
+	viewerID := middleware.GetUserIDFromContext(c)
+	if viewerID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.service.GetPublicProfile(ctx, viewerID, userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateMe handles PATCH /me
+// Contacts are sent a profile_updated event.
+func (h *ProfileHandler) UpdateMe(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.service.UpdateProfile(ctx, userID, service.ProfileUpdate{
+		DisplayName:     req.DisplayName,
+		Bio:             req.Bio,
+		StatusText:      req.StatusText,
+		StatusExpiresAt: req.StatusExpiresAt,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UploadAvatar handles PUT /me/avatar
+// Takes a multipart form with the image in the "avatar" field.
+func (h *ProfileHandler) UploadAvatar(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	// 1. Read the image, refusing oversized uploads early
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.MaxAvatarBytes+64<<10) // Room for the multipart framing
+	file, header, err := c.Request.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if stderrors.As(err, &tooLarge) {
+			respondError(c, errors.ErrAvatarTooLarge)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": "avatar file is required"})
+		return
+	}
+	defer file.Close()
+	if header.Size > h.MaxAvatarBytes {
+		respondError(c, errors.ErrAvatarTooLarge)
+		return
+	}
+	image, err := io.ReadAll(io.LimitReader(file, h.MaxAvatarBytes+1))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if int64(len(image)) > h.MaxAvatarBytes {
+		respondError(c, errors.ErrAvatarTooLarge)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	// 2. Store it
+	user, err := h.service.SetAvatar(ctx, userID, image)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteAvatar handles DELETE /me/avatar
+func (h *ProfileHandler) DeleteAvatar(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.service.RemoveAvatar(ctx, userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdatePrivacy handles PATCH /me/privacy
+// Sets who else sees the email, last seen time and online status, and who may send DMs.
+func (h *ProfileHandler) UpdatePrivacy(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req UpdatePrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrValidation.Message, "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.service.UpdatePrivacy(ctx, userID, service.PrivacySettings{
+		EmailVisibility:    req.EmailVisibility,
+		LastSeenVisibility: req.LastSeenVisibility,
+		OnlineVisibility:   req.OnlineVisibility,
+		DMPolicy:           req.DMPolicy,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,197 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chat-app/internal/errors"
+	"chat-app/internal/handlers"
+	"chat-app/internal/models"
+	"chat-app/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockProfileService
+type MockProfileService struct {
+	mock.Mock
+}
+
+func (m *MockProfileService) GetProfile(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockProfileService) UpdateProfile(ctx context.Context, userID uuid.UUID, update service.ProfileUpdate) (*models.User, error) {
+	args := m.Called(ctx, userID, update)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockProfileService) SetAvatar(ctx context.Context, userID uuid.UUID, image []byte) (*models.User, error) {
+	args := m.Called(ctx, userID, image)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockProfileService) RemoveAvatar(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockProfileService) GetPublicProfile(ctx context.Context, viewerID, userID uuid.UUID) (*models.PublicUser, error) {
+	args := m.Called(ctx, viewerID, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PublicUser), args.Error(1)
+}
+
+func (m *MockProfileService) SearchUsers(ctx context.Context, viewerID uuid.UUID, query string) ([]models.PublicUser, error) {
+	args := m.Called(ctx, viewerID, query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.PublicUser), args.Error(1)
+}
+
+func (m *MockProfileService) UpdatePrivacy(ctx context.Context, userID uuid.UUID, settings service.PrivacySettings) (*models.User, error) {
+	args := m.Called(ctx, userID, settings)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func setupProfileTest() (*handlers.ProfileHandler, *MockProfileService, *gin.Engine, uuid.UUID) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(MockProfileService)
+	handler := handlers.NewProfileHandler(mockService)
+	userID := uuid.New()
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userID", userID) })
+	return handler, mockService, r, userID
+}
+
+func avatarForm(t *testing.T, image []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("avatar", "me.png")
+	require.NoError(t, err)
+	_, err = part.Write(image)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return body, w.FormDataContentType()
+}
+
+func TestUpdateMe(t *testing.T) {
+	handler, mockService, r, userID := setupProfileTest()
+	r.PATCH("/me", handler.UpdateMe)
+
+	// Limits are enforced
+	req, _ := http.NewRequest("PATCH", "/me", bytes.NewBufferString(`{"status_text":"`+string(bytes.Repeat([]byte("a"), 141))+`"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	name := "Alice"
+	mockService.On("UpdateProfile", mock.AnythingOfType("*context.timerCtx"), userID, service.ProfileUpdate{DisplayName: &name}).
+		Return(&models.User{DisplayName: "Alice"}, nil)
+	req, _ = http.NewRequest("PATCH", "/me", bytes.NewBufferString(`{"display_name":"Alice"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"display_name":"Alice"`)
+}
+
+func TestUploadAvatar(t *testing.T) {
+	handler, mockService, r, userID := setupProfileTest()
+	handler.MaxAvatarBytes = 16
+	r.PUT("/me/avatar", handler.UploadAvatar)
+
+	// Too large
+	body, contentType := avatarForm(t, bytes.Repeat([]byte("x"), 17))
+	req, _ := http.NewRequest("PUT", "/me/avatar", body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Not an image
+	mockService.On("SetAvatar", mock.AnythingOfType("*context.timerCtx"), userID, []byte("hello")).Return(nil, errors.ErrInvalidAvatar)
+	body, contentType = avatarForm(t, []byte("hello"))
+	req, _ = http.NewRequest("PUT", "/me/avatar", body)
+	req.Header.Set("Content-Type", contentType)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "PROFILE_INVALID_AVATAR")
+
+	// Missing file
+	req, _ = http.NewRequest("PUT", "/me/avatar", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetUser_ReturnsPublicProfile(t *testing.T) {
+	handler, mockService, r, viewerID := setupProfileTest()
+	r.GET("/users/:id", handler.GetUser)
+
+	target := uuid.New()
+	mockService.On("GetPublicProfile", mock.AnythingOfType("*context.timerCtx"), viewerID, target).
+		Return(&models.PublicUser{ID: target, Username: "alice"}, nil)
+
+	req, _ := http.NewRequest("GET", "/users/"+target.String(), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"username":"alice"`)
+	assert.NotContains(t, w.Body.String(), "email")
+	assert.NotContains(t, w.Body.String(), "is_online")
+}
+
+func TestUpdatePrivacy_ValidatesValues(t *testing.T) {
+	handler, mockService, r, userID := setupProfileTest()
+	r.PATCH("/me/privacy", handler.UpdatePrivacy)
+
+	req, _ := http.NewRequest("PATCH", "/me/privacy", bytes.NewBufferString(`{"email_visibility":"friends"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	nobody := "nobody"
+	mockService.On("UpdatePrivacy", mock.AnythingOfType("*context.timerCtx"), userID, service.PrivacySettings{EmailVisibility: &nobody}).
+		Return(&models.User{EmailVisibility: nobody}, nil)
+	req, _ = http.NewRequest("PATCH", "/me/privacy", bytes.NewBufferString(`{"email_visibility":"nobody"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"email_visibility":"nobody"`)
+
+	// DMs can be limited to contacts, not closed
+	req, _ = http.NewRequest("PATCH", "/me/privacy", bytes.NewBufferString(`{"dm_policy":"nobody"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
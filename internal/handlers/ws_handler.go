@@ -3,49 +3,86 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"chat-app/internal/middleware"
+	"chat-app/internal/protocol"
 	"chat-app/internal/service"
 	"chat-app/internal/websocket"
+	"chat-app/pkg/jwt"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	gorilla "github.com/gorilla/websocket"
 )
 
+// authTimeout bounds how long an unauthenticated connection may take to send its auth frame.
+const authTimeout = 5 * time.Second
+
 type WSHandler struct {
-	hub         *websocket.Hub
-	authService service.AuthService
-	MsgService  service.MessageService
+	hub           *websocket.Hub
+	authService   service.AuthService
+	ticketService service.WSTicketService
+	MsgService    service.MessageService
+	Drafts        service.DraftService
 }
 
-func NewWSHandler(hub *websocket.Hub, authService service.AuthService) *WSHandler {
+func NewWSHandler(hub *websocket.Hub, authService service.AuthService, ticketService service.WSTicketService) *WSHandler {
 	return &WSHandler{
-		hub:         hub,
-		authService: authService,
+		hub:           hub,
+		authService:   authService,
+		ticketService: ticketService,
 	}
 }
 
 var upgrader = gorilla.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	// Wire encodings clients may negotiate via Sec-WebSocket-Protocol
+	Subprotocols: protocol.Subprotocols(),
 	// Helper to check origin for CORS
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all for MVP. Lock down in production.
 	},
 }
 
-func (h *WSHandler) ServeWS(c *gin.Context) {
-	// 1. Auth Check (Token in Query Param)
-	token := c.Query("token")
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization token required"})
+// IssueTicket mints a single-use ticket for opening a WebSocket via GET /ws?ticket=...
+func (h *WSHandler) IssueTicket(c *gin.Context) {
+	userID := middleware.GetUserIDFromContext(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	userID, err := h.authService.ValidateToken(token)
+	ticket, expiresAt, err := h.ticketService.Issue(userID, middleware.GetSessionIDFromContext(c), middleware.GetTokenExpiryFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ticket":     ticket,
+		"expires_at": expiresAt,
+	})
+}
+
+// ServeWS upgrades the connection once the client has authenticated. Credentials
+// are accepted, in order of precedence, as a single-use ticket (?ticket=), as a
+// "bearer.<jwt>" Sec-WebSocket-Protocol entry, or as an auth frame sent first
+// after the upgrade. Access tokens are never read from the URL, which ends up in logs.
+// The connection is closed when its token expires unless the client sends reauth.
+func (h *WSHandler) ServeWS(c *gin.Context) {
+	// 1. Auth Check (ticket or subprotocol bearer token)
+	claims, err := h.authenticateRequest(c.Request)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 		return
 	}
+	if claims == nil && !gorilla.IsWebSocketUpgrade(c.Request) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
+		return
+	}
 
 	// 2. Upgrade to WebSocket
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -54,15 +91,28 @@ func (h *WSHandler) ServeWS(c *gin.Context) {
 		return
 	}
 
-	// 3. Register Client
 	client := &websocket.Client{
-		Hub:        h.hub,
-		Conn:       conn,
-		Send:       make(chan []byte, 256),
-		UserID:     userID,
-		MsgService: h.MsgService,
+		Hub:             h.hub,
+		Conn:            conn,
+		Send:            make(chan []byte, 256),
+		MsgService:      h.MsgService,
+		Drafts:          h.Drafts,
+		Codec:           protocol.CodecFor(conn.Subprotocol()),
+		ProtocolVersion: protocol.MinVersion,
+		Authenticator:   h.authenticateFrame,
 	}
 
+	// 3. In-band auth for clients that presented no credentials during the upgrade
+	if claims != nil {
+		client.UserID = claims.UserID
+		client.SessionID = claims.SessionID
+		client.SetTokenExpiry(claims.ExpiresAt)
+	} else if err := client.Authenticate(authTimeout); err != nil {
+		log.Println("WebSocket authentication failed:", err)
+		return
+	}
+
+	// 4. Register Client
 	h.hub.Register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
@@ -70,3 +120,25 @@ func (h *WSHandler) ServeWS(c *gin.Context) {
 	go client.WritePump()
 	go client.ReadPump()
 }
+
+// authenticateRequest resolves credentials carried by the upgrade request itself.
+// It returns nil claims without error when there are none.
+func (h *WSHandler) authenticateRequest(r *http.Request) (*jwt.Claims, error) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		return h.ticketService.Redeem(ticket)
+	}
+	for _, sub := range gorilla.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(sub, protocol.SubprotocolBearerPrefix); ok {
+			return h.authService.ParseToken(token)
+		}
+	}
+	return nil, nil
+}
+
+// authenticateFrame resolves the credentials of auth and reauth frames.
+func (h *WSHandler) authenticateFrame(p *protocol.AuthPayload) (*jwt.Claims, error) {
+	if p.Ticket != "" {
+		return h.ticketService.Redeem(p.Ticket)
+	}
+	return h.authService.ParseToken(p.Token)
+}
@@ -5,7 +5,10 @@ import (
 	"chat-app/internal/errors"
 	"chat-app/internal/handlers"
 	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+	"chat-app/internal/service"
 	"chat-app/internal/websocket"
+	"chat-app/pkg/jwt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -54,35 +57,97 @@ func (m *MockUserRepository) Search(ctx context.Context, query string, excludeUs
 	return args.Get(0).([]models.User), args.Error(1)
 }
 
-// MockConversationRepository to mock conversation lookups for presence broadcasting
-type MockConversationRepository struct {
+func (m *MockUserRepository) UpdatePassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	args := m.Called(ctx, userID, passwordHash)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) MarkEmailVerified(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) DeleteAccount(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+
+func (m *MockUserRepository) SetTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	args := m.Called(ctx, userID, secret)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) EnableTwoFactor(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	args := m.Called(ctx, userID, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) UpdatePrivacy(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.User), args.Error(1)
+}
+
+func (m *MockUserRepository) UpdatePresence(ctx context.Context, userID uuid.UUID, presence string) error {
+	args := m.Called(ctx, userID, presence)
+	return args.Error(0)
+}
+
+// MockContactRepository to mock contact lookups for presence broadcasting
+type MockContactRepository struct {
 	mock.Mock
 }
 
-func (m *MockConversationRepository) Upsert(ctx context.Context, conv *models.Conversation) error {
-	args := m.Called(ctx, conv)
+func (m *MockContactRepository) Create(ctx context.Context, contact *models.Contact) error {
+	args := m.Called(ctx, contact)
 	return args.Error(0)
 }
 
-func (m *MockConversationRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]models.Conversation, error) {
-	args := m.Called(ctx, userID)
+func (m *MockContactRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.Contact, error) {
+	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).([]models.Conversation), args.Error(1)
+	return args.Get(0).(*models.Contact), args.Error(1)
 }
 
-func (m *MockConversationRepository) IncrementUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, lastMessage string) error {
-	args := m.Called(ctx, userID, convType, targetID, lastMessage)
+func (m *MockContactRepository) FindBetween(ctx context.Context, userID, otherID uuid.UUID) (*models.Contact, error) {
+	args := m.Called(ctx, userID, otherID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Contact), args.Error(1)
+}
+
+func (m *MockContactRepository) Accept(ctx context.Context, id uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, id, at)
 	return args.Error(0)
 }
 
-func (m *MockConversationRepository) ResetUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) error {
-	args := m.Called(ctx, userID, convType, targetID)
+func (m *MockContactRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
 	return args.Error(0)
 }
 
-func (m *MockConversationRepository) FindContactsOfUser(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+func (m *MockContactRepository) FindContactIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
 	args := m.Called(ctx, userID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -90,18 +155,49 @@ func (m *MockConversationRepository) FindContactsOfUser(ctx context.Context, use
 	return args.Get(0).([]uuid.UUID), args.Error(1)
 }
 
-func setupWSTest() (*handlers.WSHandler, *MockAuthService, *MockUserRepository, *MockConversationRepository, *gin.Engine) {
+func (m *MockContactRepository) FindPending(ctx context.Context, userID uuid.UUID) ([]models.Contact, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Contact), args.Error(1)
+}
+
+func (m *MockContactRepository) AreContacts(ctx context.Context, userID, otherID uuid.UUID) (bool, error) {
+	args := m.Called(ctx, userID, otherID)
+	return args.Bool(0), args.Error(1)
+}
+
+func setupWSTest() (*handlers.WSHandler, *MockAuthService, *MockUserRepository, *MockContactRepository, *gin.Engine) {
+	handler, mockAuthService, mockUserRepo, mockContactRepo, _, r := setupWSTestWithTickets()
+	return handler, mockAuthService, mockUserRepo, mockContactRepo, r
+}
+
+func setupWSTestWithTickets() (*handlers.WSHandler, *MockAuthService, *MockUserRepository, *MockContactRepository, service.WSTicketService, *gin.Engine) {
 	gin.SetMode(gin.TestMode)
 	mockAuthService := new(MockAuthService)
 	mockUserRepo := new(MockUserRepository)
-	mockConvRepo := new(MockConversationRepository)
+	mockContactRepo := new(MockContactRepository)
 
-	hub := websocket.NewHub(mockUserRepo, mockConvRepo)
+	hub := websocket.NewHub(mockUserRepo, mockContactRepo)
 	go hub.Run() // Start hub
 
-	handler := handlers.NewWSHandler(hub, mockAuthService)
+	tickets := service.NewWSTicketService(time.Minute)
+	handler := handlers.NewWSHandler(hub, mockAuthService, tickets)
 	r := gin.New()
-	return handler, mockAuthService, mockUserRepo, mockConvRepo, r
+	return handler, mockAuthService, mockUserRepo, mockContactRepo, tickets, r
+}
+
+// bearerDialer offers the JSON codec plus the access token as subprotocols, like a browser would.
+func bearerDialer(token string) *gorilla.Dialer {
+	return &gorilla.Dialer{Subprotocols: []string{protocol.SubprotocolJSON, protocol.SubprotocolBearerPrefix + token}}
+}
+
+// expectPresenceCalls allows the Hub's register/unregister lookups for userID.
+func expectPresenceCalls(mockRepo *MockUserRepository, mockContactRepo *MockContactRepository, userID uuid.UUID) {
+	mockRepo.On("UpdateOnlineStatus", mock.Anything, userID, mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockRepo.On("FindByID", mock.Anything, userID).Return(&models.User{BaseModel: models.BaseModel{ID: userID}}, nil).Maybe()
+	mockContactRepo.On("FindContactIDs", mock.Anything, userID).Return([]uuid.UUID{}, nil).Maybe()
 }
 
 func TestServeWS_NoToken(t *testing.T) {
@@ -119,21 +215,34 @@ func TestServeWS_InvalidToken(t *testing.T) {
 	handler, mockService, _, _, r := setupWSTest()
 	r.GET("/ws", handler.ServeWS)
 
-	mockService.On("ValidateToken", "invalid_token").Return(uuid.Nil, errors.ErrUnauthorized)
+	mockService.On("ParseToken", "invalid_token").Return(nil, errors.ErrUnauthorized)
 
-	req, _ := http.NewRequest("GET", "/ws?token=invalid_token", nil)
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	_, resp, err := bearerDialer("invalid_token").Dial("ws"+s.URL[4:]+"/ws", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestServeWS_QueryTokenIgnored(t *testing.T) {
+	handler, mockService, _, _, r := setupWSTest()
+	r.GET("/ws", handler.ServeWS)
+
+	req, _ := http.NewRequest("GET", "/ws?token=valid_token", nil)
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockService.AssertNotCalled(t, "ParseToken", mock.Anything)
 }
 
 func TestServeWS_Success(t *testing.T) {
-	handler, mockService, mockRepo, mockConvRepo, r := setupWSTest()
+	handler, mockService, mockRepo, mockContactRepo, r := setupWSTest()
 	r.GET("/ws", handler.ServeWS)
 
 	userID := uuid.New()
-	mockService.On("ValidateToken", "valid_token").Return(userID, nil)
+	mockService.On("ParseToken", "valid_token").Return(&jwt.Claims{UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}, nil)
 
 	// Expect UpdateOnlineStatus to be called with true (Online)
 	mockRepo.On("UpdateOnlineStatus", mock.AnythingOfType("*context.timerCtx"), userID, true, mock.Anything).Return(nil).Maybe()
@@ -148,22 +257,22 @@ func TestServeWS_Success(t *testing.T) {
 		IsOnline:  true,
 	}, nil).Maybe()
 
-	// Expect FindByUser to be called for initial presence sync
-	mockConvRepo.On("FindByUser", mock.AnythingOfType("*context.timerCtx"), userID).Return([]models.Conversation{}, nil).Maybe()
 
-	// Expect FindContactsOfUser to be called for presence broadcasting
-	mockConvRepo.On("FindContactsOfUser", mock.AnythingOfType("*context.timerCtx"), userID).Return([]uuid.UUID{}, nil).Maybe()
+	// Expect FindContactIDs to be called for presence broadcasting
+	mockContactRepo.On("FindContactIDs", mock.AnythingOfType("*context.timerCtx"), userID).Return([]uuid.UUID{}, nil).Maybe()
 
 	// Create a test server to handle the websocket upgrade
 	s := httptest.NewServer(r)
 	defer s.Close()
 
 	// Convert http URL to ws URL
-	u := "ws" + s.URL[4:] + "/ws?token=valid_token"
+	u := "ws" + s.URL[4:] + "/ws"
 
-	// Connect to the server
-	ws, _, err := gorilla.DefaultDialer.Dial(u, nil)
+	// Connect to the server, passing the token as a subprotocol
+	ws, resp, err := bearerDialer("valid_token").Dial(u, nil)
 	assert.NoError(t, err)
+	// Only the codec is selected; the bearer entry is never echoed back
+	assert.Equal(t, protocol.SubprotocolJSON, resp.Header.Get("Sec-WebSocket-Protocol"))
 
 	// Optionally send a ping or message to verify
 	err = ws.WriteMessage(gorilla.PingMessage, []byte{})
@@ -176,3 +285,108 @@ func TestServeWS_Success(t *testing.T) {
 	// Give it a moment to process disconnection
 	time.Sleep(50 * time.Millisecond)
 }
+
+func TestServeWS_MsgPackSubprotocol(t *testing.T) {
+	handler, mockService, mockRepo, mockContactRepo, r := setupWSTest()
+	r.GET("/ws", handler.ServeWS)
+
+	userID := uuid.New()
+	mockService.On("ParseToken", "valid_token").Return(&jwt.Claims{UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}, nil)
+	mockRepo.On("UpdateOnlineStatus", mock.Anything, userID, mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockRepo.On("FindByID", mock.Anything, userID).Return(&models.User{BaseModel: models.BaseModel{ID: userID}}, nil).Maybe()
+	mockContactRepo.On("FindContactIDs", mock.Anything, userID).Return([]uuid.UUID{}, nil).Maybe()
+
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	dialer := gorilla.Dialer{Subprotocols: []string{protocol.SubprotocolMsgPack, protocol.SubprotocolBearerPrefix + "valid_token"}}
+	ws, resp, err := dialer.Dial("ws"+s.URL[4:]+"/ws", nil)
+	assert.NoError(t, err)
+	defer ws.Close()
+	assert.Equal(t, protocol.SubprotocolMsgPack, resp.Header.Get("Sec-WebSocket-Protocol"))
+
+	// Frames are exchanged as MessagePack binary messages in both directions
+	hello, _ := protocol.MsgPack.Encode([]byte(`{"type":"hello","payload":{"version":1}}`))
+	assert.NoError(t, ws.WriteMessage(gorilla.BinaryMessage, hello))
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	msgType, frame, err := ws.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, gorilla.BinaryMessage, msgType)
+
+	decoded, err := protocol.MsgPack.Decode(frame)
+	assert.NoError(t, err)
+	assert.Contains(t, string(decoded), `"type":"welcome"`)
+}
+
+func TestServeWS_TicketIsSingleUse(t *testing.T) {
+	handler, _, mockRepo, mockContactRepo, tickets, r := setupWSTestWithTickets()
+	r.GET("/ws", handler.ServeWS)
+
+	userID := uuid.New()
+	expectPresenceCalls(mockRepo, mockContactRepo, userID)
+
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	ticket, _, err := tickets.Issue(userID, uuid.New(), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+s.URL[4:]+"/ws?ticket="+ticket, nil)
+	assert.NoError(t, err)
+	defer ws.Close()
+
+	// Replaying the same ticket is rejected
+	_, resp, err := gorilla.DefaultDialer.Dial("ws"+s.URL[4:]+"/ws?ticket="+ticket, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestServeWS_AuthFrame(t *testing.T) {
+	handler, mockService, mockRepo, mockContactRepo, r := setupWSTest()
+	r.GET("/ws", handler.ServeWS)
+
+	userID := uuid.New()
+	mockService.On("ParseToken", "valid_token").Return(&jwt.Claims{UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}, nil)
+	expectPresenceCalls(mockRepo, mockContactRepo, userID)
+
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+s.URL[4:]+"/ws", nil)
+	assert.NoError(t, err)
+	defer ws.Close()
+
+	assert.NoError(t, ws.WriteMessage(gorilla.TextMessage, []byte(`{"type":"auth","id":"a-1","payload":{"token":"valid_token"}}`)))
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var env protocol.Envelope
+	assert.NoError(t, ws.ReadJSON(&env))
+	assert.Equal(t, protocol.EventAuthenticated, env.Type)
+	assert.Equal(t, "a-1", env.ID)
+}
+
+func TestServeWS_AuthFrameRequiredFirst(t *testing.T) {
+	handler, mockService, _, _, r := setupWSTest()
+	r.GET("/ws", handler.ServeWS)
+
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	ws, _, err := gorilla.DefaultDialer.Dial("ws"+s.URL[4:]+"/ws", nil)
+	assert.NoError(t, err)
+	defer ws.Close()
+
+	assert.NoError(t, ws.WriteMessage(gorilla.TextMessage, []byte(`{"type":"hello","payload":{"version":1}}`)))
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var env protocol.Envelope
+	assert.NoError(t, ws.ReadJSON(&env))
+	assert.Equal(t, protocol.EventError, env.Type)
+	assert.Contains(t, string(env.Payload), protocol.ErrCodeUnauthorized)
+
+	// The server then closes the connection
+	_, _, err = ws.ReadMessage()
+	assert.True(t, gorilla.IsCloseError(err, gorilla.ClosePolicyViolation))
+	mockService.AssertNotCalled(t, "ParseToken", mock.Anything)
+}
@@ -0,0 +1,31 @@
+package mailer
+
+import (
+	"context"
+	"log"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email such as verification and password reset links.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// logMailer writes messages to the log instead of sending them. For development only:
+// the log then contains working verification and reset links.
+type logMailer struct{}
+
+func NewLogMailer() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Mailer: to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP is a minimal SMTP server recording one transaction.
+type fakeSMTP struct {
+	addr string
+	auth string
+	from string
+	to   string
+	data string
+	done chan struct{}
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeSMTP{addr: ln.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		f.serve(conn)
+	}()
+	return f
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH PLAIN"):
+			raw, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(line[len("AUTH PLAIN"):]))
+			f.auth = string(raw)
+			reply("235 2.7.0 Authentication successful")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			f.from = line[len("MAIL FROM:"):]
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			f.to = line[len("RCPT TO:"):]
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			f.data = b.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	srv := startFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(srv.addr)
+	m := NewSMTPMailer(SMTPConfig{Host: host, Port: port, Username: "user", Password: "pass", From: "Chat App <noreply@example.com>"})
+
+	err := m.Send(context.Background(), Message{To: "alice@example.com", Subject: "Hello", Body: "line one\nline two"})
+	require.NoError(t, err)
+	<-srv.done
+
+	assert.Equal(t, "\x00user\x00pass", srv.auth)
+	assert.Equal(t, "<noreply@example.com>", srv.from)
+	assert.Equal(t, "<alice@example.com>", srv.to)
+	assert.Contains(t, srv.data, "From: Chat App <noreply@example.com>\r\n")
+	assert.Contains(t, srv.data, "Subject: Hello\r\n")
+	assert.Contains(t, srv.data, "\r\n\r\nline one\r\nline two")
+}
+
+func TestSMTPMailer_RejectsHeaderInjection(t *testing.T) {
+	m := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: "1", From: "noreply@example.com"})
+
+	err := m.Send(context.Background(), Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hi"})
+	assert.Error(t, err)
+}
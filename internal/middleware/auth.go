@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"strings"
+	"time"
 
 	"chat-app/internal/errors"
 	"chat-app/pkg/jwt"
@@ -28,14 +29,17 @@ func AuthMiddleware(jwtService jwt.Service) gin.HandlerFunc {
 		}
 
 		tokenString := parts[1]
-		userID, err := jwtService.ValidateToken(tokenString)
+		claims, err := jwtService.ParseToken(tokenString)
 		if err != nil {
 			c.AbortWithStatusJSON(401, gin.H{"error": errors.ErrUnauthorized})
 			return
 		}
 
 		// Set userID in context for downstream handlers
-		c.Set("userID", userID)
+		c.Set("userID", claims.UserID)
+		c.Set("sessionID", claims.SessionID)
+		c.Set("tokenID", claims.TokenID)
+		c.Set("tokenExpiresAt", claims.ExpiresAt)
 		c.Next()
 	}
 }
@@ -53,3 +57,31 @@ func GetUserIDFromContext(c *gin.Context) uuid.UUID {
 	}
 	return userID
 }
+
+// GetTokenExpiryFromContext returns when the access token of the request expires.
+// Returns the zero time if not found.
+func GetTokenExpiryFromContext(c *gin.Context) time.Time {
+	val, exists := c.Get("tokenExpiresAt")
+	if !exists {
+		return time.Time{}
+	}
+	expiresAt, _ := val.(time.Time)
+	return expiresAt
+}
+
+// GetSessionIDFromContext returns the session (refresh token family) the access
+// token was issued for. Returns uuid.Nil if not found.
+func GetSessionIDFromContext(c *gin.Context) uuid.UUID {
+	val, exists := c.Get("sessionID")
+	if !exists {
+		return uuid.Nil
+	}
+	sessionID, _ := val.(uuid.UUID)
+	return sessionID
+}
+
+// GetTokenIDFromContext returns the jti of the access token of the request.
+// Returns "" if not found.
+func GetTokenIDFromContext(c *gin.Context) string {
+	return c.GetString("tokenID")
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"log"
+	"strconv"
+
+	"chat-app/internal/errors"
+	"chat-app/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFunc picks the rate limit bucket of a request.
+type KeyFunc func(c *gin.Context) string
+
+// ClientIPKey gives every client IP its own bucket.
+func ClientIPKey(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// RateLimit rejects requests with 429 once the bucket picked by key is empty.
+// Every response carries X-RateLimit-Limit and X-RateLimit-Remaining; rejected
+// ones also carry Retry-After. If the store fails the request is let through.
+func RateLimit(limiter *ratelimit.Limiter, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := limiter.Allow(c.Request.Context(), key(c))
+		if err != nil {
+			log.Printf("Rate limiter error: %v", err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limiter.Limit().Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		if !res.Allowed {
+			retry := &errors.RateLimitError{RetryAfter: res.RetryAfter}
+			c.Header("Retry-After", strconv.Itoa(retry.RetryAfterSeconds()))
+			c.AbortWithStatusJSON(errors.ErrTooManyRequests.Status, gin.H{"error": errors.ErrTooManyRequests})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Account token purposes
+const (
+	AccountTokenVerifyEmail   = "verify_email"
+	AccountTokenResetPassword = "reset_password"
+)
+
+// AccountToken is a single-use token mailed to the user, e.g. to verify their
+// email address or reset their password. Only its hash is stored.
+type AccountToken struct {
+	BaseModel
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Purpose   string     `gorm:"size:20;not null" json:"purpose"`
+	TokenHash string     `gorm:"type:varchar(255);not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"` // Set once redeemed or superseded
+}
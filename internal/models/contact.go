@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Contact request states
+const (
+	ContactPending  = "PENDING"
+	ContactAccepted = "ACCEPTED"
+)
+
+// Contact is a contact request from UserID to ContactID. Once accepted the two
+// users are each other's contacts. There is one row per pair of users.
+type Contact struct {
+	BaseModel
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_contact_pair" json:"user_id"` // Who sent the request
+	ContactID  uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_contact_pair;index" json:"contact_id"`
+	Status     string     `gorm:"size:20;not null;default:'PENDING'" json:"status"` // PENDING or ACCEPTED
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+// Other returns the user on the other side of the request from userID.
+func (c *Contact) Other(userID uuid.UUID) uuid.UUID {
+	if c.UserID == userID {
+		return c.ContactID
+	}
+	return c.UserID
+}
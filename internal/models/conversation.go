@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,6 +15,44 @@ type Conversation struct {
 	LastMessage   string    `gorm:"size:500" json:"last_message"`
 	LastMessageAt time.Time `json:"last_message_at"`
 	UnreadCount   int       `gorm:"default:0" json:"unread_count"`
+	MentionCount  int       `gorm:"not null;default:0" json:"mention_count"` // Unread messages @mentioning the user
+
+	// Per-user settings: muted conversations don't trigger notification-style
+	// events (until MutedUntil, if set), archived ones return with the next
+	// message and pinned ones are listed first
+	Muted      bool       `gorm:"not null;default:false" json:"muted"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	Archived   bool       `gorm:"not null;default:false" json:"archived"`
+	Pinned     bool       `gorm:"not null;default:false" json:"pinned"`
+}
+
+// IsMuted reports whether the conversation is muted at the given time.
+func (c *Conversation) IsMuted(now time.Time) bool {
+	return c.Muted && (c.MutedUntil == nil || now.Before(*c.MutedUntil))
+}
+
+// InboxEntry is a conversation as the inbox lists it, with what it shows about
+// the target. ConversationRepository.FindInbox loads them in one query.
+type InboxEntry struct {
+	Conversation
+	TargetName  string // Username for DMs, group name for groups
+	MemberCount int    // Groups only
+	Draft       string
+
+	// DMs only: the target's online status and who they show it to
+	TargetOnline     bool
+	OnlineVisibility string
+	IsContact        bool
+}
+
+// OnlineStatus returns whether the target of a DM is online, or nil for groups
+// and for users who hide their status from the viewer.
+func (e *InboxEntry) OnlineStatus() *bool {
+	if strings.ToUpper(e.Type) != "DM" || !visibleTo(e.OnlineVisibility, VisibilityEveryone, e.IsContact) {
+		return nil
+	}
+	online := e.TargetOnline
+	return &online
 }
 
 // Add composite unique index
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// Draft is a message a user started typing in a conversation, kept so it can
+// be continued on their other devices. An empty Content means no draft.
+type Draft struct {
+	BaseModel
+	UserID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_user_draft" json:"user_id"`
+	Type     string    `gorm:"size:10;not null;uniqueIndex:idx_user_draft" json:"type"` // DM or GROUP
+	TargetID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_user_draft" json:"target_id"`
+	Content  string    `gorm:"type:text" json:"content"`
+}
+
+func (Draft) TableName() string {
+	return "drafts"
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RateLimitEntry holds the rate limit state of one key, shared by all server
+// instances: a token bucket (Tokens) and/or a failure counter (Failures, LastFailure).
+type RateLimitEntry struct {
+	Key         string    `gorm:"primaryKey;size:255"`
+	Tokens      float64   `gorm:"not null;default:0"`
+	Failures    int       `gorm:"not null;default:0"`
+	LastFailure time.Time `gorm:"index"`
+	UpdatedAt   time.Time `gorm:"index"`
+}
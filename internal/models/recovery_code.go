@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the user has
+// lost their authenticator. Only its hash is stored.
+type RecoveryCode struct {
+	BaseModel
+	UserID   uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	CodeHash string     `gorm:"type:varchar(255);not null" json:"-"`
+	UsedAt   *time.Time `json:"used_at,omitempty"`
+}
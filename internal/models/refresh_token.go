@@ -6,13 +6,19 @@ import (
 	"github.com/google/uuid"
 )
 
-// RefreshToken represents a long-lived session token
+// RefreshToken represents a long-lived session token.
+// Every refresh rotates the token: the old one is revoked and points at its
+// replacement, and both share a FamilyID identifying the login session.
+// IPAddress, UserAgent and LastUsedAt describe the device as of the latest refresh.
 type RefreshToken struct {
 	BaseModel
-	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
-	TokenHash string    `gorm:"type:varchar(255);not null;uniqueIndex" json:"-"` // Store hash, not raw token
-	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
-	Revoked   bool      `gorm:"default:false" json:"revoked"`
-	IPAddress string    `gorm:"size:45" json:"ip_address,omitempty"` // IPv6 can be 45 chars
-	UserAgent string    `gorm:"size:255" json:"user_agent,omitempty"`
+	UserID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	FamilyID     uuid.UUID  `gorm:"type:uuid;index" json:"family_id"`
+	TokenHash    string     `gorm:"type:varchar(255);not null;uniqueIndex" json:"-"` // Store hash, not raw token
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	Revoked      bool       `gorm:"default:false" json:"revoked"`
+	ReplacedByID *uuid.UUID `gorm:"type:uuid" json:"replaced_by_id,omitempty"` // Set once rotated
+	IPAddress    string     `gorm:"size:45" json:"ip_address,omitempty"`       // IPv6 can be 45 chars
+	UserAgent    string     `gorm:"size:255" json:"user_agent,omitempty"`
+	LastUsedAt   time.Time  `json:"last_used_at"` // Issued or last refreshed
 }
@@ -2,6 +2,30 @@ package models
 
 import (
 	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DeletedUsername is shown in place of the username of a deleted account.
+const DeletedUsername = "Deleted user"
+
+// Who may see a private field: anyone, only the user's contacts, or no one else.
+const (
+	VisibilityEveryone = "everyone"
+	VisibilityContacts = "contacts"
+	VisibilityNobody   = "nobody"
+)
+
+// Presence states. Users choose online, away, dnd or invisible; connected users
+// who haven't chosen otherwise are shown away while idle, and invisible users
+// are shown offline.
+const (
+	PresenceOnline    = "online"
+	PresenceAway      = "away"
+	PresenceDND       = "dnd"
+	PresenceInvisible = "invisible"
+	PresenceOffline   = "offline"
 )
 
 type User struct {
@@ -11,4 +35,114 @@ type User struct {
 	Password string    `gorm:"size:255;not null" json:"-"` // Never result password
 	IsOnline bool      `gorm:"default:false" json:"is_online"`
 	LastSeen time.Time `json:"last_seen"`
+	Presence string    `gorm:"size:10;not null;default:online" json:"presence"` // Chosen presence state, kept across connections
+
+	// Public profile. The status message disappears once StatusExpiresAt passes.
+	DisplayName     string     `gorm:"size:64" json:"display_name"`
+	Bio             string     `gorm:"size:500" json:"bio"`
+	AvatarURL       string     `gorm:"size:255" json:"avatar_url"`
+	StatusText      string     `gorm:"size:140" json:"status_text"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+
+	// Privacy settings (Visibility*): who else sees the email, the last seen time and the online status
+	EmailVisibility    string `gorm:"size:10;not null;default:contacts" json:"email_visibility"`
+	LastSeenVisibility string `gorm:"size:10;not null;default:everyone" json:"last_seen_visibility"`
+	OnlineVisibility   string `gorm:"size:10;not null;default:everyone" json:"online_visibility"`
+	DMPolicy           string `gorm:"size:10;not null;default:everyone" json:"dm_policy"` // Who may message the user directly: everyone or contacts
+
+	EmailVerifiedAt  *time.Time `json:"email_verified_at,omitempty"`               // Nil until the user follows the verification link
+	AccountDeletedAt *time.Time `gorm:"index" json:"account_deleted_at,omitempty"` // Set when the account was deleted and anonymized
+
+	// Two-factor authentication (TOTP). The secret is set by setup and only
+	// enforced once TwoFactorEnabledAt is set by verifying a first code.
+	TOTPSecret         string     `gorm:"size:64" json:"-"`
+	TOTPLastStep       int64      `gorm:"not null;default:0" json:"-"` // Time step of the last accepted code, which can't be used again
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at,omitempty"`
+}
+
+// AfterFind hides the placeholder username of deleted accounts, so messages they
+// sent stay readable as sent by DeletedUsername, and drops an expired status message.
+func (u *User) AfterFind(tx *gorm.DB) error {
+	if u.AccountDeletedAt != nil {
+		u.Username = DeletedUsername
+	}
+	if u.StatusExpiresAt != nil && !u.StatusExpiresAt.After(time.Now()) {
+		u.StatusText = ""
+		u.StatusExpiresAt = nil
+	}
+	return nil
+}
+
+// PublicUser is a user as shown to other users: the public profile, plus the
+// email and presence where the user's privacy settings allow.
+type PublicUser struct {
+	ID              uuid.UUID  `json:"id"`
+	Username        string     `json:"username"`
+	DisplayName     string     `json:"display_name"`
+	Bio             string     `json:"bio"`
+	AvatarURL       string     `json:"avatar_url"`
+	StatusText      string     `json:"status_text"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+
+	Email    string     `json:"email,omitempty"`
+	IsOnline *bool      `json:"is_online,omitempty"`
+	Presence string     `json:"presence,omitempty"` // With is_online: online, away or dnd
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// PublicView returns the user as seen by someone else. isContact tells whether
+// the viewer is one of the user's contacts.
+func (u *User) PublicView(isContact bool) PublicUser {
+	p := PublicUser{
+		ID:              u.ID,
+		Username:        u.Username,
+		DisplayName:     u.DisplayName,
+		Bio:             u.Bio,
+		AvatarURL:       u.AvatarURL,
+		StatusText:      u.StatusText,
+		StatusExpiresAt: u.StatusExpiresAt,
+	}
+	if u.AccountDeletedAt != nil {
+		return p
+	}
+	if visibleTo(u.EmailVisibility, VisibilityContacts, isContact) {
+		p.Email = u.Email
+	}
+	if visibleTo(u.OnlineVisibility, VisibilityEveryone, isContact) {
+		isOnline := u.IsOnline
+		p.IsOnline = &isOnline
+		if isOnline {
+			p.Presence = u.Presence
+		}
+	}
+	if visibleTo(u.LastSeenVisibility, VisibilityEveryone, isContact) && !u.LastSeen.IsZero() {
+		lastSeen := u.LastSeen
+		p.LastSeen = &lastSeen
+	}
+	return p
+}
+
+// AcceptsDMsFrom reports whether someone may send the user direct messages.
+func (u *User) AcceptsDMsFrom(isContact bool) bool {
+	return visibleTo(u.DMPolicy, VisibilityEveryone, isContact)
+}
+
+// ShowsOnlineTo reports whether the online status is visible to a viewer.
+func (u *User) ShowsOnlineTo(isContact bool) bool {
+	return visibleTo(u.OnlineVisibility, VisibilityEveryone, isContact)
+}
+
+// visibleTo applies a visibility setting; unset settings fall back to def.
+func visibleTo(visibility, def string, isContact bool) bool {
+	if visibility == "" {
+		visibility = def
+	}
+	switch visibility {
+	case VisibilityEveryone:
+		return true
+	case VisibilityContacts:
+		return isContact
+	default:
+		return false
+	}
 }
@@ -0,0 +1,12 @@
+package models
+
+import "github.com/google/uuid"
+
+// UserIdentity links a user to an account at an external identity provider
+// (OIDC single sign-on). Subjects are only unique per issuer.
+type UserIdentity struct {
+	BaseModel
+	UserID  uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Issuer  string    `gorm:"size:255;not null;uniqueIndex:idx_identity_subject" json:"issuer"`
+	Subject string    `gorm:"size:255;not null;uniqueIndex:idx_identity_subject" json:"subject"`
+}
@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// WebSocket subprotocols negotiated via Sec-WebSocket-Protocol.
+const (
+	SubprotocolJSON    = "chat.v1.json"
+	SubprotocolMsgPack = "chat.v1.msgpack"
+)
+
+// Codec converts frames between the canonical JSON encoding, which services
+// and the Hub produce, and the wire encoding a client negotiated.
+type Codec interface {
+	// Subprotocol is the Sec-WebSocket-Protocol value selecting this codec.
+	Subprotocol() string
+	// Binary reports whether frames are sent as binary WebSocket messages.
+	Binary() bool
+	// Encode converts a canonical JSON frame to the wire encoding.
+	Encode(jsonFrame []byte) ([]byte, error)
+	// Decode converts a wire frame to canonical JSON.
+	Decode(frame []byte) ([]byte, error)
+}
+
+var (
+	// JSON is the default codec, used when the client negotiates no subprotocol.
+	JSON Codec = jsonCodec{}
+	// MsgPack encodes frames as MessagePack binary messages.
+	MsgPack Codec = msgpackCodec{}
+)
+
+// Subprotocols lists the supported subprotocols in server preference order.
+func Subprotocols() []string {
+	return []string{SubprotocolMsgPack, SubprotocolJSON}
+}
+
+// CodecFor returns the codec for a negotiated subprotocol, falling back to JSON.
+func CodecFor(subprotocol string) Codec {
+	if subprotocol == SubprotocolMsgPack {
+		return MsgPack
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string                     { return SubprotocolJSON }
+func (jsonCodec) Binary() bool                            { return false }
+func (jsonCodec) Encode(jsonFrame []byte) ([]byte, error) { return jsonFrame, nil }
+func (jsonCodec) Decode(frame []byte) ([]byte, error)     { return frame, nil }
+
+// msgpackCodec transcodes frames structurally, so a MessagePack frame carries
+// exactly the same fields as its JSON counterpart (UUIDs and timestamps stay strings).
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgPack }
+func (msgpackCodec) Binary() bool        { return true }
+
+func (msgpackCodec) Encode(jsonFrame []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(jsonFrame))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(normalizeNumbers(v))
+}
+
+func (msgpackCodec) Decode(frame []byte) ([]byte, error) {
+	var v interface{}
+	if err := msgpack.Unmarshal(frame, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// normalizeNumbers turns json.Number values into int64 where possible so that
+// integers are not widened to floats on the wire.
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range t {
+			t[k] = normalizeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = normalizeNumbers(item)
+		}
+	}
+	return v
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Inbound payloads are validated by the `ws` struct tag:
+//   required   - field must be non-zero
+//   enum=A|B   - string field must be one of the listed values
+//   max=N      - string field must be at most N characters long
+// Cross-field rules are expressed by implementing Validate() error.
+
+// AuthPayload authenticates a connection that presented no credentials during the
+// upgrade. It must be the first frame and carries either an access token or a WS ticket.
+// The same payload renews the credentials of an authenticated connection via reauth.
+type AuthPayload struct {
+	Token  string `json:"token,omitempty"`
+	Ticket string `json:"ticket,omitempty"`
+}
+
+func (p *AuthPayload) Validate() error {
+	if (p.Token == "") == (p.Ticket == "") {
+		return errors.New("exactly one of token or ticket is required")
+	}
+	return nil
+}
+
+// HelloPayload opens the version handshake.
+type HelloPayload struct {
+	Version int `json:"version" ws:"required"`
+}
+
+// SetActiveConversationPayload tells the server which conversation the client is viewing.
+// A nil TargetID clears the active conversation.
+type SetActiveConversationPayload struct {
+	ConversationType string    `json:"conversation_type" ws:"required,enum=DM|GROUP"`
+	TargetID         uuid.UUID `json:"target_id"` // user ID for DM, group ID for GROUP
+}
+
+// SendMessagePayload sends a DM (ToUserID) or a group message (GroupID).
+type SendMessagePayload struct {
+	ToUserID uuid.UUID `json:"to_user_id"` // Simplified for DM
+	GroupID  uuid.UUID `json:"group_id"`   // For Group (optional)
+	Content  string    `json:"content" ws:"required,max=4000"`
+}
+
+func (p *SendMessagePayload) Validate() error {
+	if p.ToUserID == uuid.Nil && p.GroupID == uuid.Nil {
+		return errors.New("one of to_user_id or group_id is required")
+	}
+	if p.ToUserID != uuid.Nil && p.GroupID != uuid.Nil {
+		return errors.New("to_user_id and group_id are mutually exclusive")
+	}
+	return nil
+}
+
+// MessageDeliveredPayload acknowledges delivery of a message to this device. [F06]
+type MessageDeliveredPayload struct {
+	MessageID uuid.UUID `json:"message_id" ws:"required"`
+}
+
+// TypingPayload is the body of typing_start and typing_stop commands. [F07]
+type TypingPayload struct {
+	ConversationType string    `json:"conversation_type" ws:"required,enum=DM|GROUP"`
+	TargetID         uuid.UUID `json:"target_id" ws:"required"` // user ID for DM, group ID for GROUP
+}
+
+// SetPresencePayload chooses the presence state shown to contacts. Invisible
+// users appear offline but keep receiving messages.
+type SetPresencePayload struct {
+	Status string `json:"status" ws:"required,enum=online|away|dnd|invisible"`
+}
+
+// PresenceSubscriptionPayload is the body of subscribe_presence and
+// unsubscribe_presence commands. Subscribers get user_online and user_offline
+// events for the users, as far as their privacy settings allow, until they
+// unsubscribe or disconnect.
+type PresenceSubscriptionPayload struct {
+	UserIDs []uuid.UUID `json:"user_ids" ws:"required"`
+}
+
+func (p *PresenceSubscriptionPayload) Validate() error {
+	if len(p.UserIDs) == 0 {
+		return errors.New("user_ids is required")
+	}
+	if len(p.UserIDs) > MaxPresenceSubscriptions {
+		return fmt.Errorf("at most %d user_ids are allowed", MaxPresenceSubscriptions)
+	}
+	return nil
+}
+
+// SaveDraftPayload saves the message being typed in a conversation, so the
+// user's other devices can continue it. An empty Content clears the draft.
+type SaveDraftPayload struct {
+	ConversationType string    `json:"conversation_type" ws:"required,enum=DM|GROUP"`
+	TargetID         uuid.UUID `json:"target_id" ws:"required"` // user ID for DM, group ID for GROUP
+	Content          string    `json:"content" ws:"max=4000"`
+}
+
+// commands maps every client → server command type to its payload type.
+var commands = map[string]func() interface{}{
+	CmdAuth:                  func() interface{} { return &AuthPayload{} },
+	CmdReauth:                func() interface{} { return &AuthPayload{} },
+	CmdHello:                 func() interface{} { return &HelloPayload{} },
+	CmdSetActiveConversation: func() interface{} { return &SetActiveConversationPayload{} },
+	CmdSendMessage:           func() interface{} { return &SendMessagePayload{} },
+	CmdMessageDelivered:      func() interface{} { return &MessageDeliveredPayload{} },
+	CmdTypingStart:           func() interface{} { return &TypingPayload{} },
+	CmdTypingStop:            func() interface{} { return &TypingPayload{} },
+	CmdSetPresence:           func() interface{} { return &SetPresencePayload{} },
+	CmdSubscribePresence:     func() interface{} { return &PresenceSubscriptionPayload{} },
+	CmdUnsubscribePresence:   func() interface{} { return &PresenceSubscriptionPayload{} },
+	CmdSaveDraft:             func() interface{} { return &SaveDraftPayload{} },
+}
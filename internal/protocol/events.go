@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// AuthenticatedPayload answers a successful auth or reauth command.
+// ExpiresAt is when the connection will be closed unless it is renewed with reauth.
+type AuthenticatedPayload struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ReauthRequiredPayload warns that the connection's access token is about to expire.
+type ReauthRequiredPayload struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// WelcomePayload answers a hello command with the negotiated version.
+type WelcomePayload struct {
+	Version       int `json:"version"`
+	ServerVersion int `json:"server_version"`
+	MinVersion    int `json:"min_version"`
+}
+
+// AckPayload confirms that the command with the envelope's ID was processed.
+type AckPayload struct {
+	Type string `json:"type"` // The acknowledged command type
+}
+
+// ErrorPayload describes why a command was rejected.
+type ErrorPayload struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"` // With rate_limited: when the command may be retried
+}
+
+// MessagePayload is the body of new_message and message_sent events.
+type MessagePayload struct {
+	ID         uuid.UUID     `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	SenderID   uuid.UUID     `json:"sender_id"`
+	ReceiverID *uuid.UUID    `json:"receiver_id,omitempty"` // For DMs
+	GroupID    *uuid.UUID    `json:"group_id,omitempty"`    // For group messages
+	Content    string        `json:"content"`
+	MsgType    string        `json:"msg_type"`
+	Sender     MessageSender `json:"sender"`
+
+	// Muted is set on new_message events for recipients who muted the
+	// conversation: clients show the message without notifying.
+	Muted bool `json:"muted,omitempty"`
+}
+
+// MessageSender is the sender of a message as every recipient sees it: the
+// same public fields message history loads, and nothing the privacy settings guard.
+type MessageSender struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+}
+
+// NewMessagePayload builds the event body for a message, with msg.Sender loaded.
+func NewMessagePayload(msg *models.Message) MessagePayload {
+	return MessagePayload{
+		ID:         msg.ID,
+		CreatedAt:  msg.CreatedAt,
+		UpdatedAt:  msg.UpdatedAt,
+		SenderID:   msg.SenderID,
+		ReceiverID: msg.ReceiverID,
+		GroupID:    msg.GroupID,
+		Content:    msg.Content,
+		MsgType:    msg.MsgType,
+		Sender: MessageSender{
+			ID:          msg.SenderID,
+			Username:    msg.Sender.Username,
+			DisplayName: msg.Sender.DisplayName,
+			AvatarURL:   msg.Sender.AvatarURL,
+		},
+	}
+}
+
+// ReceiptUpdatePayload notifies a sender that a recipient received or read a message. [F06]
+type ReceiptUpdatePayload struct {
+	MessageID uuid.UUID `json:"message_id"`
+	UserID    uuid.UUID `json:"user_id"` // Who read/received it
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TypingEventPayload is the body of user_typing and user_stopped_typing events. [F07]
+// Username is only set for user_typing.
+type TypingEventPayload struct {
+	UserID           uuid.UUID `json:"user_id"`
+	ConversationType string    `json:"conversation_type"`
+	TargetID         uuid.UUID `json:"target_id"`
+	Username         string    `json:"username,omitempty"`
+}
+
+// PresencePayload is the body of user_online and user_offline events.
+// user_online is sent again whenever the status of an online user changes.
+type PresencePayload struct {
+	UserID uuid.UUID `json:"user_id"`
+	Status string    `json:"status,omitempty"` // With user_online: online, away or dnd
+}
+
+// ProfileUpdatedPayload is the body of profile_updated events: a user's new public profile.
+type ProfileUpdatedPayload struct {
+	UserID          uuid.UUID  `json:"user_id"`
+	Username        string     `json:"username"`
+	DisplayName     string     `json:"display_name"`
+	Bio             string     `json:"bio"`
+	AvatarURL       string     `json:"avatar_url"`
+	StatusText      string     `json:"status_text"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+}
+
+// DraftPayload is the body of draft_updated events, sent to a user's other
+// devices when they save a draft. An empty Content means the draft was cleared.
+type DraftPayload struct {
+	ConversationType string    `json:"conversation_type"`
+	TargetID         uuid.UUID `json:"target_id"`
+	Content          string    `json:"content"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// BadgePayload is the body of badge_update events, sent to all of a user's
+// devices whenever their unread counts change. GET /me/unread returns the same.
+type BadgePayload struct {
+	TotalUnread   int                 `json:"total_unread"`
+	TotalMentions int                 `json:"total_mentions"`
+	Conversations []ConversationBadge `json:"conversations"` // Only those with unread messages
+}
+
+// ConversationBadge holds the unread counts of one conversation.
+type ConversationBadge struct {
+	ConversationType string    `json:"conversation_type"`
+	TargetID         uuid.UUID `json:"target_id"`
+	UnreadCount      int       `json:"unread_count"`
+	MentionCount     int       `json:"mention_count"`
+}
+
+// events maps every server → client event type to its payload type.
+var events = map[string]func() interface{}{
+	EventAuthenticated:     func() interface{} { return &AuthenticatedPayload{} },
+	EventReauthRequired:    func() interface{} { return &ReauthRequiredPayload{} },
+	EventWelcome:           func() interface{} { return &WelcomePayload{} },
+	EventAck:               func() interface{} { return &AckPayload{} },
+	EventError:             func() interface{} { return &ErrorPayload{} },
+	EventNewMessage:        func() interface{} { return &MessagePayload{} },
+	EventMessageSent:       func() interface{} { return &MessagePayload{} },
+	EventReceiptUpdate:     func() interface{} { return &ReceiptUpdatePayload{} },
+	EventUserTyping:        func() interface{} { return &TypingEventPayload{} },
+	EventUserStoppedTyping: func() interface{} { return &TypingEventPayload{} },
+	EventUserOnline:        func() interface{} { return &PresencePayload{} },
+	EventUserOffline:       func() interface{} { return &PresencePayload{} },
+	EventProfileUpdated:    func() interface{} { return &ProfileUpdatedPayload{} },
+	EventDraftUpdated:      func() interface{} { return &DraftPayload{} },
+	EventBadgeUpdate:       func() interface{} { return &BadgePayload{} },
+}
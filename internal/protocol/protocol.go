@@ -0,0 +1,110 @@
+// Package protocol defines the WebSocket wire protocol spoken between the
+// server and chat clients: the frame envelope, every client→server command
+// and server→client event, and the rules used to validate inbound frames.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version is the protocol version implemented by this server.
+const Version = 1
+
+// MinVersion is the oldest protocol version the server still accepts.
+const MinVersion = 1
+
+// Client → server command types
+const (
+	CmdAuth                  = "auth"
+	CmdReauth                = "reauth"
+	CmdHello                 = "hello"
+	CmdSetActiveConversation = "set_active_conversation"
+	CmdSendMessage           = "send_message"
+	CmdMessageDelivered      = "message_delivered"
+	CmdTypingStart           = "typing_start"
+	CmdTypingStop            = "typing_stop"
+	CmdSetPresence           = "set_presence"
+	CmdSubscribePresence     = "subscribe_presence"
+	CmdUnsubscribePresence   = "unsubscribe_presence"
+	CmdSaveDraft             = "save_draft"
+)
+
+// Server → client event types
+const (
+	EventAuthenticated     = "authenticated"
+	EventReauthRequired    = "reauth_required"
+	EventWelcome           = "welcome"
+	EventAck               = "ack"
+	EventError             = "error"
+	EventNewMessage        = "new_message"
+	EventMessageSent       = "message_sent"
+	EventReceiptUpdate     = "receipt_update"
+	EventUserTyping        = "user_typing"
+	EventUserStoppedTyping = "user_stopped_typing"
+	EventUserOnline        = "user_online"
+	EventUserOffline       = "user_offline"
+	EventProfileUpdated    = "profile_updated"
+	EventDraftUpdated      = "draft_updated"
+	EventBadgeUpdate       = "badge_update"
+)
+
+// Error codes carried in EventError payloads
+const (
+	ErrCodeInvalidFrame       = "invalid_frame"
+	ErrCodeUnauthorized       = "unauthorized"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeValidation         = "validation_failed"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeRateLimited        = "rate_limited" // Carries retry_after_ms
+	ErrCodeInternal           = "internal_error"
+)
+
+// SubprotocolBearerPrefix marks a Sec-WebSocket-Protocol entry carrying an access token
+// ("bearer.<jwt>"). The server never selects it, so browsers must also offer a codec subprotocol.
+const SubprotocolBearerPrefix = "bearer."
+
+// MaxIDLength bounds the client-chosen correlation ID.
+const MaxIDLength = 64
+
+// MaxPresenceSubscriptions bounds how many users one connection may watch with subscribe_presence.
+const MaxPresenceSubscriptions = 200
+
+// Envelope is the outer structure of every frame in both directions.
+// ID is an optional client-chosen correlation ID; the server echoes it on the
+// ack, error or result event that answers the command.
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Error is returned by DecodeCommand when a frame does not conform to the protocol.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
+}
+
+// Encode builds a server → client frame for the given event.
+func Encode(eventType string, payload interface{}) ([]byte, error) {
+	return EncodeReply(eventType, "", payload)
+}
+
+// EncodeReply builds a server → client frame answering the command with the given correlation ID.
+func EncodeReply(eventType, id string, payload interface{}) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{Type: eventType, ID: id, Payload: raw})
+}
+
+// IsSupportedVersion reports whether the server can speak the given client version.
+func IsSupportedVersion(v int) bool {
+	return v >= MinVersion && v <= Version
+}
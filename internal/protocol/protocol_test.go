@@ -0,0 +1,116 @@
+package protocol_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"chat-app/internal/protocol"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestDecodeCommand_SendMessage(t *testing.T) {
+	to := uuid.New()
+	frame := []byte(`{"type":"send_message","payload":{"to_user_id":"` + to.String() + `","content":"hi"}}`)
+
+	env, payload, err := protocol.DecodeCommand(frame)
+
+	require.NoError(t, err)
+	assert.Equal(t, protocol.CmdSendMessage, env.Type)
+	p, ok := payload.(*protocol.SendMessagePayload)
+	require.True(t, ok)
+	assert.Equal(t, to, p.ToUserID)
+	assert.Equal(t, "hi", p.Content)
+}
+
+func TestDecodeCommand_Rejections(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		code  string
+	}{
+		{"not json", `{`, protocol.ErrCodeInvalidFrame},
+		{"missing type", `{"payload":{}}`, protocol.ErrCodeInvalidFrame},
+		{"unknown type", `{"type":"launch_rockets"}`, protocol.ErrCodeUnknownType},
+		{"wrong field type", `{"type":"hello","payload":{"version":"one"}}`, protocol.ErrCodeValidation},
+		{"missing required", `{"type":"message_delivered","payload":{}}`, protocol.ErrCodeValidation},
+		{"bad enum", `{"type":"typing_start","payload":{"conversation_type":"CHANNEL","target_id":"` + uuid.NewString() + `"}}`, protocol.ErrCodeValidation},
+		{"no recipient", `{"type":"send_message","payload":{"content":"hi"}}`, protocol.ErrCodeValidation},
+		{"empty content", `{"type":"send_message","payload":{"to_user_id":"` + uuid.NewString() + `","content":""}}`, protocol.ErrCodeValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := protocol.DecodeCommand([]byte(tt.frame))
+
+			var protoErr *protocol.Error
+			require.ErrorAs(t, err, &protoErr)
+			assert.Equal(t, tt.code, protoErr.Code)
+		})
+	}
+}
+
+func TestDecodeCommand_ClearActiveConversation(t *testing.T) {
+	_, payload, err := protocol.DecodeCommand([]byte(`{"type":"set_active_conversation","payload":{"conversation_type":"DM"}}`))
+
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Nil, payload.(*protocol.SetActiveConversationPayload).TargetID)
+}
+
+func TestEncode(t *testing.T) {
+	userID := uuid.New()
+	frame, err := protocol.Encode(protocol.EventUserOnline, protocol.PresencePayload{UserID: userID})
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(frame, &decoded))
+	assert.Equal(t, "user_online", decoded["type"])
+	assert.Equal(t, userID.String(), decoded["payload"].(map[string]interface{})["user_id"])
+}
+
+func TestSchema_IsUpToDate(t *testing.T) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	require.NoError(t, enc.Encode(protocol.Schema()))
+
+	committed, err := os.ReadFile("../../specs/ws_protocol.schema.json")
+	require.NoError(t, err)
+	assert.Equal(t, string(committed), buf.String(), "run `make schema` to regenerate specs/ws_protocol.schema.json")
+}
+
+func TestMsgPackCodec_RoundTrip(t *testing.T) {
+	frame, err := protocol.Encode(protocol.EventError, protocol.ErrorPayload{Code: "x", Message: "y"})
+	require.NoError(t, err)
+
+	codec := protocol.CodecFor(protocol.SubprotocolMsgPack)
+	assert.True(t, codec.Binary())
+
+	wire, err := codec.Encode(frame)
+	require.NoError(t, err)
+	assert.NotEqual(t, frame, wire)
+
+	back, err := codec.Decode(wire)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(frame), string(back))
+}
+
+func TestMsgPackCodec_KeepsIntegers(t *testing.T) {
+	codec := protocol.MsgPack
+	wire, err := codec.Encode([]byte(`{"type":"welcome","payload":{"version":1,"server_version":1,"min_version":1}}`))
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, msgpack.Unmarshal(wire, &decoded))
+	assert.IsType(t, int64(0), decoded["payload"].(map[string]interface{})["version"])
+}
+
+func TestCodecFor_DefaultsToJSON(t *testing.T) {
+	assert.Equal(t, protocol.JSON, protocol.CodecFor(""))
+	assert.Equal(t, protocol.JSON, protocol.CodecFor("unknown"))
+}
@@ -0,0 +1,161 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	uuidType          = reflect.TypeOf(uuid.UUID{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// Schema generates a JSON Schema (draft 2020-12) document describing every
+// frame of the protocol. ClientFrame and ServerFrame are the entry points;
+// payload types live under $defs.
+func Schema() map[string]interface{} {
+	defs := map[string]interface{}{}
+	g := &schemaGen{defs: defs}
+
+	defs["ClientFrame"] = map[string]interface{}{"oneOf": g.frames(commands, true)}
+	defs["ServerFrame"] = map[string]interface{}{"oneOf": g.frames(events, false)}
+
+	return map[string]interface{}{
+		"$schema":            "https://json-schema.org/draft/2020-12/schema",
+		"$id":                "https://github.com/Nikhilnv1209/go-chat-app/ws-protocol.schema.json",
+		"title":              "Chat WebSocket protocol",
+		"x-protocol-version": Version,
+		"x-min-version":      MinVersion,
+		"$defs":              defs,
+	}
+}
+
+type schemaGen struct {
+	defs map[string]interface{}
+}
+
+// frames builds one envelope schema per frame type, sorted by type for stable output.
+func (g *schemaGen) frames(registry map[string]func() interface{}, inbound bool) []interface{} {
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	out := make([]interface{}, 0, len(types))
+	for _, t := range types {
+		payloadType := reflect.TypeOf(registry[t]()).Elem()
+		out = append(out, map[string]interface{}{
+			"title":    t,
+			"type":     "object",
+			"required": []string{"type", "payload"},
+			"properties": map[string]interface{}{
+				"type":    map[string]interface{}{"const": t},
+				"id":      map[string]interface{}{"type": "string", "maxLength": MaxIDLength},
+				"payload": g.ref(payloadType, inbound),
+			},
+		})
+	}
+	return out
+}
+
+// ref registers a named struct under $defs and returns a reference to it.
+func (g *schemaGen) ref(t reflect.Type, inbound bool) map[string]interface{} {
+	name := t.Name()
+	if _, ok := g.defs[name]; !ok {
+		g.defs[name] = nil // reserve to break cycles
+		g.defs[name] = g.object(t, inbound)
+	}
+	return map[string]interface{}{"$ref": "#/$defs/" + name}
+}
+
+// object describes a struct. For inbound payloads required fields come from
+// the `ws` tag; for outbound payloads every field without omitempty is required.
+func (g *schemaGen) object(t reflect.Type, inbound bool) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	g.fields(t, inbound, props, &required)
+
+	obj := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		obj["required"] = required
+	}
+	return obj
+}
+
+func (g *schemaGen) fields(t reflect.Type, inbound bool, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && f.Tag.Get("json") == "" && f.Type.Kind() == reflect.Struct {
+			g.fields(f.Type, inbound, props, required)
+			continue
+		}
+		name, omitempty := jsonName(f)
+		if name == "" {
+			continue
+		}
+
+		rules := parseRules(f.Tag.Get("ws"))
+		prop := g.typeSchema(f.Type, inbound)
+		if len(rules.enum) > 0 {
+			prop["enum"] = rules.enum
+		}
+		if rules.max > 0 {
+			prop["maxLength"] = rules.max
+		}
+		props[name] = prop
+
+		if (inbound && rules.required) || (!inbound && !omitempty && f.Type.Kind() != reflect.Ptr) {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func (g *schemaGen) typeSchema(t reflect.Type, inbound bool) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	}
+	if t.Kind() == reflect.Ptr {
+		return map[string]interface{}{
+			"anyOf": []interface{}{g.typeSchema(t.Elem(), inbound), map[string]interface{}{"type": "null"}},
+		}
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		// Custom JSON encoding (e.g. gorm.DeletedAt); shape is not derivable from the Go type.
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.typeSchema(t.Elem(), inbound)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.typeSchema(t.Elem(), inbound)}
+	case reflect.Struct:
+		return g.ref(t, inbound)
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,121 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fieldRules holds the parsed `ws` tag of a struct field.
+type fieldRules struct {
+	required bool
+	enum     []string
+	max      int
+}
+
+func parseRules(tag string) fieldRules {
+	var r fieldRules
+	for _, part := range strings.Split(tag, ",") {
+		switch {
+		case part == "required":
+			r.required = true
+		case strings.HasPrefix(part, "enum="):
+			r.enum = strings.Split(strings.TrimPrefix(part, "enum="), "|")
+		case strings.HasPrefix(part, "max="):
+			r.max, _ = strconv.Atoi(strings.TrimPrefix(part, "max="))
+		}
+	}
+	return r
+}
+
+// jsonName returns the wire name of a struct field, or "" if it is not serialized.
+func jsonName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, strings.Contains(opts, "omitempty")
+}
+
+// DecodeCommand parses a client → server frame and validates its payload.
+// It returns the envelope and a pointer to the typed payload for the command.
+// Unknown payload fields are ignored so that newer clients can talk to older servers.
+func DecodeCommand(frame []byte) (*Envelope, interface{}, error) {
+	var env Envelope
+	if err := json.Unmarshal(frame, &env); err != nil {
+		return nil, nil, &Error{Code: ErrCodeInvalidFrame, Message: "frame is not valid JSON"}
+	}
+	if env.Type == "" {
+		return &env, nil, &Error{Code: ErrCodeInvalidFrame, Message: "type is required"}
+	}
+	if len(env.ID) > MaxIDLength {
+		env.ID = ""
+		return &env, nil, &Error{Code: ErrCodeInvalidFrame, Message: fmt.Sprintf("id must be at most %d characters", MaxIDLength)}
+	}
+
+	newPayload, ok := commands[env.Type]
+	if !ok {
+		return &env, nil, &Error{Code: ErrCodeUnknownType, Message: fmt.Sprintf("unknown command type %q", env.Type)}
+	}
+
+	payload := newPayload()
+	if len(env.Payload) > 0 && string(env.Payload) != "null" {
+		if err := json.Unmarshal(env.Payload, payload); err != nil {
+			return &env, nil, &Error{Code: ErrCodeValidation, Message: "payload: " + err.Error()}
+		}
+	}
+
+	if err := Validate(payload); err != nil {
+		return &env, nil, err
+	}
+	return &env, payload, nil
+}
+
+// Validate checks a payload against its `ws` tag rules and its own Validate method.
+func Validate(payload interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(payload))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _ := jsonName(f)
+		rules := parseRules(f.Tag.Get("ws"))
+		fv := v.Field(i)
+
+		if rules.required && fv.IsZero() {
+			return &Error{Code: ErrCodeValidation, Message: name + " is required"}
+		}
+		if fv.Kind() != reflect.String || fv.Len() == 0 {
+			continue
+		}
+		s := fv.String()
+		if len(rules.enum) > 0 && !contains(rules.enum, s) {
+			return &Error{Code: ErrCodeValidation, Message: fmt.Sprintf("%s must be one of %s", name, strings.Join(rules.enum, ", "))}
+		}
+		if rules.max > 0 && utf8.RuneCountInString(s) > rules.max {
+			return &Error{Code: ErrCodeValidation, Message: fmt.Sprintf("%s must be at most %d characters", name, rules.max)}
+		}
+	}
+
+	if vd, ok := payload.(interface{ Validate() error }); ok {
+		if err := vd.Validate(); err != nil {
+			return &Error{Code: ErrCodeValidation, Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrAccountTokenInvalid is returned by Consume for unknown, used or expired tokens.
+var ErrAccountTokenInvalid = errors.New("account token is invalid or expired")
+
+type accountTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewAccountTokenRepository(db *gorm.DB) AccountTokenRepository {
+	return &accountTokenRepository{db: db}
+}
+
+func (r *accountTokenRepository) Create(ctx context.Context, token *models.AccountToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+// Consume redeems the token with the given hash and purpose. Only one caller can
+// consume a token; the others get ErrAccountTokenInvalid.
+func (r *accountTokenRepository) Consume(ctx context.Context, hash, purpose string) (*models.AccountToken, error) {
+	var token models.AccountToken
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("token_hash = ? AND purpose = ?", hash, purpose).First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAccountTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+			return ErrAccountTokenInvalid
+		}
+
+		now := time.Now()
+		res := tx.Model(&models.AccountToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrAccountTokenInvalid
+		}
+		token.UsedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// InvalidateByUser marks the user's outstanding tokens of a purpose as used,
+// e.g. when a newer one is mailed or the password has been reset.
+func (r *accountTokenRepository) InvalidateByUser(ctx context.Context, userID uuid.UUID, purpose string) error {
+	return r.db.WithContext(ctx).Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
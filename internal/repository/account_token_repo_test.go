@@ -0,0 +1,66 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAccountToken(userID uuid.UUID, purpose string, ttl time.Duration) *models.AccountToken {
+	return &models.AccountToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: uuid.NewString(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+}
+
+func TestAccountTokenRepository_ConsumeOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewAccountTokenRepository(setupTestDB(t))
+
+	token := newAccountToken(uuid.New(), models.AccountTokenResetPassword, time.Hour)
+	require.NoError(t, repo.Create(ctx, token))
+
+	// The purpose must match
+	_, err := repo.Consume(ctx, token.TokenHash, models.AccountTokenVerifyEmail)
+	assert.ErrorIs(t, err, repository.ErrAccountTokenInvalid)
+
+	consumed, err := repo.Consume(ctx, token.TokenHash, models.AccountTokenResetPassword)
+	require.NoError(t, err)
+	assert.Equal(t, token.UserID, consumed.UserID)
+	assert.NotNil(t, consumed.UsedAt)
+
+	_, err = repo.Consume(ctx, token.TokenHash, models.AccountTokenResetPassword)
+	assert.ErrorIs(t, err, repository.ErrAccountTokenInvalid)
+}
+
+func TestAccountTokenRepository_ExpiredAndInvalidated(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewAccountTokenRepository(setupTestDB(t))
+	userID := uuid.New()
+
+	expired := newAccountToken(userID, models.AccountTokenVerifyEmail, -time.Minute)
+	require.NoError(t, repo.Create(ctx, expired))
+	_, err := repo.Consume(ctx, expired.TokenHash, models.AccountTokenVerifyEmail)
+	assert.ErrorIs(t, err, repository.ErrAccountTokenInvalid)
+
+	superseded := newAccountToken(userID, models.AccountTokenVerifyEmail, time.Hour)
+	otherPurpose := newAccountToken(userID, models.AccountTokenResetPassword, time.Hour)
+	require.NoError(t, repo.Create(ctx, superseded))
+	require.NoError(t, repo.Create(ctx, otherPurpose))
+
+	require.NoError(t, repo.InvalidateByUser(ctx, userID, models.AccountTokenVerifyEmail))
+
+	_, err = repo.Consume(ctx, superseded.TokenHash, models.AccountTokenVerifyEmail)
+	assert.ErrorIs(t, err, repository.ErrAccountTokenInvalid)
+	_, err = repo.Consume(ctx, otherPurpose.TokenHash, models.AccountTokenResetPassword)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type contactRepository struct {
+	db *gorm.DB
+}
+
+func NewContactRepository(db *gorm.DB) ContactRepository {
+	return &contactRepository{db: db}
+}
+
+func (r *contactRepository) Create(ctx context.Context, contact *models.Contact) error {
+	return r.db.WithContext(ctx).Create(contact).Error
+}
+
+// FindByID returns the request, or nil if it doesn't exist.
+func (r *contactRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.Contact, error) {
+	return r.first(r.db.WithContext(ctx).Where("id = ?", id))
+}
+
+// FindBetween returns the request between two users, sent by either, or nil.
+func (r *contactRepository) FindBetween(ctx context.Context, userID, otherID uuid.UUID) (*models.Contact, error) {
+	return r.first(r.db.WithContext(ctx).Where(
+		"(user_id = ? AND contact_id = ?) OR (user_id = ? AND contact_id = ?)",
+		userID, otherID, otherID, userID,
+	))
+}
+
+func (r *contactRepository) Accept(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.Contact{}).
+		Where("id = ? AND status = ?", id, models.ContactPending).
+		Updates(map[string]interface{}{
+			"status":      models.ContactAccepted,
+			"accepted_at": at,
+		}).Error
+}
+
+// Delete removes a request or ends a contact relationship.
+func (r *contactRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Unscoped().Delete(&models.Contact{}, "id = ?", id).Error
+}
+
+// FindContactIDs returns the IDs of the user's accepted contacts.
+func (r *contactRepository) FindContactIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	var contacts []models.Contact
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND (user_id = ? OR contact_id = ?)", models.ContactAccepted, userID, userID).
+		Find(&contacts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(contacts))
+	for i := range contacts {
+		ids = append(ids, contacts[i].Other(userID))
+	}
+	return ids, nil
+}
+
+// FindPending returns the open requests the user sent or received, newest first.
+func (r *contactRepository) FindPending(ctx context.Context, userID uuid.UUID) ([]models.Contact, error) {
+	var contacts []models.Contact
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND (user_id = ? OR contact_id = ?)", models.ContactPending, userID, userID).
+		Order("created_at DESC").
+		Find(&contacts).Error
+	return contacts, err
+}
+
+func (r *contactRepository) AreContacts(ctx context.Context, userID, otherID uuid.UUID) (bool, error) {
+	contact, err := r.FindBetween(ctx, userID, otherID)
+	if err != nil {
+		return false, err
+	}
+	return contact != nil && contact.Status == models.ContactAccepted, nil
+}
+
+func (r *contactRepository) first(query *gorm.DB) (*models.Contact, error) {
+	var contact models.Contact
+	if err := query.First(&contact).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &contact, nil
+}
@@ -0,0 +1,57 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContactRepository_RequestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewContactRepository(setupTestDB(t))
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+
+	request := &models.Contact{UserID: alice, ContactID: bob, Status: models.ContactPending}
+	require.NoError(t, repo.Create(ctx, request))
+	require.NoError(t, repo.Create(ctx, &models.Contact{UserID: carol, ContactID: alice, Status: models.ContactPending}))
+
+	// The request is found from either side, but isn't a contact yet
+	found, err := repo.FindBetween(ctx, bob, alice)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, request.ID, found.ID)
+	isContact, err := repo.AreContacts(ctx, alice, bob)
+	require.NoError(t, err)
+	assert.False(t, isContact)
+
+	pending, err := repo.FindPending(ctx, alice)
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+
+	// Accepted, both users see each other as contacts
+	require.NoError(t, repo.Accept(ctx, request.ID, time.Now()))
+	isContact, err = repo.AreContacts(ctx, bob, alice)
+	require.NoError(t, err)
+	assert.True(t, isContact)
+
+	ids, err := repo.FindContactIDs(ctx, alice)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{bob}, ids)
+	ids, err = repo.FindContactIDs(ctx, bob)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{alice}, ids)
+
+	// Removed, the pair may start over
+	require.NoError(t, repo.Delete(ctx, request.ID))
+	found, err = repo.FindBetween(ctx, alice, bob)
+	require.NoError(t, err)
+	assert.Nil(t, found)
+	require.NoError(t, repo.Create(ctx, &models.Contact{UserID: alice, ContactID: bob, Status: models.ContactPending}))
+}
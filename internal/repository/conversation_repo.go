@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"chat-app/internal/models"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,6 +11,19 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// ErrInvalidCursor is returned when the conversation a page should start after doesn't exist.
+var ErrInvalidCursor = errors.New("pagination cursor not found")
+
+// InboxFilter selects and pages the conversations returned by FindInbox.
+// Zero values don't filter.
+type InboxFilter struct {
+	Type       string     // DM or GROUP
+	UnreadOnly bool       // Only conversations with unread messages
+	Archived   *bool      // Only archived, or only unarchived, conversations
+	BeforeID   *uuid.UUID // Continue after this conversation, the last of the previous page
+	Limit      int
+}
+
 type conversationRepository struct {
 	db *gorm.DB
 }
@@ -20,64 +34,176 @@ func NewConversationRepository(db *gorm.DB) ConversationRepository {
 
 func (r *conversationRepository) Upsert(ctx context.Context, conv *models.Conversation) error {
 	// Upsert: On conflict (user_id, type, target_id), update last_message_at and last_message
+	// A new message brings an archived conversation back to the inbox
 	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}, {Name: "target_id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"last_message_at", "last_message"}),
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "type"}, {Name: "target_id"}},
+		DoUpdates: append(clause.AssignmentColumns([]string{"last_message_at", "last_message"}),
+			clause.Assignment{Column: clause.Column{Name: "archived"}, Value: false}),
 	}).Create(conv).Error
 }
 
 func (r *conversationRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]models.Conversation, error) {
 	var convs []models.Conversation
-	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("last_message_at DESC").Find(&convs).Error
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("pinned DESC, last_message_at DESC").Find(&convs).Error
 	return convs, err
 }
 
-func (r *conversationRepository) IncrementUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, lastMessage string) error {
-	// We need to upsert first to ensure the row exists, but GORM's atomic increment is tricky with Upsert in one go.
-	// For MVP, we can try to update, if 0 rows affected, then create.
+// FindInbox returns a page of the user's conversations, pinned first, then by
+// last message time, together with the target's name, member count or online
+// status and the user's draft, in a single query. Like User.AfterFind, it shows
+// models.DeletedUsername for deleted accounts.
+func (r *conversationRepository) FindInbox(ctx context.Context, userID uuid.UUID, filter InboxFilter) ([]models.InboxEntry, error) {
+	query := r.db.WithContext(ctx).Table("conversations AS c").
+		Select(`c.*,
+			CASE WHEN UPPER(c.type) = 'GROUP' THEN g.name WHEN u.account_deleted_at IS NOT NULL THEN ? ELSE u.username END AS target_name,
+			(SELECT COUNT(*) FROM group_members gm WHERE UPPER(c.type) = 'GROUP' AND gm.group_id = c.target_id) AS member_count,
+			COALESCE(d.content, '') AS draft,
+			COALESCE(u.is_online, false) AS target_online,
+			COALESCE(u.online_visibility, '') AS online_visibility,
+			EXISTS (SELECT 1 FROM contacts ct WHERE ct.status = ? AND ct.deleted_at IS NULL AND
+				((ct.user_id = c.user_id AND ct.contact_id = c.target_id) OR (ct.user_id = c.target_id AND ct.contact_id = c.user_id))) AS is_contact`,
+			models.DeletedUsername, models.ContactAccepted).
+		Joins("LEFT JOIN users u ON UPPER(c.type) = 'DM' AND u.id = c.target_id").
+		Joins("LEFT JOIN groups g ON UPPER(c.type) = 'GROUP' AND g.id = c.target_id").
+		Joins("LEFT JOIN drafts d ON d.user_id = c.user_id AND d.type = c.type AND d.target_id = c.target_id AND d.deleted_at IS NULL").
+		Where("c.user_id = ? AND c.deleted_at IS NULL", userID).
+		Order("c.pinned DESC, c.last_message_at DESC, c.id DESC")
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
 
-	result := r.db.WithContext(ctx).Model(&models.Conversation{}).
+	// Filters
+	if filter.Type != "" {
+		query = query.Where("UPPER(c.type) = ?", filter.Type)
+	}
+	if filter.UnreadOnly {
+		query = query.Where("c.unread_count > 0")
+	}
+	if filter.Archived != nil {
+		query = query.Where("c.archived = ?", *filter.Archived)
+	}
+
+	// Cursor-based pagination: continue after the given conversation in the same order
+	if filter.BeforeID != nil {
+		var cursor models.Conversation
+		err := r.db.WithContext(ctx).Select("id", "pinned", "last_message_at").
+			Where("id = ? AND user_id = ?", *filter.BeforeID, userID).
+			First(&cursor).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrInvalidCursor
+			}
+			return nil, err
+		}
+
+		older := "(c.last_message_at < ? OR (c.last_message_at = ? AND c.id < ?))"
+		if cursor.Pinned {
+			query = query.Where("(NOT c.pinned OR "+older+")", cursor.LastMessageAt, cursor.LastMessageAt, cursor.ID)
+		} else {
+			query = query.Where("NOT c.pinned AND "+older, cursor.LastMessageAt, cursor.LastMessageAt, cursor.ID)
+		}
+	}
+
+	var entries []models.InboxEntry
+	err := query.Scan(&entries).Error
+	return entries, err
+}
+
+func (r *conversationRepository) FindOne(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) (*models.Conversation, error) {
+	var conv models.Conversation
+	err := r.db.WithContext(ctx).
 		Where("user_id = ? AND type = ? AND target_id = ?", userID, convType, targetID).
+		First(&conv).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// UpdateSettings saves the mute, archive and pin settings of the conversation.
+func (r *conversationRepository) UpdateSettings(ctx context.Context, conv *models.Conversation) error {
+	return r.db.WithContext(ctx).Model(&models.Conversation{}).
+		Where("id = ?", conv.ID).
 		Updates(map[string]interface{}{
-			"unread_count":    gorm.Expr("unread_count + ?", 1),
-			"last_message":    lastMessage,
-			"last_message_at": time.Now(),
-		})
+			"muted":       conv.Muted,
+			"muted_until": conv.MutedUntil,
+			"archived":    conv.Archived,
+			"pinned":      conv.Pinned,
+		}).Error
+}
 
-	if result.Error != nil {
-		return result.Error
+// FindMutedUserIDs returns which of the users currently mute their conversation
+// of the given type with targetID.
+func (r *conversationRepository) FindMutedUserIDs(ctx context.Context, convType string, targetID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	if len(userIDs) == 0 {
+		return ids, nil
 	}
+	err := r.db.WithContext(ctx).Model(&models.Conversation{}).
+		Where("type = ? AND target_id = ? AND user_id IN ?", convType, targetID, userIDs).
+		Where("muted = ? AND (muted_until IS NULL OR muted_until > ?)", true, time.Now()).
+		Pluck("user_id", &ids).Error
+	return ids, err
+}
 
-	if result.RowsAffected == 0 {
-		// Create new if not exists
-		newConv := models.Conversation{
-			UserID:        userID,
-			Type:          convType,
-			TargetID:      targetID,
-			LastMessage:   lastMessage,
-			LastMessageAt: time.Now(),
-			UnreadCount:   1,
-		}
-		return r.db.WithContext(ctx).Create(&newConv).Error
+// IncrementUnread records a new unread message, and whether it mentions the
+// user, in a single upsert so concurrent messages can't lose a count or race
+// to create the conversation.
+func (r *conversationRepository) IncrementUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, lastMessage string, mentioned bool) error {
+	now := time.Now()
+	mentions := 0
+	if mentioned {
+		mentions = 1
 	}
 
-	return nil
+	conv := models.Conversation{
+		UserID:        userID,
+		Type:          convType,
+		TargetID:      targetID,
+		LastMessage:   lastMessage,
+		LastMessageAt: now,
+		UnreadCount:   1,
+		MentionCount:  mentions,
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "type"}, {Name: "target_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"unread_count":    gorm.Expr("conversations.unread_count + 1"),
+			"mention_count":   gorm.Expr("conversations.mention_count + ?", mentions),
+			"last_message":    lastMessage,
+			"last_message_at": now,
+			"archived":        false,
+			"updated_at":      now,
+		}),
+	}).Create(&conv).Error
 }
 
-func (r *conversationRepository) ResetUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) error {
-	return r.db.WithContext(ctx).Model(&models.Conversation{}).
+// ResetUnread marks the conversation as read and reports whether it had unread messages.
+func (r *conversationRepository) ResetUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.Conversation{}).
 		Where("user_id = ? AND type = ? AND target_id = ?", userID, convType, targetID).
-		Update("unread_count", 0).Error
+		Where("unread_count > 0 OR mention_count > 0").
+		Updates(map[string]interface{}{
+			"unread_count":  0,
+			"mention_count": 0,
+		})
+	return result.RowsAffected > 0, result.Error
 }
 
-// FindContactsOfUser returns all user IDs who have a DM conversation with the given user.
-// This is used for broadcasting presence updates.
-func (r *conversationRepository) FindContactsOfUser(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
-	var contacts []uuid.UUID
-	// Find all users who have this user as a target_id in their DM conversations
-	err := r.db.WithContext(ctx).Model(&models.Conversation{}).
-		Where("type = ? AND target_id = ?", "DM", userID).
-		Distinct("user_id").
-		Pluck("user_id", &contacts).Error
-	return contacts, err
+// FindUnreadCounts returns the users' conversations with unread messages, most
+// recent first, leaving out those currently muted.
+func (r *conversationRepository) FindUnreadCounts(ctx context.Context, userIDs []uuid.UUID) ([]models.Conversation, error) {
+	var convs []models.Conversation
+	if len(userIDs) == 0 {
+		return convs, nil
+	}
+	err := r.db.WithContext(ctx).
+		Where("user_id IN ? AND unread_count > 0", userIDs).
+		Where("muted = ? OR (muted_until IS NOT NULL AND muted_until <= ?)", false, time.Now()).
+		Order("last_message_at DESC").
+		Find(&convs).Error
+	return convs, err
 }
@@ -0,0 +1,226 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationRepository_Settings(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewConversationRepository(setupTestDB(t))
+	userID, older, newer := uuid.New(), uuid.New(), uuid.New()
+
+	require.NoError(t, repo.IncrementUnread(ctx, userID, "DM", older, "first", false))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, repo.IncrementUnread(ctx, userID, "DM", newer, "second", false))
+
+	// Pinned conversations come first
+	conv, err := repo.FindOne(ctx, userID, "DM", older)
+	require.NoError(t, err)
+	require.NotNil(t, conv)
+	conv.Pinned, conv.Archived = true, true
+	require.NoError(t, repo.UpdateSettings(ctx, conv))
+
+	convs, err := repo.FindByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, convs, 2)
+	assert.Equal(t, older, convs[0].TargetID)
+	assert.True(t, convs[0].Archived)
+
+	// A new message unarchives it, both ways of recording one
+	require.NoError(t, repo.IncrementUnread(ctx, userID, "DM", older, "third", false))
+	conv, err = repo.FindOne(ctx, userID, "DM", older)
+	require.NoError(t, err)
+	assert.False(t, conv.Archived)
+	assert.True(t, conv.Pinned)
+
+	conv.Archived = true
+	require.NoError(t, repo.UpdateSettings(ctx, conv))
+	require.NoError(t, repo.Upsert(ctx, &models.Conversation{UserID: userID, Type: "DM", TargetID: older, LastMessage: "fourth", LastMessageAt: time.Now()}))
+	conv, err = repo.FindOne(ctx, userID, "DM", older)
+	require.NoError(t, err)
+	assert.False(t, conv.Archived)
+
+	missing, err := repo.FindOne(ctx, userID, "GROUP", older)
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestConversationRepository_FindMutedUserIDs(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewConversationRepository(setupTestDB(t))
+	groupID, forever, expired, unmuted := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	past := time.Now().Add(-time.Minute)
+	for userID, mute := range map[uuid.UUID]*models.Conversation{
+		forever: {Muted: true},
+		expired: {Muted: true, MutedUntil: &past},
+		unmuted: {},
+	} {
+		require.NoError(t, repo.IncrementUnread(ctx, userID, "GROUP", groupID, "hello", false))
+		conv, err := repo.FindOne(ctx, userID, "GROUP", groupID)
+		require.NoError(t, err)
+		conv.Muted, conv.MutedUntil = mute.Muted, mute.MutedUntil
+		require.NoError(t, repo.UpdateSettings(ctx, conv))
+	}
+
+	muted, err := repo.FindMutedUserIDs(ctx, "GROUP", groupID, []uuid.UUID{forever, expired, unmuted})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{forever}, muted)
+}
+
+func TestConversationRepository_UnreadCounts(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewConversationRepository(setupTestDB(t))
+	userID, dmTarget, groupID := uuid.New(), uuid.New(), uuid.New()
+
+	require.NoError(t, repo.IncrementUnread(ctx, userID, "DM", dmTarget, "hi", false))
+	require.NoError(t, repo.IncrementUnread(ctx, userID, "GROUP", groupID, "@alice", true))
+	require.NoError(t, repo.IncrementUnread(ctx, userID, "GROUP", groupID, "again", false))
+	require.NoError(t, repo.IncrementUnread(ctx, userID, "GROUP", groupID, "@alice?", true))
+
+	convs, err := repo.FindUnreadCounts(ctx, []uuid.UUID{userID})
+	require.NoError(t, err)
+	require.Len(t, convs, 2)
+	assert.Equal(t, groupID, convs[0].TargetID)
+	assert.Equal(t, 3, convs[0].UnreadCount)
+	assert.Equal(t, 2, convs[0].MentionCount)
+	assert.Equal(t, "@alice?", convs[0].LastMessage)
+	assert.Equal(t, 1, convs[1].UnreadCount)
+	assert.Equal(t, 0, convs[1].MentionCount)
+
+	// Resetting reports whether there was anything to reset
+	changed, err := repo.ResetUnread(ctx, userID, "GROUP", groupID)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = repo.ResetUnread(ctx, userID, "GROUP", groupID)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	convs, err = repo.FindUnreadCounts(ctx, []uuid.UUID{userID})
+	require.NoError(t, err)
+	require.Len(t, convs, 1)
+	assert.Equal(t, dmTarget, convs[0].TargetID)
+}
+
+func TestConversationRepository_UnreadCountsOfSeveralUsersSkipMuted(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewConversationRepository(setupTestDB(t))
+	alice, bob, carol, groupID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	for _, userID := range []uuid.UUID{alice, bob, carol} {
+		require.NoError(t, repo.IncrementUnread(ctx, userID, "GROUP", groupID, "hi", false))
+	}
+
+	// Bob muted the group, Carol's mute has expired
+	past := time.Now().Add(-time.Minute)
+	for userID, until := range map[uuid.UUID]*time.Time{bob: nil, carol: &past} {
+		conv, err := repo.FindOne(ctx, userID, "GROUP", groupID)
+		require.NoError(t, err)
+		conv.Muted, conv.MutedUntil = true, until
+		require.NoError(t, repo.UpdateSettings(ctx, conv))
+	}
+
+	convs, err := repo.FindUnreadCounts(ctx, []uuid.UUID{alice, bob, carol})
+	require.NoError(t, err)
+	var users []uuid.UUID
+	for _, conv := range convs {
+		users = append(users, conv.UserID)
+	}
+	assert.ElementsMatch(t, []uuid.UUID{alice, carol}, users)
+}
+
+func TestConversationRepository_FindInbox(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	repo := repository.NewConversationRepository(db)
+	userID := uuid.New()
+
+	// Bob shows his status to contacts and is one, Carol too but isn't
+	bob := &models.User{Username: "bob", Email: "bob@example.com", IsOnline: true, OnlineVisibility: models.VisibilityContacts}
+	carol := &models.User{Username: "carol", Email: "carol@example.com", IsOnline: true, OnlineVisibility: models.VisibilityContacts}
+	group := &models.Group{Name: "Family"}
+	require.NoError(t, db.Create(bob).Error)
+	require.NoError(t, db.Create(carol).Error)
+	require.NoError(t, db.Create(group).Error)
+	require.NoError(t, db.Create(&models.Contact{UserID: bob.ID, ContactID: userID, Status: models.ContactAccepted}).Error)
+	require.NoError(t, db.Create(&[]models.GroupMember{{GroupID: group.ID, UserID: userID}, {GroupID: group.ID, UserID: bob.ID}}).Error)
+	require.NoError(t, repository.NewDraftRepository(db).Save(ctx, &models.Draft{UserID: userID, Type: "DM", TargetID: carol.ID, Content: "See you"}))
+
+	// Oldest first: the group, Carol (pinned), Bob
+	now := time.Now()
+	convs := []*models.Conversation{
+		{UserID: userID, Type: "GROUP", TargetID: group.ID, LastMessageAt: now.Add(-2 * time.Hour)},
+		{UserID: userID, Type: "DM", TargetID: carol.ID, LastMessageAt: now.Add(-time.Hour), Pinned: true},
+		{UserID: userID, Type: "DM", TargetID: bob.ID, LastMessageAt: now, UnreadCount: 2},
+	}
+	for _, conv := range convs {
+		require.NoError(t, db.Create(conv).Error)
+	}
+
+	entries, err := repo.FindInbox(ctx, userID, repository.InboxFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "carol", entries[0].TargetName, "pinned first")
+	assert.Equal(t, "See you", entries[0].Draft)
+	assert.Nil(t, entries[0].OnlineStatus(), "Carol hides her status from non-contacts")
+	assert.Equal(t, "bob", entries[1].TargetName)
+	require.NotNil(t, entries[1].OnlineStatus())
+	assert.True(t, *entries[1].OnlineStatus())
+	assert.Equal(t, "Family", entries[2].TargetName)
+	assert.Equal(t, 2, entries[2].MemberCount)
+
+	// Pages continue after the last conversation of the previous one
+	page, err := repo.FindInbox(ctx, userID, repository.InboxFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	page, err = repo.FindInbox(ctx, userID, repository.InboxFilter{Limit: 2, BeforeID: &page[1].ID})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, group.ID, page[0].TargetID)
+	page, err = repo.FindInbox(ctx, userID, repository.InboxFilter{BeforeID: &entries[0].ID})
+	require.NoError(t, err)
+	assert.Len(t, page, 2, "after the pinned conversation come all others")
+
+	unknown := uuid.New()
+	_, err = repo.FindInbox(ctx, userID, repository.InboxFilter{BeforeID: &unknown})
+	assert.ErrorIs(t, err, repository.ErrInvalidCursor)
+
+	// Filters
+	page, err = repo.FindInbox(ctx, userID, repository.InboxFilter{Type: "GROUP"})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	page, err = repo.FindInbox(ctx, userID, repository.InboxFilter{UnreadOnly: true})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, bob.ID, page[0].TargetID)
+	archived := true
+	page, err = repo.FindInbox(ctx, userID, repository.InboxFilter{Archived: &archived})
+	require.NoError(t, err)
+	assert.Empty(t, page)
+}
+
+func TestConversationRepository_FindInboxDeletedPeer(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	repo := repository.NewConversationRepository(db)
+	userID := uuid.New()
+
+	dave := &models.User{Username: "dave", Email: "dave@example.com"}
+	require.NoError(t, db.Create(dave).Error)
+	require.NoError(t, repo.IncrementUnread(ctx, userID, "DM", dave.ID, "bye", false))
+	require.NoError(t, repository.NewUserRepository(db).DeleteAccount(ctx, dave.ID, time.Now()))
+
+	entries, err := repo.FindInbox(ctx, userID, repository.InboxFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, models.DeletedUsername, entries[0].TargetName)
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type draftRepository struct {
+	db *gorm.DB
+}
+
+func NewDraftRepository(db *gorm.DB) DraftRepository {
+	return &draftRepository{db: db}
+}
+
+// Save creates or replaces the user's draft for the conversation. Clearing a
+// draft saves it with empty content.
+func (r *draftRepository) Save(ctx context.Context, draft *models.Draft) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}, {Name: "target_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content", "updated_at"}),
+	}).Create(draft).Error
+}
+
+// FindByUser returns the user's non-empty drafts.
+func (r *draftRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]models.Draft, error) {
+	var drafts []models.Draft
+	err := r.db.WithContext(ctx).Where("user_id = ? AND content <> ''", userID).Find(&drafts).Error
+	return drafts, err
+}
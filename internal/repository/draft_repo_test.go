@@ -0,0 +1,40 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDraftRepository_SaveReplacesAndClears(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewDraftRepository(setupTestDB(t))
+	userID, bob, groupID := uuid.New(), uuid.New(), uuid.New()
+
+	require.NoError(t, repo.Save(ctx, &models.Draft{UserID: userID, Type: "DM", TargetID: bob, Content: "Hel"}))
+	require.NoError(t, repo.Save(ctx, &models.Draft{UserID: userID, Type: "DM", TargetID: bob, Content: "Hello"}))
+	require.NoError(t, repo.Save(ctx, &models.Draft{UserID: userID, Type: "GROUP", TargetID: groupID, Content: "Hi all"}))
+	require.NoError(t, repo.Save(ctx, &models.Draft{UserID: uuid.New(), Type: "DM", TargetID: bob, Content: "Not mine"}))
+
+	drafts, err := repo.FindByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, drafts, 2, "one draft per conversation")
+	byTarget := map[uuid.UUID]string{}
+	for _, draft := range drafts {
+		byTarget[draft.TargetID] = draft.Content
+	}
+	assert.Equal(t, "Hello", byTarget[bob])
+
+	// Saving empty content clears the draft
+	require.NoError(t, repo.Save(ctx, &models.Draft{UserID: userID, Type: "DM", TargetID: bob}))
+	drafts, err = repo.FindByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, drafts, 1)
+	assert.Equal(t, groupID, drafts[0].TargetID)
+}
@@ -13,8 +13,18 @@ type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	FindByID(ctx context.Context, id uuid.UUID) (*models.User, error)
 	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByIDs(ctx context.Context, ids []uuid.UUID) ([]models.User, error)
 	UpdateOnlineStatus(ctx context.Context, userID uuid.UUID, isOnline bool, lastSeen time.Time) error
+	UpdatePresence(ctx context.Context, userID uuid.UUID, presence string) error
 	Search(ctx context.Context, query string, excludeUserID uuid.UUID) ([]models.User, error)
+	UpdatePassword(ctx context.Context, userID uuid.UUID, passwordHash string) error
+	UpdateProfile(ctx context.Context, user *models.User) error
+	UpdatePrivacy(ctx context.Context, user *models.User) error
+	MarkEmailVerified(ctx context.Context, userID uuid.UUID, at time.Time) error
+	DeleteAccount(ctx context.Context, userID uuid.UUID, at time.Time) error
+	SetTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error
+	EnableTwoFactor(ctx context.Context, userID uuid.UUID, at time.Time) error
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
 }
 
 type MessageRepository interface {
@@ -42,9 +52,13 @@ type GroupRepository interface {
 type ConversationRepository interface {
 	Upsert(ctx context.Context, conv *models.Conversation) error
 	FindByUser(ctx context.Context, userID uuid.UUID) ([]models.Conversation, error)
-	IncrementUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, lastMessage string) error
-	ResetUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) error
-	FindContactsOfUser(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) // For presence broadcasting
+	FindInbox(ctx context.Context, userID uuid.UUID, filter InboxFilter) ([]models.InboxEntry, error)
+	FindOne(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) (*models.Conversation, error)
+	UpdateSettings(ctx context.Context, conv *models.Conversation) error
+	FindMutedUserIDs(ctx context.Context, convType string, targetID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error)
+	IncrementUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, lastMessage string, mentioned bool) error
+	ResetUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) (bool, error)
+	FindUnreadCounts(ctx context.Context, userIDs []uuid.UUID) ([]models.Conversation, error)
 }
 
 type RefreshTokenRepository interface {
@@ -52,4 +66,43 @@ type RefreshTokenRepository interface {
 	GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
 	Revoke(ctx context.Context, id uuid.UUID) error
 	RevokeByUser(ctx context.Context, userID uuid.UUID) error
+	Rotate(ctx context.Context, oldID uuid.UUID, next *models.RefreshToken) error
+	RevokeFamily(ctx context.Context, familyID uuid.UUID) error
+	FindActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.RefreshToken, error) // One per session
+	RevokeOtherFamilies(ctx context.Context, userID, keepFamilyID uuid.UUID) error
+}
+
+type AccountTokenRepository interface {
+	Create(ctx context.Context, token *models.AccountToken) error
+	Consume(ctx context.Context, hash, purpose string) (*models.AccountToken, error)
+	InvalidateByUser(ctx context.Context, userID uuid.UUID, purpose string) error
+}
+
+type RecoveryCodeRepository interface {
+	Replace(ctx context.Context, userID uuid.UUID, hashes []string) error
+	Consume(ctx context.Context, userID uuid.UUID, hash string) error
+	DeleteByUser(ctx context.Context, userID uuid.UUID) error
+}
+
+type UserIdentityRepository interface {
+	Create(ctx context.Context, identity *models.UserIdentity) error
+	FindBySubject(ctx context.Context, issuer, subject string) (*models.UserIdentity, error)
+}
+
+// ContactRepository stores contact requests and the accepted contacts they become.
+type ContactRepository interface {
+	Create(ctx context.Context, contact *models.Contact) error
+	FindByID(ctx context.Context, id uuid.UUID) (*models.Contact, error)
+	FindBetween(ctx context.Context, userID, otherID uuid.UUID) (*models.Contact, error)
+	Accept(ctx context.Context, id uuid.UUID, at time.Time) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	FindContactIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+	FindPending(ctx context.Context, userID uuid.UUID) ([]models.Contact, error)
+	AreContacts(ctx context.Context, userID, otherID uuid.UUID) (bool, error)
+}
+
+// DraftRepository stores the unsent message each user is typing per conversation.
+type DraftRepository interface {
+	Save(ctx context.Context, draft *models.Draft) error
+	FindByUser(ctx context.Context, userID uuid.UUID) ([]models.Draft, error)
 }
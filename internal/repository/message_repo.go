@@ -31,7 +31,10 @@ func (r *messageRepository) FindByID(ctx context.Context, id uuid.UUID) (*models
 }
 
 func (r *messageRepository) FindByConversation(ctx context.Context, userID, targetID uuid.UUID, msgType string, limit int, beforeID *uuid.UUID) ([]models.Message, error) {
-	query := r.db.WithContext(ctx).Preload("Sender").Order("created_at DESC").Limit(limit)
+	// Only the sender's public profile: their email and presence are private
+	query := r.db.WithContext(ctx).Preload("Sender", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "username", "display_name", "avatar_url", "account_deleted_at")
+	}).Order("created_at DESC").Limit(limit)
 
 	// Cursor-based pagination: if beforeID is provided, fetch messages older than that message
 	if beforeID != nil {
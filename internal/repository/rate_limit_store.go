@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/pkg/ratelimit"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rateLimitStore keeps rate limit state in the database so that limits hold
+// across server instances. Each operation locks the key's row.
+type rateLimitStore struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+// rateLimitRetention is how long an untouched entry is kept. It must exceed
+// every backoff window and the time any bucket takes to refill.
+const rateLimitRetention = 24 * time.Hour
+
+func NewRateLimitStore(db *gorm.DB) ratelimit.Store {
+	return &rateLimitStore{db: db}
+}
+
+// lock loads the entry at key for update. A missing entry is returned unsaved
+// with a zero UpdatedAt.
+func (s *rateLimitStore) lock(tx *gorm.DB, key string) (*models.RateLimitEntry, error) {
+	var entry models.RateLimitEntry
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.RateLimitEntry{Key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *rateLimitStore) save(tx *gorm.DB, entry *models.RateLimitEntry) error {
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(entry).Error
+}
+
+func (s *rateLimitStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	s.purge(ctx)
+
+	var res ratelimit.Result
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		entry, err := s.lock(tx, key)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if entry.UpdatedAt.IsZero() {
+			entry.Tokens = float64(limit.Burst)
+			entry.UpdatedAt = now
+		}
+		entry.Tokens, res = ratelimit.Refill(entry.Tokens, now.Sub(entry.UpdatedAt), limit)
+		entry.UpdatedAt = now
+		return s.save(tx, entry)
+	})
+	return res, err
+}
+
+func (s *rateLimitStore) RecordFailure(ctx context.Context, key string, window time.Duration) (ratelimit.Failures, error) {
+	var failures ratelimit.Failures
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		entry, err := s.lock(tx, key)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if now.Sub(entry.LastFailure) > window {
+			entry.Failures = 0
+		}
+		entry.Failures++
+		entry.LastFailure = now
+		entry.UpdatedAt = now
+		failures = ratelimit.Failures{Count: entry.Failures, Last: now}
+		return s.save(tx, entry)
+	})
+	return failures, err
+}
+
+func (s *rateLimitStore) Failures(ctx context.Context, key string, window time.Duration) (ratelimit.Failures, error) {
+	var entry models.RateLimitEntry
+	err := s.db.WithContext(ctx).Where("key = ?", key).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ratelimit.Failures{}, nil
+	}
+	if err != nil {
+		return ratelimit.Failures{}, err
+	}
+	if entry.Failures == 0 || time.Since(entry.LastFailure) > window {
+		return ratelimit.Failures{}, nil
+	}
+	return ratelimit.Failures{Count: entry.Failures, Last: entry.LastFailure}, nil
+}
+
+func (s *rateLimitStore) Reset(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("key = ?", key).Delete(&models.RateLimitEntry{}).Error
+}
+
+// purge deletes stale entries, at most once an hour per instance.
+func (s *rateLimitStore) purge(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastPurge) < time.Hour {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPurge = time.Now()
+	s.mu.Unlock()
+
+	s.db.WithContext(ctx).Where("updated_at < ?", time.Now().Add(-rateLimitRetention)).Delete(&models.RateLimitEntry{})
+}
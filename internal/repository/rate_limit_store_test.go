@@ -0,0 +1,60 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-app/internal/repository"
+	"chat-app/pkg/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitStore_Take(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	store := repository.NewRateLimitStore(db)
+	limit := ratelimit.PerMinute(2)
+
+	for i := 0; i < 2; i++ {
+		res, err := store.Take(ctx, "auth:1.2.3.4", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+	res, err := store.Take(ctx, "auth:1.2.3.4", limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Greater(t, res.RetryAfter, time.Duration(0))
+
+	// A second instance sees the same bucket
+	res, err = repository.NewRateLimitStore(db).Take(ctx, "auth:1.2.3.4", limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	res, err = store.Take(ctx, "auth:5.6.7.8", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+func TestRateLimitStore_Failures(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	store := repository.NewRateLimitStore(db)
+
+	_, err := store.RecordFailure(ctx, "login:a", time.Minute)
+	require.NoError(t, err)
+	f, err := store.RecordFailure(ctx, "login:a", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 2, f.Count)
+
+	f, err = store.Failures(ctx, "login:a", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 2, f.Count)
+
+	require.NoError(t, store.Reset(ctx, "login:a"))
+	f, err = store.Failures(ctx, "login:a", time.Minute)
+	require.NoError(t, err)
+	assert.Zero(t, f.Count)
+}
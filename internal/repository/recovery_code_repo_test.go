@@ -0,0 +1,31 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoveryCodeRepository_ConsumeOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewRecoveryCodeRepository(setupTestDB(t))
+	userID := uuid.New()
+
+	require.NoError(t, repo.Replace(ctx, userID, []string{"hash-1", "hash-2"}))
+
+	// Codes belong to their user
+	assert.ErrorIs(t, repo.Consume(ctx, uuid.New(), "hash-1"), repository.ErrRecoveryCodeInvalid)
+
+	require.NoError(t, repo.Consume(ctx, userID, "hash-1"))
+	assert.ErrorIs(t, repo.Consume(ctx, userID, "hash-1"), repository.ErrRecoveryCodeInvalid)
+
+	// A new set replaces the old one
+	require.NoError(t, repo.Replace(ctx, userID, []string{"hash-3"}))
+	assert.ErrorIs(t, repo.Consume(ctx, userID, "hash-2"), repository.ErrRecoveryCodeInvalid)
+	assert.NoError(t, repo.Consume(ctx, userID, "hash-3"))
+}
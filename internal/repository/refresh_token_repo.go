@@ -3,11 +3,16 @@ package repository
 import (
 	"context"
 	"chat-app/internal/models"
+	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ErrRefreshTokenRotated is returned by Rotate when the token was already revoked or rotated.
+var ErrRefreshTokenRotated = errors.New("refresh token already rotated or revoked")
+
 type refreshTokenRepository struct {
 	db *gorm.DB
 }
@@ -36,3 +41,49 @@ func (r *refreshTokenRepository) Revoke(ctx context.Context, id uuid.UUID) error
 func (r *refreshTokenRepository) RevokeByUser(ctx context.Context, userID uuid.UUID) error {
 	return r.db.WithContext(ctx).Model(&models.RefreshToken{}).Where("user_id = ?", userID).Update("revoked", true).Error
 }
+
+// Rotate revokes the token oldID, links it to next and stores next, atomically.
+// Only one caller can rotate a given token; the others get ErrRefreshTokenRotated.
+func (r *refreshTokenRepository) Rotate(ctx context.Context, oldID uuid.UUID, next *models.RefreshToken) error {
+	if next.ID == uuid.Nil {
+		next.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked = ?", oldID, false).
+			Updates(map[string]interface{}{"revoked": true, "replaced_by_id": next.ID})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrRefreshTokenRotated
+		}
+		return tx.Create(next).Error
+	})
+}
+
+// RevokeFamily revokes every token of a session. Tokens issued before rotation
+// have no family_id and form a family identified by their own ID.
+func (r *refreshTokenRepository) RevokeFamily(ctx context.Context, familyID uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("family_id = ? OR id = ?", familyID, familyID).
+		Update("revoked", true).Error
+}
+
+// FindActiveByUser returns the unrevoked, unexpired tokens of the user, most recently used first.
+// Rotation keeps at most one such token per family.
+func (r *refreshTokenRepository) FindActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.RefreshToken, error) {
+	var tokens []models.RefreshToken
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND revoked = ? AND expires_at > ?", userID, false, time.Now()).
+		Order("last_used_at DESC").
+		Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeOtherFamilies revokes every session of the user except keepFamilyID.
+func (r *refreshTokenRepository) RevokeOtherFamilies(ctx context.Context, userID, keepFamilyID uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND family_id <> ? AND id <> ?", userID, keepFamilyID, keepFamilyID).
+		Update("revoked", true).Error
+}
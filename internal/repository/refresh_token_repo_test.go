@@ -0,0 +1,152 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.RefreshToken{}, &models.AccountToken{}, &models.User{}, &models.Conversation{}, &models.GroupMember{}, &models.RateLimitEntry{}, &models.RecoveryCode{}, &models.UserIdentity{}, &models.Contact{}, &models.Draft{}, &models.Group{}))
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1) // every connection would get its own in-memory database
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func newToken(userID, familyID uuid.UUID) *models.RefreshToken {
+	return &models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: uuid.NewString(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+}
+
+func TestRefreshTokenRepository_Rotate(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewRefreshTokenRepository(setupTestDB(t))
+
+	userID, familyID := uuid.New(), uuid.New()
+	old := newToken(userID, familyID)
+	require.NoError(t, repo.Create(ctx, old))
+
+	next := newToken(userID, familyID)
+	require.NoError(t, repo.Rotate(ctx, old.ID, next))
+
+	stored, err := repo.GetByHash(ctx, old.TokenHash)
+	require.NoError(t, err)
+	assert.True(t, stored.Revoked)
+	require.NotNil(t, stored.ReplacedByID)
+	assert.Equal(t, next.ID, *stored.ReplacedByID)
+
+	stored, err = repo.GetByHash(ctx, next.TokenHash)
+	require.NoError(t, err)
+	assert.False(t, stored.Revoked)
+	assert.Equal(t, familyID, stored.FamilyID)
+}
+
+func TestRefreshTokenRepository_RotateTwiceFails(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewRefreshTokenRepository(setupTestDB(t))
+
+	userID, familyID := uuid.New(), uuid.New()
+	old := newToken(userID, familyID)
+	require.NoError(t, repo.Create(ctx, old))
+	require.NoError(t, repo.Rotate(ctx, old.ID, newToken(userID, familyID)))
+
+	loser := newToken(userID, familyID)
+	err := repo.Rotate(ctx, old.ID, loser)
+	assert.ErrorIs(t, err, repository.ErrRefreshTokenRotated)
+
+	// The losing replacement must not have been stored
+	_, err = repo.GetByHash(ctx, loser.TokenHash)
+	assert.Error(t, err)
+}
+
+func TestRefreshTokenRepository_RevokeFamily(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewRefreshTokenRepository(setupTestDB(t))
+
+	userID, familyID := uuid.New(), uuid.New()
+	first := newToken(userID, familyID)
+	second := newToken(userID, familyID)
+	otherSession := newToken(userID, uuid.New())
+	for _, tok := range []*models.RefreshToken{first, second, otherSession} {
+		require.NoError(t, repo.Create(ctx, tok))
+	}
+
+	require.NoError(t, repo.RevokeFamily(ctx, familyID))
+
+	for _, tok := range []*models.RefreshToken{first, second} {
+		stored, err := repo.GetByHash(ctx, tok.TokenHash)
+		require.NoError(t, err)
+		assert.True(t, stored.Revoked)
+	}
+	stored, err := repo.GetByHash(ctx, otherSession.TokenHash)
+	require.NoError(t, err)
+	assert.False(t, stored.Revoked)
+}
+
+func TestRefreshTokenRepository_FindActiveByUser(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewRefreshTokenRepository(setupTestDB(t))
+
+	userID := uuid.New()
+	older := newToken(userID, uuid.New())
+	older.LastUsedAt = time.Now().Add(-time.Hour)
+	newer := newToken(userID, uuid.New())
+	newer.LastUsedAt = time.Now()
+	revoked := newToken(userID, uuid.New())
+	revoked.Revoked = true
+	expired := newToken(userID, uuid.New())
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	for _, tok := range []*models.RefreshToken{older, newer, revoked, expired, newToken(uuid.New(), uuid.New())} {
+		require.NoError(t, repo.Create(ctx, tok))
+	}
+
+	active, err := repo.FindActiveByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, active, 2)
+	assert.Equal(t, newer.ID, active[0].ID)
+	assert.Equal(t, older.ID, active[1].ID)
+}
+
+func TestRefreshTokenRepository_RevokeOtherFamilies(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewRefreshTokenRepository(setupTestDB(t))
+
+	userID, keep := uuid.New(), uuid.New()
+	kept := newToken(userID, keep)
+	other := newToken(userID, uuid.New())
+	someoneElse := newToken(uuid.New(), uuid.New())
+	for _, tok := range []*models.RefreshToken{kept, other, someoneElse} {
+		require.NoError(t, repo.Create(ctx, tok))
+	}
+
+	require.NoError(t, repo.RevokeOtherFamilies(ctx, userID, keep))
+
+	active, err := repo.FindActiveByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, active, 1)
+	assert.Equal(t, kept.ID, active[0].ID)
+
+	stored, err := repo.GetByHash(ctx, someoneElse.TokenHash)
+	require.NoError(t, err)
+	assert.False(t, stored.Revoked)
+}
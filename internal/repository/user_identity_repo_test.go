@@ -0,0 +1,34 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserIdentityRepository_SubjectIsUniquePerIssuer(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewUserIdentityRepository(setupTestDB(t))
+	userID := uuid.New()
+
+	found, err := repo.FindBySubject(ctx, "https://idp-a", "42")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	require.NoError(t, repo.Create(ctx, &models.UserIdentity{UserID: userID, Issuer: "https://idp-a", Subject: "42"}))
+	assert.Error(t, repo.Create(ctx, &models.UserIdentity{UserID: uuid.New(), Issuer: "https://idp-a", Subject: "42"}))
+
+	// The same subject at another provider is someone else
+	require.NoError(t, repo.Create(ctx, &models.UserIdentity{UserID: uuid.New(), Issuer: "https://idp-b", Subject: "42"}))
+
+	found, err = repo.FindBySubject(ctx, "https://idp-a", "42")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, userID, found.UserID)
+}
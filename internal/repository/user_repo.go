@@ -45,6 +45,16 @@ func (r *userRepository) FindByEmail(ctx context.Context, email string) (*models
 	return &user, nil
 }
 
+// FindByIDs returns the users with the given IDs that exist, in no particular order.
+func (r *userRepository) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
+	var users []models.User
+	if len(ids) == 0 {
+		return users, nil
+	}
+	err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
 func (r *userRepository) UpdateOnlineStatus(ctx context.Context, userID uuid.UUID, isOnline bool, lastSeen time.Time) error {
 	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
 		"is_online": isOnline,
@@ -54,11 +64,13 @@ func (r *userRepository) UpdateOnlineStatus(ctx context.Context, userID uuid.UUI
 
 func (r *userRepository) Search(ctx context.Context, query string, excludeUserID uuid.UUID) ([]models.User, error) {
 	var users []models.User
-	db := r.db.WithContext(ctx).Where("id != ?", excludeUserID)
+	db := r.db.WithContext(ctx).Where("id != ? AND account_deleted_at IS NULL", excludeUserID)
 
 	if query != "" {
+		// Emails only match exactly, and only if their owner lets everyone see them
 		searchPattern := "%" + query + "%"
-		db = db.Where("username LIKE ? OR email LIKE ?", searchPattern, searchPattern)
+		db = db.Where("username LIKE ? OR display_name LIKE ? OR (email = ? AND email_visibility = ?)",
+			searchPattern, searchPattern, query, models.VisibilityEveryone)
 	} else {
 		// If query is empty, limit results (e.g., top 20 recent users or random)
 		// For now, let's just limit to 20 to avoid dumping the whole DB
@@ -70,3 +82,165 @@ func (r *userRepository) Search(ctx context.Context, query string, excludeUserID
 	}
 	return users, nil
 }
+
+func (r *userRepository) UpdatePassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Update("password", passwordHash).Error
+}
+
+// UpdateProfile saves the public profile fields of user.
+func (r *userRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"display_name":      user.DisplayName,
+		"bio":               user.Bio,
+		"avatar_url":        user.AvatarURL,
+		"status_text":       user.StatusText,
+		"status_expires_at": user.StatusExpiresAt,
+	}).Error
+}
+
+// UpdatePresence saves the presence state the user chose.
+func (r *userRepository) UpdatePresence(ctx context.Context, userID uuid.UUID, presence string) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Update("presence", presence).Error
+}
+
+// UpdatePrivacy saves the privacy settings of user, including who may DM them.
+func (r *userRepository) UpdatePrivacy(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"email_visibility":     user.EmailVisibility,
+		"last_seen_visibility": user.LastSeenVisibility,
+		"online_visibility":    user.OnlineVisibility,
+		"dm_policy":            user.DMPolicy,
+	}).Error
+}
+
+// MarkEmailVerified records when the user proved ownership of their email.
+// An earlier verification is kept.
+func (r *userRepository) MarkEmailVerified(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NULL", userID).
+		Update("email_verified_at", at).Error
+}
+
+// SetTOTPSecret stores a new, not yet enabled, TOTP secret. An empty secret
+// turns two-factor authentication off.
+func (r *userRepository) SetTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":           secret,
+		"totp_last_step":        0,
+		"two_factor_enabled_at": nil,
+	}).Error
+}
+
+// EnableTwoFactor starts enforcing the stored TOTP secret.
+func (r *userRepository) EnableTwoFactor(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND totp_secret <> ''", userID).
+		Update("two_factor_enabled_at", at).Error
+}
+
+// UseTOTPStep records that the code of a time step was accepted. It returns
+// false if that step (or a later one) was used already, i.e. the code is replayed.
+func (r *userRepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	return res.RowsAffected > 0, res.Error
+}
+
+// DeleteAccount anonymizes the user, atomically:
+//  1. The row is kept so that messages they sent still resolve, but every personal
+//     field is overwritten and the password can no longer match.
+//  2. Their own inbox entries are deleted, and their drafts erased. Peers keep
+//     their conversations and history.
+//  3. They leave every group and lose their contacts. A group losing its last
+//     admin gets its longest-standing remaining member promoted.
+//  4. Outstanding verification and reset links stop working, and two-factor
+//     authentication is removed.
+func (r *userRepository) DeleteAccount(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		placeholder := "deleted-" + userID.String()
+		res := tx.Model(&models.User{}).Where("id = ? AND account_deleted_at IS NULL", userID).Updates(map[string]interface{}{
+			"username":           placeholder,
+			"email":              placeholder + "@deleted.invalid",
+			"password":           "!", // Not a bcrypt hash, so no password matches
+			"is_online":          false,
+			"email_verified_at":  nil,
+			"account_deleted_at": at,
+
+			"display_name":      "",
+			"bio":               "",
+			"avatar_url":        "",
+			"status_text":       "",
+			"status_expires_at": nil,
+
+			"totp_secret":           "",
+			"two_factor_enabled_at": nil,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.Conversation{}).Error; err != nil {
+			return err
+		}
+		// Drafts are unsent message content, so they are not kept even soft-deleted
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.Draft{}).Error; err != nil {
+			return err
+		}
+
+		var memberships []models.GroupMember
+		if err := tx.Where("user_id = ?", userID).Find(&memberships).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.GroupMember{}).Error; err != nil {
+			return err
+		}
+		for _, m := range memberships {
+			if m.Role != "ADMIN" {
+				continue
+			}
+			if err := handOffAdmin(tx, m.GroupID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.UserIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ? OR contact_id = ?", userID, userID).Delete(&models.Contact{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.AccountToken{}).
+			Where("user_id = ? AND used_at IS NULL", userID).
+			Update("used_at", at).Error
+	})
+}
+
+// handOffAdmin promotes the longest-standing member of a group left without an admin.
+func handOffAdmin(tx *gorm.DB, groupID uuid.UUID) error {
+	var admins int64
+	if err := tx.Model(&models.GroupMember{}).Where("group_id = ? AND role = ?", groupID, "ADMIN").Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins > 0 {
+		return nil
+	}
+
+	var successor models.GroupMember
+	err := tx.Where("group_id = ?", groupID).Order("joined_at ASC").First(&successor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // Nobody left
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.GroupMember{}).
+		Where("group_id = ? AND user_id = ?", groupID, successor.UserID).
+		Update("role", "ADMIN").Error
+}
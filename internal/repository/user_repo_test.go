@@ -0,0 +1,162 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserRepository_DeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	repo := repository.NewUserRepository(db)
+
+	alice := &models.User{Username: "alice", Email: "alice@example.com", Password: "hash"}
+	bob := &models.User{Username: "bob", Email: "bob@example.com", Password: "hash"}
+	carol := &models.User{Username: "carol", Email: "carol@example.com", Password: "hash"}
+	for _, u := range []*models.User{alice, bob, carol} {
+		require.NoError(t, repo.Create(ctx, u))
+	}
+
+	// alice is the only admin of a group bob joined before carol
+	groupID := uuid.New()
+	require.NoError(t, db.Create(&[]models.GroupMember{
+		{GroupID: groupID, UserID: alice.ID, Role: "ADMIN", JoinedAt: time.Now().Add(-3 * time.Hour)},
+		{GroupID: groupID, UserID: bob.ID, Role: "MEMBER", JoinedAt: time.Now().Add(-2 * time.Hour)},
+		{GroupID: groupID, UserID: carol.ID, Role: "MEMBER", JoinedAt: time.Now().Add(-time.Hour)},
+	}).Error)
+	require.NoError(t, db.Create(&[]models.Conversation{
+		{UserID: alice.ID, Type: "DM", TargetID: bob.ID},
+		{UserID: bob.ID, Type: "DM", TargetID: alice.ID},
+	}).Error)
+	drafts := repository.NewDraftRepository(db)
+	require.NoError(t, drafts.Save(ctx, &models.Draft{UserID: alice.ID, Type: "DM", TargetID: bob.ID, Content: "secret plans"}))
+	require.NoError(t, drafts.Save(ctx, &models.Draft{UserID: bob.ID, Type: "DM", TargetID: alice.ID, Content: "see you"}))
+
+	require.NoError(t, repo.DeleteAccount(ctx, alice.ID, time.Now()))
+
+	// 1. Anonymized, and shown as a deleted user
+	deleted, err := repo.FindByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DeletedUsername, deleted.Username)
+	assert.NotEqual(t, "alice@example.com", deleted.Email)
+	assert.NotNil(t, deleted.AccountDeletedAt)
+	byEmail, err := repo.FindByEmail(ctx, "alice@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, byEmail)
+
+	// 2. Only alice's inbox entry is gone
+	var conversations []models.Conversation
+	require.NoError(t, db.Find(&conversations).Error)
+	require.Len(t, conversations, 1)
+	assert.Equal(t, bob.ID, conversations[0].UserID)
+
+	// ... and so are her drafts, for good
+	var remaining []models.Draft
+	require.NoError(t, db.Unscoped().Find(&remaining).Error)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, bob.ID, remaining[0].UserID)
+
+	// 3. bob took over the group
+	var members []models.GroupMember
+	require.NoError(t, db.Where("group_id = ?", groupID).Order("joined_at").Find(&members).Error)
+	require.Len(t, members, 2)
+	assert.Equal(t, bob.ID, members[0].UserID)
+	assert.Equal(t, "ADMIN", members[0].Role)
+	assert.Equal(t, "MEMBER", members[1].Role)
+
+	// Deleted users don't show up in search, and can't be deleted twice
+	users, err := repo.Search(ctx, "", bob.ID)
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Error(t, repo.DeleteAccount(ctx, alice.ID, time.Now()))
+}
+
+func TestUserRepository_TwoFactor(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewUserRepository(setupTestDB(t))
+
+	user := &models.User{Username: "alice", Email: "alice@example.com", Password: "hash"}
+	require.NoError(t, repo.Create(ctx, user))
+
+	require.NoError(t, repo.SetTOTPSecret(ctx, user.ID, "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, repo.EnableTwoFactor(ctx, user.ID, time.Now()))
+	found, err := repo.FindByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", found.TOTPSecret)
+	assert.NotNil(t, found.TwoFactorEnabledAt)
+
+	// Each time step is accepted once, and never after a later one
+	fresh, err := repo.UseTOTPStep(ctx, user.ID, 100)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+	for _, step := range []int64{100, 99} {
+		fresh, err = repo.UseTOTPStep(ctx, user.ID, step)
+		require.NoError(t, err)
+		assert.False(t, fresh, "step %d", step)
+	}
+
+	// Clearing the secret turns 2FA off
+	require.NoError(t, repo.SetTOTPSecret(ctx, user.ID, ""))
+	found, err = repo.FindByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, found.TOTPSecret)
+	assert.Nil(t, found.TwoFactorEnabledAt)
+}
+
+func TestUserRepository_UpdateProfile_ExpiredStatusIsHidden(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewUserRepository(setupTestDB(t))
+
+	user := &models.User{Username: "alice", Email: "alice@example.com", Password: "hash"}
+	require.NoError(t, repo.Create(ctx, user))
+
+	soon := time.Now().Add(time.Hour)
+	user.DisplayName, user.Bio, user.StatusText, user.StatusExpiresAt = "Alice", "Hi there", "In a meeting", &soon
+	require.NoError(t, repo.UpdateProfile(ctx, user))
+
+	found, err := repo.FindByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", found.DisplayName)
+	assert.Equal(t, "In a meeting", found.StatusText)
+	require.NotNil(t, found.StatusExpiresAt)
+
+	past := time.Now().Add(-time.Minute)
+	user.StatusExpiresAt = &past
+	require.NoError(t, repo.UpdateProfile(ctx, user))
+
+	found, err = repo.FindByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, found.StatusText)
+	assert.Nil(t, found.StatusExpiresAt)
+	assert.Equal(t, "Hi there", found.Bio)
+}
+
+func TestUserRepository_Search_MatchesEmailOnlyWhenPublic(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewUserRepository(setupTestDB(t))
+
+	private := &models.User{Username: "alice", Email: "alice@example.com", Password: "hash"}
+	public := &models.User{Username: "bob", Email: "bob@example.com", Password: "hash", EmailVisibility: models.VisibilityEveryone}
+	for _, u := range []*models.User{private, public} {
+		require.NoError(t, repo.Create(ctx, u))
+	}
+
+	for query, want := range map[string]int{
+		"example.com":       0, // No partial email matches
+		"alice@example.com": 0,
+		"bob@example.com":   1,
+		"ali":               1,
+	} {
+		users, err := repo.Search(ctx, query, uuid.New())
+		require.NoError(t, err)
+		assert.Len(t, users, want, query)
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/mailer"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AccountConfig configures the links mailed by AccountService.
+type AccountConfig struct {
+	BaseURL   string        // Frontend URL the links point at, e.g. https://chat.example.com
+	VerifyTTL time.Duration // Lifetime of email verification links
+	ResetTTL  time.Duration // Lifetime of password reset links
+}
+
+// Default link lifetimes
+const (
+	DefaultVerifyTTL = 24 * time.Hour
+	DefaultResetTTL  = time.Hour
+)
+
+type accountService struct {
+	userRepo  repository.UserRepository
+	tokenRepo repository.AccountTokenRepository
+	mailer    mailer.Mailer
+	auth      AuthService
+	cfg       AccountConfig
+}
+
+func NewAccountService(userRepo repository.UserRepository, tokenRepo repository.AccountTokenRepository, m mailer.Mailer, auth AuthService, cfg AccountConfig) AccountService {
+	if cfg.VerifyTTL == 0 {
+		cfg.VerifyTTL = DefaultVerifyTTL
+	}
+	if cfg.ResetTTL == 0 {
+		cfg.ResetTTL = DefaultResetTTL
+	}
+	return &accountService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		mailer:    m,
+		auth:      auth,
+		cfg:       cfg,
+	}
+}
+
+// SendVerification mails the user a link proving ownership of their email.
+// Earlier links stop working. Already verified users get no mail.
+func (s *accountService) SendVerification(ctx context.Context, user *models.User) error {
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	rawToken, err := s.issueToken(ctx, user.ID, models.AccountTokenVerifyEmail, s.cfg.VerifyTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, s.link("/verify-email", rawToken), s.cfg.VerifyTTL),
+	})
+}
+
+// ResendVerification mails a fresh verification link to the user.
+func (s *accountService) ResendVerification(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return apperrors.ErrNotFound
+	}
+	return s.SendVerification(ctx, user)
+}
+
+// VerifyEmail redeems a verification link.
+func (s *accountService) VerifyEmail(ctx context.Context, rawToken string) error {
+	token, err := s.consume(ctx, rawToken, models.AccountTokenVerifyEmail)
+	if err != nil {
+		return err
+	}
+	return s.userRepo.MarkEmailVerified(ctx, token.UserID, time.Now())
+}
+
+// ForgotPassword mails a reset link if email belongs to a user. It reports success
+// either way so that it cannot be used to find out which emails are registered.
+func (s *accountService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	rawToken, err := s.issueToken(ctx, user.ID, models.AccountTokenResetPassword, s.cfg.ResetTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset your password. If it was you, open this link:\n\n%s\n\nThe link expires in %s. If it wasn't you, ignore this email.\n",
+			user.Username, s.link("/reset-password", rawToken), s.cfg.ResetTTL),
+	}); err != nil {
+		// Don't reveal delivery problems (and thereby the account) to the caller
+		log.Printf("Failed to send password reset email to user %s: %v", user.ID, err)
+	}
+	return nil
+}
+
+// ResetPassword redeems a reset link. The new password signs the user out
+// everywhere, and since the link was mailed to them it also verifies their email.
+func (s *accountService) ResetPassword(ctx context.Context, rawToken, newPassword string) error {
+	token, err := s.consume(ctx, rawToken, models.AccountTokenResetPassword)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(ctx, token.UserID, string(hashedPassword)); err != nil {
+		return err
+	}
+
+	// Other reset links mailed earlier must not work with the new password in place
+	if err := s.tokenRepo.InvalidateByUser(ctx, token.UserID, models.AccountTokenResetPassword); err != nil {
+		return err
+	}
+	if err := s.userRepo.MarkEmailVerified(ctx, token.UserID, time.Now()); err != nil {
+		return err
+	}
+	return s.auth.LogoutAll(ctx, token.UserID)
+}
+
+// issueToken stores a new token for the purpose, superseding older ones, and returns it raw.
+func (s *accountService) issueToken(ctx context.Context, userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	rawToken := base64.RawURLEncoding.EncodeToString(b)
+
+	if err := s.tokenRepo.InvalidateByUser(ctx, userID, purpose); err != nil {
+		return "", err
+	}
+	token := &models.AccountToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(rawToken),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		return "", err
+	}
+	return rawToken, nil
+}
+
+func (s *accountService) consume(ctx context.Context, rawToken, purpose string) (*models.AccountToken, error) {
+	token, err := s.tokenRepo.Consume(ctx, hashToken(rawToken), purpose)
+	if errors.Is(err, repository.ErrAccountTokenInvalid) {
+		return nil, apperrors.ErrInvalidAccountToken
+	}
+	return token, err
+}
+
+func (s *accountService) link(path, rawToken string) string {
+	return s.cfg.BaseURL + path + "?token=" + url.QueryEscape(rawToken)
+}
@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/mailer"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"chat-app/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MockAccountTokenRepo
+type MockAccountTokenRepo struct {
+	mock.Mock
+}
+
+func (m *MockAccountTokenRepo) Create(ctx context.Context, token *models.AccountToken) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+func (m *MockAccountTokenRepo) Consume(ctx context.Context, hash, purpose string) (*models.AccountToken, error) {
+	args := m.Called(ctx, hash, purpose)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.AccountToken), args.Error(1)
+}
+
+func (m *MockAccountTokenRepo) InvalidateByUser(ctx context.Context, userID uuid.UUID, purpose string) error {
+	args := m.Called(ctx, userID, purpose)
+	return args.Error(0)
+}
+
+// fakeMailer records sent messages.
+type fakeMailer struct {
+	sent []mailer.Message
+}
+
+func (f *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// stubAuthService records LogoutAll calls.
+type stubAuthService struct {
+	service.AuthService
+	loggedOut []uuid.UUID
+}
+
+func (s *stubAuthService) LogoutAll(ctx context.Context, userID uuid.UUID) error {
+	s.loggedOut = append(s.loggedOut, userID)
+	return nil
+}
+
+func setupAccountService() (service.AccountService, *MockUserRepo, *MockAccountTokenRepo, *fakeMailer, *stubAuthService) {
+	userRepo := new(MockUserRepo)
+	tokenRepo := new(MockAccountTokenRepo)
+	mail := &fakeMailer{}
+	auth := &stubAuthService{}
+	svc := service.NewAccountService(userRepo, tokenRepo, mail, auth, service.AccountConfig{BaseURL: "https://chat.example.com"})
+	return svc, userRepo, tokenRepo, mail, auth
+}
+
+// linkToken extracts the token from the link in a mailed message.
+func linkToken(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	i := strings.Index(msg.Body, "https://chat.example.com/")
+	require.GreaterOrEqual(t, i, 0)
+	link, err := url.Parse(strings.Fields(msg.Body[i:])[0])
+	require.NoError(t, err)
+	return link.Query().Get("token")
+}
+
+func TestAccountService_SendVerification_StoresOnlyHash(t *testing.T) {
+	ctx := context.Background()
+	svc, _, tokenRepo, mail, _ := setupAccountService()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Username: "alice", Email: "alice@example.com"}
+
+	var stored *models.AccountToken
+	tokenRepo.On("InvalidateByUser", ctx, user.ID, models.AccountTokenVerifyEmail).Return(nil)
+	tokenRepo.On("Create", ctx, mock.MatchedBy(func(tok *models.AccountToken) bool {
+		stored = tok
+		return tok.Purpose == models.AccountTokenVerifyEmail && tok.UserID == user.ID
+	})).Return(nil)
+
+	require.NoError(t, svc.SendVerification(ctx, user))
+
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "alice@example.com", mail.sent[0].To)
+	raw := linkToken(t, mail.sent[0])
+	assert.NotEmpty(t, raw)
+	assert.NotEqual(t, raw, stored.TokenHash)
+	assert.NotContains(t, mail.sent[0].Body, stored.TokenHash)
+}
+
+func TestAccountService_ForgotPassword_UnknownEmailIsSilent(t *testing.T) {
+	ctx := context.Background()
+	svc, userRepo, tokenRepo, mail, _ := setupAccountService()
+
+	userRepo.On("FindByEmail", ctx, "nobody@example.com").Return(nil, nil)
+
+	assert.NoError(t, svc.ForgotPassword(ctx, "nobody@example.com"))
+	assert.Empty(t, mail.sent)
+	tokenRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ResetPassword(t *testing.T) {
+	ctx := context.Background()
+	svc, userRepo, tokenRepo, _, auth := setupAccountService()
+	userID := uuid.New()
+
+	tokenRepo.On("Consume", ctx, mock.Anything, models.AccountTokenResetPassword).
+		Return(&models.AccountToken{UserID: userID, Purpose: models.AccountTokenResetPassword}, nil)
+	tokenRepo.On("InvalidateByUser", ctx, userID, models.AccountTokenResetPassword).Return(nil)
+	userRepo.On("UpdatePassword", ctx, userID, mock.MatchedBy(func(hash string) bool {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte("new-password")) == nil
+	})).Return(nil)
+	userRepo.On("MarkEmailVerified", ctx, userID, mock.Anything).Return(nil)
+
+	require.NoError(t, svc.ResetPassword(ctx, "raw-token", "new-password"))
+
+	userRepo.AssertExpectations(t)
+	tokenRepo.AssertExpectations(t)
+	assert.Equal(t, []uuid.UUID{userID}, auth.loggedOut, "every session is signed out")
+}
+
+func TestAccountService_ResetPassword_InvalidToken(t *testing.T) {
+	ctx := context.Background()
+	svc, userRepo, tokenRepo, _, auth := setupAccountService()
+
+	tokenRepo.On("Consume", ctx, mock.Anything, models.AccountTokenResetPassword).Return(nil, repository.ErrAccountTokenInvalid)
+
+	err := svc.ResetPassword(ctx, "used-token", "new-password")
+
+	assert.ErrorIs(t, err, apperrors.ErrInvalidAccountToken)
+	userRepo.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything, mock.Anything)
+	assert.Empty(t, auth.loggedOut)
+}
@@ -11,6 +11,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,14 +23,47 @@ type authService struct {
 	userRepo         repository.UserRepository
 	refreshTokenRepo repository.RefreshTokenRepository
 	jwtService       jwt.Service
+	disconnector     SessionDisconnector
+	loginGuard       *LoginGuard
+	twoFactor        TwoFactorService
+	identities       repository.UserIdentityRepository
 }
 
-func NewAuthService(userRepo repository.UserRepository, refreshTokenRepo repository.RefreshTokenRepository, jwtService jwt.Service) AuthService {
-	return &authService{
+// AuthOption configures optional behaviour of the AuthService.
+type AuthOption func(*authService)
+
+// WithLoginGuard throttles failed logins with guard.
+func WithLoginGuard(guard *LoginGuard) AuthOption {
+	return func(s *authService) {
+		s.loginGuard = guard
+	}
+}
+
+// WithTwoFactor lets users with two-factor authentication complete their logins.
+func WithTwoFactor(twoFactor TwoFactorService) AuthOption {
+	return func(s *authService) {
+		s.twoFactor = twoFactor
+	}
+}
+
+// WithIdentities enables sign-in through an external identity provider (see LoginExternal).
+func WithIdentities(identities repository.UserIdentityRepository) AuthOption {
+	return func(s *authService) {
+		s.identities = identities
+	}
+}
+
+func NewAuthService(userRepo repository.UserRepository, refreshTokenRepo repository.RefreshTokenRepository, jwtService jwt.Service, disconnector SessionDisconnector, opts ...AuthOption) AuthService {
+	s := &authService{
 		userRepo:         userRepo,
 		refreshTokenRepo: refreshTokenRepo,
 		jwtService:       jwtService,
+		disconnector:     disconnector,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *authService) Register(ctx context.Context, username, email, password string) (string, string, *models.User, error) {
@@ -61,49 +95,233 @@ func (s *authService) Register(ctx context.Context, username, email, password st
 		return "", "", nil, err
 	}
 
-	// 4. Generate Tokens
-	accessToken, err := s.jwtService.GenerateToken(user.ID)
-	if err != nil {
-		return "", "", nil, err
+	// 4. Generate Tokens (a new session)
+	return s.startSession(ctx, user)
+}
+
+// Login signs the user in. Unknown emails and wrong passwords fail alike with
+// ErrInvalidCredentials; with a LoginGuard, repeated failures are rejected with
+// a *apperrors.RateLimitError until the lockout expires. Accounts with two-factor
+// authentication get a *TwoFactorRequiredError instead of tokens.
+func (s *authService) Login(ctx context.Context, email, password string) (string, string, *models.User, error) {
+	ip := clientInfoFromContext(ctx).IPAddress
+
+	// 1. Refuse while locked out
+	if s.loginGuard != nil {
+		if wait := s.loginGuard.Check(ctx, email, ip); wait > 0 {
+			return "", "", nil, &apperrors.RateLimitError{RetryAfter: wait}
+		}
 	}
 
-	refreshToken, err := s.createRefreshToken(ctx, user.ID)
+	// 2. Find user and check password
+	user, err := s.userRepo.FindByEmail(ctx, email)
 	if err != nil {
 		return "", "", nil, err
 	}
+	if user == nil {
+		comparePasswordTiming(password)
+	}
+	if user == nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		if s.loginGuard != nil {
+			s.loginGuard.Fail(ctx, email, ip)
+		}
+		return "", "", nil, apperrors.ErrInvalidCredentials
+	}
 
-	return accessToken, refreshToken, user, nil
+	// 3. With 2FA the password only earns a challenge; the failure counters
+	// stay until the second step succeeds
+	if user.TwoFactorEnabledAt != nil {
+		return "", "", nil, s.twoFactorChallenge(user)
+	}
+	if s.loginGuard != nil {
+		s.loginGuard.Succeed(ctx, email)
+	}
+
+	// 4. Generate Tokens (a new session)
+	return s.startSession(ctx, user)
 }
 
-func (s *authService) Login(ctx context.Context, email, password string) (string, string, *models.User, error) {
-	// 1. Find user
-	user, err := s.userRepo.FindByEmail(ctx, email)
+// twoFactorChallenge answers a successful first login step of an account with
+// two-factor authentication: a *TwoFactorRequiredError for LoginTwoFactor.
+func (s *authService) twoFactorChallenge(user *models.User) error {
+	challenge, expiresAt, err := s.jwtService.GenerateChallengeToken(user.ID, TwoFactorChallengeTTL)
+	if err != nil {
+		return err
+	}
+	return &TwoFactorRequiredError{ChallengeToken: challenge, ExpiresAt: expiresAt}
+}
+
+// LoginTwoFactor completes a login that Login answered with a
+// TwoFactorRequiredError. code is a TOTP code or a recovery code. Wrong codes
+// count as failed logins.
+func (s *authService) LoginTwoFactor(ctx context.Context, challengeToken, code string) (string, string, *models.User, error) {
+	if s.twoFactor == nil {
+		return "", "", nil, errors.New("two-factor authentication is not configured")
+	}
+	ip := clientInfoFromContext(ctx).IPAddress
+
+	// 1. Check the challenge
+	claims, err := s.jwtService.ParseChallengeToken(challengeToken)
+	if err != nil {
+		return "", "", nil, apperrors.ErrInvalidChallenge
+	}
+	user, err := s.userRepo.FindByID(ctx, claims.UserID)
 	if err != nil {
 		return "", "", nil, err
 	}
 	if user == nil {
-		return "", "", nil, apperrors.ErrNotFound
+		return "", "", nil, apperrors.ErrInvalidChallenge
 	}
 
-	// 2. Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", "", nil, apperrors.ErrInvalidCredentials
+	// 2. Refuse while locked out
+	if s.loginGuard != nil {
+		if wait := s.loginGuard.Check(ctx, user.Email, ip); wait > 0 {
+			return "", "", nil, &apperrors.RateLimitError{RetryAfter: wait}
+		}
+	}
+
+	// 3. Check the code
+	if err := s.twoFactor.Verify(ctx, user, code); err != nil {
+		if errors.Is(err, apperrors.ErrInvalidTwoFactor) && s.loginGuard != nil {
+			s.loginGuard.Fail(ctx, user.Email, ip)
+		}
+		return "", "", nil, err
+	}
+	s.jwtService.Revoke(claims) // The challenge is single-use
+	if s.loginGuard != nil {
+		s.loginGuard.Succeed(ctx, user.Email)
 	}
 
-	// 3. Generate Tokens
-	accessToken, err := s.jwtService.GenerateToken(user.ID)
+	// 4. Generate Tokens (a new session)
+	return s.startSession(ctx, user)
+}
+
+// LoginExternal signs in the user behind an identity asserted by an external
+// identity provider. Unknown identities are linked to the account with the same
+// email, or get a new account, but only when the provider verified the email.
+// The provider is trusted to authenticate the user, so the login guard doesn't
+// apply, but accounts with two-factor authentication still get a
+// *TwoFactorRequiredError like from Login.
+func (s *authService) LoginExternal(ctx context.Context, identity ExternalIdentity) (string, string, *models.User, error) {
+	if s.identities == nil {
+		return "", "", nil, errors.New("external identities are not configured")
+	}
+
+	// 1. Known identity: sign in its user
+	link, err := s.identities.FindBySubject(ctx, identity.Issuer, identity.Subject)
 	if err != nil {
 		return "", "", nil, err
 	}
+	if link != nil {
+		user, err := s.userRepo.FindByID(ctx, link.UserID)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if user == nil || user.AccountDeletedAt != nil {
+			return "", "", nil, apperrors.ErrUnauthorized
+		}
+		if user.TwoFactorEnabledAt != nil {
+			return "", "", nil, s.twoFactorChallenge(user)
+		}
+		return s.startSession(ctx, user)
+	}
 
-	refreshToken, err := s.createRefreshToken(ctx, user.ID)
+	// 2. Only a verified email may claim or create an account
+	if identity.Email == "" || !identity.EmailVerified {
+		return "", "", nil, apperrors.ErrSSOEmailUnverified
+	}
+
+	// 3. Link to the account with that email, or provision one
+	user, err := s.userRepo.FindByEmail(ctx, identity.Email)
 	if err != nil {
 		return "", "", nil, err
 	}
+	now := time.Now()
+	if user == nil {
+		if user, err = s.provisionUser(ctx, identity, now); err != nil {
+			return "", "", nil, err
+		}
+	} else if user.EmailVerifiedAt == nil {
+		// The provider just proved ownership of the address
+		if err := s.userRepo.MarkEmailVerified(ctx, user.ID, now); err != nil {
+			return "", "", nil, err
+		}
+		user.EmailVerifiedAt = &now
+	}
 
-	return accessToken, refreshToken, user, nil
+	if err := s.identities.Create(ctx, &models.UserIdentity{
+		UserID:  user.ID,
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+	}); err != nil {
+		return "", "", nil, err
+	}
+
+	// 4. Generate Tokens (a new session), after the second factor if enabled
+	if user.TwoFactorEnabledAt != nil {
+		return "", "", nil, s.twoFactorChallenge(user)
+	}
+	return s.startSession(ctx, user)
 }
 
+// provisionUser creates a password-less account for an external identity. The
+// username comes from the email (or the name), with a random suffix if taken.
+func (s *authService) provisionUser(ctx context.Context, identity ExternalIdentity, now time.Time) (*models.User, error) {
+	base := externalUsername(identity)
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		username := base
+		if attempt > 0 {
+			b := make([]byte, 3)
+			if _, err := rand.Read(b); err != nil {
+				return nil, err
+			}
+			username = truncate(base, 43) + "-" + hex.EncodeToString(b)
+		}
+		user := &models.User{
+			Username:        username,
+			Email:           identity.Email,
+			Password:        "!", // Not a bcrypt hash, so no password matches
+			LastSeen:        now,
+			IsOnline:        true,
+			EmailVerifiedAt: &now,
+		}
+		if err = s.userRepo.Create(ctx, user); err == nil {
+			return user, nil
+		}
+	}
+	return nil, err
+}
+
+// externalUsername derives a username from the local part of the email, falling
+// back to the display name.
+func externalUsername(identity ExternalIdentity) string {
+	name := identity.Email
+	if i := strings.IndexByte(name, '@'); i > 0 {
+		name = name[:i]
+	}
+	if name == "" {
+		name = identity.Name
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return truncate(b.String(), 50)
+}
+
+// ErrRefreshTokenReused signals that an already-rotated refresh token was presented,
+// i.e. it was most likely stolen. The whole token family has been revoked.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+
 func (s *authService) Refresh(ctx context.Context, refreshToken string) (string, string, error) {
 	// 1. Hash the incoming token
 	hash := hashToken(refreshToken)
@@ -115,10 +333,15 @@ func (s *authService) Refresh(ctx context.Context, refreshToken string) (string,
 		return "", "", apperrors.ErrInvalidCredentials // Or a specific ErrInvalidToken
 	}
 
+	familyID := sessionIDOfToken(storedToken)
+
 	// 3. Validate
 	if storedToken.Revoked {
-		// Security alert: Someone tried to use a revoked token!
-		// We could revoke ALL user tokens here for safety.
+		// Security alert: a rotated token was replayed, so either the client or an
+		// attacker holds a stale copy. Kill the whole session.
+		if storedToken.ReplacedByID != nil {
+			return "", "", s.revokeReusedFamily(ctx, storedToken.UserID, familyID)
+		}
 		return "", "", errors.New("token revoked")
 	}
 	if time.Now().After(storedToken.ExpiresAt) {
@@ -126,60 +349,186 @@ func (s *authService) Refresh(ctx context.Context, refreshToken string) (string,
 	}
 
 	// 4. Generate new Access Token
-	accessToken, err := s.jwtService.GenerateToken(storedToken.UserID)
+	accessToken, err := s.jwtService.GenerateToken(storedToken.UserID, familyID)
 	if err != nil {
 		return "", "", err
 	}
 
-	// 5. Rotate Refresh Token (Optional but good)
-	// For this MVP, let's KEEP the same refresh token to avoid cookie complexity
-	// OR we can rotate it. Let's start with basic: Reuse same refresh token until expiry.
-	// If we want rotation, we'd revoke old and create new.
-	// Let's just return the SAME refresh token for now, or "" if not changing.
-	// The interface returns (string, string, error). Let's return new access, SAME refresh.
+	// 5. Rotate Refresh Token (same family, the old one is revoked)
+	newRefreshToken, next, err := newRefreshToken(ctx, storedToken.UserID, familyID)
+	if err != nil {
+		return "", "", err
+	}
+	if next.IPAddress == "" && next.UserAgent == "" {
+		next.IPAddress, next.UserAgent = storedToken.IPAddress, storedToken.UserAgent
+	}
+	if err := s.refreshTokenRepo.Rotate(ctx, storedToken.ID, next); err != nil {
+		// Lost a race against another refresh with the same token
+		if errors.Is(err, repository.ErrRefreshTokenRotated) {
+			return "", "", s.revokeReusedFamily(ctx, storedToken.UserID, familyID)
+		}
+		return "", "", err
+	}
+
+	return accessToken, newRefreshToken, nil
+}
 
-	return accessToken, refreshToken, nil
+// revokeReusedFamily revokes every token of the family, including its access
+// tokens, and disconnects the session's sockets.
+func (s *authService) revokeReusedFamily(ctx context.Context, userID, familyID uuid.UUID) error {
+	if err := s.refreshTokenRepo.RevokeFamily(ctx, familyID); err != nil {
+		return err
+	}
+	s.endSession(familyID)
+	return ErrRefreshTokenReused
 }
 
-func (s *authService) Logout(ctx context.Context, refreshToken string) error {
+// Logout ends the session of refreshToken and denylists accessToken. Either may
+// be empty; invalid tokens are ignored.
+func (s *authService) Logout(ctx context.Context, refreshToken, accessToken string) error {
+	if accessToken != "" {
+		if claims, err := s.jwtService.ParseToken(accessToken); err == nil {
+			s.jwtService.Revoke(claims)
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
 	hash := hashToken(refreshToken)
 	storedToken, err := s.refreshTokenRepo.GetByHash(ctx, hash)
 	if err != nil {
 		return nil // Already gone or invalid, just ignore
 	}
-	return s.refreshTokenRepo.Revoke(ctx, storedToken.ID)
+	if err := s.refreshTokenRepo.Revoke(ctx, storedToken.ID); err != nil {
+		return err
+	}
+	s.endSession(sessionIDOfToken(storedToken))
+	return nil
 }
 
-func (s *authService) ValidateToken(tokenString string) (uuid.UUID, error) {
-	return s.jwtService.ValidateToken(tokenString)
+// LogoutAll revokes every refresh token of the user, denylists the access tokens
+// of their sessions and closes their live WebSocket connections.
+func (s *authService) LogoutAll(ctx context.Context, userID uuid.UUID) error {
+	tokens, err := s.refreshTokenRepo.FindActiveByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.refreshTokenRepo.RevokeByUser(ctx, userID); err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		s.jwtService.RevokeSession(sessionIDOfToken(&t))
+	}
+	s.disconnector.DisconnectUser(userID)
+	return nil
+}
+
+// ChangePassword replaces the user's password after checking the current one.
+// Every session is revoked (RevokeByUser); the caller's session sessionID
+// continues with the returned refresh token, the others are signed out.
+func (s *authService) ChangePassword(ctx context.Context, userID, sessionID uuid.UUID, currentPassword, newPassword string) (string, error) {
+	// 1. Check the current password
+	user, err := s.checkPassword(ctx, userID, currentPassword)
+	if err != nil {
+		return "", err
+	}
+
+	// 2. Store the new one
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	if err := s.userRepo.UpdatePassword(ctx, user.ID, string(hashedPassword)); err != nil {
+		return "", err
+	}
+
+	// 3. Sign out the other sessions
+	tokens, err := s.refreshTokenRepo.FindActiveByUser(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.refreshTokenRepo.RevokeByUser(ctx, userID); err != nil {
+		return "", err
+	}
+	for _, t := range tokens {
+		if id := sessionIDOfToken(&t); id != sessionID {
+			s.endSession(id)
+		}
+	}
+
+	// 4. Keep the current session going
+	if sessionID == uuid.Nil {
+		sessionID = uuid.New()
+	}
+	return s.createRefreshToken(ctx, userID, sessionID)
+}
+
+// DeleteAccount signs the user out everywhere and anonymizes their account.
+// Messages they sent stay readable by their peers, attributed to models.DeletedUsername.
+func (s *authService) DeleteAccount(ctx context.Context, userID uuid.UUID, password string) error {
+	if _, err := s.checkPassword(ctx, userID, password); err != nil {
+		return err
+	}
+	if err := s.LogoutAll(ctx, userID); err != nil {
+		return err
+	}
+	return s.userRepo.DeleteAccount(ctx, userID, time.Now())
+}
+
+// checkPassword loads the user and verifies their password.
+func (s *authService) checkPassword(ctx context.Context, userID uuid.UUID, password string) (*models.User, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, apperrors.ErrNotFound
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, apperrors.ErrIncorrectPassword
+	}
+	return user, nil
 }
 
-func (s *authService) SearchUsers(ctx context.Context, query string, excludeUserID uuid.UUID) ([]models.User, error) {
-	return s.userRepo.Search(ctx, query, excludeUserID)
+// endSession invalidates the outstanding access tokens of a revoked session and
+// closes its WebSocket connections.
+func (s *authService) endSession(sessionID uuid.UUID) {
+	s.jwtService.RevokeSession(sessionID)
+	s.disconnector.DisconnectSession(sessionID)
 }
 
-func (s *authService) GetUser(ctx context.Context, id uuid.UUID) (*models.User, error) {
-	return s.userRepo.FindByID(ctx, id)
+func (s *authService) ValidateToken(tokenString string) (uuid.UUID, error) {
+	return s.jwtService.ValidateToken(tokenString)
+}
+
+func (s *authService) ParseToken(tokenString string) (*jwt.Claims, error) {
+	return s.jwtService.ParseToken(tokenString)
 }
 
 // Helpers
 
-func (s *authService) createRefreshToken(ctx context.Context, userID uuid.UUID) (string, error) {
-	// Generate 32 bytes of random entropy
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+// startSession signs the user in on a new session, returning its access and refresh tokens.
+func (s *authService) startSession(ctx context.Context, user *models.User) (string, string, *models.User, error) {
+	sessionID := uuid.New()
+	accessToken, err := s.jwtService.GenerateToken(user.ID, sessionID)
+	if err != nil {
+		return "", "", nil, err
 	}
-	rawToken := base64.URLEncoding.EncodeToString(b)
 
-	// Hash it for storage
-	hash := hashToken(rawToken)
+	refreshToken, err := s.createRefreshToken(ctx, user.ID, sessionID)
+	if err != nil {
+		return "", "", nil, err
+	}
 
-	token := &models.RefreshToken{
-		UserID:    userID,
-		TokenHash: hash,
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour), // 7 days
-		Revoked:   false,
+	return accessToken, refreshToken, user, nil
+}
+
+// createRefreshToken starts a new token family, i.e. a new login session.
+func (s *authService) createRefreshToken(ctx context.Context, userID, sessionID uuid.UUID) (string, error) {
+	rawToken, token, err := newRefreshToken(ctx, userID, sessionID)
+	if err != nil {
+		return "", err
 	}
 
 	if err := s.refreshTokenRepo.Create(ctx, token); err != nil {
@@ -189,6 +538,30 @@ func (s *authService) createRefreshToken(ctx context.Context, userID uuid.UUID)
 	return rawToken, nil
 }
 
+// newRefreshToken generates a raw token and its unsaved record in the given family,
+// tagged with the device found in ctx (see WithClientInfo).
+func newRefreshToken(ctx context.Context, userID, familyID uuid.UUID) (string, *models.RefreshToken, error) {
+	// Generate 32 bytes of random entropy
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	rawToken := base64.URLEncoding.EncodeToString(b)
+
+	info := clientInfoFromContext(ctx)
+	token := &models.RefreshToken{
+		UserID:     userID,
+		FamilyID:   familyID,
+		TokenHash:  hashToken(rawToken),                // Hash it for storage
+		ExpiresAt:  time.Now().Add(7 * 24 * time.Hour), // 7 days
+		Revoked:    false,
+		IPAddress:  truncate(info.IPAddress, 45),
+		UserAgent:  truncate(info.UserAgent, 255),
+		LastUsedAt: time.Now(),
+	}
+	return rawToken, token, nil
+}
+
 func hashToken(token string) string {
 	hash := sha256.Sum256([]byte(token))
 	return hex.EncodeToString(hash[:])
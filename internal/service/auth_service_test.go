@@ -0,0 +1,464 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"chat-app/internal/service"
+	"chat-app/pkg/jwt"
+	"chat-app/pkg/ratelimit"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MockRefreshTokenRepo
+type MockRefreshTokenRepo struct {
+	mock.Mock
+}
+
+func (m *MockRefreshTokenRepo) Create(ctx context.Context, token *models.RefreshToken) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepo) GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	args := m.Called(ctx, hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.RefreshToken), args.Error(1)
+}
+
+func (m *MockRefreshTokenRepo) Revoke(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepo) RevokeByUser(ctx context.Context, userID uuid.UUID) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepo) Rotate(ctx context.Context, oldID uuid.UUID, next *models.RefreshToken) error {
+	args := m.Called(ctx, oldID, next)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepo) RevokeFamily(ctx context.Context, familyID uuid.UUID) error {
+	args := m.Called(ctx, familyID)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepo) FindActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.RefreshToken, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.RefreshToken), args.Error(1)
+}
+
+func (m *MockRefreshTokenRepo) RevokeOtherFamilies(ctx context.Context, userID, keepFamilyID uuid.UUID) error {
+	args := m.Called(ctx, userID, keepFamilyID)
+	return args.Error(0)
+}
+
+// MockDisconnector
+type MockDisconnector struct {
+	mock.Mock
+}
+
+func (m *MockDisconnector) DisconnectUser(userID uuid.UUID) {
+	m.Called(userID)
+}
+
+func (m *MockDisconnector) DisconnectSession(sessionID uuid.UUID) {
+	m.Called(sessionID)
+}
+
+func setupAuthService() (service.AuthService, *MockRefreshTokenRepo, *MockDisconnector) {
+	mockTokenRepo := new(MockRefreshTokenRepo)
+	mockDisconnector := new(MockDisconnector)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(new(MockUserRepo), mockTokenRepo, jwtService, mockDisconnector)
+	return svc, mockTokenRepo, mockDisconnector
+}
+
+func TestAuthService_Refresh_RotatesWithinFamily(t *testing.T) {
+	ctx := context.Background()
+	svc, mockTokenRepo, mockDisconnector := setupAuthService()
+
+	stored := &models.RefreshToken{
+		BaseModel: models.BaseModel{ID: uuid.New()},
+		UserID:    uuid.New(),
+		FamilyID:  uuid.New(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	mockTokenRepo.On("GetByHash", ctx, mock.Anything).Return(stored, nil)
+	mockTokenRepo.On("Rotate", ctx, stored.ID, mock.MatchedBy(func(next *models.RefreshToken) bool {
+		return next.FamilyID == stored.FamilyID && next.UserID == stored.UserID
+	})).Return(nil)
+
+	accessToken, newRefreshToken, err := svc.Refresh(ctx, "old-token")
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, accessToken)
+	assert.NotEmpty(t, newRefreshToken)
+	assert.NotEqual(t, "old-token", newRefreshToken)
+	mockTokenRepo.AssertExpectations(t)
+	mockDisconnector.AssertNotCalled(t, "DisconnectSession", mock.Anything)
+}
+
+func TestAuthService_Refresh_ReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	svc, mockTokenRepo, mockDisconnector := setupAuthService()
+
+	replacedBy := uuid.New()
+	stored := &models.RefreshToken{
+		BaseModel:    models.BaseModel{ID: uuid.New()},
+		UserID:       uuid.New(),
+		FamilyID:     uuid.New(),
+		ExpiresAt:    time.Now().Add(time.Hour),
+		Revoked:      true,
+		ReplacedByID: &replacedBy,
+	}
+	mockTokenRepo.On("GetByHash", ctx, mock.Anything).Return(stored, nil)
+	mockTokenRepo.On("RevokeFamily", ctx, stored.FamilyID).Return(nil)
+	mockDisconnector.On("DisconnectSession", stored.FamilyID).Return()
+
+	_, _, err := svc.Refresh(ctx, "stolen-token")
+
+	assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+	mockTokenRepo.AssertExpectations(t)
+	mockDisconnector.AssertExpectations(t)
+}
+
+func TestAuthService_Refresh_LostRotationRaceIsReuse(t *testing.T) {
+	ctx := context.Background()
+	svc, mockTokenRepo, mockDisconnector := setupAuthService()
+
+	stored := &models.RefreshToken{
+		BaseModel: models.BaseModel{ID: uuid.New()},
+		UserID:    uuid.New(),
+		FamilyID:  uuid.New(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	mockTokenRepo.On("GetByHash", ctx, mock.Anything).Return(stored, nil)
+	mockTokenRepo.On("Rotate", ctx, stored.ID, mock.Anything).Return(repository.ErrRefreshTokenRotated)
+	mockTokenRepo.On("RevokeFamily", ctx, stored.FamilyID).Return(nil)
+	mockDisconnector.On("DisconnectSession", stored.FamilyID).Return()
+
+	_, _, err := svc.Refresh(ctx, "raced-token")
+
+	assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+	mockDisconnector.AssertExpectations(t)
+}
+
+func TestAuthService_Refresh_LoggedOutTokenRejected(t *testing.T) {
+	ctx := context.Background()
+	svc, mockTokenRepo, mockDisconnector := setupAuthService()
+
+	stored := &models.RefreshToken{
+		BaseModel: models.BaseModel{ID: uuid.New()},
+		UserID:    uuid.New(),
+		FamilyID:  uuid.New(),
+		ExpiresAt: time.Now().Add(time.Hour),
+		Revoked:   true,
+	}
+	mockTokenRepo.On("GetByHash", ctx, mock.Anything).Return(stored, nil)
+
+	_, _, err := svc.Refresh(ctx, "logged-out-token")
+
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, service.ErrRefreshTokenReused)
+	mockTokenRepo.AssertNotCalled(t, "RevokeFamily", mock.Anything, mock.Anything)
+	mockDisconnector.AssertNotCalled(t, "DisconnectSession", mock.Anything)
+}
+
+func TestAuthService_ListSessions_FlagsCurrent(t *testing.T) {
+	ctx := context.Background()
+	svc, mockTokenRepo, _ := setupAuthService()
+
+	userID := uuid.New()
+	current := models.RefreshToken{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: userID, FamilyID: uuid.New(), UserAgent: "Firefox"}
+	other := models.RefreshToken{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: userID, FamilyID: uuid.New(), UserAgent: "Safari"}
+
+	mockTokenRepo.On("FindActiveByUser", ctx, userID).Return([]models.RefreshToken{current, other}, nil)
+	mockTokenRepo.On("GetByHash", ctx, mock.Anything).Return(&current, nil)
+
+	sessions, err := svc.ListSessions(ctx, userID, "current-token")
+
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+	assert.Equal(t, current.FamilyID, sessions[0].ID)
+	assert.True(t, sessions[0].Current)
+	assert.False(t, sessions[1].Current)
+}
+
+func TestAuthService_RevokeSession_OtherUsersSessionNotFound(t *testing.T) {
+	ctx := context.Background()
+	svc, mockTokenRepo, _ := setupAuthService()
+
+	userID := uuid.New()
+	mockTokenRepo.On("FindActiveByUser", ctx, userID).Return([]models.RefreshToken{
+		{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: userID, FamilyID: uuid.New()},
+	}, nil)
+
+	err := svc.RevokeSession(ctx, userID, uuid.New())
+
+	assert.ErrorIs(t, err, apperrors.ErrSessionNotFound)
+	mockTokenRepo.AssertNotCalled(t, "RevokeFamily", mock.Anything, mock.Anything)
+}
+
+func TestAuthService_Login_RecordsClientInfo(t *testing.T) {
+	mockUserRepo := new(MockUserRepo)
+	mockTokenRepo := new(MockRefreshTokenRepo)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(mockUserRepo, mockTokenRepo, jwtService, new(MockDisconnector))
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Email: "a@example.com", Password: string(hashed)}
+	ctx := service.WithClientInfo(context.Background(), service.ClientInfo{IPAddress: "203.0.113.7", UserAgent: "Firefox"})
+
+	mockUserRepo.On("FindByEmail", ctx, user.Email).Return(user, nil)
+	mockTokenRepo.On("Create", ctx, mock.MatchedBy(func(tok *models.RefreshToken) bool {
+		return tok.IPAddress == "203.0.113.7" && tok.UserAgent == "Firefox" && tok.FamilyID != uuid.Nil && !tok.LastUsedAt.IsZero()
+	})).Return(nil)
+
+	_, _, _, err := svc.Login(ctx, user.Email, "password123")
+
+	assert.NoError(t, err)
+	mockTokenRepo.AssertExpectations(t)
+}
+
+func TestAuthService_Login_AccessTokenCarriesSession(t *testing.T) {
+	mockUserRepo := new(MockUserRepo)
+	mockTokenRepo := new(MockRefreshTokenRepo)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(mockUserRepo, mockTokenRepo, jwtService, new(MockDisconnector))
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Email: "a@example.com", Password: string(hashed)}
+	ctx := context.Background()
+
+	var familyID uuid.UUID
+	mockUserRepo.On("FindByEmail", ctx, user.Email).Return(user, nil)
+	mockTokenRepo.On("Create", ctx, mock.MatchedBy(func(tok *models.RefreshToken) bool {
+		familyID = tok.FamilyID
+		return true
+	})).Return(nil)
+
+	accessToken, _, _, err := svc.Login(ctx, user.Email, "password123")
+	assert.NoError(t, err)
+
+	claims, err := jwtService.ParseToken(accessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, claims.UserID)
+	assert.Equal(t, familyID, claims.SessionID)
+}
+
+func TestAuthService_Login_UnknownEmailLooksLikeWrongPassword(t *testing.T) {
+	mockUserRepo := new(MockUserRepo)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(mockUserRepo, new(MockRefreshTokenRepo), jwtService, new(MockDisconnector))
+	ctx := context.Background()
+
+	mockUserRepo.On("FindByEmail", ctx, "nobody@example.com").Return(nil, nil)
+
+	_, _, _, err := svc.Login(ctx, "nobody@example.com", "password123")
+
+	assert.ErrorIs(t, err, apperrors.ErrInvalidCredentials)
+}
+
+func TestAuthService_Login_LocksOutAfterRepeatedFailures(t *testing.T) {
+	mockUserRepo := new(MockUserRepo)
+	mockTokenRepo := new(MockRefreshTokenRepo)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(mockUserRepo, mockTokenRepo, jwtService, new(MockDisconnector),
+		service.WithLoginGuard(service.NewLoginGuard(ratelimit.NewMemoryStore())))
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Email: "a@example.com", Password: string(hashed)}
+	ctx := service.WithClientInfo(context.Background(), service.ClientInfo{IPAddress: "203.0.113.7"})
+	mockUserRepo.On("FindByEmail", ctx, mock.Anything).Return(user, nil)
+
+	// The free attempts fail normally
+	for i := 0; i < service.LoginAccountFreeAttempts; i++ {
+		_, _, _, err := svc.Login(ctx, user.Email, "wrong")
+		assert.ErrorIs(t, err, apperrors.ErrInvalidCredentials)
+	}
+
+	// Then the account is locked, even for the right password and regardless of case
+	_, _, _, err := svc.Login(ctx, user.Email, "wrong")
+	assert.ErrorIs(t, err, apperrors.ErrInvalidCredentials)
+	_, _, _, err = svc.Login(ctx, "A@Example.com", "password123")
+	var rlErr *apperrors.RateLimitError
+	assert.ErrorAs(t, err, &rlErr)
+	assert.ErrorIs(t, err, apperrors.ErrTooManyRequests)
+	assert.InDelta(t, service.LoginBackoffBase, rlErr.RetryAfter, float64(100*time.Millisecond))
+	mockTokenRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+// stubTwoFactor accepts a single code.
+type stubTwoFactor struct {
+	service.TwoFactorService
+	code string
+}
+
+func (s *stubTwoFactor) Verify(ctx context.Context, user *models.User, code string) error {
+	if code != s.code {
+		return apperrors.ErrInvalidTwoFactor
+	}
+	return nil
+}
+
+func TestAuthService_Login_TwoFactor(t *testing.T) {
+	mockUserRepo := new(MockUserRepo)
+	mockTokenRepo := new(MockRefreshTokenRepo)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(mockUserRepo, mockTokenRepo, jwtService, new(MockDisconnector),
+		service.WithTwoFactor(&stubTwoFactor{code: "123456"}))
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	enabledAt := time.Now()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Email: "a@example.com", Password: string(hashed), TwoFactorEnabledAt: &enabledAt}
+	ctx := context.Background()
+	mockUserRepo.On("FindByEmail", ctx, user.Email).Return(user, nil)
+	mockUserRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+
+	// 1. The password only earns a challenge
+	_, _, _, err := svc.Login(ctx, user.Email, "password123")
+	var challenge *service.TwoFactorRequiredError
+	require.ErrorAs(t, err, &challenge)
+	assert.NotEmpty(t, challenge.ChallengeToken)
+	_, err = svc.ParseToken(challenge.ChallengeToken)
+	assert.Error(t, err, "a challenge is not an access token")
+
+	// 2. A wrong code fails
+	_, _, _, err = svc.LoginTwoFactor(ctx, challenge.ChallengeToken, "000000")
+	assert.ErrorIs(t, err, apperrors.ErrInvalidTwoFactor)
+
+	// 3. The right code completes the login
+	mockTokenRepo.On("Create", ctx, mock.Anything).Return(nil).Once()
+	accessToken, refreshToken, loggedIn, err := svc.LoginTwoFactor(ctx, challenge.ChallengeToken, "123456")
+	require.NoError(t, err)
+	assert.NotEmpty(t, accessToken)
+	assert.NotEmpty(t, refreshToken)
+	assert.Equal(t, user.ID, loggedIn.ID)
+
+	// 4. The challenge can't be used again
+	_, _, _, err = svc.LoginTwoFactor(ctx, challenge.ChallengeToken, "123456")
+	assert.ErrorIs(t, err, apperrors.ErrInvalidChallenge)
+}
+
+func TestAuthService_Logout_RevokesAccessTokenAndSession(t *testing.T) {
+	ctx := context.Background()
+	mockTokenRepo := new(MockRefreshTokenRepo)
+	mockDisconnector := new(MockDisconnector)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(new(MockUserRepo), mockTokenRepo, jwtService, mockDisconnector)
+
+	stored := &models.RefreshToken{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: uuid.New(), FamilyID: uuid.New()}
+	accessToken, _ := jwtService.GenerateToken(stored.UserID, stored.FamilyID)
+	otherSession, _ := jwtService.GenerateToken(stored.UserID, uuid.New())
+
+	mockTokenRepo.On("GetByHash", ctx, mock.Anything).Return(stored, nil)
+	mockTokenRepo.On("Revoke", ctx, stored.ID).Return(nil)
+	mockDisconnector.On("DisconnectSession", stored.FamilyID).Return()
+
+	err := svc.Logout(ctx, "refresh-token", accessToken)
+
+	assert.NoError(t, err)
+	_, err = jwtService.ParseToken(accessToken)
+	assert.ErrorIs(t, err, jwt.ErrTokenRevoked)
+	_, err = jwtService.ParseToken(otherSession)
+	assert.NoError(t, err, "other sessions stay signed in")
+	mockDisconnector.AssertExpectations(t)
+}
+
+func TestAuthService_ChangePassword_KeepsCurrentSession(t *testing.T) {
+	ctx := context.Background()
+	mockUserRepo := new(MockUserRepo)
+	mockTokenRepo := new(MockRefreshTokenRepo)
+	mockDisconnector := new(MockDisconnector)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(mockUserRepo, mockTokenRepo, jwtService, mockDisconnector)
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.MinCost)
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Password: string(hashed)}
+	current := models.RefreshToken{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: user.ID, FamilyID: uuid.New()}
+	other := models.RefreshToken{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: user.ID, FamilyID: uuid.New()}
+	currentAccess, _ := jwtService.GenerateToken(user.ID, current.FamilyID)
+	otherAccess, _ := jwtService.GenerateToken(user.ID, other.FamilyID)
+
+	mockUserRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+	mockUserRepo.On("UpdatePassword", ctx, user.ID, mock.MatchedBy(func(hash string) bool {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte("new-password")) == nil
+	})).Return(nil)
+	mockTokenRepo.On("FindActiveByUser", ctx, user.ID).Return([]models.RefreshToken{current, other}, nil)
+	mockTokenRepo.On("RevokeByUser", ctx, user.ID).Return(nil)
+	mockTokenRepo.On("Create", ctx, mock.MatchedBy(func(tok *models.RefreshToken) bool {
+		return tok.FamilyID == current.FamilyID
+	})).Return(nil)
+	mockDisconnector.On("DisconnectSession", other.FamilyID).Return()
+
+	refreshToken, err := svc.ChangePassword(ctx, user.ID, current.FamilyID, "old-password", "new-password")
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, refreshToken)
+	mockUserRepo.AssertExpectations(t)
+	mockTokenRepo.AssertExpectations(t)
+	mockDisconnector.AssertExpectations(t)
+
+	_, err = jwtService.ParseToken(currentAccess)
+	assert.NoError(t, err)
+	_, err = jwtService.ParseToken(otherAccess)
+	assert.ErrorIs(t, err, jwt.ErrTokenRevoked)
+}
+
+func TestAuthService_ChangePassword_WrongPassword(t *testing.T) {
+	ctx := context.Background()
+	mockUserRepo := new(MockUserRepo)
+	mockTokenRepo := new(MockRefreshTokenRepo)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(mockUserRepo, mockTokenRepo, jwtService, new(MockDisconnector))
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.MinCost)
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Password: string(hashed)}
+	mockUserRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+
+	_, err := svc.ChangePassword(ctx, user.ID, uuid.New(), "guess", "new-password")
+
+	assert.ErrorIs(t, err, apperrors.ErrIncorrectPassword)
+	mockUserRepo.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything, mock.Anything)
+	mockTokenRepo.AssertNotCalled(t, "RevokeByUser", mock.Anything, mock.Anything)
+}
+
+func TestAuthService_DeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	mockUserRepo := new(MockUserRepo)
+	mockTokenRepo := new(MockRefreshTokenRepo)
+	mockDisconnector := new(MockDisconnector)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(mockUserRepo, mockTokenRepo, jwtService, mockDisconnector)
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Password: string(hashed)}
+
+	mockUserRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+	mockTokenRepo.On("FindActiveByUser", ctx, user.ID).Return([]models.RefreshToken{}, nil)
+	mockTokenRepo.On("RevokeByUser", ctx, user.ID).Return(nil)
+	mockDisconnector.On("DisconnectUser", user.ID).Return()
+	mockUserRepo.On("DeleteAccount", ctx, user.ID, mock.Anything).Return(nil)
+
+	assert.NoError(t, svc.DeleteAccount(ctx, user.ID, "password"))
+	mockUserRepo.AssertExpectations(t)
+	mockDisconnector.AssertExpectations(t)
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// ContactRequest is an open contact request with the user on the other side.
+type ContactRequest struct {
+	ID        uuid.UUID         `json:"id"`
+	User      models.PublicUser `json:"user"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ContactRequests lists the requests a user received and the ones they sent.
+type ContactRequests struct {
+	Incoming []ContactRequest `json:"incoming"`
+	Outgoing []ContactRequest `json:"outgoing"`
+}
+
+type contactService struct {
+	contactRepo repository.ContactRepository
+	userRepo    repository.UserRepository
+}
+
+func NewContactService(contactRepo repository.ContactRepository, userRepo repository.UserRepository) ContactService {
+	return &contactService{
+		contactRepo: contactRepo,
+		userRepo:    userRepo,
+	}
+}
+
+// SendRequest asks targetID to become a contact. If they already asked the
+// user, their request is accepted instead.
+func (s *contactService) SendRequest(ctx context.Context, userID, targetID uuid.UUID) (*models.Contact, error) {
+	if userID == targetID {
+		return nil, apperrors.ErrValidation
+	}
+
+	// 1. The target must be an active account
+	target, err := s.userRepo.FindByID(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil || target.AccountDeletedAt != nil {
+		return nil, apperrors.ErrNotFound
+	}
+
+	// 2. At most one request per pair of users
+	existing, err := s.contactRepo.FindBetween(ctx, userID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if existing.Status == models.ContactPending && existing.UserID == targetID {
+			return s.accept(ctx, existing)
+		}
+		return nil, apperrors.ErrAlreadyContacts
+	}
+
+	// 3. Create the request
+	contact := &models.Contact{
+		UserID:    userID,
+		ContactID: targetID,
+		Status:    models.ContactPending,
+	}
+	if err := s.contactRepo.Create(ctx, contact); err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+// AcceptRequest accepts a request sent to the user.
+func (s *contactService) AcceptRequest(ctx context.Context, userID, requestID uuid.UUID) (*models.Contact, error) {
+	contact, err := s.findIncoming(ctx, userID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	return s.accept(ctx, contact)
+}
+
+// DeclineRequest deletes a request sent to the user. The sender is not told.
+func (s *contactService) DeclineRequest(ctx context.Context, userID, requestID uuid.UUID) error {
+	contact, err := s.findIncoming(ctx, userID, requestID)
+	if err != nil {
+		return err
+	}
+	return s.contactRepo.Delete(ctx, contact.ID)
+}
+
+// RemoveContact ends a contact relationship, or cancels a request between the two users.
+func (s *contactService) RemoveContact(ctx context.Context, userID, otherID uuid.UUID) error {
+	contact, err := s.contactRepo.FindBetween(ctx, userID, otherID)
+	if err != nil {
+		return err
+	}
+	if contact == nil {
+		return apperrors.ErrContactNotFound
+	}
+	return s.contactRepo.Delete(ctx, contact.ID)
+}
+
+// ListContacts returns the user's contacts as contacts see them, including
+// their online status unless they hide it. Sorted by name.
+func (s *contactService) ListContacts(ctx context.Context, userID uuid.UUID) ([]models.PublicUser, error) {
+	ids, err := s.contactRepo.FindContactIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.userRepo.FindByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := make([]models.PublicUser, 0, len(users))
+	for i := range users {
+		contacts = append(contacts, users[i].PublicView(true))
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		return strings.ToLower(displayName(contacts[i])) < strings.ToLower(displayName(contacts[j]))
+	})
+	return contacts, nil
+}
+
+// ListRequests returns the open requests of the user, newest first.
+func (s *contactService) ListRequests(ctx context.Context, userID uuid.UUID) (*ContactRequests, error) {
+	pending, err := s.contactRepo.FindPending(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. Load the users on the other side
+	ids := make([]uuid.UUID, 0, len(pending))
+	for i := range pending {
+		ids = append(ids, pending[i].Other(userID))
+	}
+	users, err := s.userRepo.FindByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]*models.User, len(users))
+	for i := range users {
+		byID[users[i].ID] = &users[i]
+	}
+
+	// 2. Split them by direction, skipping users that no longer exist
+	requests := &ContactRequests{Incoming: []ContactRequest{}, Outgoing: []ContactRequest{}}
+	for _, contact := range pending {
+		other, ok := byID[contact.Other(userID)]
+		if !ok {
+			continue
+		}
+		request := ContactRequest{ID: contact.ID, User: other.PublicView(false), CreatedAt: contact.CreatedAt}
+		if contact.ContactID == userID {
+			requests.Incoming = append(requests.Incoming, request)
+		} else {
+			requests.Outgoing = append(requests.Outgoing, request)
+		}
+	}
+	return requests, nil
+}
+
+// findIncoming returns a pending request sent to the user. Requests sent to
+// someone else are reported as not found.
+func (s *contactService) findIncoming(ctx context.Context, userID, requestID uuid.UUID) (*models.Contact, error) {
+	contact, err := s.contactRepo.FindByID(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if contact == nil || contact.ContactID != userID || contact.Status != models.ContactPending {
+		return nil, apperrors.ErrContactNotFound
+	}
+	return contact, nil
+}
+
+func (s *contactService) accept(ctx context.Context, contact *models.Contact) (*models.Contact, error) {
+	now := time.Now()
+	if err := s.contactRepo.Accept(ctx, contact.ID, now); err != nil {
+		return nil, err
+	}
+	contact.Status = models.ContactAccepted
+	contact.AcceptedAt = &now
+	return contact, nil
+}
+
+func displayName(user models.PublicUser) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return user.Username
+}
@@ -0,0 +1,196 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockContactRepo
+type MockContactRepo struct {
+	mock.Mock
+}
+
+func (m *MockContactRepo) Create(ctx context.Context, contact *models.Contact) error {
+	args := m.Called(ctx, contact)
+	return args.Error(0)
+}
+
+func (m *MockContactRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.Contact, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Contact), args.Error(1)
+}
+
+func (m *MockContactRepo) FindBetween(ctx context.Context, userID, otherID uuid.UUID) (*models.Contact, error) {
+	args := m.Called(ctx, userID, otherID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Contact), args.Error(1)
+}
+
+func (m *MockContactRepo) Accept(ctx context.Context, id uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, id, at)
+	return args.Error(0)
+}
+
+func (m *MockContactRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockContactRepo) FindContactIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]uuid.UUID), args.Error(1)
+}
+
+func (m *MockContactRepo) FindPending(ctx context.Context, userID uuid.UUID) ([]models.Contact, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Contact), args.Error(1)
+}
+
+func (m *MockContactRepo) AreContacts(ctx context.Context, userID, otherID uuid.UUID) (bool, error) {
+	args := m.Called(ctx, userID, otherID)
+	return args.Bool(0), args.Error(1)
+}
+
+func setupContactService() (service.ContactService, *MockContactRepo, *MockUserRepo) {
+	contactRepo := new(MockContactRepo)
+	userRepo := new(MockUserRepo)
+	return service.NewContactService(contactRepo, userRepo), contactRepo, userRepo
+}
+
+func TestContactService_SendRequest(t *testing.T) {
+	svc, contactRepo, userRepo := setupContactService()
+	ctx := context.Background()
+	userID, targetID := uuid.New(), uuid.New()
+	userRepo.On("FindByID", ctx, targetID).Return(&models.User{BaseModel: models.BaseModel{ID: targetID}}, nil)
+	contactRepo.On("FindBetween", ctx, userID, targetID).Return(nil, nil)
+	contactRepo.On("Create", ctx, mock.AnythingOfType("*models.Contact")).Return(nil)
+
+	contact, err := svc.SendRequest(ctx, userID, targetID)
+	require.NoError(t, err)
+	assert.Equal(t, userID, contact.UserID)
+	assert.Equal(t, targetID, contact.ContactID)
+	assert.Equal(t, models.ContactPending, contact.Status)
+
+	_, err = svc.SendRequest(ctx, userID, userID)
+	assert.ErrorIs(t, err, apperrors.ErrValidation)
+}
+
+func TestContactService_SendRequest_AcceptsReverseRequest(t *testing.T) {
+	svc, contactRepo, userRepo := setupContactService()
+	ctx := context.Background()
+	userID, targetID := uuid.New(), uuid.New()
+	reverse := &models.Contact{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: targetID, ContactID: userID, Status: models.ContactPending}
+	userRepo.On("FindByID", ctx, targetID).Return(&models.User{BaseModel: models.BaseModel{ID: targetID}}, nil)
+	contactRepo.On("FindBetween", ctx, userID, targetID).Return(reverse, nil)
+	contactRepo.On("Accept", ctx, reverse.ID, mock.AnythingOfType("time.Time")).Return(nil)
+
+	contact, err := svc.SendRequest(ctx, userID, targetID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ContactAccepted, contact.Status)
+	assert.NotNil(t, contact.AcceptedAt)
+	contactRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestContactService_SendRequest_Duplicate(t *testing.T) {
+	svc, contactRepo, userRepo := setupContactService()
+	ctx := context.Background()
+	userID, targetID := uuid.New(), uuid.New()
+	userRepo.On("FindByID", ctx, targetID).Return(&models.User{BaseModel: models.BaseModel{ID: targetID}}, nil)
+	contactRepo.On("FindBetween", ctx, userID, targetID).
+		Return(&models.Contact{UserID: userID, ContactID: targetID, Status: models.ContactPending}, nil)
+
+	_, err := svc.SendRequest(ctx, userID, targetID)
+	assert.ErrorIs(t, err, apperrors.ErrAlreadyContacts)
+}
+
+func TestContactService_AcceptRequest_OnlyByAddressee(t *testing.T) {
+	svc, contactRepo, _ := setupContactService()
+	ctx := context.Background()
+	sender, addressee := uuid.New(), uuid.New()
+	request := &models.Contact{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: sender, ContactID: addressee, Status: models.ContactPending}
+	contactRepo.On("FindByID", ctx, request.ID).Return(request, nil)
+	contactRepo.On("Accept", ctx, request.ID, mock.AnythingOfType("time.Time")).Return(nil)
+
+	_, err := svc.AcceptRequest(ctx, sender, request.ID)
+	assert.ErrorIs(t, err, apperrors.ErrContactNotFound, "the sender can't accept their own request")
+
+	contact, err := svc.AcceptRequest(ctx, addressee, request.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ContactAccepted, contact.Status)
+	contactRepo.AssertNumberOfCalls(t, "Accept", 1)
+}
+
+func TestContactService_ListRequests(t *testing.T) {
+	svc, contactRepo, userRepo := setupContactService()
+	ctx := context.Background()
+	userID := uuid.New()
+	alice := models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Username: "alice", Email: "alice@example.com"}
+	bob := models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Username: "bob"}
+	incoming := models.Contact{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: alice.ID, ContactID: userID, Status: models.ContactPending}
+	outgoing := models.Contact{BaseModel: models.BaseModel{ID: uuid.New()}, UserID: userID, ContactID: bob.ID, Status: models.ContactPending}
+	contactRepo.On("FindPending", ctx, userID).Return([]models.Contact{incoming, outgoing}, nil)
+	userRepo.On("FindByIDs", ctx, []uuid.UUID{alice.ID, bob.ID}).Return([]models.User{bob, alice}, nil)
+
+	requests, err := svc.ListRequests(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, requests.Incoming, 1)
+	require.Len(t, requests.Outgoing, 1)
+	assert.Equal(t, incoming.ID, requests.Incoming[0].ID)
+	assert.Equal(t, "alice", requests.Incoming[0].User.Username)
+	assert.Empty(t, requests.Incoming[0].User.Email, "requests are shown as to a stranger")
+	assert.Equal(t, "bob", requests.Outgoing[0].User.Username)
+}
+
+func TestContactService_ListContacts_ShowsOnlineStatus(t *testing.T) {
+	svc, contactRepo, userRepo := setupContactService()
+	ctx := context.Background()
+	userID := uuid.New()
+	online := models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Username: "zoe", IsOnline: true, OnlineVisibility: models.VisibilityContacts}
+	hidden := models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Username: "adam", IsOnline: true, OnlineVisibility: models.VisibilityNobody}
+	contactRepo.On("FindContactIDs", ctx, userID).Return([]uuid.UUID{online.ID, hidden.ID}, nil)
+	userRepo.On("FindByIDs", ctx, []uuid.UUID{online.ID, hidden.ID}).Return([]models.User{online, hidden}, nil)
+
+	contacts, err := svc.ListContacts(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, contacts, 2)
+	assert.Equal(t, "adam", contacts[0].Username, "sorted by name")
+	assert.Nil(t, contacts[0].IsOnline)
+	require.NotNil(t, contacts[1].IsOnline)
+	assert.True(t, *contacts[1].IsOnline)
+}
+
+func TestSendDirectMessage_ContactsOnly(t *testing.T) {
+	ctx := context.Background()
+	mockUserRepo := new(MockUserRepo)
+	mockContactRepo := new(MockContactRepo)
+	svc := service.NewMessageService(new(MockMessageRepo), new(MockConversationRepo), new(MockGroupRepo), new(MockMessageReceiptRepo), mockUserRepo, new(MockHub),
+		service.WithContacts(mockContactRepo))
+
+	senderID, receiverID := uuid.New(), uuid.New()
+	mockUserRepo.On("FindByID", ctx, receiverID).Return(&models.User{BaseModel: models.BaseModel{ID: receiverID}, DMPolicy: models.VisibilityContacts}, nil)
+	mockContactRepo.On("AreContacts", ctx, senderID, receiverID).Return(false, nil)
+
+	_, err := svc.SendDirectMessage(ctx, senderID, receiverID, "Hello")
+	assert.ErrorIs(t, err, apperrors.ErrDMNotAllowed)
+}
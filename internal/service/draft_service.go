@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"unicode/utf8"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// MaxDraftLength is the longest draft kept, in characters: as long as a message.
+const MaxDraftLength = 4000
+
+type draftService struct {
+	draftRepo repository.DraftRepository
+	groupRepo repository.GroupRepository
+}
+
+func NewDraftService(draftRepo repository.DraftRepository, groupRepo repository.GroupRepository) DraftService {
+	return &draftService{
+		draftRepo: draftRepo,
+		groupRepo: groupRepo,
+	}
+}
+
+// SaveDraft replaces the user's draft for the conversation. Empty content
+// clears it.
+func (s *draftService) SaveDraft(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, content string) (*models.Draft, error) {
+	// 1. Validate the conversation and the content
+	if (convType != "DM" && convType != "GROUP") || targetID == uuid.Nil {
+		return nil, apperrors.ErrValidation
+	}
+	if utf8.RuneCountInString(content) > MaxDraftLength {
+		return nil, apperrors.ErrValidation
+	}
+
+	// 2. Group drafts are for members only
+	if convType == "GROUP" {
+		isMember, err := s.groupRepo.IsMember(ctx, targetID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, apperrors.ErrForbidden
+		}
+	}
+
+	// 3. Save it
+	draft := &models.Draft{
+		UserID:   userID,
+		Type:     convType,
+		TargetID: targetID,
+		Content:  content,
+	}
+	if err := s.draftRepo.Save(ctx, draft); err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+// ListDrafts returns the user's drafts, one per conversation at most.
+func (s *draftService) ListDrafts(ctx context.Context, userID uuid.UUID) ([]models.Draft, error) {
+	return s.draftRepo.FindByUser(ctx, userID)
+}
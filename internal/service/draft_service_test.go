@@ -0,0 +1,66 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockDraftRepo
+type MockDraftRepo struct {
+	mock.Mock
+}
+
+func (m *MockDraftRepo) Save(ctx context.Context, draft *models.Draft) error {
+	args := m.Called(ctx, draft)
+	return args.Error(0)
+}
+
+func (m *MockDraftRepo) FindByUser(ctx context.Context, userID uuid.UUID) ([]models.Draft, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Draft), args.Error(1)
+}
+
+func TestDraftService_SaveDraft(t *testing.T) {
+	ctx := context.Background()
+	draftRepo, groupRepo := new(MockDraftRepo), new(MockGroupRepo)
+	svc := service.NewDraftService(draftRepo, groupRepo)
+	userID, bob := uuid.New(), uuid.New()
+	draftRepo.On("Save", ctx, mock.AnythingOfType("*models.Draft")).Return(nil)
+
+	draft, err := svc.SaveDraft(ctx, userID, "DM", bob, "Hello")
+	require.NoError(t, err)
+	assert.Equal(t, userID, draft.UserID)
+	assert.Equal(t, bob, draft.TargetID)
+	assert.Equal(t, "Hello", draft.Content)
+
+	_, err = svc.SaveDraft(ctx, userID, "DM", bob, strings.Repeat("é", service.MaxDraftLength+1))
+	assert.ErrorIs(t, err, apperrors.ErrValidation)
+	_, err = svc.SaveDraft(ctx, userID, "CHANNEL", bob, "Hello")
+	assert.ErrorIs(t, err, apperrors.ErrValidation)
+	draftRepo.AssertNumberOfCalls(t, "Save", 1)
+}
+
+func TestDraftService_SaveDraft_GroupMembersOnly(t *testing.T) {
+	ctx := context.Background()
+	draftRepo, groupRepo := new(MockDraftRepo), new(MockGroupRepo)
+	svc := service.NewDraftService(draftRepo, groupRepo)
+	userID, groupID := uuid.New(), uuid.New()
+	groupRepo.On("IsMember", ctx, groupID, userID).Return(false, nil)
+
+	_, err := svc.SaveDraft(ctx, userID, "GROUP", groupID, "Hi all")
+	assert.ErrorIs(t, err, apperrors.ErrForbidden)
+	draftRepo.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+}
@@ -3,6 +3,9 @@ package service
 import (
 	"context"
 	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+	"chat-app/pkg/jwt"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -11,10 +14,70 @@ type AuthService interface {
 	Register(ctx context.Context, username, email, password string) (string, string, *models.User, error)
 	Login(ctx context.Context, email, password string) (string, string, *models.User, error)
 	Refresh(ctx context.Context, refreshToken string) (string, string, error)
-	Logout(ctx context.Context, refreshToken string) error
+	Logout(ctx context.Context, refreshToken, accessToken string) error
+	LogoutAll(ctx context.Context, userID uuid.UUID) error
+	ListSessions(ctx context.Context, userID uuid.UUID, currentRefreshToken string) ([]Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error
+	RevokeOtherSessions(ctx context.Context, userID uuid.UUID, currentRefreshToken string) error
+	ChangePassword(ctx context.Context, userID, sessionID uuid.UUID, currentPassword, newPassword string) (string, error)
+	DeleteAccount(ctx context.Context, userID uuid.UUID, password string) error
+	LoginTwoFactor(ctx context.Context, challengeToken, code string) (string, string, *models.User, error)
+	LoginExternal(ctx context.Context, identity ExternalIdentity) (string, string, *models.User, error)
 	ValidateToken(tokenString string) (uuid.UUID, error)
-	SearchUsers(ctx context.Context, query string, excludeUserID uuid.UUID) ([]models.User, error)
-	GetUser(ctx context.Context, id uuid.UUID) (*models.User, error)
+	ParseToken(tokenString string) (*jwt.Claims, error)
+}
+
+// AccountService handles the flows that prove email ownership: verifying the
+// address and resetting a forgotten password. Both mail single-use links.
+type AccountService interface {
+	SendVerification(ctx context.Context, user *models.User) error
+	ResendVerification(ctx context.Context, userID uuid.UUID) error
+	VerifyEmail(ctx context.Context, token string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, newPassword string) error
+}
+
+// TwoFactorService manages TOTP two-factor authentication and its recovery codes.
+type TwoFactorService interface {
+	Setup(ctx context.Context, userID uuid.UUID) (*TwoFactorSetup, error)
+	Enable(ctx context.Context, userID uuid.UUID, code string) ([]string, error)
+	Disable(ctx context.Context, userID uuid.UUID, password string) error
+	Verify(ctx context.Context, user *models.User, code string) error
+}
+
+// OIDCService runs the OpenID Connect authorization code flow with PKCE and
+// signs the user in with the identity it yields.
+type OIDCService interface {
+	Begin() (string, string, error)
+	Complete(ctx context.Context, state, code string) (string, string, *models.User, error)
+}
+
+// ProfileService manages a user's own public profile: display name, bio,
+// status message and avatar. Changes are pushed to the user's contacts.
+// Other users only ever get models.PublicUser, which respects privacy settings.
+type ProfileService interface {
+	GetProfile(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	GetPublicProfile(ctx context.Context, viewerID, userID uuid.UUID) (*models.PublicUser, error)
+	SearchUsers(ctx context.Context, viewerID uuid.UUID, query string) ([]models.PublicUser, error)
+	UpdatePrivacy(ctx context.Context, userID uuid.UUID, settings PrivacySettings) (*models.User, error)
+	UpdateProfile(ctx context.Context, userID uuid.UUID, update ProfileUpdate) (*models.User, error)
+	SetAvatar(ctx context.Context, userID uuid.UUID, image []byte) (*models.User, error)
+	RemoveAvatar(ctx context.Context, userID uuid.UUID) (*models.User, error)
+}
+
+// WSTicketService mints single-use, short-lived tickets that authenticate a
+// WebSocket upgrade without putting the access token in the URL.
+// A ticket carries the expiry of the access token it was minted with.
+type WSTicketService interface {
+	Issue(userID, sessionID uuid.UUID, tokenExpiresAt time.Time) (string, time.Time, error)
+	Redeem(ticket string) (*jwt.Claims, error)
+}
+
+// SessionDisconnector closes live connections once their sessions are revoked.
+// Implemented by websocket.Hub.
+type SessionDisconnector interface {
+	DisconnectUser(userID uuid.UUID)
+	DisconnectSession(sessionID uuid.UUID)
 }
 
 type MessageService interface {
@@ -26,6 +89,9 @@ type MessageService interface {
 	GetMessageReceipts(ctx context.Context, userID, messageID uuid.UUID) ([]models.MessageReceipt, error)
 	GetUserInfo(ctx context.Context, userID uuid.UUID) (*models.User, error)
 	BroadcastTypingIndicator(ctx context.Context, userID uuid.UUID, username, convType string, targetID uuid.UUID, isTyping bool) error
+	GetUnreadCounts(ctx context.Context, userID uuid.UUID) (*protocol.BadgePayload, error)
+	MarkConversationRead(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) error
+	PushBadge(ctx context.Context, userID uuid.UUID)
 }
 
 type GroupService interface {
@@ -33,3 +99,20 @@ type GroupService interface {
 	AddMember(ctx context.Context, adminID, groupID, newMemberID uuid.UUID) error
 	RemoveMember(ctx context.Context, adminID, groupID, memberID uuid.UUID) error
 }
+
+// ContactService manages contact requests and the contact list.
+type ContactService interface {
+	SendRequest(ctx context.Context, userID, targetID uuid.UUID) (*models.Contact, error)
+	AcceptRequest(ctx context.Context, userID, requestID uuid.UUID) (*models.Contact, error)
+	DeclineRequest(ctx context.Context, userID, requestID uuid.UUID) error
+	RemoveContact(ctx context.Context, userID, otherID uuid.UUID) error
+	ListContacts(ctx context.Context, userID uuid.UUID) ([]models.PublicUser, error)
+	ListRequests(ctx context.Context, userID uuid.UUID) (*ContactRequests, error)
+}
+
+// DraftService keeps the message each user is typing per conversation, so they
+// can continue on another device.
+type DraftService interface {
+	SaveDraft(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, content string) (*models.Draft, error)
+	ListDrafts(ctx context.Context, userID uuid.UUID) ([]models.Draft, error)
+}
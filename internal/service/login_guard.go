@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"chat-app/pkg/ratelimit"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoginGuard slows down password guessing. Failed logins are counted per
+// account and per client IP; past a few free attempts each further failure
+// locks the account (or IP) out for twice as long, up to a maximum.
+// Unknown emails are counted like real accounts so lockouts don't reveal which exist.
+type LoginGuard struct {
+	account *ratelimit.Backoff
+	ip      *ratelimit.Backoff
+}
+
+// Login backoff policy
+const (
+	LoginAccountFreeAttempts = 5
+	LoginIPFreeAttempts      = 20 // Shared networks put many users behind one IP
+	LoginBackoffBase         = time.Second
+	LoginBackoffMax          = 15 * time.Minute
+	LoginBackoffWindow       = 15 * time.Minute
+)
+
+func NewLoginGuard(store ratelimit.Store) *LoginGuard {
+	return &LoginGuard{
+		account: ratelimit.NewBackoff(store, "login:account", LoginAccountFreeAttempts, LoginBackoffBase, LoginBackoffMax, LoginBackoffWindow),
+		ip:      ratelimit.NewBackoff(store, "login:ip", LoginIPFreeAttempts, LoginBackoffBase, LoginBackoffMax, LoginBackoffWindow),
+	}
+}
+
+// Check returns how long logins for email from ip are locked out, or 0.
+// The guard fails open: store errors are logged and ignored.
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) time.Duration {
+	wait, err := g.account.Check(ctx, accountKey(email))
+	if err != nil {
+		log.Printf("Login guard: %v", err)
+	}
+	if ip != "" {
+		ipWait, err := g.ip.Check(ctx, ip)
+		if err != nil {
+			log.Printf("Login guard: %v", err)
+		}
+		wait = max(wait, ipWait)
+	}
+	return wait
+}
+
+// Fail records a failed login.
+func (g *LoginGuard) Fail(ctx context.Context, email, ip string) {
+	if _, err := g.account.Fail(ctx, accountKey(email)); err != nil {
+		log.Printf("Login guard: %v", err)
+	}
+	if ip != "" {
+		if _, err := g.ip.Fail(ctx, ip); err != nil {
+			log.Printf("Login guard: %v", err)
+		}
+	}
+}
+
+// Succeed clears the account's failures. The IP's are kept, or an attacker
+// could reset them by signing in to an account of their own.
+func (g *LoginGuard) Succeed(ctx context.Context, email string) {
+	if err := g.account.Reset(ctx, accountKey(email)); err != nil {
+		log.Printf("Login guard: %v", err)
+	}
+}
+
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// comparePasswordTiming spends as long as a password check would, so that
+// unknown emails can't be told apart by response time.
+func comparePasswordTiming(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-password"), bcrypt.DefaultCost)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/pkg/ratelimit"
+
+	"github.com/google/uuid"
+)
+
+// MessageLimits bounds how fast messages and typing events are fanned out.
+// Zero limits are not enforced.
+type MessageLimits struct {
+	PerUser         ratelimit.Limit // Messages one user may send, across all conversations
+	PerConversation ratelimit.Limit // Messages all members together may send to one conversation
+	TypingInterval  time.Duration   // Minimum time between typing_start events of a user in a conversation
+}
+
+// MessageOption configures optional behaviour of the MessageService.
+type MessageOption func(*messageService)
+
+// WithMessageLimits enforces limits, keeping their state in store.
+func WithMessageLimits(store ratelimit.Store, limits MessageLimits) MessageOption {
+	return func(s *messageService) {
+		if limits.PerUser.Burst > 0 {
+			s.userLimiter = ratelimit.NewLimiter(store, "msg:user", limits.PerUser)
+		}
+		if limits.PerConversation.Burst > 0 {
+			s.convLimiter = ratelimit.NewLimiter(store, "msg:conv", limits.PerConversation)
+		}
+		if limits.TypingInterval > 0 {
+			s.typingLimiter = ratelimit.NewLimiter(store, "typing", ratelimit.Limit{
+				Rate:  1 / limits.TypingInterval.Seconds(),
+				Burst: 1,
+			})
+		}
+	}
+}
+
+// checkSendLimits takes a token from the sender's bucket and the conversation's.
+// It returns a *apperrors.RateLimitError once either is empty. Store errors are
+// logged and the message let through.
+func (s *messageService) checkSendLimits(ctx context.Context, senderID uuid.UUID, convKey string) error {
+	if s.userLimiter != nil {
+		if err := takeToken(ctx, s.userLimiter, senderID.String()); err != nil {
+			return err
+		}
+	}
+	if s.convLimiter != nil {
+		if err := takeToken(ctx, s.convLimiter, convKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allowTyping reports whether a typing event may be broadcast. typing_start
+// events arriving faster than the typing interval are dropped; typing_stop is
+// always let through so recipients never keep showing a stale indicator.
+func (s *messageService) allowTyping(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, isTyping bool) bool {
+	if s.typingLimiter == nil || !isTyping {
+		return true
+	}
+	return takeToken(ctx, s.typingLimiter, userID.String()+":"+convType+":"+targetID.String()) == nil
+}
+
+func takeToken(ctx context.Context, limiter *ratelimit.Limiter, key string) error {
+	res, err := limiter.Allow(ctx, key)
+	if err != nil {
+		log.Printf("Message rate limiter error: %v", err)
+		return nil
+	}
+	if !res.Allowed {
+		return &apperrors.RateLimitError{RetryAfter: res.RetryAfter}
+	}
+	return nil
+}
+
+// dmConversationKey identifies a DM conversation the same way for both participants.
+func dmConversationKey(a, b uuid.UUID) string {
+	ids := []string{a.String(), b.String()}
+	sort.Strings(ids)
+	return "DM:" + ids[0] + ":" + ids[1]
+}
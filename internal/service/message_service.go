@@ -2,12 +2,17 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	apperrors "chat-app/internal/errors"
 	"chat-app/internal/models"
+	"chat-app/internal/protocol"
 	"chat-app/internal/repository"
+	"chat-app/pkg/ratelimit"
 
 	"github.com/google/uuid"
 )
@@ -16,6 +21,8 @@ import (
 // This decouples the service package from the websocket package.
 type Hub interface {
 	SendToUser(userID uuid.UUID, message []byte)
+	SendToUsers(userIDs []uuid.UUID, message []byte) // Encodes the message once for all of them
+	ConnectedUsers(userIDs []uuid.UUID) []uuid.UUID  // Those of the users with an open connection
 	IsUserViewingConversation(convType string, targetID uuid.UUID) bool
 }
 
@@ -26,6 +33,12 @@ type messageService struct {
 	receiptRepo repository.MessageReceiptRepository
 	userRepo    repository.UserRepository
 	hub         Hub
+
+	userLimiter   *ratelimit.Limiter // Optional, see WithMessageLimits
+	convLimiter   *ratelimit.Limiter
+	typingLimiter *ratelimit.Limiter
+
+	contactRepo repository.ContactRepository // Optional, see WithContacts
 }
 
 func NewMessageService(
@@ -35,8 +48,9 @@ func NewMessageService(
 	receiptRepo repository.MessageReceiptRepository,
 	userRepo repository.UserRepository,
 	hub Hub,
+	opts ...MessageOption,
 ) MessageService {
-	return &messageService{
+	s := &messageService{
 		msgRepo:     msgRepo,
 		convRepo:    convRepo,
 		groupRepo:   groupRepo,
@@ -44,9 +58,32 @@ func NewMessageService(
 		userRepo:    userRepo,
 		hub:         hub,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithContacts lets users accept direct messages from their contacts only
+// (models.User.DMPolicy).
+func WithContacts(contactRepo repository.ContactRepository) MessageOption {
+	return func(s *messageService) {
+		s.contactRepo = contactRepo
+	}
 }
 
 func (s *messageService) SendDirectMessage(ctx context.Context, senderID, receiverID uuid.UUID, content string) (*models.Message, error) {
+	// 0. Flood control
+	if err := s.checkSendLimits(ctx, senderID, dmConversationKey(senderID, receiverID)); err != nil {
+		return nil, err
+	}
+
+	// 0.5 The receiver must exist, and may only accept DMs from their contacts
+	receiver, err := s.checkDMPolicy(ctx, senderID, receiverID)
+	if err != nil {
+		return nil, err
+	}
+
 	// 1. Create Message
 	msg := &models.Message{
 		BaseModel: models.BaseModel{
@@ -64,7 +101,7 @@ func (s *messageService) SendDirectMessage(ctx context.Context, senderID, receiv
 
 	// 1.5 Populate Sender info for response/broadcast
 	sender, err := s.userRepo.FindByID(ctx, senderID)
-	if err == nil {
+	if err == nil && sender != nil {
 		msg.Sender = *sender
 	}
 
@@ -94,6 +131,8 @@ func (s *messageService) SendDirectMessage(ctx context.Context, senderID, receiv
 	// Check if receiver is currently viewing this conversation
 	// If yes, reset unread to 0 (standard chat app behavior)
 	// If no, increment unread count
+	// The receiver's side of the conversation has the sender as its target
+	muted := s.mutedBy(ctx, "DM", senderID, []uuid.UUID{receiverID})
 	if s.hub.IsUserViewingConversation("DM", senderID) {
 		// Receiver is viewing the chat, update last message but keep unread at 0
 		s.convRepo.Upsert(ctx, &models.Conversation{
@@ -105,18 +144,29 @@ func (s *messageService) SendDirectMessage(ctx context.Context, senderID, receiv
 			UnreadCount:   0,
 		})
 	} else {
-		// Receiver is not viewing the chat, increment unread
-		s.convRepo.IncrementUnread(ctx, receiverID, "DM", senderID, content)
+		// Receiver is not viewing the chat, increment unread and update their badge
+		// unless they muted the conversation, which the badge leaves out
+		mentioned := false
+		if strings.Contains(content, "@") {
+			mentioned = mentions(content, receiver.Username)
+		}
+		if err := s.convRepo.IncrementUnread(ctx, receiverID, "DM", senderID, content, mentioned); err == nil && !muted[receiverID] {
+			s.pushBadges(ctx, []uuid.UUID{receiverID})
+		}
 	}
 
 	// 5. Real-time Delivery via WebSocket
-	payload, _ := json.Marshal(map[string]interface{}{
-		"type":    "new_message",
-		"payload": msg,
-	})
-
-	// Broadcast to receiver's devices
-	s.hub.SendToUser(receiverID, payload)
+	event := protocol.NewMessagePayload(msg)
+	payload, _ := protocol.Encode(protocol.EventNewMessage, event)
+
+	// Broadcast to receiver's devices, marked as muted if they muted the conversation
+	if muted[receiverID] {
+		event.Muted = true
+		mutedPayload, _ := protocol.Encode(protocol.EventNewMessage, event)
+		s.hub.SendToUser(receiverID, mutedPayload)
+	} else {
+		s.hub.SendToUser(receiverID, payload)
+	}
 
 	// Broadcast to sender's other devices for multi-device sync
 	s.hub.SendToUser(senderID, payload)
@@ -124,6 +174,30 @@ func (s *messageService) SendDirectMessage(ctx context.Context, senderID, receiv
 	return msg, nil
 }
 
+// checkDMPolicy returns the receiver of a direct message. It refuses missing and
+// deleted accounts, and users who only accept DMs from contacts unless the sender is one.
+func (s *messageService) checkDMPolicy(ctx context.Context, senderID, receiverID uuid.UUID) (*models.User, error) {
+	receiver, err := s.userRepo.FindByID(ctx, receiverID)
+	if err != nil {
+		return nil, err
+	}
+	if receiver == nil || receiver.AccountDeletedAt != nil {
+		return nil, apperrors.ErrNotFound
+	}
+	if s.contactRepo == nil || senderID == receiverID || receiver.AcceptsDMsFrom(false) {
+		return receiver, nil
+	}
+
+	isContact, err := s.contactRepo.AreContacts(ctx, senderID, receiverID)
+	if err != nil {
+		return nil, err
+	}
+	if !isContact {
+		return nil, apperrors.ErrDMNotAllowed
+	}
+	return receiver, nil
+}
+
 func (s *messageService) SendGroupMessage(ctx context.Context, senderID, groupID uuid.UUID, content string) (*models.Message, error) {
 	// 1. Verify sender is a member of the group
 	isMember, err := s.groupRepo.IsMember(ctx, groupID, senderID)
@@ -134,6 +208,11 @@ func (s *messageService) SendGroupMessage(ctx context.Context, senderID, groupID
 		return nil, ErrNotGroupMember
 	}
 
+	// 1.5 Flood control
+	if err := s.checkSendLimits(ctx, senderID, "GROUP:"+groupID.String()); err != nil {
+		return nil, err
+	}
+
 	// 2. Create Message
 	msg := &models.Message{
 		BaseModel: models.BaseModel{
@@ -151,7 +230,7 @@ func (s *messageService) SendGroupMessage(ctx context.Context, senderID, groupID
 
 	// 2.5 Populate Sender info for response/broadcast
 	sender, err := s.userRepo.FindByID(ctx, senderID)
-	if err == nil {
+	if err == nil && sender != nil {
 		msg.Sender = *sender
 	}
 
@@ -186,7 +265,28 @@ func (s *messageService) SendGroupMessage(ctx context.Context, senderID, groupID
 		}
 	}
 
-	// 4. For each member (except sender), update conversation and broadcast
+	// 3.5 Find the members mentioned by username
+	mentioned := make(map[uuid.UUID]bool)
+	if strings.Contains(content, "@") && len(receipts) > 0 {
+		ids := make([]uuid.UUID, 0, len(receipts))
+		for _, receipt := range receipts {
+			ids = append(ids, receipt.UserID)
+		}
+		if users, err := s.userRepo.FindByIDs(ctx, ids); err == nil {
+			for _, user := range users {
+				mentioned[user.ID] = mentions(content, user.Username)
+			}
+		}
+	}
+
+	// 4. For each member (except sender), update conversation, then broadcast to all of them at once
+	memberIDs := make([]uuid.UUID, 0, len(receipts))
+	for _, receipt := range receipts {
+		memberIDs = append(memberIDs, receipt.UserID)
+	}
+	muted := s.mutedBy(ctx, "GROUP", groupID, memberIDs)
+	recipients := make([]uuid.UUID, 0, len(receipts))
+	var mutedRecipients, badged []uuid.UUID
 	for _, member := range members {
 
 		if member.UserID == senderID {
@@ -215,24 +315,31 @@ func (s *messageService) SendGroupMessage(ctx context.Context, senderID, groupID
 				UnreadCount:   0,
 			})
 		} else {
-			// Member is not viewing the group, increment unread
-			s.convRepo.IncrementUnread(ctx, member.UserID, "GROUP", groupID, content)
+			// Member is not viewing the group, increment unread and update their badge
+			// unless they muted the group, which the badge leaves out
+			if err := s.convRepo.IncrementUnread(ctx, member.UserID, "GROUP", groupID, content, mentioned[member.UserID]); err == nil && !muted[member.UserID] {
+				badged = append(badged, member.UserID)
+			}
 		}
 
-		// Real-time delivery via WebSocket
-		payload, _ := json.Marshal(map[string]interface{}{
-			"type":    "new_message",
-			"payload": msg,
-		})
-		s.hub.SendToUser(member.UserID, payload)
+		if muted[member.UserID] {
+			mutedRecipients = append(mutedRecipients, member.UserID)
+		} else {
+			recipients = append(recipients, member.UserID)
+		}
 	}
 
-	// Broadcast to sender's devices for multi-device sync
-	senderPayload, _ := json.Marshal(map[string]interface{}{
-		"type":    "new_message",
-		"payload": msg,
-	})
-	s.hub.SendToUser(senderID, senderPayload)
+	// Real-time delivery via WebSocket, including the sender's devices for multi-device sync.
+	// Members who muted the group get the message marked as muted.
+	event := protocol.NewMessagePayload(msg)
+	payload, _ := protocol.Encode(protocol.EventNewMessage, event)
+	s.hub.SendToUsers(append(recipients, senderID), payload)
+	if len(mutedRecipients) > 0 {
+		event.Muted = true
+		mutedPayload, _ := protocol.Encode(protocol.EventNewMessage, event)
+		s.hub.SendToUsers(mutedRecipients, mutedPayload)
+	}
+	s.pushBadges(ctx, badged)
 
 	return msg, nil
 }
@@ -269,14 +376,11 @@ func (s *messageService) updateReceiptStatus(ctx context.Context, userID uuid.UU
 		// 3. Broadcast receipt_update to Sender [F06]
 		// Don't broadcast to self if it's a note to self (unlikely)
 		if msg.SenderID != userID {
-			payload, _ := json.Marshal(map[string]interface{}{
-				"type": "receipt_update",
-				"payload": map[string]interface{}{
-					"message_id": msgID,
-					"user_id":    userID, // Who read/received it
-					"status":     status,
-					"updated_at": time.Now(),
-				},
+			payload, _ := protocol.Encode(protocol.EventReceiptUpdate, protocol.ReceiptUpdatePayload{
+				MessageID: msgID,
+				UserID:    userID, // Who read/received it
+				Status:    status,
+				UpdatedAt: time.Now(),
 			})
 			s.hub.SendToUser(msg.SenderID, payload)
 		}
@@ -284,6 +388,102 @@ func (s *messageService) updateReceiptStatus(ctx context.Context, userID uuid.UU
 	return nil
 }
 
+// GetUnreadCounts returns the user's unread and mention counts, per conversation
+// and in total. The totals are summed from the same rows, so they agree with the
+// unread counts the inbox shows. Muted conversations are left out: they keep
+// their unread counts in the inbox, but don't add to the badge.
+func (s *messageService) GetUnreadCounts(ctx context.Context, userID uuid.UUID) (*protocol.BadgePayload, error) {
+	convs, err := s.convRepo.FindUnreadCounts(ctx, []uuid.UUID{userID})
+	if err != nil {
+		return nil, err
+	}
+	return newBadge(convs), nil
+}
+
+func newBadge(convs []models.Conversation) *protocol.BadgePayload {
+	badge := &protocol.BadgePayload{Conversations: make([]protocol.ConversationBadge, 0, len(convs))}
+	for _, conv := range convs {
+		badge.TotalUnread += conv.UnreadCount
+		badge.TotalMentions += conv.MentionCount
+		badge.Conversations = append(badge.Conversations, protocol.ConversationBadge{
+			ConversationType: conv.Type,
+			TargetID:         conv.TargetID,
+			UnreadCount:      conv.UnreadCount,
+			MentionCount:     conv.MentionCount,
+		})
+	}
+	return badge
+}
+
+// MarkConversationRead resets the unread counts of the conversation and, if
+// there were any, updates the badge on all of the user's devices.
+func (s *messageService) MarkConversationRead(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) error {
+	changed, err := s.convRepo.ResetUnread(ctx, userID, convType, targetID)
+	if err != nil {
+		return err
+	}
+	if changed {
+		s.PushBadge(ctx, userID)
+	}
+	return nil
+}
+
+// PushBadge sends the user's current unread counts to all of their devices,
+// e.g. after they muted or unmuted a conversation.
+func (s *messageService) PushBadge(ctx context.Context, userID uuid.UUID) {
+	s.pushBadges(ctx, []uuid.UUID{userID})
+}
+
+// pushBadges sends those of the users who are connected their current unread
+// counts, loaded in a single query. Failures are ignored; the next change or
+// GET /me/unread catches up.
+func (s *messageService) pushBadges(ctx context.Context, userIDs []uuid.UUID) {
+	connected := s.hub.ConnectedUsers(userIDs)
+	if len(connected) == 0 {
+		return
+	}
+	convs, err := s.convRepo.FindUnreadCounts(ctx, connected)
+	if err != nil {
+		return
+	}
+
+	byUser := make(map[uuid.UUID][]models.Conversation, len(connected))
+	for _, conv := range convs {
+		byUser[conv.UserID] = append(byUser[conv.UserID], conv)
+	}
+	for _, userID := range connected {
+		payload, _ := protocol.Encode(protocol.EventBadgeUpdate, newBadge(byUser[userID]))
+		s.hub.SendToUser(userID, payload)
+	}
+}
+
+// mentions reports whether the content @mentions the username, ignoring case.
+// The mention must stand on its own, so "@bob" doesn't match "@bobby" or "a@bob.com".
+func mentions(content, username string) bool {
+	if username == "" {
+		return false
+	}
+	text := strings.ToLower(content)
+	needle := "@" + strings.ToLower(username)
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], needle)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(needle)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (start == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after)) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
 func (s *messageService) GetMessageReceipts(ctx context.Context, userID, messageID uuid.UUID) ([]models.MessageReceipt, error) {
 	// 1. Fetch Message to verify access
 	msg, err := s.msgRepo.FindByID(ctx, messageID)
@@ -318,33 +518,31 @@ func (s *messageService) GetUserInfo(ctx context.Context, userID uuid.UUID) (*mo
 
 func (s *messageService) BroadcastTypingIndicator(ctx context.Context, userID uuid.UUID, username, convType string, targetID uuid.UUID, isTyping bool) error {
 	// Build event type
-	eventType := "user_typing"
+	eventType := protocol.EventUserTyping
 	if !isTyping {
-		eventType = "user_stopped_typing"
+		eventType = protocol.EventUserStoppedTyping
 	}
 
-	// Build base payload
-	payloadData := map[string]interface{}{
-		"user_id":           userID,
-		"conversation_type": convType,
-		"target_id":         targetID,
+	// Build payload; username is only sent with typing_start events
+	payloadData := protocol.TypingEventPayload{
+		UserID:           userID,
+		ConversationType: convType,
+		TargetID:         targetID,
 	}
-
-	// Add username only for typing_start events
 	if isTyping {
-		payloadData["username"] = username
+		payloadData.Username = username
 	}
 
 	switch convType {
 	case "DM":
 		// Send to single user
-		// Prevent broadcast to self
-		if targetID != userID {
-			payload, _ := json.Marshal(map[string]interface{}{
-				"type":    eventType,
-				"payload": payloadData,
-			})
-			s.hub.SendToUser(targetID, payload)
+		// Prevent broadcast to self, and drop events arriving faster than clients should send them
+		if targetID != userID && s.allowTyping(ctx, userID, convType, targetID, isTyping) {
+			// The receiver's side of the conversation has the sender as its target
+			if muted := s.mutedBy(ctx, convType, userID, []uuid.UUID{targetID}); !muted[targetID] {
+				payload, _ := protocol.Encode(eventType, payloadData)
+				s.hub.SendToUser(targetID, payload)
+			}
 		}
 	case "GROUP":
 		// Verify sender is a member
@@ -355,6 +553,9 @@ func (s *messageService) BroadcastTypingIndicator(ctx context.Context, userID uu
 		if !isMember {
 			return ErrNotGroupMember
 		}
+		if !s.allowTyping(ctx, userID, convType, targetID, isTyping) {
+			return nil
+		}
 
 		// Get all group members
 		members, err := s.groupRepo.GetMembers(ctx, targetID)
@@ -362,18 +563,39 @@ func (s *messageService) BroadcastTypingIndicator(ctx context.Context, userID uu
 			return err
 		}
 
-		// Broadcast to all members except sender
-		payload, _ := json.Marshal(map[string]interface{}{
-			"type":    eventType,
-			"payload": payloadData,
-		})
-
+		// Broadcast to all members except sender and those who muted the group
+		memberIDs := make([]uuid.UUID, 0, len(members))
 		for _, member := range members {
 			if member.UserID != userID {
-				s.hub.SendToUser(member.UserID, payload)
+				memberIDs = append(memberIDs, member.UserID)
+			}
+		}
+		muted := s.mutedBy(ctx, convType, targetID, memberIDs)
+		recipients := make([]uuid.UUID, 0, len(memberIDs))
+		for _, memberID := range memberIDs {
+			if !muted[memberID] {
+				recipients = append(recipients, memberID)
 			}
 		}
+		payload, _ := protocol.Encode(eventType, payloadData)
+		s.hub.SendToUsers(recipients, payload)
 	}
 
 	return nil
 }
+
+// mutedBy returns which of the users muted their conversation of the given type
+// with targetID. Notification-style events such as typing indicators and badge
+// updates skip them, and their new_message events are marked as muted.
+// If the lookup fails, nobody is considered muted.
+func (s *messageService) mutedBy(ctx context.Context, convType string, targetID uuid.UUID, userIDs []uuid.UUID) map[uuid.UUID]bool {
+	muted := make(map[uuid.UUID]bool)
+	ids, err := s.convRepo.FindMutedUserIDs(ctx, convType, targetID, userIDs)
+	if err != nil {
+		return muted
+	}
+	for _, id := range ids {
+		muted[id] = true
+	}
+	return muted
+}
@@ -2,9 +2,14 @@ package service_test
 
 import (
 	"context"
+	apperrors "chat-app/internal/errors"
 	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+	"chat-app/internal/repository"
 	"chat-app/internal/service"
+	"chat-app/pkg/ratelimit"
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
@@ -48,23 +53,52 @@ func (m *MockConversationRepo) FindByUser(ctx context.Context, userID uuid.UUID)
 	args := m.Called(ctx, userID)
 	return args.Get(0).([]models.Conversation), args.Error(1)
 }
-func (m *MockConversationRepo) IncrementUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, lastMessage string) error {
-	args := m.Called(ctx, userID, convType, targetID, lastMessage)
+func (m *MockConversationRepo) IncrementUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, lastMessage string, mentioned bool) error {
+	args := m.Called(ctx, userID, convType, targetID, lastMessage, mentioned)
 	return args.Error(0)
 }
-func (m *MockConversationRepo) ResetUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) error {
+func (m *MockConversationRepo) ResetUnread(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) (bool, error) {
 	args := m.Called(ctx, userID, convType, targetID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockConversationRepo) FindOne(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID) (*models.Conversation, error) {
+	args := m.Called(ctx, userID, convType, targetID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Conversation), args.Error(1)
+}
+
+func (m *MockConversationRepo) UpdateSettings(ctx context.Context, conv *models.Conversation) error {
+	args := m.Called(ctx, conv)
 	return args.Error(0)
 }
 
-func (m *MockConversationRepo) FindContactsOfUser(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
-	args := m.Called(ctx, userID)
+func (m *MockConversationRepo) FindMutedUserIDs(ctx context.Context, convType string, targetID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	args := m.Called(ctx, convType, targetID, userIDs)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]uuid.UUID), args.Error(1)
 }
 
+func (m *MockConversationRepo) FindInbox(ctx context.Context, userID uuid.UUID, filter repository.InboxFilter) ([]models.InboxEntry, error) {
+	args := m.Called(ctx, userID, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.InboxEntry), args.Error(1)
+}
+
+func (m *MockConversationRepo) FindUnreadCounts(ctx context.Context, userIDs []uuid.UUID) ([]models.Conversation, error) {
+	args := m.Called(ctx, userIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Conversation), args.Error(1)
+}
+
 // MockGroupRepo
 type MockGroupRepo struct {
 	mock.Mock
@@ -101,12 +135,31 @@ func (m *MockGroupRepo) AddMember(ctx context.Context, groupID, userID uuid.UUID
 // MockHub
 type MockHub struct {
 	mock.Mock
+	Offline map[uuid.UUID]bool // Users without an open connection
 }
 
 func (m *MockHub) SendToUser(userID uuid.UUID, message []byte) {
 	m.Called(userID, message)
 }
 
+// SendToUsers records one SendToUser call per user, so tests can expect each recipient.
+func (m *MockHub) SendToUsers(userIDs []uuid.UUID, message []byte) {
+	for _, userID := range userIDs {
+		m.SendToUser(userID, message)
+	}
+}
+
+// ConnectedUsers treats everyone not in Offline as connected.
+func (m *MockHub) ConnectedUsers(userIDs []uuid.UUID) []uuid.UUID {
+	connected := make([]uuid.UUID, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if !m.Offline[userID] {
+			connected = append(connected, userID)
+		}
+	}
+	return connected
+}
+
 func (m *MockHub) IsUserViewingConversation(convType string, targetID uuid.UUID) bool {
 	args := m.Called(convType, targetID)
 	return args.Bool(0)
@@ -151,6 +204,60 @@ func (m *MockUserRepo) Search(ctx context.Context, query string, excludeUserID u
 	return args.Get(0).([]models.User), args.Error(1)
 }
 
+func (m *MockUserRepo) UpdatePassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	args := m.Called(ctx, userID, passwordHash)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) MarkEmailVerified(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) DeleteAccount(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+
+func (m *MockUserRepo) SetTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	args := m.Called(ctx, userID, secret)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) EnableTwoFactor(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	args := m.Called(ctx, userID, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepo) UpdateProfile(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) UpdatePrivacy(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.User), args.Error(1)
+}
+
+func (m *MockUserRepo) UpdatePresence(ctx context.Context, userID uuid.UUID, presence string) error {
+	args := m.Called(ctx, userID, presence)
+	return args.Error(0)
+}
+
 // MockMessageReceiptRepo [F06]
 type MockMessageReceiptRepo struct {
 	mock.Mock
@@ -204,6 +311,12 @@ func TestSendDirectMessage(t *testing.T) {
 		return msg.SenderID == senderID && *msg.ReceiverID == receiverID && msg.Content == content
 	})).Return(nil)
 
+	// 0.5 Look up the receiver
+	mockUserRepo.On("FindByID", ctx, receiverID).Return(&models.User{
+		BaseModel: models.BaseModel{ID: receiverID},
+		Username:  "Receiver",
+	}, nil)
+
 	// 1.5 Get sender info for response
 	mockUserRepo.On("FindByID", ctx, senderID).Return(&models.User{
 		BaseModel: models.BaseModel{ID: senderID},
@@ -222,9 +335,11 @@ func TestSendDirectMessage(t *testing.T) {
 
 	// 3.5 Check if receiver is viewing the conversation (returns false by default for this test)
 	mockHub.On("IsUserViewingConversation", "DM", senderID).Return(false)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "DM", senderID, mock.Anything).Return([]uuid.UUID{}, nil)
 
-	// 3. Increment Unread for Receiver (now with 5 params including lastMessage)
-	mockConvRepo.On("IncrementUnread", ctx, receiverID, "DM", senderID, content).Return(nil)
+	// 3. Increment Unread for Receiver (with lastMessage and whether it mentions them)
+	mockConvRepo.On("IncrementUnread", ctx, receiverID, "DM", senderID, content, false).Return(nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{receiverID}).Return([]models.Conversation{}, nil)
 
 	// 4. Send to Hub for receiver (B006: also send to sender's other devices)
 	mockHub.On("SendToUser", receiverID, mock.MatchedBy(func(payload []byte) bool {
@@ -233,6 +348,13 @@ func TestSendDirectMessage(t *testing.T) {
 		return msg["type"] == "new_message"
 	})).Return()
 
+	// Badge update for the receiver
+	mockHub.On("SendToUser", receiverID, mock.MatchedBy(func(payload []byte) bool {
+		var msg map[string]interface{}
+		json.Unmarshal(payload, &msg)
+		return msg["type"] == "badge_update"
+	})).Return()
+
 	// B006: Also broadcast to sender's other devices for multi-device sync
 	mockHub.On("SendToUser", senderID, mock.MatchedBy(func(payload []byte) bool {
 		var msg map[string]interface{}
@@ -254,6 +376,92 @@ func TestSendDirectMessage(t *testing.T) {
 	mockUserRepo.AssertExpectations(t)
 }
 
+func TestSendDirectMessage_EventCarriesOnlyPublicSenderFields(t *testing.T) {
+	ctx := context.Background()
+	mockMsgRepo, mockConvRepo, mockReceiptRepo, mockUserRepo, mockHub := new(MockMessageRepo), new(MockConversationRepo), new(MockMessageReceiptRepo), new(MockUserRepo), new(MockHub)
+	svc := service.NewMessageService(mockMsgRepo, mockConvRepo, new(MockGroupRepo), mockReceiptRepo, mockUserRepo, mockHub)
+
+	senderID, receiverID := uuid.New(), uuid.New()
+	mockMsgRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockUserRepo.On("FindByID", ctx, senderID).Return(&models.User{
+		BaseModel:       models.BaseModel{ID: senderID},
+		Username:        "sender",
+		DisplayName:     "Sender",
+		Email:           "sender@example.com",
+		IsOnline:        true,
+		Presence:        models.PresenceInvisible,
+		EmailVisibility: models.VisibilityNobody,
+	}, nil)
+	mockUserRepo.On("FindByID", ctx, receiverID).Return(&models.User{BaseModel: models.BaseModel{ID: receiverID}}, nil)
+	mockReceiptRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockConvRepo.On("Upsert", ctx, mock.Anything).Return(nil)
+	mockHub.On("IsUserViewingConversation", "DM", senderID).Return(true)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "DM", senderID, mock.Anything).Return([]uuid.UUID{}, nil)
+
+	var frame []byte
+	mockHub.On("SendToUser", receiverID, mock.Anything).Run(func(args mock.Arguments) {
+		frame = args.Get(1).([]byte)
+	}).Return()
+	mockHub.On("SendToUser", senderID, mock.Anything).Return()
+
+	_, err := svc.SendDirectMessage(ctx, senderID, receiverID, "Hello")
+	assert.NoError(t, err)
+
+	var env struct {
+		Type    string `json:"type"`
+		Payload struct {
+			Sender map[string]interface{} `json:"sender"`
+		} `json:"payload"`
+	}
+	assert.NoError(t, json.Unmarshal(frame, &env))
+	assert.Equal(t, protocol.EventNewMessage, env.Type)
+	assert.Equal(t, "sender", env.Payload.Sender["username"])
+	assert.Equal(t, "Sender", env.Payload.Sender["display_name"])
+	for _, field := range []string{"email", "presence", "is_online", "last_seen", "email_visibility", "dm_policy", "two_factor_enabled_at"} {
+		assert.NotContains(t, env.Payload.Sender, field)
+	}
+}
+
+func TestSendDirectMessage_MissingSenderIsNotFatal(t *testing.T) {
+	ctx := context.Background()
+	mockMsgRepo, mockConvRepo, mockReceiptRepo, mockUserRepo, mockHub := new(MockMessageRepo), new(MockConversationRepo), new(MockMessageReceiptRepo), new(MockUserRepo), new(MockHub)
+	svc := service.NewMessageService(mockMsgRepo, mockConvRepo, new(MockGroupRepo), mockReceiptRepo, mockUserRepo, mockHub)
+
+	senderID, receiverID := uuid.New(), uuid.New()
+	mockMsgRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockUserRepo.On("FindByID", ctx, senderID).Return(nil, nil)
+	mockUserRepo.On("FindByID", ctx, receiverID).Return(&models.User{BaseModel: models.BaseModel{ID: receiverID}}, nil)
+	mockReceiptRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockConvRepo.On("Upsert", ctx, mock.Anything).Return(nil)
+	mockHub.On("IsUserViewingConversation", "DM", senderID).Return(true)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "DM", senderID, mock.Anything).Return([]uuid.UUID{}, nil)
+	mockHub.On("SendToUser", mock.Anything, mock.Anything).Return()
+
+	msg, err := svc.SendDirectMessage(ctx, senderID, receiverID, "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", msg.Content)
+}
+
+func TestSendDirectMessage_RefusesDeletedAccount(t *testing.T) {
+	ctx := context.Background()
+	mockMsgRepo, mockConvRepo, mockUserRepo := new(MockMessageRepo), new(MockConversationRepo), new(MockUserRepo)
+	svc := service.NewMessageService(mockMsgRepo, mockConvRepo, new(MockGroupRepo), new(MockMessageReceiptRepo), mockUserRepo, new(MockHub))
+
+	senderID, deletedID, missingID := uuid.New(), uuid.New(), uuid.New()
+	deletedAt := time.Now()
+	mockUserRepo.On("FindByID", ctx, deletedID).Return(&models.User{BaseModel: models.BaseModel{ID: deletedID}, AccountDeletedAt: &deletedAt}, nil)
+	mockUserRepo.On("FindByID", ctx, missingID).Return(nil, nil)
+
+	_, err := svc.SendDirectMessage(ctx, senderID, deletedID, "Hello")
+	assert.ErrorIs(t, err, apperrors.ErrNotFound)
+	_, err = svc.SendDirectMessage(ctx, senderID, missingID, "Hello")
+	assert.ErrorIs(t, err, apperrors.ErrNotFound)
+
+	// Nothing is stored, so no inbox row appears for the deleted account
+	mockMsgRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+	mockConvRepo.AssertNotCalled(t, "IncrementUnread", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
 func TestGetHistory_Conversation(t *testing.T) {
 	ctx := context.Background()
 	mockMsgRepo := new(MockMessageRepo)
@@ -322,7 +530,11 @@ func TestLongConversationFlow(t *testing.T) {
 			return msg.SenderID == sender && *msg.ReceiverID == receiver && msg.Content == content
 		})).Return(nil).Once()
 
-		// Get sender info for response
+		// Look up the receiver, then get sender info for response
+		mockUserRepo.On("FindByID", ctx, receiver).Return(&models.User{
+			BaseModel: models.BaseModel{ID: receiver},
+			Username:  "User",
+		}, nil).Once()
 		mockUserRepo.On("FindByID", ctx, sender).Return(&models.User{
 			BaseModel: models.BaseModel{ID: sender},
 			Username:  "User",
@@ -339,11 +551,14 @@ func TestLongConversationFlow(t *testing.T) {
 
 		// Check if viewing conversation (returns false by default)
 		mockHub.On("IsUserViewingConversation", "DM", sender).Return(false).Once()
+		mockConvRepo.On("FindMutedUserIDs", ctx, "DM", sender, mock.Anything).Return([]uuid.UUID{}, nil)
 
-		mockConvRepo.On("IncrementUnread", ctx, receiver, "DM", sender, content).Return(nil).Once()
+		mockConvRepo.On("IncrementUnread", ctx, receiver, "DM", sender, content, false).Return(nil).Once()
+		mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{receiver}).Return([]models.Conversation{}, nil)
 
 		// B006: Send to both receiver and sender (for multi-device sync)
-		mockHub.On("SendToUser", receiver, mock.Anything).Return().Once()
+		// plus the receiver's badge update
+		mockHub.On("SendToUser", receiver, mock.Anything).Return().Twice()
 		mockHub.On("SendToUser", sender, mock.Anything).Return().Once()
 
 		// Execute
@@ -406,15 +621,17 @@ func TestSendGroupMessage_Success(t *testing.T) {
 
 	// Mock: Check if viewing conversation (returns false for all members)
 	mockHub.On("IsUserViewingConversation", "GROUP", groupID).Return(false).Times(2)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "GROUP", groupID, mock.Anything).Return([]uuid.UUID{}, nil)
 
 	// Mock: Upsert sender's conversation
 	mockConvRepo.On("Upsert", ctx, mock.MatchedBy(func(conv *models.Conversation) bool {
 		return conv.UserID == senderID && conv.TargetID == groupID && conv.Type == "GROUP"
 	})).Return(nil)
 
-	// Mock: Increment unread for other members (now with 5 params including content)
-	mockConvRepo.On("IncrementUnread", ctx, member1, "GROUP", groupID, content).Return(nil)
-	mockConvRepo.On("IncrementUnread", ctx, member2, "GROUP", groupID, content).Return(nil)
+	// Mock: Increment unread for other members (with content and whether it mentions them)
+	mockConvRepo.On("IncrementUnread", ctx, member1, "GROUP", groupID, content, false).Return(nil)
+	mockConvRepo.On("IncrementUnread", ctx, member2, "GROUP", groupID, content, false).Return(nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{member1, member2}).Return([]models.Conversation{}, nil)
 
 	// Mock: Send to hub for other members
 	mockHub.On("SendToUser", member1, mock.MatchedBy(func(payload []byte) bool {
@@ -429,6 +646,19 @@ func TestSendGroupMessage_Success(t *testing.T) {
 		return msg["type"] == "new_message"
 	})).Return()
 
+	// Badge update for the members
+	mockHub.On("SendToUser", member1, mock.MatchedBy(func(payload []byte) bool {
+		var msg map[string]interface{}
+		json.Unmarshal(payload, &msg)
+		return msg["type"] == "badge_update"
+	})).Return()
+
+	mockHub.On("SendToUser", member2, mock.MatchedBy(func(payload []byte) bool {
+		var msg map[string]interface{}
+		json.Unmarshal(payload, &msg)
+		return msg["type"] == "badge_update"
+	})).Return()
+
 	// B006: Also send to sender's other devices
 	mockHub.On("SendToUser", senderID, mock.MatchedBy(func(payload []byte) bool {
 		var msg map[string]interface{}
@@ -530,10 +760,12 @@ func TestSendGroupMessage_BroadcastsToAllMembers(t *testing.T) {
 
 	// Mock: Check if viewing conversation (returns false for all members in this test)
 	mockHub.On("IsUserViewingConversation", "GROUP", groupID).Return(false).Times(4)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "GROUP", groupID, mock.Anything).Return([]uuid.UUID{}, nil)
 
-	// Mock for each other member
+	// Mock for each other member, whose badges are loaded at once
+	mockConvRepo.On("FindUnreadCounts", ctx, otherMemberIDs).Return([]models.Conversation{}, nil)
 	for _, memberID := range otherMemberIDs {
-		mockConvRepo.On("IncrementUnread", ctx, memberID, "GROUP", groupID, mock.AnythingOfType("string")).Return(nil)
+		mockConvRepo.On("IncrementUnread", ctx, memberID, "GROUP", groupID, mock.AnythingOfType("string"), false).Return(nil)
 		mockHub.On("SendToUser", memberID, mock.Anything).Return()
 	}
 	// B006: Also send to sender's other devices
@@ -593,6 +825,7 @@ func TestSendGroupMessage_UpdatesConversationForAllMembers(t *testing.T) {
 
 	// Mock: Check if viewing conversation (returns false for all members)
 	mockHub.On("IsUserViewingConversation", "GROUP", groupID).Return(false).Times(2)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "GROUP", groupID, mock.Anything).Return([]uuid.UUID{}, nil)
 
 	// Expect conversation upsert for sender (unread = 0)
 	mockConvRepo.On("Upsert", ctx, mock.MatchedBy(func(conv *models.Conversation) bool {
@@ -600,8 +833,9 @@ func TestSendGroupMessage_UpdatesConversationForAllMembers(t *testing.T) {
 	})).Return(nil)
 
 	// Expect increment unread for other members (with 5th param)
-	mockConvRepo.On("IncrementUnread", ctx, member1, "GROUP", groupID, mock.AnythingOfType("string")).Return(nil)
-	mockConvRepo.On("IncrementUnread", ctx, member2, "GROUP", groupID, mock.AnythingOfType("string")).Return(nil)
+	mockConvRepo.On("IncrementUnread", ctx, member1, "GROUP", groupID, mock.AnythingOfType("string"), false).Return(nil)
+	mockConvRepo.On("IncrementUnread", ctx, member2, "GROUP", groupID, mock.AnythingOfType("string"), false).Return(nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{member1, member2}).Return([]models.Conversation{}, nil)
 
 	mockHub.On("SendToUser", mock.Anything, mock.Anything).Return()
 	mockHub.On("SendToUser", senderID, mock.Anything).Return()
@@ -616,8 +850,8 @@ func TestSendGroupMessage_UpdatesConversationForAllMembers(t *testing.T) {
 	mockConvRepo.AssertCalled(t, "Upsert", ctx, mock.MatchedBy(func(conv *models.Conversation) bool {
 		return conv.UserID == senderID
 	}))
-	mockConvRepo.AssertCalled(t, "IncrementUnread", ctx, member1, "GROUP", groupID, "Update conversations")
-	mockConvRepo.AssertCalled(t, "IncrementUnread", ctx, member2, "GROUP", groupID, "Update conversations")
+	mockConvRepo.AssertCalled(t, "IncrementUnread", ctx, member1, "GROUP", groupID, "Update conversations", false)
+	mockConvRepo.AssertCalled(t, "IncrementUnread", ctx, member2, "GROUP", groupID, "Update conversations", false)
 }
 
 func TestSendGroupMessage_SenderDoesNotReceiveOwnMessage(t *testing.T) {
@@ -657,9 +891,11 @@ func TestSendGroupMessage_SenderDoesNotReceiveOwnMessage(t *testing.T) {
 
 	// Mock: Check if viewing conversation (returns false for all members)
 	mockHub.On("IsUserViewingConversation", "GROUP", groupID).Return(false).Times(1)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "GROUP", groupID, mock.Anything).Return([]uuid.UUID{}, nil)
 
 	mockConvRepo.On("Upsert", ctx, mock.Anything).Return(nil)
-	mockConvRepo.On("IncrementUnread", ctx, member1, "GROUP", groupID, mock.AnythingOfType("string")).Return(nil)
+	mockConvRepo.On("IncrementUnread", ctx, member1, "GROUP", groupID, mock.AnythingOfType("string"), false).Return(nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{member1}).Return([]models.Conversation{}, nil)
 	mockHub.On("SendToUser", member1, mock.Anything).Return()
 
 	// B006: Also send to sender's other devices
@@ -737,3 +973,311 @@ func TestGetMessageReceipts_Forbidden(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "access denied", err.Error())
 }
+
+func TestSendDirectMessage_RateLimitedPerUser(t *testing.T) {
+	ctx := context.Background()
+	mockMsgRepo := new(MockMessageRepo)
+	mockConvRepo := new(MockConversationRepo)
+	mockHub := new(MockHub)
+	mockUserRepo := new(MockUserRepo)
+	mockReceiptRepo := new(MockMessageReceiptRepo)
+
+	svc := service.NewMessageService(mockMsgRepo, mockConvRepo, new(MockGroupRepo), mockReceiptRepo, mockUserRepo, mockHub,
+		service.WithMessageLimits(ratelimit.NewMemoryStore(), service.MessageLimits{PerUser: ratelimit.PerMinuteBurst(60, 2)}))
+
+	senderID := uuid.New()
+	mockMsgRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockUserRepo.On("FindByID", ctx, mock.Anything).Return(&models.User{}, nil)
+	mockReceiptRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockConvRepo.On("Upsert", ctx, mock.Anything).Return(nil)
+	mockConvRepo.On("IncrementUnread", ctx, mock.Anything, "DM", senderID, "hi", false).Return(nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, mock.Anything).Return([]models.Conversation{}, nil)
+	mockHub.On("IsUserViewingConversation", "DM", senderID).Return(false)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "DM", senderID, mock.Anything).Return([]uuid.UUID{}, nil)
+	mockHub.On("SendToUser", mock.Anything, mock.Anything).Return()
+
+	// The burst goes through, to any recipients
+	for i := 0; i < 2; i++ {
+		_, err := svc.SendDirectMessage(ctx, senderID, uuid.New(), "hi")
+		assert.NoError(t, err)
+	}
+
+	// Then the sender has to wait about a second for the next token
+	_, err := svc.SendDirectMessage(ctx, senderID, uuid.New(), "hi")
+	var rlErr *apperrors.RateLimitError
+	assert.ErrorAs(t, err, &rlErr)
+	assert.InDelta(t, time.Second, rlErr.RetryAfter, float64(100*time.Millisecond))
+	mockMsgRepo.AssertNumberOfCalls(t, "Create", 2)
+
+	// Other senders are unaffected
+	otherID := uuid.New()
+	mockConvRepo.On("IncrementUnread", ctx, mock.Anything, "DM", otherID, "hi", false).Return(nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, mock.Anything).Return([]models.Conversation{}, nil)
+	mockHub.On("IsUserViewingConversation", "DM", otherID).Return(false)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "DM", otherID, mock.Anything).Return([]uuid.UUID{}, nil)
+	_, err = svc.SendDirectMessage(ctx, otherID, uuid.New(), "hi")
+	assert.NoError(t, err)
+}
+
+func TestSendGroupMessage_RateLimitedPerConversation(t *testing.T) {
+	ctx := context.Background()
+	mockGroupRepo := new(MockGroupRepo)
+	mockMsgRepo := new(MockMessageRepo)
+
+	svc := service.NewMessageService(mockMsgRepo, new(MockConversationRepo), mockGroupRepo, new(MockMessageReceiptRepo), new(MockUserRepo), new(MockHub),
+		service.WithMessageLimits(ratelimit.NewMemoryStore(), service.MessageLimits{PerConversation: ratelimit.PerMinuteBurst(60, 1)}))
+
+	groupID := uuid.New()
+	mockGroupRepo.On("IsMember", ctx, groupID, mock.Anything).Return(true, nil)
+	mockMsgRepo.On("Create", ctx, mock.Anything).Return(errors.New("stop here"))
+
+	// The first message takes the group's only token, whoever sends the next one
+	_, err := svc.SendGroupMessage(ctx, uuid.New(), groupID, "hi")
+	assert.EqualError(t, err, "stop here")
+	_, err = svc.SendGroupMessage(ctx, uuid.New(), groupID, "hi")
+	assert.ErrorIs(t, err, apperrors.ErrTooManyRequests)
+	mockMsgRepo.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func TestSendGroupMessage_CountsMentions(t *testing.T) {
+	ctx := context.Background()
+	mockMsgRepo := new(MockMessageRepo)
+	mockConvRepo := new(MockConversationRepo)
+	mockGroupRepo := new(MockGroupRepo)
+	mockReceiptRepo := new(MockMessageReceiptRepo)
+	mockUserRepo := new(MockUserRepo)
+	mockHub := new(MockHub)
+
+	svc := service.NewMessageService(mockMsgRepo, mockConvRepo, mockGroupRepo, mockReceiptRepo, mockUserRepo, mockHub)
+
+	senderID, groupID, bob, bobby := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	content := "@BOB, can you check with bobby@example.com?"
+
+	mockGroupRepo.On("IsMember", ctx, groupID, senderID).Return(true, nil)
+	mockMsgRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockUserRepo.On("FindByID", ctx, senderID).Return(&models.User{BaseModel: models.BaseModel{ID: senderID}, Username: "alice"}, nil)
+	mockReceiptRepo.On("CreateBatch", ctx, mock.Anything).Return(nil)
+	mockGroupRepo.On("GetMembers", ctx, groupID).Return([]models.GroupMember{
+		{GroupID: groupID, UserID: senderID},
+		{GroupID: groupID, UserID: bob},
+		{GroupID: groupID, UserID: bobby},
+	}, nil)
+	mockUserRepo.On("FindByIDs", ctx, []uuid.UUID{bob, bobby}).Return([]models.User{
+		{BaseModel: models.BaseModel{ID: bob}, Username: "bob"},
+		{BaseModel: models.BaseModel{ID: bobby}, Username: "bobby"},
+	}, nil)
+	mockHub.On("IsUserViewingConversation", "GROUP", groupID).Return(false)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "GROUP", groupID, mock.Anything).Return([]uuid.UUID{}, nil)
+	mockConvRepo.On("Upsert", ctx, mock.Anything).Return(nil)
+
+	// Only bob is mentioned; "bobby@example.com" is not a mention of bobby
+	mockConvRepo.On("IncrementUnread", ctx, bob, "GROUP", groupID, content, true).Return(nil)
+	mockConvRepo.On("IncrementUnread", ctx, bobby, "GROUP", groupID, content, false).Return(nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{bob, bobby}).Return([]models.Conversation{
+		{UserID: bob, Type: "GROUP", TargetID: groupID, UnreadCount: 1, MentionCount: 1},
+		{UserID: bobby, Type: "GROUP", TargetID: groupID, UnreadCount: 1},
+	}, nil)
+
+	var bobBadge protocol.BadgePayload
+	mockHub.On("SendToUser", bob, mock.Anything).Run(func(args mock.Arguments) {
+		var env struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		json.Unmarshal(args.Get(1).([]byte), &env)
+		if env.Type == protocol.EventBadgeUpdate {
+			json.Unmarshal(env.Payload, &bobBadge)
+		}
+	}).Return()
+	mockHub.On("SendToUser", mock.Anything, mock.Anything).Return()
+
+	_, err := svc.SendGroupMessage(ctx, senderID, groupID, content)
+
+	assert.NoError(t, err)
+	mockConvRepo.AssertExpectations(t)
+	assert.Equal(t, 1, bobBadge.TotalUnread)
+	assert.Equal(t, 1, bobBadge.TotalMentions)
+}
+
+func TestSendGroupMessage_BadgesOnlyConnectedUnmutedMembersAtOnce(t *testing.T) {
+	ctx := context.Background()
+	mockMsgRepo := new(MockMessageRepo)
+	mockConvRepo := new(MockConversationRepo)
+	mockGroupRepo := new(MockGroupRepo)
+	mockReceiptRepo := new(MockMessageReceiptRepo)
+	mockUserRepo := new(MockUserRepo)
+	senderID, groupID, alice, bob, carol := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	mockHub := &MockHub{Offline: map[uuid.UUID]bool{bob: true}}
+
+	svc := service.NewMessageService(mockMsgRepo, mockConvRepo, mockGroupRepo, mockReceiptRepo, mockUserRepo, mockHub)
+
+	mockGroupRepo.On("IsMember", ctx, groupID, senderID).Return(true, nil)
+	mockMsgRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockUserRepo.On("FindByID", ctx, senderID).Return(&models.User{BaseModel: models.BaseModel{ID: senderID}}, nil)
+	mockReceiptRepo.On("CreateBatch", ctx, mock.Anything).Return(nil)
+	mockGroupRepo.On("GetMembers", ctx, groupID).Return([]models.GroupMember{
+		{GroupID: groupID, UserID: senderID},
+		{GroupID: groupID, UserID: alice},
+		{GroupID: groupID, UserID: bob},
+		{GroupID: groupID, UserID: carol},
+	}, nil)
+	mockHub.On("IsUserViewingConversation", "GROUP", groupID).Return(false)
+	mockConvRepo.On("Upsert", ctx, mock.Anything).Return(nil)
+	mockConvRepo.On("IncrementUnread", ctx, mock.Anything, "GROUP", groupID, "hi", false).Return(nil)
+
+	// Carol muted the group and bob isn't connected, so only alice's badge is loaded
+	mockConvRepo.On("FindMutedUserIDs", ctx, "GROUP", groupID, []uuid.UUID{alice, bob, carol}).Return([]uuid.UUID{carol}, nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{alice}).Return([]models.Conversation{
+		{UserID: alice, Type: "GROUP", TargetID: groupID, UnreadCount: 1},
+	}, nil).Once()
+
+	badges := map[uuid.UUID]int{}
+	mockHub.On("SendToUser", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var env struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(args.Get(1).([]byte), &env)
+		if env.Type == protocol.EventBadgeUpdate {
+			badges[args.Get(0).(uuid.UUID)]++
+		}
+	}).Return()
+
+	_, err := svc.SendGroupMessage(ctx, senderID, groupID, "hi")
+
+	assert.NoError(t, err)
+	mockConvRepo.AssertExpectations(t)
+	assert.Equal(t, map[uuid.UUID]int{alice: 1}, badges)
+}
+
+// newMessageFrames records the new_message payloads each user receives, and
+// how many badge_update events.
+type newMessageFrames struct {
+	messages map[uuid.UUID][]protocol.MessagePayload
+	badges   map[uuid.UUID]int
+}
+
+func recordFrames(mockHub *MockHub) *newMessageFrames {
+	frames := &newMessageFrames{messages: map[uuid.UUID][]protocol.MessagePayload{}, badges: map[uuid.UUID]int{}}
+	mockHub.On("SendToUser", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		userID := args.Get(0).(uuid.UUID)
+		var env struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		json.Unmarshal(args.Get(1).([]byte), &env)
+		switch env.Type {
+		case protocol.EventNewMessage:
+			var payload protocol.MessagePayload
+			json.Unmarshal(env.Payload, &payload)
+			frames.messages[userID] = append(frames.messages[userID], payload)
+		case protocol.EventBadgeUpdate:
+			frames.badges[userID]++
+		}
+	}).Return()
+	return frames
+}
+
+func TestSendDirectMessage_MarkedMutedForReceiverWhoMutedIt(t *testing.T) {
+	ctx := context.Background()
+	mockMsgRepo, mockConvRepo, mockReceiptRepo, mockUserRepo, mockHub := new(MockMessageRepo), new(MockConversationRepo), new(MockMessageReceiptRepo), new(MockUserRepo), new(MockHub)
+	svc := service.NewMessageService(mockMsgRepo, mockConvRepo, new(MockGroupRepo), mockReceiptRepo, mockUserRepo, mockHub)
+
+	senderID, receiverID := uuid.New(), uuid.New()
+	mockUserRepo.On("FindByID", ctx, mock.Anything).Return(&models.User{}, nil)
+	mockMsgRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockReceiptRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockConvRepo.On("Upsert", ctx, mock.Anything).Return(nil)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "DM", senderID, []uuid.UUID{receiverID}).Return([]uuid.UUID{receiverID}, nil)
+	mockHub.On("IsUserViewingConversation", "DM", senderID).Return(false)
+	mockConvRepo.On("IncrementUnread", ctx, receiverID, "DM", senderID, "Hello", false).Return(nil)
+	frames := recordFrames(mockHub)
+
+	_, err := svc.SendDirectMessage(ctx, senderID, receiverID, "Hello")
+
+	assert.NoError(t, err)
+	if assert.Len(t, frames.messages[receiverID], 1) {
+		assert.True(t, frames.messages[receiverID][0].Muted)
+	}
+	if assert.Len(t, frames.messages[senderID], 1) {
+		assert.False(t, frames.messages[senderID][0].Muted)
+	}
+	assert.Empty(t, frames.badges, "the muted conversation doesn't change the badge")
+	mockConvRepo.AssertNotCalled(t, "FindUnreadCounts", mock.Anything, mock.Anything)
+}
+
+func TestSendGroupMessage_MarkedMutedForMembersWhoMutedIt(t *testing.T) {
+	ctx := context.Background()
+	mockMsgRepo, mockConvRepo, mockGroupRepo, mockReceiptRepo, mockUserRepo, mockHub := new(MockMessageRepo), new(MockConversationRepo), new(MockGroupRepo), new(MockMessageReceiptRepo), new(MockUserRepo), new(MockHub)
+	svc := service.NewMessageService(mockMsgRepo, mockConvRepo, mockGroupRepo, mockReceiptRepo, mockUserRepo, mockHub)
+
+	senderID, groupID, alice, bob := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	mockGroupRepo.On("IsMember", ctx, groupID, senderID).Return(true, nil)
+	mockMsgRepo.On("Create", ctx, mock.Anything).Return(nil)
+	mockUserRepo.On("FindByID", ctx, senderID).Return(&models.User{BaseModel: models.BaseModel{ID: senderID}}, nil)
+	mockReceiptRepo.On("CreateBatch", ctx, mock.Anything).Return(nil)
+	mockGroupRepo.On("GetMembers", ctx, groupID).Return([]models.GroupMember{
+		{GroupID: groupID, UserID: senderID},
+		{GroupID: groupID, UserID: alice},
+		{GroupID: groupID, UserID: bob},
+	}, nil)
+	mockHub.On("IsUserViewingConversation", "GROUP", groupID).Return(false)
+	mockConvRepo.On("Upsert", ctx, mock.Anything).Return(nil)
+	mockConvRepo.On("IncrementUnread", ctx, mock.Anything, "GROUP", groupID, "hi", false).Return(nil)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "GROUP", groupID, []uuid.UUID{alice, bob}).Return([]uuid.UUID{bob}, nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{alice}).Return([]models.Conversation{}, nil)
+	frames := recordFrames(mockHub)
+
+	_, err := svc.SendGroupMessage(ctx, senderID, groupID, "hi")
+
+	assert.NoError(t, err)
+	for userID, muted := range map[uuid.UUID]bool{senderID: false, alice: false, bob: true} {
+		if assert.Len(t, frames.messages[userID], 1) {
+			assert.Equal(t, muted, frames.messages[userID][0].Muted)
+		}
+	}
+	assert.Equal(t, map[uuid.UUID]int{alice: 1}, frames.badges)
+}
+
+func TestGetUnreadCounts_SumsConversations(t *testing.T) {
+	ctx := context.Background()
+	mockConvRepo := new(MockConversationRepo)
+	svc := service.NewMessageService(new(MockMessageRepo), mockConvRepo, new(MockGroupRepo), new(MockMessageReceiptRepo), new(MockUserRepo), new(MockHub))
+
+	userID, dmTarget, groupID := uuid.New(), uuid.New(), uuid.New()
+	mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{userID}).Return([]models.Conversation{
+		{Type: "DM", TargetID: dmTarget, UnreadCount: 2},
+		{Type: "GROUP", TargetID: groupID, UnreadCount: 5, MentionCount: 1},
+	}, nil)
+
+	badge, err := svc.GetUnreadCounts(ctx, userID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 7, badge.TotalUnread)
+	assert.Equal(t, 1, badge.TotalMentions)
+	assert.Equal(t, []protocol.ConversationBadge{
+		{ConversationType: "DM", TargetID: dmTarget, UnreadCount: 2},
+		{ConversationType: "GROUP", TargetID: groupID, UnreadCount: 5, MentionCount: 1},
+	}, badge.Conversations)
+}
+
+func TestMarkConversationRead_PushesBadgeOnlyWhenChanged(t *testing.T) {
+	ctx := context.Background()
+	mockConvRepo := new(MockConversationRepo)
+	mockHub := new(MockHub)
+	svc := service.NewMessageService(new(MockMessageRepo), mockConvRepo, new(MockGroupRepo), new(MockMessageReceiptRepo), new(MockUserRepo), mockHub)
+
+	userID, unread, alreadyRead := uuid.New(), uuid.New(), uuid.New()
+	mockConvRepo.On("ResetUnread", ctx, userID, "DM", unread).Return(true, nil)
+	mockConvRepo.On("ResetUnread", ctx, userID, "DM", alreadyRead).Return(false, nil)
+	mockConvRepo.On("FindUnreadCounts", ctx, []uuid.UUID{userID}).Return([]models.Conversation{}, nil)
+	mockHub.On("SendToUser", userID, mock.MatchedBy(func(payload []byte) bool {
+		var msg map[string]interface{}
+		json.Unmarshal(payload, &msg)
+		return msg["type"] == "badge_update"
+	})).Return()
+
+	assert.NoError(t, svc.MarkConversationRead(ctx, userID, "DM", unread))
+	assert.NoError(t, svc.MarkConversationRead(ctx, userID, "DM", alreadyRead))
+
+	mockHub.AssertNumberOfCalls(t, "SendToUser", 1)
+}
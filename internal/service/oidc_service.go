@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/pkg/oidc"
+)
+
+// DefaultOIDCLoginTTL is how long a user may take to sign in at the identity provider.
+const DefaultOIDCLoginTTL = 10 * time.Minute
+
+// ExternalIdentity is a user as asserted by an external identity provider.
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type oidcLogin struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// oidcService keeps pending logins in memory, keyed by their state. They only
+// need to survive the round trip through the identity provider.
+type oidcService struct {
+	provider    *oidc.Provider
+	authService AuthService
+	ttl         time.Duration
+
+	mu     sync.Mutex
+	logins map[string]oidcLogin
+}
+
+func NewOIDCService(provider *oidc.Provider, authService AuthService, ttl time.Duration) OIDCService {
+	return &oidcService{
+		provider:    provider,
+		authService: authService,
+		ttl:         ttl,
+		logins:      make(map[string]oidcLogin),
+	}
+}
+
+// Begin starts a login, returning the provider URL to redirect to and its state.
+func (s *oidcService) Begin() (string, string, error) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop logins that were never completed
+	now := time.Now()
+	for key, l := range s.logins {
+		if now.After(l.expiresAt) {
+			delete(s.logins, key)
+		}
+	}
+	s.logins[hashToken(state)] = oidcLogin{nonce: nonce, verifier: verifier, expiresAt: now.Add(s.ttl)}
+
+	return s.provider.AuthCodeURL(state, nonce, verifier), state, nil
+}
+
+// Complete redeems the code the provider redirected back with and signs the
+// user in like Login does. Each state can only be completed once.
+func (s *oidcService) Complete(ctx context.Context, state, code string) (string, string, *models.User, error) {
+	key := hashToken(state)
+
+	s.mu.Lock()
+	l, ok := s.logins[key]
+	delete(s.logins, key)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(l.expiresAt) {
+		return "", "", nil, apperrors.ErrUnauthorized
+	}
+
+	claims, err := s.provider.Exchange(ctx, code, l.verifier, l.nonce)
+	if err != nil {
+		return "", "", nil, apperrors.ErrUnauthorized
+	}
+
+	return s.authService.LoginExternal(ctx, ExternalIdentity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	})
+}
@@ -0,0 +1,253 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/service"
+	"chat-app/pkg/jwt"
+	"chat-app/pkg/oidc"
+	"chat-app/pkg/oidc/oidctest"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockUserIdentityRepo
+type MockUserIdentityRepo struct {
+	mock.Mock
+}
+
+func (m *MockUserIdentityRepo) Create(ctx context.Context, identity *models.UserIdentity) error {
+	args := m.Called(ctx, identity)
+	return args.Error(0)
+}
+
+func (m *MockUserIdentityRepo) FindBySubject(ctx context.Context, issuer, subject string) (*models.UserIdentity, error) {
+	args := m.Called(ctx, issuer, subject)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.UserIdentity), args.Error(1)
+}
+
+func setupExternalLogin() (service.AuthService, *MockUserRepo, *MockUserIdentityRepo, *MockRefreshTokenRepo) {
+	userRepo := new(MockUserRepo)
+	identityRepo := new(MockUserIdentityRepo)
+	tokenRepo := new(MockRefreshTokenRepo)
+	tokenRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	svc := service.NewAuthService(userRepo, tokenRepo, jwtService, new(MockDisconnector), service.WithIdentities(identityRepo))
+	return svc, userRepo, identityRepo, tokenRepo
+}
+
+// signInAtProvider follows the provider's redirect, returning the callback's state and code.
+func signInAtProvider(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return loc.Query().Get("state"), loc.Query().Get("code")
+}
+
+func TestOIDCService_ProvisionsThenSignsInLinkedUser(t *testing.T) {
+	idp := oidctest.NewProvider("chat-app", "s3cret")
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "42", Email: "Alice.Smith@example.com", EmailVerified: true, Name: "Alice"})
+
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		IssuerURL:    idp.Issuer(),
+		ClientID:     "chat-app",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost:8080/auth/oidc/callback",
+	}, nil)
+	require.NoError(t, err)
+	authService, userRepo, identityRepo, _ := setupExternalLogin()
+	svc := service.NewOIDCService(provider, authService, time.Minute)
+	ctx := context.Background()
+
+	// 1. First login provisions a verified, password-less user and links it
+	identityRepo.On("FindBySubject", ctx, idp.Issuer(), "42").Return(nil, nil).Once()
+	userRepo.On("FindByEmail", ctx, "Alice.Smith@example.com").Return(nil, nil).Once()
+	var created *models.User
+	userRepo.On("Create", ctx, mock.AnythingOfType("*models.User")).Run(func(args mock.Arguments) {
+		created = args.Get(1).(*models.User)
+		created.ID = uuid.New()
+	}).Return(nil).Once()
+	identityRepo.On("Create", ctx, mock.MatchedBy(func(i *models.UserIdentity) bool {
+		return i.Issuer == idp.Issuer() && i.Subject == "42"
+	})).Return(nil).Once()
+
+	authURL, state, err := svc.Begin()
+	require.NoError(t, err)
+	gotState, code := signInAtProvider(t, authURL)
+	assert.Equal(t, state, gotState)
+
+	accessToken, refreshToken, user, err := svc.Complete(ctx, state, code)
+	require.NoError(t, err)
+	assert.NotEmpty(t, accessToken)
+	assert.NotEmpty(t, refreshToken)
+	assert.Equal(t, "alice.smith", user.Username)
+	assert.NotNil(t, user.EmailVerifiedAt)
+	assert.Equal(t, "!", user.Password)
+
+	// 2. The state is single-use
+	_, _, _, err = svc.Complete(ctx, state, code)
+	assert.ErrorIs(t, err, apperrors.ErrUnauthorized)
+
+	// 3. Later logins go through the link, even if the email changed
+	idp.SetUser(oidctest.User{Subject: "42", Email: "alice@new.example.com", EmailVerified: true})
+	identityRepo.On("FindBySubject", ctx, idp.Issuer(), "42").
+		Return(&models.UserIdentity{UserID: created.ID, Issuer: idp.Issuer(), Subject: "42"}, nil).Once()
+	userRepo.On("FindByID", ctx, created.ID).Return(created, nil).Once()
+
+	authURL, state, err = svc.Begin()
+	require.NoError(t, err)
+	_, code = signInAtProvider(t, authURL)
+	_, _, user, err = svc.Complete(ctx, state, code)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, user.ID)
+
+	userRepo.AssertExpectations(t)
+	identityRepo.AssertExpectations(t)
+}
+
+func TestAuthService_LoginExternal_LinksAccountWithSameEmail(t *testing.T) {
+	svc, userRepo, identityRepo, _ := setupExternalLogin()
+	ctx := context.Background()
+	existing := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Username: "bob", Email: "bob@example.com"}
+
+	identityRepo.On("FindBySubject", ctx, "https://idp", "bob-1").Return(nil, nil)
+	userRepo.On("FindByEmail", ctx, existing.Email).Return(existing, nil)
+	userRepo.On("MarkEmailVerified", ctx, existing.ID, mock.AnythingOfType("time.Time")).Return(nil)
+	identityRepo.On("Create", ctx, mock.MatchedBy(func(i *models.UserIdentity) bool { return i.UserID == existing.ID })).Return(nil)
+
+	_, _, user, err := svc.LoginExternal(ctx, service.ExternalIdentity{Issuer: "https://idp", Subject: "bob-1", Email: existing.Email, EmailVerified: true})
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, user.ID)
+	assert.NotNil(t, user.EmailVerifiedAt)
+	userRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestAuthService_LoginExternal_RequiresVerifiedEmail(t *testing.T) {
+	svc, userRepo, identityRepo, _ := setupExternalLogin()
+	ctx := context.Background()
+	identityRepo.On("FindBySubject", ctx, "https://idp", "eve").Return(nil, nil)
+
+	// An unverified email must not take over the account registered with it
+	_, _, _, err := svc.LoginExternal(ctx, service.ExternalIdentity{Issuer: "https://idp", Subject: "eve", Email: "victim@example.com"})
+	assert.ErrorIs(t, err, apperrors.ErrSSOEmailUnverified)
+	userRepo.AssertNotCalled(t, "FindByEmail", mock.Anything, mock.Anything)
+}
+
+func TestAuthService_LoginExternal_RetriesTakenUsername(t *testing.T) {
+	svc, userRepo, identityRepo, _ := setupExternalLogin()
+	ctx := context.Background()
+	identityRepo.On("FindBySubject", ctx, "https://idp", "c-1").Return(nil, nil)
+	identityRepo.On("Create", ctx, mock.Anything).Return(nil)
+	userRepo.On("FindByEmail", ctx, "carol@example.com").Return(nil, nil)
+	userRepo.On("Create", ctx, mock.MatchedBy(func(u *models.User) bool { return u.Username == "carol" })).
+		Return(errors.New("duplicate key value violates unique constraint")).Once()
+	userRepo.On("Create", ctx, mock.MatchedBy(func(u *models.User) bool { return u.Username != "carol" })).Return(nil).Once()
+
+	_, _, user, err := svc.LoginExternal(ctx, service.ExternalIdentity{Issuer: "https://idp", Subject: "c-1", Email: "carol@example.com", EmailVerified: true})
+	require.NoError(t, err)
+	assert.Regexp(t, `^carol-[0-9a-f]{6}$`, user.Username)
+}
+
+func TestAuthService_LoginExternal_DeletedAccount(t *testing.T) {
+	svc, userRepo, identityRepo, _ := setupExternalLogin()
+	ctx := context.Background()
+	deletedAt := time.Now()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, AccountDeletedAt: &deletedAt}
+	identityRepo.On("FindBySubject", ctx, "https://idp", "d-1").Return(&models.UserIdentity{UserID: user.ID}, nil)
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+
+	_, _, _, err := svc.LoginExternal(ctx, service.ExternalIdentity{Issuer: "https://idp", Subject: "d-1", Email: "d@example.com", EmailVerified: true})
+	assert.ErrorIs(t, err, apperrors.ErrUnauthorized)
+}
+
+func TestAuthService_LoginExternal_RequiresSecondFactor(t *testing.T) {
+	svc, userRepo, identityRepo, tokenRepo := setupExternalLogin()
+	ctx := context.Background()
+	enabledAt, verifiedAt := time.Now(), time.Now()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Email: "tf@example.com", EmailVerifiedAt: &verifiedAt, TwoFactorEnabledAt: &enabledAt}
+
+	// Through an existing link
+	identityRepo.On("FindBySubject", ctx, "https://idp", "tf-1").Return(&models.UserIdentity{UserID: user.ID}, nil)
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+	_, _, _, err := svc.LoginExternal(ctx, service.ExternalIdentity{Issuer: "https://idp", Subject: "tf-1", Email: user.Email, EmailVerified: true})
+	var challenge *service.TwoFactorRequiredError
+	require.ErrorAs(t, err, &challenge)
+	assert.NotEmpty(t, challenge.ChallengeToken)
+
+	// And when a new identity is linked to the account
+	identityRepo.On("FindBySubject", ctx, "https://other-idp", "tf-2").Return(nil, nil)
+	userRepo.On("FindByEmail", ctx, user.Email).Return(user, nil)
+	identityRepo.On("Create", ctx, mock.Anything).Return(nil)
+	_, _, _, err = svc.LoginExternal(ctx, service.ExternalIdentity{Issuer: "https://other-idp", Subject: "tf-2", Email: user.Email, EmailVerified: true})
+	require.ErrorAs(t, err, &challenge)
+
+	tokenRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestAuthService_ProvisionedUserDeletesAccountAfterPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	userRepo, identityRepo, tokenRepo, accountTokens := new(MockUserRepo), new(MockUserIdentityRepo), new(MockRefreshTokenRepo), new(MockAccountTokenRepo)
+	disconnector := new(MockDisconnector)
+	jwtService := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Minute})
+	authService := service.NewAuthService(userRepo, tokenRepo, jwtService, disconnector, service.WithIdentities(identityRepo))
+	mail := &fakeMailer{}
+	accountService := service.NewAccountService(userRepo, accountTokens, mail, authService, service.AccountConfig{BaseURL: "https://chat.example.com"})
+
+	// 1. Signing in with the provider creates a user without a password
+	var user *models.User
+	identityRepo.On("FindBySubject", ctx, "https://idp", "f-1").Return(nil, nil)
+	identityRepo.On("Create", ctx, mock.Anything).Return(nil)
+	userRepo.On("FindByEmail", ctx, "frank@example.com").Return(nil, nil).Once()
+	userRepo.On("Create", ctx, mock.AnythingOfType("*models.User")).Run(func(args mock.Arguments) {
+		user = args.Get(1).(*models.User)
+		user.ID = uuid.New()
+	}).Return(nil)
+	tokenRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	_, _, _, err := authService.LoginExternal(ctx, service.ExternalIdentity{Issuer: "https://idp", Subject: "f-1", Email: "frank@example.com", EmailVerified: true})
+	require.NoError(t, err)
+
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+	assert.ErrorIs(t, authService.DeleteAccount(ctx, user.ID, "!"), apperrors.ErrIncorrectPassword)
+
+	// 2. The password reset flow sets one
+	userRepo.On("FindByEmail", ctx, "frank@example.com").Return(user, nil)
+	accountTokens.On("InvalidateByUser", ctx, user.ID, models.AccountTokenResetPassword).Return(nil)
+	accountTokens.On("Create", ctx, mock.Anything).Return(nil)
+	require.NoError(t, accountService.ForgotPassword(ctx, "frank@example.com"))
+	require.Len(t, mail.sent, 1)
+
+	accountTokens.On("Consume", ctx, mock.Anything, models.AccountTokenResetPassword).
+		Return(&models.AccountToken{UserID: user.ID, Purpose: models.AccountTokenResetPassword}, nil)
+	userRepo.On("UpdatePassword", ctx, user.ID, mock.AnythingOfType("string")).Run(func(args mock.Arguments) {
+		user.Password = args.String(2)
+	}).Return(nil)
+	userRepo.On("MarkEmailVerified", ctx, user.ID, mock.Anything).Return(nil)
+	tokenRepo.On("FindActiveByUser", ctx, user.ID).Return([]models.RefreshToken{}, nil)
+	tokenRepo.On("RevokeByUser", ctx, user.ID).Return(nil)
+	disconnector.On("DisconnectUser", user.ID).Return()
+	require.NoError(t, accountService.ResetPassword(ctx, linkToken(t, mail.sent[0]), "new-password"))
+
+	// 3. With it, the account can be deleted
+	userRepo.On("DeleteAccount", ctx, user.ID, mock.Anything).Return(nil)
+	require.NoError(t, authService.DeleteAccount(ctx, user.ID, "new-password"))
+	userRepo.AssertCalled(t, "DeleteAccount", ctx, user.ID, mock.Anything)
+}
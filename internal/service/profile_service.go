@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"chat-app/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// avatarExtensions maps the accepted avatar image types to file extensions.
+var avatarExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// ProfileUpdate changes the public profile. Nil fields are left as they are.
+type ProfileUpdate struct {
+	DisplayName *string
+	Bio         *string
+	StatusText  *string
+	// StatusExpiresAt is only read along with StatusText. Nil keeps the status until changed.
+	StatusExpiresAt *time.Time
+}
+
+// PrivacySettings changes who sees the email, last seen time and online status
+// (models.Visibility*), and who may send direct messages (everyone or contacts).
+// Nil fields are left as they are.
+type PrivacySettings struct {
+	EmailVisibility    *string
+	LastSeenVisibility *string
+	OnlineVisibility   *string
+	DMPolicy           *string
+}
+
+// ProfileNotifier tells a user's contacts that their profile changed.
+// Implemented by websocket.Hub.
+type ProfileNotifier interface {
+	BroadcastProfileUpdate(user *models.User)
+}
+
+type profileService struct {
+	userRepo    repository.UserRepository
+	contactRepo repository.ContactRepository
+	avatars     storage.Store
+	notifier    ProfileNotifier
+}
+
+func NewProfileService(userRepo repository.UserRepository, contactRepo repository.ContactRepository, avatars storage.Store, notifier ProfileNotifier) ProfileService {
+	return &profileService{
+		userRepo:    userRepo,
+		contactRepo: contactRepo,
+		avatars:     avatars,
+		notifier:    notifier,
+	}
+}
+
+func (s *profileService) GetProfile(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, apperrors.ErrNotFound
+	}
+	return user, nil
+}
+
+// GetPublicProfile returns a user as viewerID sees them, within their privacy settings.
+func (s *profileService) GetPublicProfile(ctx context.Context, viewerID, userID uuid.UUID) (*models.PublicUser, error) {
+	user, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	isContact, err := s.contactRepo.AreContacts(ctx, viewerID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	public := user.PublicView(isContact)
+	return &public, nil
+}
+
+// SearchUsers finds other users by username or display name, or by exact email
+// if its owner made it public. Results are shown as viewerID sees them.
+func (s *profileService) SearchUsers(ctx context.Context, viewerID uuid.UUID, query string) ([]models.PublicUser, error) {
+	users, err := s.userRepo.Search(ctx, query, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := s.contactRepo.FindContactIDs(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]models.PublicUser, 0, len(users))
+	for i := range users {
+		results = append(results, users[i].PublicView(containsID(contacts, users[i].ID)))
+	}
+	return results, nil
+}
+
+func (s *profileService) UpdatePrivacy(ctx context.Context, userID uuid.UUID, settings PrivacySettings) (*models.User, error) {
+	user, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range []struct {
+		value *string
+		field *string
+	}{
+		{settings.EmailVisibility, &user.EmailVisibility},
+		{settings.LastSeenVisibility, &user.LastSeenVisibility},
+		{settings.OnlineVisibility, &user.OnlineVisibility},
+	} {
+		if change.value == nil {
+			continue
+		}
+		switch *change.value {
+		case models.VisibilityEveryone, models.VisibilityContacts, models.VisibilityNobody:
+			*change.field = *change.value
+		default:
+			return nil, apperrors.ErrValidation
+		}
+	}
+	if settings.DMPolicy != nil {
+		switch *settings.DMPolicy {
+		case models.VisibilityEveryone, models.VisibilityContacts:
+			user.DMPolicy = *settings.DMPolicy
+		default:
+			return nil, apperrors.ErrValidation
+		}
+	}
+
+	if err := s.userRepo.UpdatePrivacy(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *profileService) UpdateProfile(ctx context.Context, userID uuid.UUID, update ProfileUpdate) (*models.User, error) {
+	user, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. Apply the changes
+	if update.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*update.DisplayName)
+	}
+	if update.Bio != nil {
+		user.Bio = strings.TrimSpace(*update.Bio)
+	}
+	if update.StatusText != nil {
+		user.StatusText = strings.TrimSpace(*update.StatusText)
+		user.StatusExpiresAt = update.StatusExpiresAt
+		if user.StatusText == "" {
+			user.StatusExpiresAt = nil
+		} else if user.StatusExpiresAt != nil && !user.StatusExpiresAt.After(time.Now()) {
+			return nil, apperrors.ErrValidation
+		}
+	}
+
+	// 2. Save and tell the contacts
+	if err := s.userRepo.UpdateProfile(ctx, user); err != nil {
+		return nil, err
+	}
+	s.notifier.BroadcastProfileUpdate(user)
+
+	return user, nil
+}
+
+// SetAvatar replaces the avatar with an image. The type is detected from the
+// data; each upload gets a new URL so clients never show a stale cached image.
+func (s *profileService) SetAvatar(ctx context.Context, userID uuid.UUID, image []byte) (*models.User, error) {
+	ext, ok := avatarExtensions[http.DetectContentType(image)]
+	if !ok {
+		return nil, apperrors.ErrInvalidAvatar
+	}
+
+	user, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. Store the new image
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	url, err := s.avatars.Put(ctx, userID.String()+"-"+hex.EncodeToString(b)+ext, image)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Point the profile at it
+	previous := user.AvatarURL
+	user.AvatarURL = url
+	if err := s.userRepo.UpdateProfile(ctx, user); err != nil {
+		s.deleteAvatar(ctx, url)
+		return nil, err
+	}
+	s.deleteAvatar(ctx, previous)
+	s.notifier.BroadcastProfileUpdate(user)
+
+	return user, nil
+}
+
+func (s *profileService) RemoveAvatar(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	user, err := s.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.AvatarURL == "" {
+		return user, nil
+	}
+
+	previous := user.AvatarURL
+	user.AvatarURL = ""
+	if err := s.userRepo.UpdateProfile(ctx, user); err != nil {
+		return nil, err
+	}
+	s.deleteAvatar(ctx, previous)
+	s.notifier.BroadcastProfileUpdate(user)
+
+	return user, nil
+}
+
+// deleteAvatar removes an image that is no longer used. A leftover file is harmless, so failures are only logged.
+func (s *profileService) deleteAvatar(ctx context.Context, url string) {
+	if url == "" {
+		return
+	}
+	if err := s.avatars.Delete(ctx, url); err != nil {
+		log.Printf("Failed to delete avatar %s: %v", url, err)
+	}
+}
+
+func containsID(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
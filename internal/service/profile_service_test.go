@@ -0,0 +1,199 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockAvatarStore
+type MockAvatarStore struct {
+	mock.Mock
+}
+
+func (m *MockAvatarStore) Put(ctx context.Context, name string, data []byte) (string, error) {
+	args := m.Called(ctx, name, data)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockAvatarStore) Delete(ctx context.Context, url string) error {
+	args := m.Called(ctx, url)
+	return args.Error(0)
+}
+
+// MockProfileNotifier
+type MockProfileNotifier struct {
+	mock.Mock
+}
+
+func (m *MockProfileNotifier) BroadcastProfileUpdate(user *models.User) {
+	m.Called(user)
+}
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func setupProfileService() (service.ProfileService, *MockUserRepo, *MockAvatarStore, *MockProfileNotifier) {
+	svc, userRepo, _, avatars, notifier := setupProfileServiceWithContacts()
+	return svc, userRepo, avatars, notifier
+}
+
+func setupProfileServiceWithContacts() (service.ProfileService, *MockUserRepo, *MockContactRepo, *MockAvatarStore, *MockProfileNotifier) {
+	userRepo := new(MockUserRepo)
+	contactRepo := new(MockContactRepo)
+	avatars := new(MockAvatarStore)
+	notifier := new(MockProfileNotifier)
+	return service.NewProfileService(userRepo, contactRepo, avatars, notifier), userRepo, contactRepo, avatars, notifier
+}
+
+func TestProfileService_UpdateProfile(t *testing.T) {
+	svc, userRepo, _, notifier := setupProfileService()
+	ctx := context.Background()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Username: "alice", Bio: "Old bio"}
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+	userRepo.On("UpdateProfile", ctx, user).Return(nil)
+	notifier.On("BroadcastProfileUpdate", user).Return()
+
+	name, status := "  Alice  ", "On holiday"
+	until := time.Now().Add(24 * time.Hour)
+	updated, err := svc.UpdateProfile(ctx, user.ID, service.ProfileUpdate{DisplayName: &name, StatusText: &status, StatusExpiresAt: &until})
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", updated.DisplayName)
+	assert.Equal(t, "Old bio", updated.Bio, "absent fields are kept")
+	assert.Equal(t, "On holiday", updated.StatusText)
+	assert.Equal(t, &until, updated.StatusExpiresAt)
+	notifier.AssertCalled(t, "BroadcastProfileUpdate", user)
+
+	// Clearing the status drops its expiry
+	cleared := ""
+	updated, err = svc.UpdateProfile(ctx, user.ID, service.ProfileUpdate{StatusText: &cleared, StatusExpiresAt: &until})
+	require.NoError(t, err)
+	assert.Empty(t, updated.StatusText)
+	assert.Nil(t, updated.StatusExpiresAt)
+}
+
+func TestProfileService_UpdateProfile_StatusExpiryMustBeInFuture(t *testing.T) {
+	svc, userRepo, _, notifier := setupProfileService()
+	ctx := context.Background()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}}
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+
+	status, past := "Busy", time.Now().Add(-time.Minute)
+	_, err := svc.UpdateProfile(ctx, user.ID, service.ProfileUpdate{StatusText: &status, StatusExpiresAt: &past})
+	assert.ErrorIs(t, err, apperrors.ErrValidation)
+	userRepo.AssertNotCalled(t, "UpdateProfile", mock.Anything, mock.Anything)
+	notifier.AssertNotCalled(t, "BroadcastProfileUpdate", mock.Anything)
+}
+
+func TestProfileService_SetAvatar_ReplacesPreviousImage(t *testing.T) {
+	svc, userRepo, avatars, notifier := setupProfileService()
+	ctx := context.Background()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, AvatarURL: "/avatars/old.png"}
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+	userRepo.On("UpdateProfile", ctx, user).Return(nil)
+	notifier.On("BroadcastProfileUpdate", user).Return()
+
+	var name string
+	avatars.On("Put", ctx, mock.AnythingOfType("string"), pngHeader).Run(func(args mock.Arguments) {
+		name = args.String(1)
+	}).Return("/avatars/new.png", nil)
+	avatars.On("Delete", ctx, "/avatars/old.png").Return(nil)
+
+	updated, err := svc.SetAvatar(ctx, user.ID, pngHeader)
+	require.NoError(t, err)
+	assert.Equal(t, "/avatars/new.png", updated.AvatarURL)
+	assert.Regexp(t, "^"+user.ID.String()+"-[0-9a-f]{16}\\.png$", name)
+	avatars.AssertExpectations(t)
+}
+
+func TestProfileService_SetAvatar_RejectsNonImages(t *testing.T) {
+	svc, _, avatars, _ := setupProfileService()
+
+	_, err := svc.SetAvatar(context.Background(), uuid.New(), []byte("<svg onload=alert(1)></svg>"))
+	assert.ErrorIs(t, err, apperrors.ErrInvalidAvatar)
+	avatars.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProfileService_GetPublicProfile_AppliesPrivacy(t *testing.T) {
+	svc, userRepo, contactRepo, _, _ := setupProfileServiceWithContacts()
+	ctx := context.Background()
+	contact, stranger := uuid.New(), uuid.New()
+	user := &models.User{
+		BaseModel:          models.BaseModel{ID: uuid.New()},
+		Username:           "alice",
+		Email:              "alice@example.com",
+		IsOnline:           true,
+		LastSeen:           time.Now(),
+		EmailVisibility:    models.VisibilityContacts,
+		LastSeenVisibility: models.VisibilityNobody,
+		OnlineVisibility:   models.VisibilityEveryone,
+	}
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+	contactRepo.On("AreContacts", ctx, contact, user.ID).Return(true, nil)
+	contactRepo.On("AreContacts", ctx, stranger, user.ID).Return(false, nil)
+
+	seen, err := svc.GetPublicProfile(ctx, contact, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", seen.Email)
+	require.NotNil(t, seen.IsOnline)
+	assert.True(t, *seen.IsOnline)
+	assert.Nil(t, seen.LastSeen)
+
+	seen, err = svc.GetPublicProfile(ctx, stranger, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, seen.Email)
+	assert.NotNil(t, seen.IsOnline)
+	assert.Nil(t, seen.LastSeen)
+}
+
+func TestProfileService_SearchUsers_HidesEmails(t *testing.T) {
+	svc, userRepo, contactRepo, _, _ := setupProfileServiceWithContacts()
+	ctx := context.Background()
+	viewer := uuid.New()
+	contact := models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Username: "bob", Email: "bob@example.com"}
+	stranger := models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Username: "bobby", Email: "bobby@example.com"}
+	userRepo.On("Search", ctx, "bob", viewer).Return([]models.User{contact, stranger}, nil)
+	contactRepo.On("FindContactIDs", ctx, viewer).Return([]uuid.UUID{contact.ID}, nil)
+
+	results, err := svc.SearchUsers(ctx, viewer, "bob")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "bob@example.com", results[0].Email, "emails default to contacts only")
+	assert.Empty(t, results[1].Email)
+}
+
+func TestProfileService_UpdatePrivacy(t *testing.T) {
+	svc, userRepo, _, _ := setupProfileService()
+	ctx := context.Background()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}}
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+	userRepo.On("UpdatePrivacy", ctx, user).Return(nil)
+
+	invalid := "friends"
+	_, err := svc.UpdatePrivacy(ctx, user.ID, service.PrivacySettings{OnlineVisibility: &invalid})
+	assert.ErrorIs(t, err, apperrors.ErrValidation)
+
+	nobody := models.VisibilityNobody
+	updated, err := svc.UpdatePrivacy(ctx, user.ID, service.PrivacySettings{OnlineVisibility: &nobody})
+	require.NoError(t, err)
+	assert.Equal(t, models.VisibilityNobody, updated.OnlineVisibility)
+	assert.False(t, updated.ShowsOnlineTo(true))
+
+	contactsOnly := models.VisibilityContacts
+	updated, err = svc.UpdatePrivacy(ctx, user.ID, service.PrivacySettings{DMPolicy: &contactsOnly})
+	require.NoError(t, err)
+	assert.False(t, updated.AcceptsDMsFrom(false))
+	assert.True(t, updated.AcceptsDMsFrom(true))
+
+	_, err = svc.UpdatePrivacy(ctx, user.ID, service.PrivacySettings{DMPolicy: &nobody})
+	assert.ErrorIs(t, err, apperrors.ErrValidation, "DMs can't be closed to everyone")
+	userRepo.AssertNumberOfCalls(t, "UpdatePrivacy", 2)
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// ClientInfo describes the device a request came from. It is recorded on the
+// refresh tokens issued while handling the request.
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo attaches the requesting device to ctx.
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+func clientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
+
+// Session is a signed-in device, i.e. a refresh token family.
+// Its ID stays the same across token rotations.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // The session making the request
+}
+
+// ListSessions returns the user's active sessions, flagging the one currentRefreshToken belongs to.
+func (s *authService) ListSessions(ctx context.Context, userID uuid.UUID, currentRefreshToken string) ([]Session, error) {
+	tokens, err := s.refreshTokenRepo.FindActiveByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	currentID := s.sessionIDOf(ctx, userID, currentRefreshToken)
+	sessions := make([]Session, 0, len(tokens))
+	for _, t := range tokens {
+		id := sessionIDOfToken(&t)
+		sessions = append(sessions, Session{
+			ID:         id,
+			IPAddress:  t.IPAddress,
+			UserAgent:  t.UserAgent,
+			LastUsedAt: t.LastUsedAt,
+			ExpiresAt:  t.ExpiresAt,
+			Current:    id == currentID,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs one of the user's devices out.
+func (s *authService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	tokens, err := s.refreshTokenRepo.FindActiveByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if sessionIDOfToken(&t) == sessionID {
+			if err := s.refreshTokenRepo.RevokeFamily(ctx, sessionID); err != nil {
+				return err
+			}
+			s.endSession(sessionID)
+			return nil
+		}
+	}
+	return apperrors.ErrSessionNotFound
+}
+
+// RevokeOtherSessions signs out every device of the user except the one currentRefreshToken belongs to.
+func (s *authService) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, currentRefreshToken string) error {
+	currentID := s.sessionIDOf(ctx, userID, currentRefreshToken)
+	if currentID == uuid.Nil {
+		return apperrors.ErrSessionNotFound
+	}
+	tokens, err := s.refreshTokenRepo.FindActiveByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.refreshTokenRepo.RevokeOtherFamilies(ctx, userID, currentID); err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if id := sessionIDOfToken(&t); id != currentID {
+			s.endSession(id)
+		}
+	}
+	return nil
+}
+
+// sessionIDOf resolves a raw refresh token of the user to its session, or uuid.Nil.
+func (s *authService) sessionIDOf(ctx context.Context, userID uuid.UUID, refreshToken string) uuid.UUID {
+	if refreshToken == "" {
+		return uuid.Nil
+	}
+	token, err := s.refreshTokenRepo.GetByHash(ctx, hashToken(refreshToken))
+	if err != nil || token.Revoked || token.UserID != userID {
+		return uuid.Nil
+	}
+	return sessionIDOfToken(token)
+}
+
+// sessionIDOfToken is the token's family. Tokens issued before rotation are their own family.
+func sessionIDOfToken(t *models.RefreshToken) uuid.UUID {
+	if t.FamilyID == uuid.Nil {
+		return t.ID
+	}
+	return t.FamilyID
+}
+
+// truncate shortens s to at most max bytes without splitting a UTF-8 sequence.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"chat-app/pkg/totp"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// RecoveryCodeCount is how many recovery codes are issued when 2FA is enabled.
+	RecoveryCodeCount = 10
+	// TwoFactorChallengeTTL is how long the second step of a login may take.
+	TwoFactorChallengeTTL = 5 * time.Minute
+
+	totpSkew = 1 // Steps of clock drift tolerated either way
+)
+
+// TwoFactorSetup is the enrollment data shown to the user, usually as a QR code of URI.
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TwoFactorRequiredError is returned by Login when the password was right but
+// the account has two-factor authentication enabled. LoginTwoFactor completes
+// the login with the challenge token and a code.
+type TwoFactorRequiredError struct {
+	ChallengeToken string
+	ExpiresAt      time.Time
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+type twoFactorService struct {
+	userRepo repository.UserRepository
+	codeRepo repository.RecoveryCodeRepository
+	issuer   string // Shown by authenticator apps next to the account
+}
+
+func NewTwoFactorService(userRepo repository.UserRepository, codeRepo repository.RecoveryCodeRepository, issuer string) TwoFactorService {
+	return &twoFactorService{userRepo: userRepo, codeRepo: codeRepo, issuer: issuer}
+}
+
+// Setup generates a new TOTP secret for the user. It is not enforced until
+// Enable confirms the authenticator app produces matching codes.
+func (s *twoFactorService) Setup(ctx context.Context, userID uuid.UUID) (*TwoFactorSetup, error) {
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabledAt != nil {
+		return nil, apperrors.ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.SetTOTPSecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+	return &TwoFactorSetup{Secret: secret, URI: totp.URI(s.issuer, user.Email, secret)}, nil
+}
+
+// Enable turns two-factor authentication on once code matches the secret from
+// Setup, and returns the recovery codes. They are shown only this once.
+func (s *twoFactorService) Enable(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	// 1. Check the code against the pending secret
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabledAt != nil {
+		return nil, apperrors.ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, apperrors.ErrTwoFactorNotSetUp
+	}
+	if err := s.verifyTOTP(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	// 2. Issue recovery codes
+	codes, hashes, err := newRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.codeRepo.Replace(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	// 3. Enforce it from now on
+	if err := s.userRepo.EnableTwoFactor(ctx, userID, time.Now()); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off after re-checking the password.
+func (s *twoFactorService) Disable(ctx context.Context, userID uuid.UUID, password string) error {
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return apperrors.ErrIncorrectPassword
+	}
+	if err := s.userRepo.SetTOTPSecret(ctx, userID, ""); err != nil {
+		return err
+	}
+	return s.codeRepo.DeleteByUser(ctx, userID)
+}
+
+// Verify checks the second factor of a login: a current TOTP code or an unused
+// recovery code, which is used up. Codes are never accepted twice.
+func (s *twoFactorService) Verify(ctx context.Context, user *models.User, code string) error {
+	if user.TwoFactorEnabledAt == nil {
+		return apperrors.ErrTwoFactorNotSetUp
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return s.verifyTOTP(ctx, user, code)
+	}
+
+	err := s.codeRepo.Consume(ctx, user.ID, hashToken(normalizeRecoveryCode(code)))
+	if errors.Is(err, repository.ErrRecoveryCodeInvalid) {
+		return apperrors.ErrInvalidTwoFactor
+	}
+	return err
+}
+
+func (s *twoFactorService) verifyTOTP(ctx context.Context, user *models.User, code string) error {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew)
+	if !ok {
+		return apperrors.ErrInvalidTwoFactor
+	}
+	fresh, err := s.userRepo.UseTOTPStep(ctx, user.ID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return apperrors.ErrInvalidTwoFactor // Replayed
+	}
+	return nil
+}
+
+func (s *twoFactorService) findUser(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, apperrors.ErrNotFound
+	}
+	return user, nil
+}
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns n random codes formatted as "xxxxx-xxxxx" and their hashes.
+func newRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7) // 50 bits after base32 truncation
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed with any case, spacing or dashes.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
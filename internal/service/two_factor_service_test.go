@@ -0,0 +1,152 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/repository"
+	"chat-app/internal/service"
+	"chat-app/pkg/totp"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MockRecoveryCodeRepo
+type MockRecoveryCodeRepo struct {
+	mock.Mock
+}
+
+func (m *MockRecoveryCodeRepo) Replace(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	args := m.Called(ctx, userID, hashes)
+	return args.Error(0)
+}
+
+func (m *MockRecoveryCodeRepo) Consume(ctx context.Context, userID uuid.UUID, hash string) error {
+	args := m.Called(ctx, userID, hash)
+	return args.Error(0)
+}
+
+func (m *MockRecoveryCodeRepo) DeleteByUser(ctx context.Context, userID uuid.UUID) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func setupTwoFactorService() (service.TwoFactorService, *MockUserRepo, *MockRecoveryCodeRepo) {
+	userRepo := new(MockUserRepo)
+	codeRepo := new(MockRecoveryCodeRepo)
+	return service.NewTwoFactorService(userRepo, codeRepo, "Chat App"), userRepo, codeRepo
+}
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	return code
+}
+
+func TestTwoFactorService_SetupAndEnable(t *testing.T) {
+	ctx := context.Background()
+	svc, userRepo, codeRepo := setupTwoFactorService()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Email: "a@example.com"}
+
+	// 1. Setup stores a pending secret
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+	userRepo.On("SetTOTPSecret", ctx, user.ID, mock.Anything).Run(func(args mock.Arguments) {
+		user.TOTPSecret = args.String(2)
+	}).Return(nil)
+
+	setup, err := svc.Setup(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.TOTPSecret, setup.Secret)
+	assert.True(t, strings.HasPrefix(setup.URI, "otpauth://totp/Chat%20App:a@example.com?"))
+
+	// 2. A wrong code doesn't enable it
+	userRepo.On("UseTOTPStep", ctx, user.ID, mock.Anything).Return(true, nil)
+	stale, err := totp.Code(user.TOTPSecret, totp.Step(time.Now())-5)
+	require.NoError(t, err)
+	_, err = svc.Enable(ctx, user.ID, stale)
+	assert.ErrorIs(t, err, apperrors.ErrInvalidTwoFactor)
+
+	// 3. The current code enables it and issues recovery codes
+	codeRepo.On("Replace", ctx, user.ID, mock.MatchedBy(func(hashes []string) bool {
+		return len(hashes) == service.RecoveryCodeCount
+	})).Return(nil)
+	userRepo.On("EnableTwoFactor", ctx, user.ID, mock.Anything).Return(nil)
+
+	codes, err := svc.Enable(ctx, user.ID, currentCode(t, user.TOTPSecret))
+	require.NoError(t, err)
+	assert.Len(t, codes, service.RecoveryCodeCount)
+	assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, codes[0])
+	userRepo.AssertExpectations(t)
+	codeRepo.AssertExpectations(t)
+}
+
+func TestTwoFactorService_EnableRequiresSetup(t *testing.T) {
+	ctx := context.Background()
+	svc, userRepo, _ := setupTwoFactorService()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}}
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+
+	_, err := svc.Enable(ctx, user.ID, "123456")
+	assert.ErrorIs(t, err, apperrors.ErrTwoFactorNotSetUp)
+
+	now := time.Now()
+	user.TOTPSecret, user.TwoFactorEnabledAt = "JBSWY3DPEHPK3PXP", &now
+	_, err = svc.Setup(ctx, user.ID)
+	assert.ErrorIs(t, err, apperrors.ErrTwoFactorEnabled)
+}
+
+func TestTwoFactorService_VerifyRejectsReplayedCode(t *testing.T) {
+	ctx := context.Background()
+	svc, userRepo, _ := setupTwoFactorService()
+	secret, _ := totp.GenerateSecret()
+	now := time.Now()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, TOTPSecret: secret, TwoFactorEnabledAt: &now}
+
+	code := currentCode(t, secret)
+	userRepo.On("UseTOTPStep", ctx, user.ID, mock.Anything).Return(true, nil).Once()
+	userRepo.On("UseTOTPStep", ctx, user.ID, mock.Anything).Return(false, nil).Once()
+
+	assert.NoError(t, svc.Verify(ctx, user, code))
+	assert.ErrorIs(t, svc.Verify(ctx, user, code), apperrors.ErrInvalidTwoFactor)
+}
+
+func TestTwoFactorService_VerifyRecoveryCode(t *testing.T) {
+	ctx := context.Background()
+	svc, _, codeRepo := setupTwoFactorService()
+	now := time.Now()
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, TOTPSecret: "JBSWY3DPEHPK3PXP", TwoFactorEnabledAt: &now}
+
+	// Case, spaces and the dash don't matter
+	var hash string
+	codeRepo.On("Consume", ctx, user.ID, mock.Anything).Run(func(args mock.Arguments) {
+		hash = args.String(2)
+	}).Return(nil).Once()
+	require.NoError(t, svc.Verify(ctx, user, " ABCDE-fghij "))
+	codeRepo.On("Consume", ctx, user.ID, hash).Return(repository.ErrRecoveryCodeInvalid).Once()
+	assert.ErrorIs(t, svc.Verify(ctx, user, "abcdefghij"), apperrors.ErrInvalidTwoFactor)
+}
+
+func TestTwoFactorService_DisableRequiresPassword(t *testing.T) {
+	ctx := context.Background()
+	svc, userRepo, codeRepo := setupTwoFactorService()
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}, Password: string(hashed)}
+	userRepo.On("FindByID", ctx, user.ID).Return(user, nil)
+
+	assert.ErrorIs(t, svc.Disable(ctx, user.ID, "wrong"), apperrors.ErrIncorrectPassword)
+	userRepo.AssertNotCalled(t, "SetTOTPSecret", mock.Anything, mock.Anything, mock.Anything)
+
+	userRepo.On("SetTOTPSecret", ctx, user.ID, "").Return(nil)
+	codeRepo.On("DeleteByUser", ctx, user.ID).Return(nil)
+	assert.NoError(t, svc.Disable(ctx, user.ID, "password123"))
+	codeRepo.AssertExpectations(t)
+}
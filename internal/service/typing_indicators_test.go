@@ -4,8 +4,10 @@ import (
 	"context"
 	"chat-app/internal/models"
 	"chat-app/internal/service"
+	"chat-app/pkg/ratelimit"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -28,6 +30,7 @@ func TestBroadcastTypingIndicator_DM_TypingStart(t *testing.T) {
 	senderID := uuid.New()
 	targetID := uuid.New()
 	senderUsername := "Alice"
+	mockConvRepo.On("FindMutedUserIDs", ctx, "DM", senderID, []uuid.UUID{targetID}).Return([]uuid.UUID{}, nil)
 
 	// Mock: Hub.SendToUser should be called with typing event
 	mockHub.On("SendToUser", targetID, mock.MatchedBy(func(payload []byte) bool {
@@ -66,6 +69,7 @@ func TestBroadcastTypingIndicator_DM_TypingStop(t *testing.T) {
 
 	senderID := uuid.New()
 	targetID := uuid.New()
+	mockConvRepo.On("FindMutedUserIDs", ctx, "DM", senderID, []uuid.UUID{targetID}).Return([]uuid.UUID{}, nil)
 
 	// Mock: Hub.SendToUser should be called with typing stop event
 	mockHub.On("SendToUser", targetID, mock.MatchedBy(func(payload []byte) bool {
@@ -120,6 +124,7 @@ func TestBroadcastTypingIndicator_Group_Success(t *testing.T) {
 		{GroupID: groupID, UserID: member2, Role: "MEMBER"},
 	}
 	mockGroupRepo.On("GetMembers", ctx, groupID).Return(members, nil)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "GROUP", groupID, []uuid.UUID{member1, member2}).Return([]uuid.UUID{}, nil)
 
 	// Mock: Hub.SendToUser should be called for EACH OTHER member (not sender)
 	mockHub.On("SendToUser", member1, mock.MatchedBy(func(payload []byte) bool {
@@ -205,3 +210,59 @@ func TestGetUserInfo_Success(t *testing.T) {
 	assert.Equal(t, "TestUser", user.Username)
 	mockUserRepo.AssertExpectations(t)
 }
+
+func TestBroadcastTypingIndicator_Throttled(t *testing.T) {
+	ctx := context.Background()
+	mockHub := new(MockHub)
+
+	mockConvRepo := new(MockConversationRepo)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "DM", mock.Anything, mock.Anything).Return([]uuid.UUID{}, nil)
+
+	svc := service.NewMessageService(new(MockMessageRepo), mockConvRepo, new(MockGroupRepo), new(MockMessageReceiptRepo), new(MockUserRepo), mockHub,
+		service.WithMessageLimits(ratelimit.NewMemoryStore(), service.MessageLimits{TypingInterval: time.Minute}))
+
+	senderID := uuid.New()
+	targetID := uuid.New()
+	var events []string
+	mockHub.On("SendToUser", targetID, mock.Anything).Run(func(args mock.Arguments) {
+		var msg map[string]interface{}
+		json.Unmarshal(args.Get(1).([]byte), &msg)
+		events = append(events, msg["type"].(string))
+	}).Return()
+
+	// Repeated starts within the interval are dropped, but every stop goes through
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, svc.BroadcastTypingIndicator(ctx, senderID, "Alice", "DM", targetID, true))
+		assert.NoError(t, svc.BroadcastTypingIndicator(ctx, senderID, "", "DM", targetID, false))
+	}
+	assert.Equal(t, []string{"user_typing", "user_stopped_typing", "user_stopped_typing"}, events)
+
+	// Other conversations have their own interval
+	otherID := uuid.New()
+	mockHub.On("SendToUser", otherID, mock.Anything).Return()
+	assert.NoError(t, svc.BroadcastTypingIndicator(ctx, senderID, "Alice", "DM", otherID, true))
+	mockHub.AssertNumberOfCalls(t, "SendToUser", 4)
+}
+
+func TestBroadcastTypingIndicator_SkipsMutedMembers(t *testing.T) {
+	ctx := context.Background()
+	mockConvRepo := new(MockConversationRepo)
+	mockGroupRepo := new(MockGroupRepo)
+	mockHub := new(MockHub)
+
+	svc := service.NewMessageService(new(MockMessageRepo), mockConvRepo, mockGroupRepo, new(MockMessageReceiptRepo), new(MockUserRepo), mockHub)
+
+	senderID, groupID, muted, unmuted := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	mockGroupRepo.On("IsMember", ctx, groupID, senderID).Return(true, nil)
+	mockGroupRepo.On("GetMembers", ctx, groupID).Return([]models.GroupMember{
+		{GroupID: groupID, UserID: senderID},
+		{GroupID: groupID, UserID: muted},
+		{GroupID: groupID, UserID: unmuted},
+	}, nil)
+	mockConvRepo.On("FindMutedUserIDs", ctx, "GROUP", groupID, []uuid.UUID{muted, unmuted}).Return([]uuid.UUID{muted}, nil)
+	mockHub.On("SendToUser", unmuted, mock.Anything).Return()
+
+	assert.NoError(t, svc.BroadcastTypingIndicator(ctx, senderID, "Carol", "GROUP", groupID, true))
+	mockHub.AssertCalled(t, "SendToUser", unmuted, mock.Anything)
+	mockHub.AssertNotCalled(t, "SendToUser", muted, mock.Anything)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/pkg/jwt"
+
+	"github.com/google/uuid"
+)
+
+// DefaultWSTicketTTL is how long a WS ticket may wait before being redeemed.
+const DefaultWSTicketTTL = 30 * time.Second
+
+type wsTicket struct {
+	userID         uuid.UUID
+	sessionID      uuid.UUID
+	expiresAt      time.Time
+	tokenExpiresAt time.Time
+}
+
+// wsTicketService keeps tickets in memory, keyed by their hash.
+// Tickets only need to survive the round trip between POST /ws/ticket and GET /ws.
+type wsTicketService struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	tickets map[string]wsTicket
+}
+
+func NewWSTicketService(ttl time.Duration) WSTicketService {
+	return &wsTicketService{
+		ttl:     ttl,
+		tickets: make(map[string]wsTicket),
+	}
+}
+
+// Issue mints a single-use ticket for userID. The ticket never outlives the access
+// token it was issued with, and connections opened with it expire with that token.
+func (s *wsTicketService) Issue(userID, sessionID uuid.UUID, tokenExpiresAt time.Time) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	rawTicket := base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := time.Now().Add(s.ttl)
+	if !tokenExpiresAt.IsZero() && tokenExpiresAt.Before(expiresAt) {
+		expiresAt = tokenExpiresAt
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop tickets that were never redeemed
+	now := time.Now()
+	for hash, t := range s.tickets {
+		if now.After(t.expiresAt) {
+			delete(s.tickets, hash)
+		}
+	}
+	s.tickets[hashToken(rawTicket)] = wsTicket{userID: userID, sessionID: sessionID, expiresAt: expiresAt, tokenExpiresAt: tokenExpiresAt}
+
+	return rawTicket, expiresAt, nil
+}
+
+// Redeem consumes a ticket and returns the claims it stands in for. A ticket can only be redeemed once.
+func (s *wsTicketService) Redeem(ticket string) (*jwt.Claims, error) {
+	hash := hashToken(ticket)
+
+	s.mu.Lock()
+	t, ok := s.tickets[hash]
+	delete(s.tickets, hash)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(t.expiresAt) {
+		return nil, apperrors.ErrUnauthorized
+	}
+	return &jwt.Claims{UserID: t.userID, SessionID: t.sessionID, ExpiresAt: t.tokenExpiresAt}, nil
+}
@@ -0,0 +1,60 @@
+package service_test
+
+import (
+	"chat-app/internal/service"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWSTicketService_RedeemOnce(t *testing.T) {
+	svc := service.NewWSTicketService(time.Minute)
+	userID := uuid.New()
+	sessionID := uuid.New()
+
+	tokenExpiresAt := time.Now().Add(15 * time.Minute)
+
+	ticket, expiresAt, err := svc.Issue(userID, sessionID, tokenExpiresAt)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ticket)
+	assert.True(t, expiresAt.After(time.Now()))
+
+	claims, err := svc.Redeem(ticket)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, claims.UserID)
+	assert.Equal(t, sessionID, claims.SessionID)
+	assert.Equal(t, tokenExpiresAt, claims.ExpiresAt)
+
+	// Second use is rejected
+	_, err = svc.Redeem(ticket)
+	assert.Error(t, err)
+}
+
+func TestWSTicketService_Expired(t *testing.T) {
+	svc := service.NewWSTicketService(time.Millisecond)
+
+	ticket, _, err := svc.Issue(uuid.New(), uuid.New(), time.Time{})
+	assert.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+	_, err = svc.Redeem(ticket)
+	assert.Error(t, err)
+}
+
+func TestWSTicketService_Unknown(t *testing.T) {
+	svc := service.NewWSTicketService(time.Minute)
+
+	_, err := svc.Redeem("not-a-ticket")
+	assert.Error(t, err)
+}
+
+func TestWSTicketService_NeverOutlivesToken(t *testing.T) {
+	svc := service.NewWSTicketService(time.Minute)
+	tokenExpiresAt := time.Now().Add(time.Second)
+
+	_, expiresAt, err := svc.Issue(uuid.New(), uuid.New(), tokenExpiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, tokenExpiresAt, expiresAt)
+}
@@ -0,0 +1,72 @@
+// Package storage keeps user uploads such as avatars.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store saves uploaded files and serves them under public URLs.
+type Store interface {
+	// Put stores data under name, replacing any file of that name, and returns its URL.
+	Put(ctx context.Context, name string, data []byte) (string, error)
+	// Delete removes a file by the URL Put returned. URLs of other stores are ignored.
+	Delete(ctx context.Context, url string) error
+}
+
+// diskStore keeps files in a local directory that is served at baseURL.
+type diskStore struct {
+	dir     string
+	baseURL string
+}
+
+// NewDiskStore stores files in dir, creating it if needed. The server must
+// serve dir at baseURL (e.g. with gin's Static).
+func NewDiskStore(dir, baseURL string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskStore{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (s *diskStore) Put(ctx context.Context, name string, data []byte) (string, error) {
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("storage: invalid file name %q", name)
+	}
+
+	// Write to a temporary file first so readers never see a partial upload
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return "", err
+	}
+
+	return s.baseURL + "/" + name, nil
+}
+
+func (s *diskStore) Delete(ctx context.Context, url string) error {
+	name := strings.TrimPrefix(url, s.baseURL+"/")
+	if name == url || name != filepath.Base(name) || name == "." || name == ".." {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chat-app/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskStore_PutAndDelete(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "avatars")
+	store, err := storage.NewDiskStore(dir, "/avatars/")
+	require.NoError(t, err)
+
+	url, err := store.Put(ctx, "a.png", []byte("png"))
+	require.NoError(t, err)
+	assert.Equal(t, "/avatars/a.png", url)
+	data, err := os.ReadFile(filepath.Join(dir, "a.png"))
+	require.NoError(t, err)
+	assert.Equal(t, "png", string(data))
+
+	// Names can't escape the directory
+	_, err = store.Put(ctx, "../a.png", []byte("x"))
+	assert.Error(t, err)
+
+	// URLs of other stores are left alone
+	assert.NoError(t, store.Delete(ctx, "https://cdn.example.com/a.png"))
+	assert.FileExists(t, filepath.Join(dir, "a.png"))
+
+	require.NoError(t, store.Delete(ctx, url))
+	assert.NoFileExists(t, filepath.Join(dir, "a.png"))
+	assert.NoError(t, store.Delete(ctx, url), "deleting twice is fine")
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"errors"
+	"time"
+
+	"chat-app/internal/protocol"
+	"chat-app/pkg/jwt"
+
+	"github.com/gorilla/websocket"
+)
+
+// Authenticator resolves the credentials of an auth or reauth frame.
+type Authenticator func(p *protocol.AuthPayload) (*jwt.Claims, error)
+
+// Authenticate waits up to timeout for the client's first frame, which must be an
+// auth command, and sets UserID and the token expiry from it. It runs before the
+// pumps are started and before the client is registered, so it reads and writes
+// the connection directly. On failure the client is told why and the connection is closed.
+func (c *Client) Authenticate(timeout time.Duration) error {
+	c.Conn.SetReadLimit(maxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(timeout))
+
+	claims, id, err := c.readAuthFrame()
+	if err != nil {
+		var protoErr *protocol.Error
+		if !errors.As(err, &protoErr) {
+			protoErr = &protocol.Error{Code: protocol.ErrCodeUnauthorized, Message: "authentication failed"}
+		}
+		c.writeFrame(protocol.EventError, id, protocol.ErrorPayload{Code: protoErr.Code, Message: protoErr.Message})
+		c.closeWithReason(websocket.ClosePolicyViolation, "authentication required")
+		return err
+	}
+
+	c.Conn.SetReadDeadline(time.Time{})
+	c.UserID = claims.UserID
+	c.SessionID = claims.SessionID
+	c.SetTokenExpiry(claims.ExpiresAt)
+	return c.writeFrame(protocol.EventAuthenticated, id, protocol.AuthenticatedPayload{UserID: claims.UserID, ExpiresAt: claims.ExpiresAt})
+}
+
+// readAuthFrame reads and validates the auth command, returning its correlation ID.
+func (c *Client) readAuthFrame() (*jwt.Claims, string, error) {
+	_, frame, err := c.Conn.ReadMessage()
+	if err != nil {
+		return nil, "", err
+	}
+	message, err := c.codec().Decode(frame)
+	if err != nil {
+		return nil, "", &protocol.Error{Code: protocol.ErrCodeInvalidFrame, Message: "frame could not be decoded"}
+	}
+
+	env, payload, err := protocol.DecodeCommand(message)
+	id := ""
+	if env != nil {
+		id = env.ID
+	}
+	if err != nil {
+		return nil, id, err
+	}
+	p, ok := payload.(*protocol.AuthPayload)
+	if !ok || env.Type != protocol.CmdAuth {
+		return nil, id, &protocol.Error{Code: protocol.ErrCodeUnauthorized, Message: "first frame must be auth"}
+	}
+
+	claims, err := c.Authenticator(p)
+	return claims, id, err
+}
+
+// reauthenticate renews the credentials of a live connection. The new token must
+// belong to the same user and session.
+func (c *Client) reauthenticate(id string, p *protocol.AuthPayload) error {
+	if c.Authenticator == nil {
+		return &protocol.Error{Code: protocol.ErrCodeUnauthorized, Message: "reauth is not supported"}
+	}
+	claims, err := c.Authenticator(p)
+	if err != nil {
+		return &protocol.Error{Code: protocol.ErrCodeUnauthorized, Message: "invalid credentials"}
+	}
+	if claims.UserID != c.UserID {
+		return &protocol.Error{Code: protocol.ErrCodeForbidden, Message: "credentials belong to another user"}
+	}
+	if claims.SessionID != c.SessionID {
+		return &protocol.Error{Code: protocol.ErrCodeForbidden, Message: "credentials belong to another session"}
+	}
+
+	c.SetTokenExpiry(claims.ExpiresAt)
+	c.reply(protocol.EventAuthenticated, id, protocol.AuthenticatedPayload{UserID: claims.UserID, ExpiresAt: claims.ExpiresAt})
+	return nil
+}
+
+// writeFrame writes an event straight to the connection, bypassing Send.
+// Only the goroutine that owns writes (Authenticate before the pumps start, WritePump after) may call it.
+func (c *Client) writeFrame(eventType, id string, payload interface{}) error {
+	frame, err := protocol.EncodeReply(eventType, id, payload)
+	if err == nil {
+		frame, err = c.encode(frame)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.Conn.WriteMessage(c.frameType(), frame)
+}
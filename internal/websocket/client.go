@@ -1,8 +1,10 @@
 package websocket
 
 import (
+	"chat-app/internal/protocol"
 	"chat-app/internal/service"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,8 +21,13 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// Maximum message size allowed from peer. Fits a send_message or save_draft
+	// frame with 4000 characters of content, which may take up to 6 bytes each
+	// once JSON-escaped, and auth frames carrying RS256 tokens.
+	maxMessageSize = 32 * 1024
+
+	// How long before the access token expires the client is asked to reauth.
+	reauthWindow = 60 * time.Second
 )
 
 // Client is a middleman between the websocket connection and the hub.
@@ -30,19 +37,46 @@ type Client struct {
 	// The websocket connection.
 	Conn *websocket.Conn
 
-	// Buffered channel of outbound messages.
+	// Buffered channel of outbound messages, already in the client's wire encoding.
 	Send chan []byte
 
+	// Codec negotiated via Sec-WebSocket-Protocol (JSON when nil)
+	Codec protocol.Codec
+
 	// UserID associated with this client
 	UserID uuid.UUID
 
+	// SessionID of the login session (refresh token family) the client authenticated with
+	SessionID uuid.UUID
+
 	// Service to handle incoming messages
 	MsgService service.MessageService
 
+	// Drafts saves the drafts sent with save_draft
+	Drafts service.DraftService
+
 	// ActiveConversation tracks which conversation this client is currently viewing.
 	// Format: "DM:{userID}" or "GROUP:{groupID}"
 	// Empty string means no active conversation (e.g., on conversation list screen)
 	ActiveConversation string
+
+	// ProtocolVersion negotiated via the hello handshake (protocol.MinVersion until then)
+	ProtocolVersion int
+
+	// Authenticator validates credentials sent in auth and reauth frames
+	Authenticator Authenticator
+
+	// tokenExpiry is when the access token backing this connection expires, as
+	// Unix nanoseconds (0 = never). Written by ReadPump on reauth, read by WritePump.
+	tokenExpiry atomic.Int64
+
+	// lastActive is when the client last sent a frame, as Unix nanoseconds.
+	// idle is set by the Hub once that is longer ago than Hub.IdleTimeout.
+	lastActive atomic.Int64
+	idle       atomic.Bool
+
+	// presenceSubs are the users watched with subscribe_presence. Guarded by Hub.mu.
+	presenceSubs map[uuid.UUID]struct{}
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -57,14 +91,24 @@ func (c *Client) ReadPump() {
 	c.Conn.SetReadLimit(maxMessageSize)
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.Conn.SetPongHandler(func(string) error { c.Conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	c.touch()
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		_, frame, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
+		// Pongs don't count: only frames sent by the app show the user is active
+		c.touch()
+
+		message, err := c.codec().Decode(frame)
+		if err != nil {
+			log.Printf("Failed to decode %s frame: %v", c.codec().Subprotocol(), err)
+			c.sendError(protocol.ErrCodeInvalidFrame, "frame could not be decoded")
+			continue
+		}
 
 		// Process the message (for now, we'll just log it or handle basic ping/pong if needed manually,
 		// though gorilla handles ping/pong control frames automatically)
@@ -84,6 +128,13 @@ func (c *Client) WritePump() {
 		ticker.Stop()
 		c.Conn.Close()
 	}()
+
+	frameType := c.frameType()
+
+	var warnedFor int64
+	session := time.NewTimer(c.checkSession(&warnedFor))
+	defer session.Stop()
+
 	for {
 		select {
 		case message, ok := <-c.Send:
@@ -94,13 +145,13 @@ func (c *Client) WritePump() {
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
+			w, err := c.Conn.NextWriter(frameType)
 			if err != nil {
 				return
 			}
 			w.Write(message)
 
-			// Optimization: We could batch messages here, but frontend expects one JSON per frame.
+			// Optimization: We could batch messages here, but frontend expects one event per frame.
 			// So we send one message per NextWriter.
 
 			if err := w.Close(); err != nil {
@@ -111,6 +162,87 @@ func (c *Client) WritePump() {
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-session.C:
+			next := c.checkSession(&warnedFor)
+			if next < 0 {
+				return
+			}
+			session.Reset(next)
+		}
+	}
+}
+
+// touch records activity on the connection. If it was idle, the user's presence is refreshed.
+func (c *Client) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+	if c.idle.Swap(false) {
+		c.Hub.refreshPresence(c.UserID)
+	}
+}
+
+// SetTokenExpiry records when the access token backing this connection expires.
+// The zero time means the connection never expires.
+func (c *Client) SetTokenExpiry(expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		c.tokenExpiry.Store(0)
+		return
+	}
+	c.tokenExpiry.Store(expiresAt.UnixNano())
+}
+
+// checkSession asks the client to reauth once its token is about to expire and
+// closes the connection when it has. It runs on the WritePump goroutine and
+// returns how long to wait before checking again, or -1 if the connection was closed.
+// warnedFor remembers which expiry reauth_required was already sent for.
+func (c *Client) checkSession(warnedFor *int64) time.Duration {
+	exp := c.tokenExpiry.Load()
+	if exp == 0 {
+		return pingPeriod
+	}
+
+	expiresAt := time.Unix(0, exp)
+	now := time.Now()
+	if !now.Before(expiresAt) {
+		c.closeWithReason(websocket.ClosePolicyViolation, "token expired")
+		return -1
+	}
+
+	warnAt := expiresAt.Add(-reauthWindow)
+	if now.Before(warnAt) {
+		return warnAt.Sub(now)
+	}
+	if *warnedFor != exp {
+		*warnedFor = exp
+		if err := c.writeFrame(protocol.EventReauthRequired, "", protocol.ReauthRequiredPayload{ExpiresAt: expiresAt}); err != nil {
+			return -1
 		}
 	}
+	return expiresAt.Sub(now)
+}
+
+// closeWithReason sends a close frame and closes the connection. ReadPump then
+// fails and unregisters the client. Safe to call from any goroutine.
+func (c *Client) closeWithReason(code int, reason string) {
+	c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	c.Conn.Close()
+}
+
+func (c *Client) codec() protocol.Codec {
+	if c.Codec == nil {
+		return protocol.JSON
+	}
+	return c.Codec
+}
+
+// frameType is the WebSocket message type used for this client's codec.
+func (c *Client) frameType() int {
+	if c.codec().Binary() {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// encode converts a canonical JSON frame into this client's wire encoding.
+func (c *Client) encode(jsonFrame []byte) ([]byte, error) {
+	return c.codec().Encode(jsonFrame)
 }
@@ -0,0 +1,508 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+	"chat-app/internal/repository"
+	"chat-app/pkg/jwt"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectedClient returns a server-side Client and the peer connection talking to it.
+func connectedClient(t *testing.T) (*Client, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		require.NoError(t, err)
+		conns <- conn
+	}))
+	t.Cleanup(s.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:], nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { peer.Close() })
+
+	client := &Client{Conn: <-conns, Send: make(chan []byte, 16), UserID: uuid.New()}
+	return client, peer
+}
+
+func TestClient_ExpiringTokenRequiresReauthThenCloses(t *testing.T) {
+	client, peer := connectedClient(t)
+	client.SetTokenExpiry(time.Now().Add(100 * time.Millisecond)) // already inside the reauth window
+	go client.WritePump()
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	var env protocol.Envelope
+	require.NoError(t, peer.ReadJSON(&env))
+	assert.Equal(t, protocol.EventReauthRequired, env.Type)
+
+	_, _, err := peer.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+}
+
+func TestClient_AuthenticatesWithRS256Token(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := jwt.NewKey("", rsaKey)
+	require.NoError(t, err)
+	keys, err := jwt.NewKeySet(key)
+	require.NoError(t, err)
+	jwtService := jwt.NewService(jwt.Config{Keys: keys, Expiration: time.Hour})
+
+	userID := uuid.New()
+	token, err := jwtService.GenerateToken(userID, uuid.New())
+	require.NoError(t, err)
+
+	client, peer := connectedClient(t)
+	client.UserID = uuid.Nil
+	client.Authenticator = func(p *protocol.AuthPayload) (*jwt.Claims, error) {
+		return jwtService.ParseToken(p.Token)
+	}
+
+	authFrame := fmt.Sprintf(`{"type":"auth","id":"a-1","payload":{"token":%q}}`, token)
+	require.NoError(t, peer.WriteMessage(websocket.TextMessage, []byte(authFrame)))
+	require.NoError(t, client.Authenticate(time.Second))
+	assert.Equal(t, userID, client.UserID)
+
+	// The longest message the protocol accepts fits in a frame too
+	content := strings.Repeat(`\u0001`, 4000) // 24000 bytes as JSON escapes
+	sendFrame := fmt.Sprintf(`{"type":"send_message","id":"m-1","payload":{"to_user_id":%q,"content":"%s"}}`, uuid.New(), content)
+	require.NoError(t, peer.WriteMessage(websocket.TextMessage, []byte(sendFrame)))
+	_, frame, err := client.Conn.ReadMessage()
+	require.NoError(t, err)
+	_, payload, err := protocol.DecodeCommand(frame)
+	require.NoError(t, err)
+	assert.Len(t, payload.(*protocol.SendMessagePayload).Content, 4000)
+}
+
+func TestClient_ReauthExtendsSession(t *testing.T) {
+	client := newTestClient()
+	newExpiry := time.Now().Add(time.Hour)
+	client.Authenticator = func(p *protocol.AuthPayload) (*jwt.Claims, error) {
+		return &jwt.Claims{UserID: client.UserID, ExpiresAt: newExpiry}, nil
+	}
+	client.SetTokenExpiry(time.Now().Add(time.Second))
+
+	HandleMessage([]byte(`{"type":"reauth","id":"r-1","payload":{"token":"fresh"}}`), client, &stubMessageService{})
+
+	env := nextFrame(t, client)
+	assert.Equal(t, protocol.EventAuthenticated, env.Type)
+	assert.Equal(t, "r-1", env.ID)
+	assert.Equal(t, newExpiry.UnixNano(), client.tokenExpiry.Load())
+}
+
+func TestClient_ReauthRejectsOtherUser(t *testing.T) {
+	client := newTestClient()
+	client.Authenticator = func(p *protocol.AuthPayload) (*jwt.Claims, error) {
+		return &jwt.Claims{UserID: uuid.New(), ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+	expiry := time.Now().Add(time.Second)
+	client.SetTokenExpiry(expiry)
+
+	HandleMessage([]byte(`{"type":"reauth","id":"r-2","payload":{"token":"someone-else"}}`), client, &stubMessageService{})
+
+	env := nextFrame(t, client)
+	assert.Equal(t, protocol.EventError, env.Type)
+	assert.Contains(t, string(env.Payload), protocol.ErrCodeForbidden)
+	assert.Equal(t, expiry.UnixNano(), client.tokenExpiry.Load())
+}
+
+func TestHub_DisconnectUser(t *testing.T) {
+	client, peer := connectedClient(t)
+	hub := NewHub(nil, nil)
+	hub.Clients[client.UserID] = []*Client{client}
+
+	hub.DisconnectUser(client.UserID)
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := peer.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+}
+
+func TestHub_DisconnectSession(t *testing.T) {
+	revoked, revokedPeer := connectedClient(t)
+	other, otherPeer := connectedClient(t)
+	revoked.SessionID = uuid.New()
+	other.UserID, other.SessionID = revoked.UserID, uuid.New()
+
+	hub := NewHub(nil, nil)
+	hub.Clients[revoked.UserID] = []*Client{revoked, other}
+
+	hub.DisconnectSession(revoked.SessionID)
+
+	revokedPeer.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := revokedPeer.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+
+	// The user's other device stays connected
+	otherPeer.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = otherPeer.ReadMessage()
+	assert.False(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+}
+
+func TestHub_ConnectedUsers(t *testing.T) {
+	hub := NewHub(nil, nil)
+	online, offline := uuid.New(), uuid.New()
+	hub.Clients[online] = []*Client{{UserID: online}}
+
+	assert.Equal(t, []uuid.UUID{online}, hub.ConnectedUsers([]uuid.UUID{offline, online}))
+}
+
+// stubContactRepo finds a fixed list of contacts.
+type stubContactRepo struct {
+	repository.ContactRepository
+	contacts []uuid.UUID
+}
+
+func (s *stubContactRepo) FindContactIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	return s.contacts, nil
+}
+
+func TestHub_BroadcastProfileUpdate(t *testing.T) {
+	owner, contact, stranger := newTestClient(), newTestClient(), newTestClient()
+	hub := NewHub(nil, &stubContactRepo{contacts: []uuid.UUID{contact.UserID}})
+	for _, c := range []*Client{owner, contact, stranger} {
+		hub.Clients[c.UserID] = []*Client{c}
+	}
+
+	hub.BroadcastProfileUpdate(&models.User{BaseModel: models.BaseModel{ID: owner.UserID}, Username: "alice", DisplayName: "Alice"})
+	hub.wg.Wait()
+
+	env := nextFrame(t, contact)
+	assert.Equal(t, protocol.EventProfileUpdated, env.Type)
+	var payload protocol.ProfileUpdatedPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	assert.Equal(t, owner.UserID, payload.UserID)
+	assert.Equal(t, "Alice", payload.DisplayName)
+
+	// The user's own devices are updated too, strangers are not
+	assert.Equal(t, protocol.EventProfileUpdated, nextFrame(t, owner).Type)
+	assert.Empty(t, stranger.Send)
+}
+
+// stubUserRepo finds users from a map.
+type stubUserRepo struct {
+	repository.UserRepository
+	users map[uuid.UUID]*models.User
+}
+
+func (s *stubUserRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return s.users[id], nil
+}
+
+func (s *stubUserRepo) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
+	var users []models.User
+	for _, id := range ids {
+		if user, ok := s.users[id]; ok {
+			users = append(users, *user)
+		}
+	}
+	return users, nil
+}
+
+func (s *stubUserRepo) UpdateOnlineStatus(ctx context.Context, userID uuid.UUID, isOnline bool, lastSeen time.Time) error {
+	return nil
+}
+
+func (s *stubUserRepo) UpdatePresence(ctx context.Context, userID uuid.UUID, presence string) error {
+	return nil
+}
+
+func TestHub_PresenceRespectsOnlineVisibility(t *testing.T) {
+	hidden, visible, contact := newTestClient(), newTestClient(), newTestClient()
+	users := &stubUserRepo{users: map[uuid.UUID]*models.User{
+		hidden.UserID:  {BaseModel: models.BaseModel{ID: hidden.UserID}, OnlineVisibility: models.VisibilityNobody},
+		visible.UserID: {BaseModel: models.BaseModel{ID: visible.UserID}, OnlineVisibility: models.VisibilityContacts},
+	}}
+	hub := NewHub(users, &stubContactRepo{contacts: []uuid.UUID{contact.UserID}})
+	hub.Clients[contact.UserID] = []*Client{contact}
+	hub.presence[hidden.UserID] = &userPresence{chosen: models.PresenceOnline, shown: models.PresenceOnline}
+	hub.presence[visible.UserID] = &userPresence{chosen: models.PresenceOnline, shown: models.PresenceOnline}
+
+	hub.wg.Add(2)
+	go hub.broadcastPresence(hidden.UserID)
+	go hub.broadcastPresence(visible.UserID)
+	hub.wg.Wait()
+
+	env := nextFrame(t, contact)
+	assert.Equal(t, protocol.EventUserOnline, env.Type)
+	var payload protocol.PresencePayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	assert.Equal(t, visible.UserID, payload.UserID)
+	assert.Empty(t, contact.Send, "the hidden user is not announced")
+}
+
+// presenceHub returns a hub where owner is connected with the given presence and
+// contact is connected as their contact.
+func presenceHub(owner, contact *Client, chosen string) *Hub {
+	users := &stubUserRepo{users: map[uuid.UUID]*models.User{
+		owner.UserID: {BaseModel: models.BaseModel{ID: owner.UserID}},
+	}}
+	hub := NewHub(users, &stubContactRepo{contacts: []uuid.UUID{contact.UserID}})
+	hub.IdleTimeout = 5 * time.Minute
+	owner.Hub, contact.Hub = hub, hub
+	hub.Clients[owner.UserID] = []*Client{owner}
+	hub.Clients[contact.UserID] = []*Client{contact}
+	hub.presence[owner.UserID] = &userPresence{chosen: chosen, shown: chosen}
+	return hub
+}
+
+func nextPresence(t *testing.T, c *Client) (string, protocol.PresencePayload) {
+	t.Helper()
+	env := nextFrame(t, c)
+	var payload protocol.PresencePayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	return env.Type, payload
+}
+
+func TestHub_IdleUserIsShownAway(t *testing.T) {
+	owner, contact := newTestClient(), newTestClient()
+	hub := presenceHub(owner, contact, models.PresenceOnline)
+	owner.lastActive.Store(time.Now().Add(-10 * time.Minute).UnixNano())
+
+	hub.checkIdle(time.Now())
+	hub.wg.Wait()
+
+	eventType, payload := nextPresence(t, contact)
+	assert.Equal(t, protocol.EventUserOnline, eventType)
+	assert.Equal(t, models.PresenceAway, payload.Status)
+
+	// Idle connections don't change a chosen status
+	hub.checkIdle(time.Now())
+	hub.wg.Wait()
+	assert.Empty(t, contact.Send)
+
+	// Any frame from the user brings them back
+	owner.touch()
+	hub.wg.Wait()
+	_, payload = nextPresence(t, contact)
+	assert.Equal(t, models.PresenceOnline, payload.Status)
+}
+
+func TestHub_NewConnectionEndsAway(t *testing.T) {
+	owner, contact := newTestClient(), newTestClient()
+	hub := presenceHub(owner, contact, models.PresenceOnline)
+	owner.lastActive.Store(time.Now().Add(-10 * time.Minute).UnixNano())
+	hub.checkIdle(time.Now())
+	hub.wg.Wait()
+	_, payload := nextPresence(t, contact)
+	require.Equal(t, models.PresenceAway, payload.Status)
+
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	// A second device connects before sending anything
+	phone := newTestClient()
+	phone.UserID, phone.Hub = owner.UserID, hub
+	hub.Register <- phone
+
+	time.Sleep(50 * time.Millisecond)
+	hub.wg.Wait()
+	eventType, payload := nextPresence(t, contact)
+	assert.Equal(t, protocol.EventUserOnline, eventType)
+	assert.Equal(t, models.PresenceOnline, payload.Status)
+}
+
+// countingCodec counts the frames it encodes.
+type countingCodec struct {
+	protocol.Codec
+	encoded *atomic.Int32
+}
+
+func (c countingCodec) Encode(jsonFrame []byte) ([]byte, error) {
+	c.encoded.Add(1)
+	return c.Codec.Encode(jsonFrame)
+}
+
+func TestHub_SendToUsersEncodesOncePerCodec(t *testing.T) {
+	hub := NewHub(&stubUserRepo{}, &stubContactRepo{})
+	var encoded atomic.Int32
+	codec := countingCodec{Codec: protocol.MsgPack, encoded: &encoded}
+
+	var userIDs []uuid.UUID
+	var clients []*Client
+	for i := 0; i < 3; i++ {
+		phone, desktop := newTestClient(), newTestClient()
+		desktop.UserID = phone.UserID
+		phone.Codec = codec
+		hub.Clients[phone.UserID] = []*Client{phone, desktop}
+		userIDs = append(userIDs, phone.UserID)
+		clients = append(clients, phone, desktop)
+	}
+
+	hub.SendToUsers(userIDs, []byte(`{"type":"new_message","payload":{}}`))
+
+	assert.Equal(t, int32(1), encoded.Load())
+	for _, c := range clients {
+		assert.Len(t, c.Send, 1)
+	}
+}
+
+func TestHub_DNDIsKeptWhileIdle(t *testing.T) {
+	owner, contact := newTestClient(), newTestClient()
+	hub := presenceHub(owner, contact, models.PresenceDND)
+	owner.lastActive.Store(time.Now().Add(-10 * time.Minute).UnixNano())
+
+	hub.checkIdle(time.Now())
+	hub.wg.Wait()
+
+	assert.True(t, owner.idle.Load())
+	assert.Empty(t, contact.Send)
+}
+
+func TestHub_InvisibleUserAppearsOffline(t *testing.T) {
+	owner, contact := newTestClient(), newTestClient()
+	hub := presenceHub(owner, contact, models.PresenceOnline)
+
+	require.NoError(t, hub.SetPresence(context.Background(), owner.UserID, models.PresenceInvisible))
+	hub.wg.Wait()
+
+	eventType, payload := nextPresence(t, contact)
+	assert.Equal(t, protocol.EventUserOffline, eventType)
+	assert.Equal(t, owner.UserID, payload.UserID)
+
+	// Contacts connecting later don't see them either
+	hub.wg.Add(1)
+	hub.sendInitialPresence(contact)
+	assert.Empty(t, contact.Send)
+
+	// Messages are still delivered
+	hub.SendToUser(owner.UserID, []byte(`{"type":"new_message"}`))
+	assert.Equal(t, "new_message", nextFrame(t, owner).Type)
+}
+
+func TestHub_ReconnectWithinGraceIsNotAnnounced(t *testing.T) {
+	owner, contact := newTestClient(), newTestClient()
+	hub := presenceHub(owner, contact, models.PresenceOnline)
+	hub.OfflineGrace = 50 * time.Millisecond
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	// A page reload: the only connection closes and a new one opens
+	hub.Unregister <- owner
+	reloaded := newTestClient()
+	reloaded.UserID, reloaded.Hub = owner.UserID, hub
+	hub.Register <- reloaded
+
+	time.Sleep(100 * time.Millisecond)
+	hub.wg.Wait()
+	assert.Empty(t, contact.Send)
+
+	// Leaving for good is announced once the grace period passes
+	hub.Unregister <- reloaded
+	time.Sleep(100 * time.Millisecond)
+	hub.wg.Wait()
+	eventType, payload := nextPresence(t, contact)
+	assert.Equal(t, protocol.EventUserOffline, eventType)
+	assert.Equal(t, owner.UserID, payload.UserID)
+}
+
+func TestHub_PresenceChangesAreCoalescedPerContact(t *testing.T) {
+	owner, contact := newTestClient(), newTestClient()
+	hub := presenceHub(owner, contact, models.PresenceOnline)
+	hub.PresenceBatchWindow = 50 * time.Millisecond
+
+	// online -> dnd -> away within the window
+	ctx := context.Background()
+	require.NoError(t, hub.SetPresence(ctx, owner.UserID, models.PresenceDND))
+	require.NoError(t, hub.SetPresence(ctx, owner.UserID, models.PresenceAway))
+	hub.wg.Wait()
+	assert.Empty(t, contact.Send)
+
+	time.Sleep(100 * time.Millisecond)
+	_, payload := nextPresence(t, contact)
+	assert.Equal(t, models.PresenceAway, payload.Status)
+	assert.Empty(t, contact.Send, "only the latest status is sent")
+}
+
+func TestHub_SubscribePresenceOfNonContact(t *testing.T) {
+	owner, contact, watcher := newTestClient(), newTestClient(), newTestClient()
+	hub := presenceHub(owner, contact, models.PresenceOnline)
+	watcher.Hub = hub
+	hub.Clients[watcher.UserID] = []*Client{watcher}
+
+	// The current status is sent right away
+	require.NoError(t, hub.SubscribePresence(context.Background(), watcher, []uuid.UUID{owner.UserID, owner.UserID}))
+	eventType, payload := nextPresence(t, watcher)
+	assert.Equal(t, protocol.EventUserOnline, eventType)
+	assert.Equal(t, owner.UserID, payload.UserID)
+	assert.Equal(t, models.PresenceOnline, payload.Status)
+
+	// Later changes follow
+	require.NoError(t, hub.SetPresence(context.Background(), owner.UserID, models.PresenceDND))
+	hub.wg.Wait()
+	_, payload = nextPresence(t, watcher)
+	assert.Equal(t, models.PresenceDND, payload.Status)
+	_, payload = nextPresence(t, contact)
+	assert.Equal(t, models.PresenceDND, payload.Status)
+
+	// Until unsubscribed
+	hub.UnsubscribePresence(watcher, []uuid.UUID{owner.UserID})
+	require.NoError(t, hub.SetPresence(context.Background(), owner.UserID, models.PresenceOnline))
+	hub.wg.Wait()
+	assert.Empty(t, watcher.Send)
+	assert.Empty(t, hub.subscribers)
+}
+
+func TestHub_SubscribePresenceRespectsOnlineVisibility(t *testing.T) {
+	owner, contact, watcher := newTestClient(), newTestClient(), newTestClient()
+	hub := presenceHub(owner, contact, models.PresenceOnline)
+	hub.userRepo.(*stubUserRepo).users[owner.UserID].OnlineVisibility = models.VisibilityContacts
+	watcher.Hub = hub
+	hub.Clients[watcher.UserID] = []*Client{watcher}
+
+	require.NoError(t, hub.SubscribePresence(context.Background(), watcher, []uuid.UUID{owner.UserID}))
+	assert.Empty(t, watcher.Send)
+
+	require.NoError(t, hub.SetPresence(context.Background(), owner.UserID, models.PresenceAway))
+	hub.wg.Wait()
+	assert.Empty(t, watcher.Send, "only contacts see the status")
+	_, payload := nextPresence(t, contact)
+	assert.Equal(t, models.PresenceAway, payload.Status)
+}
+
+func TestHub_PresenceSubscriptionsAreLimited(t *testing.T) {
+	watcher := newTestClient()
+	hub := NewHub(&stubUserRepo{}, &stubContactRepo{})
+	hub.Clients[watcher.UserID] = []*Client{watcher}
+
+	ids := make([]uuid.UUID, protocol.MaxPresenceSubscriptions)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+	require.NoError(t, hub.SubscribePresence(context.Background(), watcher, ids))
+	require.NoError(t, hub.SubscribePresence(context.Background(), watcher, ids[:1]), "resubscribing is a no-op")
+
+	err := hub.SubscribePresence(context.Background(), watcher, []uuid.UUID{uuid.New()})
+	var protoErr *protocol.Error
+	require.ErrorAs(t, err, &protoErr)
+	assert.Equal(t, protocol.ErrCodeValidation, protoErr.Code)
+	assert.Len(t, watcher.presenceSubs, protocol.MaxPresenceSubscriptions)
+
+	// Disconnecting drops them all
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+	hub.Unregister <- watcher
+	assert.Eventually(t, func() bool {
+		hub.mu.RLock()
+		defer hub.mu.RUnlock()
+		return len(hub.subscribers) == 0
+	}, time.Second, 10*time.Millisecond)
+}
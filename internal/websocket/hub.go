@@ -2,14 +2,16 @@ package websocket
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"sync"
 	"time"
 
+	"chat-app/internal/models"
+	"chat-app/internal/protocol"
 	"chat-app/internal/repository"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
 // Hub maintains the set of active clients and broadcasts messages to the
@@ -31,7 +33,33 @@ type Hub struct {
 	userRepo repository.UserRepository
 
 	// Repository to find user's contacts for presence broadcast
-	convRepo repository.ConversationRepository
+	contactRepo repository.ContactRepository
+
+	// IdleTimeout is how long a connection may go without sending a frame before
+	// it counts as idle. Users whose connections are all idle are shown away.
+	// Zero disables idle detection. Set before Run.
+	IdleTimeout time.Duration
+
+	// OfflineGrace delays announcing that a user's last connection closed, so
+	// a quick reconnect such as a page reload goes unnoticed. Set before Run.
+	OfflineGrace time.Duration
+
+	// PresenceBatchWindow collects presence changes for this long, then sends
+	// each contact only the latest status of each user. Zero sends them right
+	// away. Set before Run.
+	PresenceBatchWindow time.Duration
+
+	// Presence state of connected users (and of users within OfflineGrace), guarded by mu
+	presence map[uuid.UUID]*userPresence
+
+	// Presence subscriptions (watched user -> clients watching them), guarded by mu
+	subscribers map[uuid.UUID]map[*Client]struct{}
+
+	// Contacts and subscribers to be told about presence changes (-> users that changed)
+	pendingMu       sync.Mutex
+	pendingPresence map[uuid.UUID]map[uuid.UUID]struct{}
+	pendingWatchers map[*Client]map[uuid.UUID]struct{}
+	flushScheduled  bool
 
 	// Context for graceful shutdown
 	ctx    context.Context
@@ -39,38 +67,62 @@ type Hub struct {
 	wg     sync.WaitGroup
 }
 
-func NewHub(userRepo repository.UserRepository, convRepo repository.ConversationRepository) *Hub {
+func NewHub(userRepo repository.UserRepository, contactRepo repository.ContactRepository) *Hub {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Hub{
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Clients:    make(map[uuid.UUID][]*Client),
-		userRepo:   userRepo,
-		convRepo:   convRepo,
-		ctx:        ctx,
-		cancel:     cancel,
+		presence:   make(map[uuid.UUID]*userPresence),
+
+		subscribers:     make(map[uuid.UUID]map[*Client]struct{}),
+		pendingPresence: make(map[uuid.UUID]map[uuid.UUID]struct{}),
+		pendingWatchers: make(map[*Client]map[uuid.UUID]struct{}),
+		userRepo:        userRepo,
+		contactRepo:     contactRepo,
+		ctx:             ctx,
+		cancel:          cancel,
 	}
 }
 
 func (h *Hub) Run() {
+	var idleCheck <-chan time.Time
+	if h.IdleTimeout > 0 {
+		ticker := time.NewTicker(h.IdleTimeout / 4)
+		defer ticker.Stop()
+		idleCheck = ticker.C
+	}
+
 	for {
 		select {
 		case <-h.ctx.Done():
 			h.shutdown()
 			return
 
+		case now := <-idleCheck:
+			h.checkIdle(now)
+
 		case client := <-h.Register:
 			h.mu.Lock()
-			// If first connection, mark online and broadcast
-			wasOffline := len(h.Clients[client.UserID]) == 0
+			// If first connection, load the chosen presence, then mark online and broadcast.
+			// A user reconnecting within the grace period just stays online, and a
+			// new active connection brings an idle user back from away.
 			h.Clients[client.UserID] = append(h.Clients[client.UserID], client)
+			p, known := h.presence[client.UserID]
+			if !known {
+				h.presence[client.UserID] = &userPresence{shown: models.PresenceOffline}
+			} else {
+				if p.offline != nil {
+					p.offline.Stop()
+					p.offline = nil
+				}
+				h.refreshPresenceLocked(client.UserID)
+			}
 			h.mu.Unlock()
 
-			if wasOffline {
-				h.wg.Add(1)
-				go h.updateUserStatus(client.UserID, true)
+			if !known {
 				h.wg.Add(1)
-				go h.broadcastPresence(client.UserID, true)
+				go h.loadPresence(client.UserID)
 			}
 
 			// Send initial presence of contacts to the new client
@@ -95,14 +147,17 @@ func (h *Hub) Run() {
 					h.Clients[client.UserID] = newClients
 				}
 				close(client.Send)
-				h.mu.Unlock()
-
-				if isNowOffline {
-					h.wg.Add(1)
-					go h.updateUserStatus(client.UserID, false)
-					h.wg.Add(1)
-					go h.broadcastPresence(client.UserID, false)
+				h.unsubscribeAllLocked(client)
+				if isNowOffline && h.OfflineGrace > 0 {
+					h.scheduleOfflineLocked(client.UserID)
+				} else {
+					// The user may now be idle or offline
+					h.refreshPresenceLocked(client.UserID)
+					if isNowOffline {
+						delete(h.presence, client.UserID)
+					}
 				}
+				h.mu.Unlock()
 			} else {
 				h.mu.Unlock()
 			}
@@ -149,6 +204,11 @@ func (h *Hub) shutdown() {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	for _, p := range h.presence {
+		if p.offline != nil {
+			p.offline.Stop()
+		}
+	}
 	for userID, clients := range h.Clients {
 		for _, client := range clients {
 			close(client.Send)
@@ -160,48 +220,121 @@ func (h *Hub) shutdown() {
 	log.Println("Hub: Shutdown complete")
 }
 
-// broadcastPresence sends presence updates to all users who have a DM conversation with this user
-func (h *Hub) broadcastPresence(userID uuid.UUID, isOnline bool) {
+// broadcastPresence tells the user's contacts and the clients subscribed to
+// their presence that their status changed
+func (h *Hub) broadcastPresence(userID uuid.UUID) {
 	defer h.wg.Done()
 
 	// Create context with timeout for DB operation
 	ctx, cancel := context.WithTimeout(h.ctx, 5*time.Second)
 	defer cancel()
 
-	eventType := "user_offline"
-	if isOnline {
-		eventType = "user_online"
+	user, err := h.userRepo.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		if err != nil {
+			log.Printf("Failed to load privacy settings of %s: %v", userID, err)
+		}
+		return
 	}
-
-	payload, _ := json.Marshal(map[string]interface{}{
-		"type": eventType,
-		"payload": map[string]interface{}{
-			"user_id": userID,
-		},
-	})
-
-	// Find all users who have this user as a target_id in their DM conversations
-	// These are the users that need to know about this user's presence
-	contacts, err := h.convRepo.FindContactsOfUser(ctx, userID)
+	contacts, err := h.contactRepo.FindContactIDs(ctx, userID)
 	if err != nil {
 		log.Printf("Failed to find contacts for presence broadcast: %v", err)
 		return
 	}
 
-	for _, contactID := range contacts {
-		h.SendToUser(contactID, payload)
+	// Users who hide their online status are never announced
+	if !user.ShowsOnlineTo(true) {
+		return
+	}
+
+	// Subscribers who are contacts are told along with the contacts
+	var watchers []*Client
+	if user.ShowsOnlineTo(false) {
+		h.mu.RLock()
+		for c := range h.subscribers[userID] {
+			if !containsID(contacts, c.UserID) {
+				watchers = append(watchers, c)
+			}
+		}
+		h.mu.RUnlock()
+	}
+
+	h.queuePresence(userID, contacts, watchers)
+}
+
+// BroadcastProfileUpdate sends the user's new public profile to their contacts
+// and to their own other devices.
+func (h *Hub) BroadcastProfileUpdate(user *models.User) {
+	payload, _ := protocol.Encode(protocol.EventProfileUpdated, protocol.ProfileUpdatedPayload{
+		UserID:          user.ID,
+		Username:        user.Username,
+		DisplayName:     user.DisplayName,
+		Bio:             user.Bio,
+		AvatarURL:       user.AvatarURL,
+		StatusText:      user.StatusText,
+		StatusExpiresAt: user.StatusExpiresAt,
+	})
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		ctx, cancel := context.WithTimeout(h.ctx, 5*time.Second)
+		defer cancel()
+
+		contacts, err := h.contactRepo.FindContactIDs(ctx, user.ID)
+		if err != nil {
+			log.Printf("Failed to find contacts for profile broadcast: %v", err)
+			return
+		}
+
+		h.SendToUsers(append([]uuid.UUID{user.ID}, contacts...), payload)
+	}()
+}
+
+// SyncDraft sends a saved draft to the user's connected devices, except the
+// one it came from (nil when saved over HTTP).
+func (h *Hub) SyncDraft(draft *models.Draft, except *Client) {
+	payload, _ := protocol.Encode(protocol.EventDraftUpdated, protocol.DraftPayload{
+		ConversationType: draft.Type,
+		TargetID:         draft.TargetID,
+		Content:          draft.Content,
+		UpdatedAt:        draft.UpdatedAt,
+	})
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, client := range h.Clients[draft.UserID] {
+		if client != except {
+			h.sendToClientLocked(client, payload)
+		}
 	}
 }
 
 // SendToUser sends a message to all connected devices of a specific user.
+// The message is a canonical JSON frame; it is encoded at most once per codec
+// in use among the user's clients.
 func (h *Hub) SendToUser(userID uuid.UUID, message []byte) {
+	h.SendToUsers([]uuid.UUID{userID}, message)
+}
+
+// SendToUsers sends a message to all connected devices of each of the users,
+// e.g. the members of a group. The message is encoded at most once per codec,
+// however many users receive it.
+func (h *Hub) SendToUsers(userIDs []uuid.UUID, message []byte) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	if clients, ok := h.Clients[userID]; ok {
-		for _, client := range clients {
+	encoded := make(map[protocol.Codec][]byte, 1)
+	for _, userID := range userIDs {
+		for _, client := range h.Clients[userID] {
+			frame, ok := encodeOnce(client, message, encoded)
+			if !ok {
+				continue
+			}
+
 			select {
-			case client.Send <- message:
+			case client.Send <- frame:
 			default:
 				close(client.Send)
 				// Clean up locked client in next loop or let ReadPump handle it
@@ -210,6 +343,73 @@ func (h *Hub) SendToUser(userID uuid.UUID, message []byte) {
 	}
 }
 
+// encodeOnce returns the message in the client's wire encoding, reusing the
+// frame already encoded for another client with the same codec.
+func encodeOnce(client *Client, message []byte, encoded map[protocol.Codec][]byte) ([]byte, bool) {
+	codec := client.codec()
+	if frame, ok := encoded[codec]; ok {
+		return frame, true
+	}
+	frame, err := codec.Encode(message)
+	if err != nil {
+		log.Printf("Failed to encode frame as %s: %v", codec.Subprotocol(), err)
+		return nil, false
+	}
+	encoded[codec] = frame
+	return frame, true
+}
+
+// ConnectedUsers returns those of the users who have at least one open connection.
+func (h *Hub) ConnectedUsers(userIDs []uuid.UUID) []uuid.UUID {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	connected := make([]uuid.UUID, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if len(h.Clients[userID]) > 0 {
+			connected = append(connected, userID)
+		}
+	}
+	return connected
+}
+
+// DisconnectUser closes every connection of the user, e.g. after their sessions
+// were revoked. Clients unregister themselves once their ReadPump fails.
+func (h *Hub) DisconnectUser(userID uuid.UUID) {
+	h.mu.RLock()
+	clients := append([]*Client(nil), h.Clients[userID]...)
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		client.closeWithReason(websocket.ClosePolicyViolation, "session revoked")
+	}
+	if len(clients) > 0 {
+		log.Printf("Hub: Disconnected %d connections for user %s", len(clients), userID)
+	}
+}
+
+// DisconnectSession closes the connections opened with tokens of one session,
+// e.g. after that device was signed out.
+func (h *Hub) DisconnectSession(sessionID uuid.UUID) {
+	h.mu.RLock()
+	var clients []*Client
+	for _, userClients := range h.Clients {
+		for _, client := range userClients {
+			if client.SessionID == sessionID {
+				clients = append(clients, client)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		client.closeWithReason(websocket.ClosePolicyViolation, "session revoked")
+	}
+	if len(clients) > 0 {
+		log.Printf("Hub: Disconnected %d connections for session %s", len(clients), sessionID)
+	}
+}
+
 // IsUserViewingConversation checks if any client of the user is currently viewing the specified conversation.
 // Returns true if at least one client has this conversation as active.
 func (h *Hub) IsUserViewingConversation(convType string, targetID uuid.UUID) bool {
@@ -256,35 +456,58 @@ func (h *Hub) sendInitialPresence(client *Client) {
 	ctx, cancel := context.WithTimeout(h.ctx, 5*time.Second)
 	defer cancel()
 
-	// 1. Get user's conversations
-	convs, err := h.convRepo.FindByUser(ctx, client.UserID)
+	// 1. Get user's contacts
+	contacts, err := h.contactRepo.FindContactIDs(ctx, client.UserID)
 	if err != nil {
-		log.Printf("Failed to fetch conversations for initial presence sync: %v", err)
+		log.Printf("Failed to fetch contacts for initial presence sync: %v", err)
 		return
 	}
 
-	// 2. Filter for DMs and checking online status
+	// 2. Filter for online contacts (active clients, not invisible)
+	online := make(map[uuid.UUID]string)
+	h.mu.RLock()
+	for _, contactID := range contacts {
+		if p := h.presence[contactID]; p != nil && p.shown != models.PresenceOffline {
+			online[contactID] = p.shown
+		}
+	}
+	h.mu.RUnlock()
+
+	// 3. Skip those who hide their online status
+	visible := make(map[uuid.UUID]string)
+	for targetID, status := range online {
+		if h.showsOnline(ctx, targetID) {
+			visible[targetID] = status
+		}
+	}
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	for _, conv := range convs {
-		if conv.Type == "DM" {
-			targetID := conv.TargetID
-			// Check if target is online (has active clients)
-			if clients, ok := h.Clients[targetID]; ok && len(clients) > 0 {
-				// 3. Send 'user_online' event to THIS client only
-				payload, _ := json.Marshal(map[string]interface{}{
-					"type": "user_online",
-					"payload": map[string]interface{}{
-						"user_id": targetID,
-					},
-				})
-				select {
-				case client.Send <- payload:
-				case <-h.ctx.Done():
-					return
-				}
-			}
+	for targetID, status := range visible {
+		// 4. Send 'user_online' event to THIS client only
+		payload, err := client.encode(presenceEvent(targetID, status))
+		if err != nil {
+			log.Printf("Failed to encode initial presence: %v", err)
+			return
+		}
+		select {
+		case client.Send <- payload:
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// showsOnline reports whether the user lets their contacts see them online.
+// If the settings can't be read, the status is hidden.
+func (h *Hub) showsOnline(ctx context.Context, userID uuid.UUID) bool {
+	user, err := h.userRepo.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		if err != nil {
+			log.Printf("Failed to load privacy settings of %s: %v", userID, err)
 		}
+		return false
 	}
+	return user.ShowsOnlineTo(true)
 }
@@ -2,180 +2,237 @@ package websocket
 
 import (
 	"context"
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/protocol"
 	"chat-app/internal/service"
-	"encoding/json"
+	"errors"
 	"log"
 
 	"github.com/google/uuid"
 )
 
-type WSMessage struct {
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload"`
-}
-
-type SendMessagePayload struct {
-	ToUserID uuid.UUID `json:"to_user_id"` // Simplified for DM
-	GroupID  uuid.UUID `json:"group_id"`   // For Group (optional)
-	Content  string    `json:"content"`
-}
-
-type MessageDeliveredPayload struct {
-	MessageID uuid.UUID `json:"message_id"`
-}
-
-type TypingPayload struct {
-	ConversationType string    `json:"conversation_type"` // "DM" or "GROUP"
-	TargetID         uuid.UUID `json:"target_id"`         // user ID for DM, group ID for GROUP
-}
-
-type SetActiveConversationPayload struct {
-	ConversationType string    `json:"conversation_type"` // "DM" or "GROUP"
-	TargetID         uuid.UUID `json:"target_id"`         // user ID for DM, group ID for GROUP
-}
-
-// HandleMessage routes incoming WS messages to appropriate services
+// HandleMessage validates an incoming WS frame against the protocol and routes
+// it to the appropriate service.
+//
+// Every command is answered so clients can implement timeouts and retries:
+// failures produce an error event, and successful commands carrying an id are
+// confirmed with an ack (or their result event, e.g. message_sent) echoing it.
 func HandleMessage(message []byte, client *Client, msgService service.MessageService) {
-	var wsMsg WSMessage
-	if err := json.Unmarshal(message, &wsMsg); err != nil {
-		log.Printf("Invalid JSON: %v", err)
+	env, payload, err := protocol.DecodeCommand(message)
+	if err != nil {
+		id := ""
+		if env != nil {
+			id = env.ID
+			// Silent ignore for stop events to handle spam/race conditions gracefully
+			if env.Type == protocol.CmdTypingStop && id == "" {
+				return
+			}
+		}
+		log.Printf("Rejected WS frame: %v", err)
+		client.replyError(id, err)
 		return
 	}
 
-	switch wsMsg.Type {
-	case "set_active_conversation":
-		var payload SetActiveConversationPayload
-		if err := json.Unmarshal(wsMsg.Payload, &payload); err != nil {
-			log.Printf("Invalid Payload for set_active_conversation: %v", err)
-			return
+	switch p := payload.(type) {
+	case *protocol.AuthPayload:
+		if env.Type == protocol.CmdReauth {
+			err = client.reauthenticate(env.ID, p)
+		} else {
+			// auth is only valid as the first frame of an unauthenticated connection
+			err = &protocol.Error{Code: protocol.ErrCodeValidation, Message: "connection is already authenticated"}
 		}
 
-		// Validate conversation type
-		if payload.ConversationType != "DM" && payload.ConversationType != "GROUP" {
-			log.Printf("Invalid conversation type: %s", payload.ConversationType)
-			return
-		}
+	case *protocol.HelloPayload:
+		err = handleHello(client, env.ID, p)
 
+	case *protocol.SetActiveConversationPayload:
 		// Set or clear active conversation
-		if payload.TargetID == uuid.Nil {
+		if p.TargetID == uuid.Nil {
 			client.Hub.ClearActiveConversation(client)
 		} else {
-			client.Hub.SetActiveConversation(client, payload.ConversationType, payload.TargetID)
+			client.Hub.SetActiveConversation(client, p.ConversationType, p.TargetID)
 		}
+		client.ack(env)
 
-	case "send_message":
-		var payload SendMessagePayload
-		if err := json.Unmarshal(wsMsg.Payload, &payload); err != nil {
-			log.Printf("Invalid Payload: %v", err)
-			return
-		}
+	case *protocol.SendMessagePayload:
+		err = handleSendMessage(client, env.ID, p, msgService)
 
+	case *protocol.MessageDeliveredPayload:
 		ctx := context.Background()
-		if payload.ToUserID != uuid.Nil {
-			// Direct Message
-			msg, err := msgService.SendDirectMessage(ctx, client.UserID, payload.ToUserID, payload.Content)
-			if err != nil {
-				log.Printf("Failed to send DM: %v", err)
-				return
-			}
-
-			// Ack to Sender
-			ack, _ := json.Marshal(map[string]interface{}{
-				"type":    "message_sent",
-				"payload": msg,
-			})
-			client.Send <- ack
-		} else if payload.GroupID != uuid.Nil {
-			// Group Message
-			msg, err := msgService.SendGroupMessage(ctx, client.UserID, payload.GroupID, payload.Content)
-			if err != nil {
-				log.Printf("Failed to send group message: %v", err)
-				return
-			}
-
-			// Ack to Sender
-			ack, _ := json.Marshal(map[string]interface{}{
-				"type":    "message_sent",
-				"payload": msg,
-			})
-			client.Send <- ack
+		if err = msgService.MarkAsDelivered(ctx, client.UserID, []uuid.UUID{p.MessageID}); err != nil {
+			log.Printf("Failed to mark delivered: %v", err)
+		} else {
+			client.ack(env)
 		}
 
-	case "message_delivered":
-		var payload MessageDeliveredPayload
-		if err := json.Unmarshal(wsMsg.Payload, &payload); err != nil {
-			log.Printf("Invalid Payload for message_delivered: %v", err)
-			return
+	case *protocol.TypingPayload:
+		if env.Type == protocol.CmdTypingStart {
+			err = handleTypingStart(client, p, msgService)
+		} else {
+			err = handleTypingStop(client, p, msgService)
+		}
+		if err == nil {
+			client.ack(env)
 		}
 
-		ctx := context.Background()
-		if err := msgService.MarkAsDelivered(ctx, client.UserID, []uuid.UUID{payload.MessageID}); err != nil {
-			log.Printf("Failed to mark delivered: %v", err)
+	case *protocol.SetPresencePayload:
+		if err = client.Hub.SetPresence(context.Background(), client.UserID, p.Status); err != nil {
+			log.Printf("Failed to set presence: %v", err)
+		} else {
+			client.ack(env)
 		}
 
-	case "typing_start":
-		handleTypingStart(client, wsMsg.Payload, msgService)
+	case *protocol.SaveDraftPayload:
+		var draft *models.Draft
+		if draft, err = client.Drafts.SaveDraft(context.Background(), client.UserID, p.ConversationType, p.TargetID, p.Content); err != nil {
+			log.Printf("Failed to save draft: %v", err)
+		} else {
+			client.Hub.SyncDraft(draft, client)
+			client.ack(env)
+		}
 
-	case "typing_stop":
-		handleTypingStop(client, wsMsg.Payload, msgService)
+	case *protocol.PresenceSubscriptionPayload:
+		if env.Type == protocol.CmdSubscribePresence {
+			err = client.Hub.SubscribePresence(context.Background(), client, p.UserIDs)
+		} else {
+			client.Hub.UnsubscribePresence(client, p.UserIDs)
+		}
+		if err == nil {
+			client.ack(env)
+		}
+	}
 
-	default:
-		log.Printf("Unknown message type: %s", wsMsg.Type)
+	if err != nil {
+		client.replyError(env.ID, err)
 	}
 }
 
-// handleTypingStart broadcasts typing indicator to relevant users
-func handleTypingStart(client *Client, payload json.RawMessage, msgService service.MessageService) {
-	var typingPayload TypingPayload
-	if err := json.Unmarshal(payload, &typingPayload); err != nil {
-		log.Printf("Invalid typing_start payload: %v", err)
-		return
+// handleHello completes the version handshake. Clients that never send hello
+// are assumed to speak protocol.MinVersion.
+func handleHello(client *Client, id string, p *protocol.HelloPayload) error {
+	if !protocol.IsSupportedVersion(p.Version) {
+		return &protocol.Error{Code: protocol.ErrCodeUnsupportedVersion, Message: "unsupported protocol version"}
 	}
+	client.ProtocolVersion = p.Version
+	client.reply(protocol.EventWelcome, id, protocol.WelcomePayload{
+		Version:       p.Version,
+		ServerVersion: protocol.Version,
+		MinVersion:    protocol.MinVersion,
+	})
+	return nil
+}
 
-	// Validation
-	if typingPayload.ConversationType != "DM" && typingPayload.ConversationType != "GROUP" {
-		log.Printf("Invalid conversation type: %s", typingPayload.ConversationType)
-		return
+func handleSendMessage(client *Client, id string, p *protocol.SendMessagePayload, msgService service.MessageService) error {
+	ctx := context.Background()
+	if p.ToUserID != uuid.Nil {
+		// Direct Message
+		msg, err := msgService.SendDirectMessage(ctx, client.UserID, p.ToUserID, p.Content)
+		if err != nil {
+			log.Printf("Failed to send DM: %v", err)
+			return err
+		}
+
+		// Ack to Sender
+		client.reply(protocol.EventMessageSent, id, protocol.NewMessagePayload(msg))
+		return nil
 	}
-	if typingPayload.TargetID == uuid.Nil {
-		log.Printf("Invalid target_id for typing event")
-		return
+
+	// Group Message
+	msg, err := msgService.SendGroupMessage(ctx, client.UserID, p.GroupID, p.Content)
+	if err != nil {
+		log.Printf("Failed to send group message: %v", err)
+		return err
 	}
 
+	// Ack to Sender
+	client.reply(protocol.EventMessageSent, id, protocol.NewMessagePayload(msg))
+	return nil
+}
+
+// handleTypingStart broadcasts typing indicator to relevant users
+func handleTypingStart(client *Client, p *protocol.TypingPayload, msgService service.MessageService) error {
 	ctx := context.Background()
 	// Get user info for the typing user
 	user, err := msgService.GetUserInfo(ctx, client.UserID)
 	if err != nil {
 		log.Printf("Failed to get user info: %v", err)
-		return
+		return err
 	}
 	if user == nil {
 		log.Printf("User info not found for ID: %s", client.UserID)
-		return
+		return errors.New("user not found")
 	}
 
 	// Broadcast typing event
-	if err := msgService.BroadcastTypingIndicator(ctx, client.UserID, user.Username, typingPayload.ConversationType, typingPayload.TargetID, true); err != nil {
+	if err := msgService.BroadcastTypingIndicator(ctx, client.UserID, user.Username, p.ConversationType, p.TargetID, true); err != nil {
 		log.Printf("Failed to broadcast typing_start: %v", err)
+		return err
 	}
+	return nil
 }
 
 // handleTypingStop broadcasts typing stop indicator to relevant users
-func handleTypingStop(client *Client, payload json.RawMessage, msgService service.MessageService) {
-	var typingPayload TypingPayload
-	if err := json.Unmarshal(payload, &typingPayload); err != nil {
-		log.Printf("Invalid typing_stop payload: %v", err)
+func handleTypingStop(client *Client, p *protocol.TypingPayload, msgService service.MessageService) error {
+	ctx := context.Background()
+	// Broadcast typing stop event
+	if err := msgService.BroadcastTypingIndicator(ctx, client.UserID, "", p.ConversationType, p.TargetID, false); err != nil {
+		log.Printf("Failed to broadcast typing_stop: %v", err)
+		return err
+	}
+	return nil
+}
+
+// reply encodes an event answering the command with the given id and queues it for this client only.
+func (c *Client) reply(eventType, id string, payload interface{}) {
+	frame, err := protocol.EncodeReply(eventType, id, payload)
+	if err == nil {
+		frame, err = c.encode(frame)
+	}
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", eventType, err)
 		return
 	}
+	c.Send <- frame
+}
 
-	// Validation
-	if typingPayload.ConversationType != "DM" && typingPayload.ConversationType != "GROUP" {
-		return // Silent ignore for stop events to handle spam/race conditions gracefully
+// ack confirms a successful command. Commands without an id are not acknowledged.
+func (c *Client) ack(env *protocol.Envelope) {
+	if env.ID == "" {
+		return
 	}
+	c.reply(protocol.EventAck, env.ID, protocol.AckPayload{Type: env.Type})
+}
 
-	ctx := context.Background()
-	// Broadcast typing stop event
-	if err := msgService.BroadcastTypingIndicator(ctx, client.UserID, "", typingPayload.ConversationType, typingPayload.TargetID, false); err != nil {
-		log.Printf("Failed to broadcast typing_stop: %v", err)
+// replyError reports a failed command, mapping service errors to protocol error codes.
+func (c *Client) replyError(id string, err error) {
+	var protoErr *protocol.Error
+	var rateErr *apperrors.RateLimitError
+	switch {
+	case errors.As(err, &protoErr):
+	case errors.As(err, &rateErr):
+		c.reply(protocol.EventError, id, protocol.ErrorPayload{
+			Code:         protocol.ErrCodeRateLimited,
+			Message:      "too many messages, slow down",
+			RetryAfterMs: max(1, rateErr.RetryAfter.Milliseconds()),
+		})
+		return
+	case errors.Is(err, service.ErrNotGroupMember):
+		protoErr = &protocol.Error{Code: protocol.ErrCodeForbidden, Message: err.Error()}
+	case errors.Is(err, apperrors.ErrDMNotAllowed):
+		protoErr = &protocol.Error{Code: protocol.ErrCodeForbidden, Message: apperrors.ErrDMNotAllowed.Message}
+	case errors.Is(err, apperrors.ErrNotFound):
+		protoErr = &protocol.Error{Code: protocol.ErrCodeValidation, Message: apperrors.ErrNotFound.Message}
+	case errors.Is(err, apperrors.ErrForbidden):
+		protoErr = &protocol.Error{Code: protocol.ErrCodeForbidden, Message: apperrors.ErrForbidden.Message}
+	case errors.Is(err, apperrors.ErrValidation):
+		protoErr = &protocol.Error{Code: protocol.ErrCodeValidation, Message: apperrors.ErrValidation.Message}
+	default:
+		protoErr = &protocol.Error{Code: protocol.ErrCodeInternal, Message: "command failed"}
 	}
+	c.reply(protocol.EventError, id, protocol.ErrorPayload{Code: protoErr.Code, Message: protoErr.Message})
+}
+
+func (c *Client) sendError(code, message string) {
+	c.reply(protocol.EventError, "", protocol.ErrorPayload{Code: code, Message: message})
 }
@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	apperrors "chat-app/internal/errors"
+	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+	"chat-app/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubMessageService implements service.MessageService with canned results.
+type stubMessageService struct {
+	service.MessageService
+	sendErr      error
+	deliveredIDs []uuid.UUID
+}
+
+func (s *stubMessageService) SendDirectMessage(ctx context.Context, senderID, receiverID uuid.UUID, content string) (*models.Message, error) {
+	if s.sendErr != nil {
+		return nil, s.sendErr
+	}
+	return &models.Message{SenderID: senderID, ReceiverID: &receiverID, Content: content}, nil
+}
+
+func (s *stubMessageService) SendGroupMessage(ctx context.Context, senderID, groupID uuid.UUID, content string) (*models.Message, error) {
+	if s.sendErr != nil {
+		return nil, s.sendErr
+	}
+	return &models.Message{SenderID: senderID, GroupID: &groupID, Content: content}, nil
+}
+
+func (s *stubMessageService) MarkAsDelivered(ctx context.Context, userID uuid.UUID, messageIDs []uuid.UUID) error {
+	s.deliveredIDs = append(s.deliveredIDs, messageIDs...)
+	return nil
+}
+
+func newTestClient() *Client {
+	return &Client{Send: make(chan []byte, 16), UserID: uuid.New()}
+}
+
+func nextFrame(t *testing.T, c *Client) protocol.Envelope {
+	t.Helper()
+	select {
+	case frame := <-c.Send:
+		var env protocol.Envelope
+		require.NoError(t, json.Unmarshal(frame, &env))
+		return env
+	default:
+		t.Fatal("expected a frame to be queued")
+		return protocol.Envelope{}
+	}
+}
+
+func TestHandleMessage_SendMessageEchoesID(t *testing.T) {
+	client := newTestClient()
+	svc := &stubMessageService{}
+
+	HandleMessage([]byte(`{"type":"send_message","id":"c-1","payload":{"to_user_id":"`+uuid.NewString()+`","content":"hi"}}`), client, svc)
+
+	env := nextFrame(t, client)
+	assert.Equal(t, protocol.EventMessageSent, env.Type)
+	assert.Equal(t, "c-1", env.ID)
+}
+
+func TestHandleMessage_ServiceErrorEchoesID(t *testing.T) {
+	client := newTestClient()
+	svc := &stubMessageService{sendErr: service.ErrNotGroupMember}
+
+	HandleMessage([]byte(`{"type":"send_message","id":"c-2","payload":{"group_id":"`+uuid.NewString()+`","content":"hi"}}`), client, svc)
+
+	env := nextFrame(t, client)
+	assert.Equal(t, protocol.EventError, env.Type)
+	assert.Equal(t, "c-2", env.ID)
+
+	var payload protocol.ErrorPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	assert.Equal(t, protocol.ErrCodeForbidden, payload.Code)
+}
+
+func TestHandleMessage_DMNotAllowedIsForbidden(t *testing.T) {
+	client := newTestClient()
+	svc := &stubMessageService{sendErr: apperrors.ErrDMNotAllowed}
+
+	HandleMessage([]byte(`{"type":"send_message","payload":{"to_user_id":"`+uuid.NewString()+`","content":"hi"}}`), client, svc)
+
+	env := nextFrame(t, client)
+	var payload protocol.ErrorPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	assert.Equal(t, protocol.ErrCodeForbidden, payload.Code)
+}
+
+func TestHandleMessage_UnknownReceiverIsValidationError(t *testing.T) {
+	client := newTestClient()
+	svc := &stubMessageService{sendErr: apperrors.ErrNotFound}
+
+	HandleMessage([]byte(`{"type":"send_message","payload":{"to_user_id":"`+uuid.NewString()+`","content":"hi"}}`), client, svc)
+
+	env := nextFrame(t, client)
+	var payload protocol.ErrorPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	assert.Equal(t, protocol.ErrCodeValidation, payload.Code)
+}
+
+func TestHandleMessage_RateLimitedCarriesRetryAfter(t *testing.T) {
+	client := newTestClient()
+	svc := &stubMessageService{sendErr: &apperrors.RateLimitError{RetryAfter: 1500 * time.Millisecond}}
+
+	HandleMessage([]byte(`{"type":"send_message","id":"c-3","payload":{"to_user_id":"`+uuid.NewString()+`","content":"hi"}}`), client, svc)
+
+	env := nextFrame(t, client)
+	assert.Equal(t, protocol.EventError, env.Type)
+	assert.Equal(t, "c-3", env.ID)
+
+	var payload protocol.ErrorPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	assert.Equal(t, protocol.ErrCodeRateLimited, payload.Code)
+	assert.Equal(t, int64(1500), payload.RetryAfterMs)
+}
+
+func TestHandleMessage_AckOnlyWithID(t *testing.T) {
+	client := newTestClient()
+	svc := &stubMessageService{}
+	msgID := uuid.NewString()
+
+	HandleMessage([]byte(`{"type":"message_delivered","payload":{"message_id":"`+msgID+`"}}`), client, svc)
+	assert.Len(t, client.Send, 0)
+
+	HandleMessage([]byte(`{"type":"message_delivered","id":"d-1","payload":{"message_id":"`+msgID+`"}}`), client, svc)
+	env := nextFrame(t, client)
+	assert.Equal(t, protocol.EventAck, env.Type)
+	assert.Equal(t, "d-1", env.ID)
+	assert.Len(t, svc.deliveredIDs, 2)
+}
+
+func TestHandleMessage_InvalidTypingStop(t *testing.T) {
+	client := newTestClient()
+	svc := &stubMessageService{}
+
+	// Without an id the error is swallowed, as before
+	HandleMessage([]byte(`{"type":"typing_stop","payload":{"conversation_type":"NOPE"}}`), client, svc)
+	assert.Len(t, client.Send, 0)
+
+	// With an id the client is told why
+	HandleMessage([]byte(`{"type":"typing_stop","id":"t-1","payload":{"conversation_type":"NOPE"}}`), client, svc)
+	env := nextFrame(t, client)
+	assert.Equal(t, protocol.EventError, env.Type)
+	assert.Equal(t, "t-1", env.ID)
+}
+
+// stubDraftService saves drafts without storing them.
+type stubDraftService struct {
+	service.DraftService
+}
+
+func (s *stubDraftService) SaveDraft(ctx context.Context, userID uuid.UUID, convType string, targetID uuid.UUID, content string) (*models.Draft, error) {
+	if convType == "GROUP" {
+		return nil, apperrors.ErrForbidden
+	}
+	return &models.Draft{UserID: userID, Type: convType, TargetID: targetID, Content: content}, nil
+}
+
+func TestHandleMessage_SaveDraftSyncsOtherDevices(t *testing.T) {
+	laptop, phone := newTestClient(), newTestClient()
+	phone.UserID = laptop.UserID
+	hub := NewHub(nil, nil)
+	hub.Clients[laptop.UserID] = []*Client{laptop, phone}
+	laptop.Hub, laptop.Drafts = hub, &stubDraftService{}
+
+	targetID := uuid.New()
+	HandleMessage([]byte(`{"type":"save_draft","id":"d-1","payload":{"conversation_type":"DM","target_id":"`+targetID.String()+`","content":"Hel"}}`), laptop, nil)
+
+	assert.Equal(t, protocol.EventAck, nextFrame(t, laptop).Type)
+	assert.Empty(t, laptop.Send, "the draft isn't echoed to its own device")
+
+	env := nextFrame(t, phone)
+	assert.Equal(t, protocol.EventDraftUpdated, env.Type)
+	var payload protocol.DraftPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	assert.Equal(t, targetID, payload.TargetID)
+	assert.Equal(t, "Hel", payload.Content)
+
+	// Drafts for groups the user isn't in are refused
+	HandleMessage([]byte(`{"type":"save_draft","id":"d-2","payload":{"conversation_type":"GROUP","target_id":"`+uuid.NewString()+`","content":"Hi"}}`), laptop, nil)
+	assert.Equal(t, protocol.EventError, nextFrame(t, laptop).Type)
+	assert.Empty(t, phone.Send)
+}
@@ -0,0 +1,264 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+
+	"github.com/google/uuid"
+)
+
+// userPresence is the presence state of a connected user.
+type userPresence struct {
+	chosen string // Set with set_presence; empty until loaded from the database
+	shown  string // Last status announced to contacts
+
+	offline *time.Timer // Announces the user offline once OfflineGrace passes
+	leaving uint64      // Counts disconnects, so a stale timer can be told apart
+}
+
+// loadPresence reads the presence the user chose on an earlier connection and
+// announces them.
+func (h *Hub) loadPresence(userID uuid.UUID) {
+	defer h.wg.Done()
+
+	ctx, cancel := context.WithTimeout(h.ctx, 5*time.Second)
+	defer cancel()
+
+	chosen := models.PresenceOnline
+	user, err := h.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load presence of %s: %v", userID, err)
+	} else if user != nil && user.Presence != "" {
+		chosen = user.Presence
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p := h.presence[userID]; p != nil && p.chosen == "" {
+		p.chosen = chosen
+		h.refreshPresenceLocked(userID)
+	}
+}
+
+// SetPresence saves the presence state the user chose and announces it.
+func (h *Hub) SetPresence(ctx context.Context, userID uuid.UUID, status string) error {
+	if err := h.userRepo.UpdatePresence(ctx, userID, status); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p := h.presence[userID]; p != nil {
+		p.chosen = status
+		h.refreshPresenceLocked(userID)
+	}
+	return nil
+}
+
+// refreshPresence announces a change in the user's status, e.g. after an idle
+// connection became active again.
+func (h *Hub) refreshPresence(userID uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.refreshPresenceLocked(userID)
+}
+
+// refreshPresenceLocked works out the status others see and announces it if it
+// changed. Going online or offline is also saved. h.mu must be held.
+func (h *Hub) refreshPresenceLocked(userID uuid.UUID) {
+	p := h.presence[userID]
+	if p == nil {
+		return
+	}
+
+	status := h.statusLocked(userID, p)
+	if status == p.shown {
+		return
+	}
+	previous := p.shown
+	p.shown = status
+
+	if (previous == models.PresenceOffline) != (status == models.PresenceOffline) {
+		h.wg.Add(1)
+		go h.updateUserStatus(userID, status != models.PresenceOffline)
+	}
+	h.wg.Add(1)
+	go h.broadcastPresence(userID)
+}
+
+// scheduleOfflineLocked announces the user offline after OfflineGrace unless
+// they reconnect first. h.mu must be held.
+func (h *Hub) scheduleOfflineLocked(userID uuid.UUID) {
+	p := h.presence[userID]
+	if p == nil {
+		return
+	}
+	p.leaving++
+	leaving := p.leaving
+	p.offline = time.AfterFunc(h.OfflineGrace, func() { h.expireOffline(userID, p, leaving) })
+}
+
+// expireOffline ends the grace period of a user who didn't reconnect.
+func (h *Hub) expireOffline(userID uuid.UUID, p *userPresence, leaving uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.ctx.Err() != nil || h.presence[userID] != p || p.offline == nil || p.leaving != leaving {
+		return // Shut down, or reconnected in the meantime
+	}
+	p.offline = nil
+	h.refreshPresenceLocked(userID)
+	delete(h.presence, userID)
+}
+
+// queuePresence schedules telling contacts (all their devices) and watchers
+// (single connections) about the user's status. Within PresenceBatchWindow,
+// each is sent only the latest status of each user.
+func (h *Hub) queuePresence(userID uuid.UUID, contacts []uuid.UUID, watchers []*Client) {
+	h.pendingMu.Lock()
+	for _, contactID := range contacts {
+		changed := h.pendingPresence[contactID]
+		if changed == nil {
+			changed = make(map[uuid.UUID]struct{})
+			h.pendingPresence[contactID] = changed
+		}
+		changed[userID] = struct{}{}
+	}
+	for _, c := range watchers {
+		changed := h.pendingWatchers[c]
+		if changed == nil {
+			changed = make(map[uuid.UUID]struct{})
+			h.pendingWatchers[c] = changed
+		}
+		changed[userID] = struct{}{}
+	}
+	if h.PresenceBatchWindow <= 0 {
+		h.pendingMu.Unlock()
+		h.flushPresence()
+		return
+	}
+	if !h.flushScheduled {
+		h.flushScheduled = true
+		time.AfterFunc(h.PresenceBatchWindow, h.flushPresence)
+	}
+	h.pendingMu.Unlock()
+}
+
+// flushPresence sends the queued presence changes with each user's current status.
+func (h *Hub) flushPresence() {
+	h.pendingMu.Lock()
+	pending, watchers := h.pendingPresence, h.pendingWatchers
+	h.pendingPresence = make(map[uuid.UUID]map[uuid.UUID]struct{})
+	h.pendingWatchers = make(map[*Client]map[uuid.UUID]struct{})
+	h.flushScheduled = false
+	h.pendingMu.Unlock()
+
+	// 1. Look up the statuses, so out-of-order broadcasts can't send a stale one
+	status := make(map[uuid.UUID]string)
+	h.mu.RLock()
+	for _, changed := range pending {
+		h.lookupStatusLocked(changed, status)
+	}
+	for _, changed := range watchers {
+		h.lookupStatusLocked(changed, status)
+	}
+	h.mu.RUnlock()
+
+	// 2. Send them, encoding each user's event once for all who are told
+	recipients := make(map[uuid.UUID][]uuid.UUID)
+	for contactID, changed := range pending {
+		for userID := range changed {
+			recipients[userID] = append(recipients[userID], contactID)
+		}
+	}
+	for userID, contacts := range recipients {
+		h.SendToUsers(contacts, presenceEvent(userID, status[userID]))
+	}
+	if len(watchers) > 0 {
+		events := make(map[uuid.UUID][]byte)
+		encoded := make(map[uuid.UUID]map[protocol.Codec][]byte)
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		for c, changed := range watchers {
+			if !h.isRegisteredLocked(c) {
+				continue // Disconnected in the meantime
+			}
+			for userID := range changed {
+				if events[userID] == nil {
+					events[userID] = presenceEvent(userID, status[userID])
+					encoded[userID] = make(map[protocol.Codec][]byte, 1)
+				}
+				h.sendEncodedLocked(c, events[userID], encoded[userID])
+			}
+		}
+	}
+}
+
+// lookupStatusLocked adds the shown status of the users to status. h.mu must be held.
+func (h *Hub) lookupStatusLocked(users map[uuid.UUID]struct{}, status map[uuid.UUID]string) {
+	for userID := range users {
+		status[userID] = models.PresenceOffline
+		if p := h.presence[userID]; p != nil {
+			status[userID] = p.shown
+		}
+	}
+}
+
+// statusLocked returns the status others see: offline without connections or
+// when invisible, the chosen away or dnd, otherwise online unless every
+// connection is idle. h.mu must be held.
+func (h *Hub) statusLocked(userID uuid.UUID, p *userPresence) string {
+	clients := h.Clients[userID]
+	if len(clients) == 0 {
+		return models.PresenceOffline
+	}
+
+	switch p.chosen {
+	case "", models.PresenceInvisible:
+		return models.PresenceOffline
+	case models.PresenceAway, models.PresenceDND:
+		return p.chosen
+	}
+	for _, c := range clients {
+		if !c.idle.Load() {
+			return models.PresenceOnline
+		}
+	}
+	return models.PresenceAway
+}
+
+// checkIdle marks connections that sent nothing for IdleTimeout as idle.
+func (h *Hub) checkIdle(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for userID, clients := range h.Clients {
+		changed := false
+		for _, c := range clients {
+			last := c.lastActive.Load()
+			if last == 0 || now.Sub(time.Unix(0, last)) < h.IdleTimeout {
+				continue
+			}
+			if c.idle.CompareAndSwap(false, true) {
+				changed = true
+			}
+		}
+		if changed {
+			h.refreshPresenceLocked(userID)
+		}
+	}
+}
+
+// presenceEvent encodes a user_online event, or user_offline for the offline status.
+func presenceEvent(userID uuid.UUID, status string) []byte {
+	if status == models.PresenceOffline {
+		payload, _ := protocol.Encode(protocol.EventUserOffline, protocol.PresencePayload{UserID: userID})
+		return payload
+	}
+	payload, _ := protocol.Encode(protocol.EventUserOnline, protocol.PresencePayload{UserID: userID, Status: status})
+	return payload
+}
@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"chat-app/internal/models"
+	"chat-app/internal/protocol"
+
+	"github.com/google/uuid"
+)
+
+// SubscribePresence lets the client watch the presence of users who aren't
+// necessarily its contacts, e.g. the members of a group. The users currently
+// online are sent right away; later changes follow as user_online and
+// user_offline events. Users who hide their status from the subscriber are
+// never reported.
+func (h *Hub) SubscribePresence(ctx context.Context, client *Client, userIDs []uuid.UUID) error {
+	h.mu.Lock()
+	// 1. Add the new subscriptions, within the limit
+	var added []uuid.UUID
+	for _, userID := range userIDs {
+		if _, ok := client.presenceSubs[userID]; !ok && !containsID(added, userID) {
+			added = append(added, userID)
+		}
+	}
+	if len(client.presenceSubs)+len(added) > protocol.MaxPresenceSubscriptions {
+		h.mu.Unlock()
+		return &protocol.Error{
+			Code:    protocol.ErrCodeValidation,
+			Message: fmt.Sprintf("at most %d presence subscriptions are allowed per connection", protocol.MaxPresenceSubscriptions),
+		}
+	}
+	if client.presenceSubs == nil {
+		client.presenceSubs = make(map[uuid.UUID]struct{})
+	}
+	online := make(map[uuid.UUID]string)
+	for _, userID := range added {
+		client.presenceSubs[userID] = struct{}{}
+		if h.subscribers[userID] == nil {
+			h.subscribers[userID] = make(map[*Client]struct{})
+		}
+		h.subscribers[userID][client] = struct{}{}
+
+		if p := h.presence[userID]; p != nil && p.shown != models.PresenceOffline {
+			online[userID] = p.shown
+		}
+	}
+	h.mu.Unlock()
+
+	if len(online) == 0 {
+		return nil
+	}
+
+	// 2. Send the current status of those online the subscriber may see
+	ids := make([]uuid.UUID, 0, len(online))
+	for userID := range online {
+		ids = append(ids, userID)
+	}
+	users, err := h.userRepo.FindByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	contacts, err := h.contactRepo.FindContactIDs(ctx, client.UserID)
+	if err != nil {
+		return err
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if !h.isRegisteredLocked(client) {
+		return nil
+	}
+	for i := range users {
+		if users[i].ShowsOnlineTo(containsID(contacts, users[i].ID)) {
+			h.sendToClientLocked(client, presenceEvent(users[i].ID, online[users[i].ID]))
+		}
+	}
+	return nil
+}
+
+// UnsubscribePresence stops watching the users. Unknown users are ignored.
+func (h *Hub) UnsubscribePresence(client *Client, userIDs []uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, userID := range userIDs {
+		h.unsubscribeLocked(client, userID)
+	}
+}
+
+// unsubscribeAllLocked drops the subscriptions of a disconnecting client. h.mu must be held.
+func (h *Hub) unsubscribeAllLocked(client *Client) {
+	for userID := range client.presenceSubs {
+		h.unsubscribeLocked(client, userID)
+	}
+}
+
+func (h *Hub) unsubscribeLocked(client *Client, userID uuid.UUID) {
+	delete(client.presenceSubs, userID)
+	if watchers := h.subscribers[userID]; watchers != nil {
+		delete(watchers, client)
+		if len(watchers) == 0 {
+			delete(h.subscribers, userID)
+		}
+	}
+}
+
+// isRegisteredLocked reports whether the client is still connected. h.mu must be held.
+func (h *Hub) isRegisteredLocked(client *Client) bool {
+	for _, c := range h.Clients[client.UserID] {
+		if c == client {
+			return true
+		}
+	}
+	return false
+}
+
+// sendToClientLocked queues a canonical JSON frame for one client, dropping it
+// if the client is too slow to keep up. h.mu must be held.
+func (h *Hub) sendToClientLocked(client *Client, message []byte) {
+	h.sendEncodedLocked(client, message, make(map[protocol.Codec][]byte, 1))
+}
+
+// sendEncodedLocked is sendToClientLocked for a message sent to several
+// clients, sharing their encoded frames. h.mu must be held.
+func (h *Hub) sendEncodedLocked(client *Client, message []byte, encoded map[protocol.Codec][]byte) {
+	frame, ok := encodeOnce(client, message, encoded)
+	if !ok {
+		return
+	}
+	select {
+	case client.Send <- frame:
+	default:
+		log.Printf("Dropped a frame for a slow client of %s", client.UserID)
+	}
+}
+
+func containsID(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// Denylist records revoked token and session IDs until the tokens carrying
+// them would have expired anyway.
+type Denylist interface {
+	Add(id string, until time.Time)
+	Contains(id string) bool
+}
+
+// memoryDenylist is a process-local Denylist. Entries are dropped once they expire.
+type memoryDenylist struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func NewMemoryDenylist() Denylist {
+	return &memoryDenylist{entries: make(map[string]time.Time)}
+}
+
+func (d *memoryDenylist) Add(id string, until time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for k, exp := range d.entries {
+		if now.After(exp) {
+			delete(d.entries, k)
+		}
+	}
+	if exp, ok := d.entries[id]; !ok || until.After(exp) {
+		d.entries[id] = until
+	}
+}
+
+func (d *memoryDenylist) Contains(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	exp, ok := d.entries[id]
+	return ok && time.Now().Before(exp)
+}
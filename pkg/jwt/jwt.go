@@ -2,63 +2,220 @@ package jwt
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+// Defaults for the iss and aud claims
+const (
+	DefaultIssuer   = "chat-app"
+	DefaultAudience = "chat-app"
+)
+
+// Token types (typ claim). ParseToken only accepts access tokens.
+const (
+	TokenTypeAccess    = "access"
+	TokenTypeChallenge = "2fa_challenge" // Proves the password step of a two-factor login
+)
+
+// ErrTokenRevoked is returned for tokens whose ID or session has been denylisted.
+var ErrTokenRevoked = errors.New("token has been revoked")
+
 type Config struct {
 	Secret     string
 	Expiration time.Duration
+	Keys       *KeySet  // Signing and verification keys; HS256 with Secret when nil
+	Issuer     string   // iss claim; DefaultIssuer when empty
+	Audience   string   // aud claim; DefaultAudience when empty
+	Denylist   Denylist // Revoked token/session IDs; in-memory when nil
+}
+
+// Claims are the validated contents of an access token.
+type Claims struct {
+	UserID    uuid.UUID
+	SessionID uuid.UUID // The refresh token family the token was issued for
+	TokenID   string    // jti
+	Type      string
+	ExpiresAt time.Time
 }
 
 type Service interface {
-	GenerateToken(userID uuid.UUID) (string, error)
+	GenerateToken(userID, sessionID uuid.UUID) (string, error)
 	ValidateToken(tokenString string) (uuid.UUID, error)
+	ParseToken(tokenString string) (*Claims, error)
+	// Revoke denylists a single access token until it expires.
+	Revoke(claims *Claims)
+	// RevokeSession denylists every access token issued for the session so far.
+	RevokeSession(sessionID uuid.UUID)
+	// GenerateChallengeToken issues a short-lived token completing a two-factor login.
+	GenerateChallengeToken(userID uuid.UUID, ttl time.Duration) (string, time.Time, error)
+	// ParseChallengeToken validates a challenge token. Revoke makes it single-use.
+	ParseChallengeToken(tokenString string) (*Claims, error)
+	JWKS() JWKS
 }
 
 type service struct {
 	config Config
+	keys   *KeySet
 }
 
 func NewService(config Config) Service {
-	return &service{config: config}
+	keys := config.Keys
+	if keys == nil {
+		keys, _ = NewKeySet(NewHMACKey([]byte(config.Secret)))
+	}
+	if config.Issuer == "" {
+		config.Issuer = DefaultIssuer
+	}
+	if config.Audience == "" {
+		config.Audience = DefaultAudience
+	}
+	if config.Denylist == nil {
+		config.Denylist = NewMemoryDenylist()
+	}
+	return &service{config: config, keys: keys}
 }
 
-func (s *service) GenerateToken(userID uuid.UUID) (string, error) {
-	claims := jwt.MapClaims{
+func (s *service) GenerateToken(userID, sessionID uuid.UUID) (string, error) {
+	now := time.Now()
+	return s.sign(jwt.MapClaims{
+		"iss": s.config.Issuer,
+		"aud": s.config.Audience,
 		"sub": userID.String(),
-		"exp": time.Now().Add(s.config.Expiration).Unix(),
-		"iat": time.Now().Unix(),
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.config.Secret))
+		"sid": sessionID.String(),
+		"jti": uuid.NewString(),
+		"typ": TokenTypeAccess,
+		"exp": now.Add(s.config.Expiration).Unix(),
+		"iat": now.Unix(),
+	})
 }
 
-func (s *service) ValidateToken(tokenString string) (uuid.UUID, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(s.config.Secret), nil
+func (s *service) GenerateChallengeToken(userID uuid.UUID, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	token, err := s.sign(jwt.MapClaims{
+		"iss": s.config.Issuer,
+		"aud": s.config.Audience,
+		"sub": userID.String(),
+		"jti": uuid.NewString(),
+		"typ": TokenTypeChallenge,
+		"exp": expiresAt.Unix(),
+		"iat": now.Unix(),
 	})
+	return token, time.Unix(expiresAt.Unix(), 0), err
+}
 
+// sign signs claims with the current key.
+func (s *service) sign(claims jwt.MapClaims) (string, error) {
+	key := s.keys.current
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+func (s *service) ValidateToken(tokenString string) (uuid.UUID, error) {
+	claims, err := s.ParseToken(tokenString)
 	if err != nil {
 		return uuid.Nil, err
 	}
+	return claims.UserID, nil
+}
+
+// ParseToken validates the token (signature, expiry, issuer, audience and type)
+// and checks it against the denylist.
+func (s *service) ParseToken(tokenString string) (*Claims, error) {
+	claims, mapClaims, err := s.parse(tokenString, TokenTypeAccess)
+	if err != nil {
+		return nil, err
+	}
+	sessionID, err := uuidClaim(mapClaims, "sid")
+	if err != nil {
+		return nil, err
+	}
+	if s.config.Denylist.Contains(sessionKey(sessionID)) {
+		return nil, ErrTokenRevoked
+	}
+	claims.SessionID = sessionID
+	return claims, nil
+}
+
+func (s *service) ParseChallengeToken(tokenString string) (*Claims, error) {
+	claims, _, err := s.parse(tokenString, TokenTypeChallenge)
+	return claims, err
+}
+
+// parse validates a token of the given type and the claims all types share.
+func (s *service) parse(tokenString, typ string) (*Claims, jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, s.keys.lookup,
+		jwt.WithValidMethods(s.keys.methods()),
+		jwt.WithIssuer(s.config.Issuer),
+		jwt.WithAudience(s.config.Audience),
+		jwt.WithExpirationRequired(),
+	)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, nil, errors.New("invalid token")
+	}
+
+	if t, _ := claims["typ"].(string); t != typ {
+		return nil, nil, errors.New("invalid token type")
+	}
+	userID, err := uuidClaim(claims, "sub")
+	if err != nil {
+		return nil, nil, err
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, nil, errors.New("missing token ID")
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return nil, nil, errors.New("invalid expiration claim")
+	}
+
+	if s.config.Denylist.Contains(jti) {
+		return nil, nil, ErrTokenRevoked
+	}
+
+	return &Claims{UserID: userID, TokenID: jti, Type: typ, ExpiresAt: exp.Time}, claims, nil
+}
+
+func (s *service) Revoke(claims *Claims) {
+	s.config.Denylist.Add(claims.TokenID, claims.ExpiresAt)
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if sub, ok := claims["sub"].(string); ok {
-			id, err := uuid.Parse(sub)
-			if err != nil {
-				return uuid.Nil, errors.New("invalid subject UUID")
-			}
-			return id, nil
-		}
-		return uuid.Nil, errors.New("invalid subject claim")
+func (s *service) RevokeSession(sessionID uuid.UUID) {
+	// Tokens of the session issued from now on would belong to a revoked
+	// refresh token family, so covering one token lifetime is enough.
+	s.config.Denylist.Add(sessionKey(sessionID), time.Now().Add(s.config.Expiration))
+}
+
+// sessionKey namespaces session IDs in the denylist, apart from token IDs.
+func sessionKey(sessionID uuid.UUID) string {
+	return "sid:" + sessionID.String()
+}
+
+func uuidClaim(claims jwt.MapClaims, name string) (uuid.UUID, error) {
+	s, ok := claims[name].(string)
+	if !ok {
+		return uuid.Nil, fmt.Errorf("invalid %s claim", name)
 	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid %s UUID", name)
+	}
+	return id, nil
+}
 
-	return uuid.Nil, errors.New("invalid token")
+// JWKS returns the public verification keys, for /.well-known/jwks.json.
+func (s *service) JWKS() JWKS {
+	return s.keys.JWKS()
 }
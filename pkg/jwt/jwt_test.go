@@ -0,0 +1,254 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEd25519Key(t *testing.T, kid string) *Key {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := NewKey(kid, priv)
+	require.NoError(t, err)
+	return key
+}
+
+func newService(t *testing.T, current *Key, previous ...*Key) Service {
+	t.Helper()
+	keys, err := NewKeySet(current, previous...)
+	require.NoError(t, err)
+	return NewService(Config{Expiration: time.Minute, Keys: keys})
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func TestService_HS256RoundTrip(t *testing.T) {
+	svc := NewService(Config{Secret: "test-secret", Expiration: time.Minute})
+	userID := uuid.New()
+
+	sessionID := uuid.New()
+
+	token, err := svc.GenerateToken(userID, sessionID)
+	require.NoError(t, err)
+
+	claims, err := svc.ParseToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, userID, claims.UserID)
+	assert.Equal(t, sessionID, claims.SessionID)
+	assert.Equal(t, TokenTypeAccess, claims.Type)
+	assert.NotEmpty(t, claims.TokenID)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), claims.ExpiresAt, 2*time.Second)
+
+	// HMAC secrets are never published
+	assert.Empty(t, svc.JWKS().Keys)
+}
+
+func TestService_AsymmetricRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rs256, err := NewKey("", rsaKey)
+	require.NoError(t, err)
+
+	for _, key := range []*Key{rs256, newEd25519Key(t, "ed-1")} {
+		t.Run(key.Method.Alg(), func(t *testing.T) {
+			svc := newService(t, key)
+			userID := uuid.New()
+
+			token, err := svc.GenerateToken(userID, uuid.New())
+			require.NoError(t, err)
+
+			id, err := svc.ValidateToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, userID, id)
+
+			jwks := svc.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, key.ID, jwks.Keys[0].Kid)
+			assert.Equal(t, key.Method.Alg(), jwks.Keys[0].Alg)
+		})
+	}
+	assert.NotEmpty(t, rs256.ID, "kid defaults to the thumbprint")
+}
+
+func TestService_RotationAcceptsPreviousKey(t *testing.T) {
+	oldKey := newEd25519Key(t, "old")
+	newKey := newEd25519Key(t, "new")
+	userID := uuid.New()
+
+	oldToken, err := newService(t, oldKey).GenerateToken(userID, uuid.New())
+	require.NoError(t, err)
+
+	// After rotation the old key only verifies
+	oldPublic, err := NewKey("old", oldKey.verifyKey)
+	require.NoError(t, err)
+	rotated := newService(t, newKey, oldPublic)
+
+	id, err := rotated.ValidateToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, userID, id)
+
+	jwks := rotated.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "new", jwks.Keys[0].Kid)
+
+	// Once the old key is dropped its tokens are rejected
+	_, err = newService(t, newKey).ValidateToken(oldToken)
+	assert.Error(t, err)
+}
+
+func TestService_RejectsUnknownKeyAndAlgorithm(t *testing.T) {
+	svc := newService(t, newEd25519Key(t, "ed-1"))
+
+	foreign, err := newService(t, newEd25519Key(t, "ed-2")).GenerateToken(uuid.New(), uuid.New())
+	require.NoError(t, err)
+	_, err = svc.ValidateToken(foreign)
+	assert.Error(t, err)
+
+	// An HS256 token must not be accepted by an EdDSA keyset
+	hmac, err := NewService(Config{Secret: "s", Expiration: time.Minute}).GenerateToken(uuid.New(), uuid.New())
+	require.NoError(t, err)
+	_, err = svc.ValidateToken(hmac)
+	assert.Error(t, err)
+}
+
+func TestService_ValidatesIssuerAndAudience(t *testing.T) {
+	svc := NewService(Config{Secret: "s", Expiration: time.Minute})
+
+	for name, cfg := range map[string]Config{
+		"issuer":   {Secret: "s", Expiration: time.Minute, Issuer: "someone-else"},
+		"audience": {Secret: "s", Expiration: time.Minute, Audience: "another-api"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			token, err := NewService(cfg).GenerateToken(uuid.New(), uuid.New())
+			require.NoError(t, err)
+			_, err = svc.ParseToken(token)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestService_Revoke(t *testing.T) {
+	svc := NewService(Config{Secret: "s", Expiration: time.Minute})
+	sessionID := uuid.New()
+
+	first, err := svc.GenerateToken(uuid.New(), sessionID)
+	require.NoError(t, err)
+	second, err := svc.GenerateToken(uuid.New(), sessionID)
+	require.NoError(t, err)
+
+	// Revoking one token leaves the others of the session alone
+	claims, err := svc.ParseToken(first)
+	require.NoError(t, err)
+	svc.Revoke(claims)
+	_, err = svc.ParseToken(first)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+	_, err = svc.ParseToken(second)
+	assert.NoError(t, err)
+
+	// Revoking the session rejects all of its tokens
+	svc.RevokeSession(sessionID)
+	_, err = svc.ParseToken(second)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
+
+func TestNewKeySet_RequiresSigningKey(t *testing.T) {
+	key := newEd25519Key(t, "k")
+	public, err := NewKey("k", key.verifyKey)
+	require.NoError(t, err)
+
+	_, err = NewKeySet(public)
+	assert.Error(t, err)
+
+	_, err = NewKeySet(key, public)
+	assert.Error(t, err, "duplicate kid")
+}
+
+func TestLoadKeySet(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	privPath := writePEM(t, "PRIVATE KEY", der)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	pubPath := writePEM(t, "PUBLIC KEY", pubDER)
+
+	t.Run("EdDSA with previous key", func(t *testing.T) {
+		ks, err := LoadKeySet(KeySetConfig{
+			Algorithm:        AlgEdDSA,
+			PrivateKeyFile:   privPath,
+			KeyID:            "current",
+			PreviousKeyFiles: []string{"legacy=" + pubPath},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "current", ks.current.ID)
+		assert.ElementsMatch(t, []string{AlgEdDSA, AlgRS256}, ks.methods())
+
+		jwks := ks.JWKS()
+		require.Len(t, jwks.Keys, 2)
+		assert.Equal(t, "OKP", jwks.Keys[0].Kty)
+		assert.Equal(t, "legacy", jwks.Keys[1].Kid)
+	})
+
+	t.Run("algorithm mismatch", func(t *testing.T) {
+		_, err := LoadKeySet(KeySetConfig{Algorithm: AlgRS256, PrivateKeyFile: privPath})
+		assert.Error(t, err)
+	})
+
+	t.Run("public key cannot sign", func(t *testing.T) {
+		_, err := LoadKeySet(KeySetConfig{Algorithm: AlgRS256, PrivateKeyFile: pubPath})
+		assert.Error(t, err)
+	})
+
+	t.Run("HS256 requires a secret", func(t *testing.T) {
+		_, err := LoadKeySet(KeySetConfig{Algorithm: AlgHS256})
+		assert.Error(t, err)
+	})
+}
+
+func TestService_ChallengeToken(t *testing.T) {
+	svc := NewService(Config{Secret: "s", Expiration: time.Minute})
+	userID := uuid.New()
+
+	challenge, expiresAt, err := svc.GenerateChallengeToken(userID, 5*time.Minute)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), expiresAt, 2*time.Second)
+
+	claims, err := svc.ParseChallengeToken(challenge)
+	require.NoError(t, err)
+	assert.Equal(t, userID, claims.UserID)
+	assert.Equal(t, TokenTypeChallenge, claims.Type)
+
+	// Challenge and access tokens are not interchangeable
+	_, err = svc.ParseToken(challenge)
+	assert.Error(t, err)
+	access, err := svc.GenerateToken(userID, uuid.New())
+	require.NoError(t, err)
+	_, err = svc.ParseChallengeToken(access)
+	assert.Error(t, err)
+
+	// Revoking makes it single-use
+	svc.Revoke(claims)
+	_, err = svc.ParseChallengeToken(challenge)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
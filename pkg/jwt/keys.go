@@ -0,0 +1,289 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is a signing or verification key identified by its kid.
+// Keys loaded from a public key can only verify.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey wraps a shared secret for HS256. HMAC keys are never published in the JWKS.
+func NewHMACKey(secret []byte) *Key {
+	sum := sha256.Sum256(secret)
+	return &Key{
+		ID:        "hs256-" + base64.RawURLEncoding.EncodeToString(sum[:6]),
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// NewKey wraps an RSA or Ed25519 key. A private key can sign and verify, a public
+// key can only verify. An empty kid defaults to the RFC 7638 thumbprint.
+func NewKey(kid string, key interface{}) (*Key, error) {
+	k := &Key{}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.Method, k.signKey, k.verifyKey = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.Method, k.verifyKey = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.Method, k.signKey, k.verifyKey = jwt.SigningMethodEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.Method, k.verifyKey = jwt.SigningMethodEdDSA, key
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	k.ID = kid
+	if k.ID == "" {
+		k.ID = k.thumbprint()
+	}
+	return k, nil
+}
+
+// ParsePEMKey parses a PKCS#8 or PKCS#1 private key, or a PKIX public key.
+func ParsePEMKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(kid, key)
+}
+
+// LoadKeyFile reads a PEM key from disk. The spec is either "path" or "kid=path".
+func LoadKeyFile(spec string) (*Key, error) {
+	kid, path, ok := strings.Cut(spec, "=")
+	if !ok {
+		kid, path = "", spec
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePEMKey(kid, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// CanSign reports whether the key holds private material.
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of an asymmetric key.
+func (k *Key) thumbprint() string {
+	jwk := k.publicJWK()
+	// Required members only, in lexicographic order
+	var members string
+	if jwk.Kty == "RSA" {
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	} else {
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWK is the public part of a key as published in the JWKS (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *Key) publicJWK() JWK {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch pub := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// KeySet holds the key new tokens are signed with plus older keys that are
+// still accepted, so keys can be rotated without invalidating live tokens.
+type KeySet struct {
+	current *Key
+	byID    map[string]*Key
+}
+
+// NewKeySet builds a keyset. The current key must be able to sign.
+func NewKeySet(current *Key, previous ...*Key) (*KeySet, error) {
+	if current == nil || !current.CanSign() {
+		return nil, errors.New("current key must be a private key or secret")
+	}
+	ks := &KeySet{current: current, byID: map[string]*Key{current.ID: current}}
+	for _, k := range previous {
+		if _, dup := ks.byID[k.ID]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		ks.byID[k.ID] = k
+	}
+	return ks, nil
+}
+
+// KeySetConfig describes where to load the keyset from.
+type KeySetConfig struct {
+	Algorithm        string   // HS256 (default), RS256 or EdDSA
+	Secret           string   // HS256 only
+	PrivateKeyFile   string   // RS256/EdDSA: PEM private key used for signing
+	KeyID            string   // Optional kid of the signing key (defaults to its thumbprint)
+	PreviousKeyFiles []string // PEM keys still accepted for verification, as "path" or "kid=path"
+}
+
+// LoadKeySet builds a keyset from configuration.
+func LoadKeySet(cfg KeySetConfig) (*KeySet, error) {
+	var current *Key
+	switch cfg.Algorithm {
+	case "", AlgHS256:
+		if cfg.Secret == "" {
+			return nil, errors.New("HS256 requires a secret")
+		}
+		current = NewHMACKey([]byte(cfg.Secret))
+	case AlgRS256, AlgEdDSA:
+		if cfg.PrivateKeyFile == "" {
+			return nil, fmt.Errorf("%s requires a private key file", cfg.Algorithm)
+		}
+		spec := cfg.PrivateKeyFile
+		if cfg.KeyID != "" {
+			spec = cfg.KeyID + "=" + spec
+		}
+		var err error
+		if current, err = LoadKeyFile(spec); err != nil {
+			return nil, err
+		}
+		if current.Method.Alg() != cfg.Algorithm {
+			return nil, fmt.Errorf("private key is for %s, not %s", current.Method.Alg(), cfg.Algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", cfg.Algorithm)
+	}
+
+	previous := make([]*Key, 0, len(cfg.PreviousKeyFiles))
+	for _, spec := range cfg.PreviousKeyFiles {
+		k, err := LoadKeyFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, k)
+	}
+	return NewKeySet(current, previous...)
+}
+
+// lookup finds the verification key for a token. Tokens without a kid (issued
+// before keysets existed) are checked against the current key.
+func (ks *KeySet) lookup(token *jwt.Token) (interface{}, error) {
+	key := ks.current
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok = ks.byID[kid]; !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.verifyKey, nil
+}
+
+// methods lists the algorithms of every key in the set.
+func (ks *KeySet) methods() []string {
+	seen := map[string]bool{}
+	var algs []string
+	for _, k := range ks.byID {
+		if alg := k.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// JWKS returns the public keys of the set. HMAC secrets are never published.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, k := range ks.ordered() {
+		if k.Method == jwt.SigningMethodHS256 {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, k.publicJWK())
+	}
+	return jwks
+}
+
+// ordered returns the current key first, then the rest sorted by kid.
+func (ks *KeySet) ordered() []*Key {
+	keys := []*Key{ks.current}
+	var rest []string
+	for id := range ks.byID {
+		if id != ks.current.ID {
+			rest = append(rest, id)
+		}
+	}
+	sort.Strings(rest)
+	for _, id := range rest {
+		keys = append(keys, ks.byID[id])
+	}
+	return keys
+}
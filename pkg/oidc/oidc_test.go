@@ -0,0 +1,82 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"chat-app/pkg/oidc"
+	"chat-app/pkg/oidc/oidctest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// authorize follows the provider's redirect and returns the callback query.
+func authorize(t *testing.T, authURL string) url.Values {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return loc.Query()
+}
+
+func TestProvider_CodeFlowWithPKCE(t *testing.T) {
+	idp := oidctest.NewProvider("chat-app", "s3cret")
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "42", Email: "alice@example.com", EmailVerified: true, Name: "Alice"})
+
+	ctx := context.Background()
+	p, err := oidc.Discover(ctx, oidc.Config{
+		IssuerURL:    idp.Issuer(),
+		ClientID:     "chat-app",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost:8080/auth/oidc/callback",
+		Scopes:       []string{"email", "profile"},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, idp.Issuer(), p.Issuer())
+
+	verifier, _ := oidc.RandomString()
+	callback := authorize(t, p.AuthCodeURL("state-1", "nonce-1", verifier))
+	assert.Equal(t, "state-1", callback.Get("state"))
+
+	// A wrong verifier is refused, and burns the code
+	_, err = p.Exchange(ctx, callback.Get("code"), "wrong-verifier", "nonce-1")
+	assert.Error(t, err)
+
+	callback = authorize(t, p.AuthCodeURL("state-2", "nonce-2", verifier))
+	claims, err := p.Exchange(ctx, callback.Get("code"), verifier, "nonce-2")
+	require.NoError(t, err)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, "alice@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+	assert.Equal(t, idp.Issuer(), claims.Issuer)
+}
+
+func TestProvider_RejectsWrongNonce(t *testing.T) {
+	idp := oidctest.NewProvider("chat-app", "")
+	defer idp.Close()
+
+	ctx := context.Background()
+	p, err := oidc.Discover(ctx, oidc.Config{IssuerURL: idp.Issuer(), ClientID: "chat-app", RedirectURL: "http://localhost/cb"}, nil)
+	require.NoError(t, err)
+
+	verifier, _ := oidc.RandomString()
+	callback := authorize(t, p.AuthCodeURL("state", "nonce-1", verifier))
+	_, err = p.Exchange(ctx, callback.Get("code"), verifier, "another-nonce")
+	assert.ErrorContains(t, err, "nonce")
+}
+
+func TestDiscover_IssuerMismatch(t *testing.T) {
+	idp := oidctest.NewProvider("chat-app", "")
+	defer idp.Close()
+
+	_, err := oidc.Discover(context.Background(), oidc.Config{IssuerURL: idp.Issuer() + "/tenant"}, nil)
+	assert.Error(t, err)
+}
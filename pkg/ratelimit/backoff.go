@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Backoff locks a key out for exponentially growing periods after repeated
+// failures, e.g. failed logins for an account or client IP.
+type Backoff struct {
+	store  Store
+	prefix string
+
+	Free   int           // Failures allowed before the first lockout
+	Base   time.Duration // First lockout; each further failure doubles it
+	Max    time.Duration // Longest lockout
+	Window time.Duration // Failures are forgotten this long after the last one
+}
+
+// NewBackoff creates a backoff with the given policy. The prefix namespaces its keys in the store.
+func NewBackoff(store Store, prefix string, free int, base, max, window time.Duration) *Backoff {
+	return &Backoff{store: store, prefix: prefix, Free: free, Base: base, Max: max, Window: window}
+}
+
+// Check returns how long key is still locked out, or 0.
+func (b *Backoff) Check(ctx context.Context, key string) (time.Duration, error) {
+	f, err := b.store.Failures(ctx, b.prefix+":"+key, b.Window)
+	if err != nil {
+		return 0, err
+	}
+	if wait := time.Until(f.Last.Add(b.lockout(f.Count))); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// Fail records a failure for key and returns the resulting lockout, or 0.
+func (b *Backoff) Fail(ctx context.Context, key string) (time.Duration, error) {
+	f, err := b.store.RecordFailure(ctx, b.prefix+":"+key, b.Window)
+	if err != nil {
+		return 0, err
+	}
+	return b.lockout(f.Count), nil
+}
+
+// Reset clears the failures of key, e.g. after a successful login.
+func (b *Backoff) Reset(ctx context.Context, key string) error {
+	return b.store.Reset(ctx, b.prefix+":"+key)
+}
+
+// lockout is the lockout after count failures: none for the first Free,
+// then Base, 2*Base, 4*Base... up to Max.
+func (b *Backoff) lockout(count int) time.Duration {
+	over := count - b.Free
+	if over <= 0 {
+		return 0
+	}
+	d := b.Base
+	for i := 1; i < over && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	return d
+}
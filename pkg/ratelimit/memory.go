@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	tokens    float64
+	failures  Failures
+	updatedAt time.Time
+	expiresAt time.Time // When the entry carries no information anymore
+}
+
+// memoryStore keeps state in process memory. Limits are per instance.
+type memoryStore struct {
+	mu       sync.Mutex
+	entries  map[string]*memoryEntry
+	lastScan time.Time
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (s *memoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.purge(now)
+
+	e, ok := s.entries[key]
+	if !ok {
+		e = &memoryEntry{tokens: float64(limit.Burst), updatedAt: now}
+		s.entries[key] = e
+	}
+
+	var res Result
+	e.tokens, res = Refill(e.tokens, now.Sub(e.updatedAt), limit)
+	e.updatedAt = now
+	// A full bucket is the same as no entry
+	if limit.Rate > 0 {
+		e.expiresAt = now.Add(time.Duration((float64(limit.Burst) - e.tokens) / limit.Rate * float64(time.Second)))
+	} else {
+		e.expiresAt = time.Time{}
+	}
+	return res, nil
+}
+
+func (s *memoryStore) RecordFailure(ctx context.Context, key string, window time.Duration) (Failures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.purge(now)
+
+	e, ok := s.entries[key]
+	if !ok || now.Sub(e.failures.Last) > window {
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+	e.failures.Count++
+	e.failures.Last = now
+	e.expiresAt = now.Add(window)
+	return e.failures, nil
+}
+
+func (s *memoryStore) Failures(ctx context.Context, key string, window time.Duration) (Failures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || time.Since(e.failures.Last) > window {
+		return Failures{}, nil
+	}
+	return e.failures, nil
+}
+
+func (s *memoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// purge drops expired entries, at most once a minute.
+func (s *memoryStore) purge(now time.Time) {
+	if now.Sub(s.lastScan) < time.Minute {
+		return
+	}
+	s.lastScan = now
+	for key, e := range s.entries {
+		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
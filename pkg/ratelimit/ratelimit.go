@@ -0,0 +1,93 @@
+// Package ratelimit provides token bucket rate limiting and exponential backoff
+// on top of a pluggable Store, so limits can be kept in memory or shared
+// between server instances.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is a token bucket: Burst tokens, refilled at Rate tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute allows n requests per minute with bursts of up to n.
+func PerMinute(n int) Limit {
+	return PerMinuteBurst(n, n)
+}
+
+// PerMinuteBurst allows n requests per minute with bursts of up to burst.
+// A burst below 1 means n.
+func PerMinuteBurst(n, burst int) Limit {
+	if burst < 1 {
+		burst = n
+	}
+	return Limit{Rate: float64(n) / 60, Burst: burst}
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // When the next token is available, if not Allowed
+}
+
+// Failures is the failure history of a key.
+type Failures struct {
+	Count int
+	Last  time.Time
+}
+
+// Store keeps rate limit state. Implementations must be safe for concurrent use
+// and make each operation atomic per key.
+type Store interface {
+	// Take removes a token from the bucket at key.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// RecordFailure counts a failure at key. Failures older than window are forgotten.
+	RecordFailure(ctx context.Context, key string, window time.Duration) (Failures, error)
+	// Failures returns the failures at key within window.
+	Failures(ctx context.Context, key string, window time.Duration) (Failures, error)
+	// Reset forgets the state at key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Limiter applies one Limit to many keys, e.g. one bucket per client IP.
+type Limiter struct {
+	store  Store
+	prefix string
+	limit  Limit
+}
+
+// NewLimiter creates a limiter. The prefix namespaces its keys in the store.
+func NewLimiter(store Store, prefix string, limit Limit) *Limiter {
+	return &Limiter{store: store, prefix: prefix, limit: limit}
+}
+
+// Allow takes a token for key.
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.store.Take(ctx, l.prefix+":"+key, l.limit)
+}
+
+// Limit returns the limit applied to each key.
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Refill computes the tokens in a bucket after elapsed time and takes one if
+// possible. It implements the bucket arithmetic for Store implementations.
+func Refill(tokens float64, elapsed time.Duration, limit Limit) (float64, Result) {
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+	if tokens >= 1 {
+		tokens--
+		return tokens, Result{Allowed: true, Remaining: int(tokens)}
+	}
+	var retryAfter time.Duration
+	if limit.Rate > 0 {
+		retryAfter = time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	}
+	return tokens, Result{Allowed: false, RetryAfter: retryAfter}
+}
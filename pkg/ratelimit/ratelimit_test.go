@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefill(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 2}
+
+	tokens, res := Refill(2, 0, limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+
+	tokens, res = Refill(tokens, 0, limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	tokens, res = Refill(tokens, 250*time.Millisecond, limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 750*time.Millisecond, res.RetryAfter)
+
+	// Refills never exceed the burst
+	_, res = Refill(tokens, time.Hour, limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+}
+
+func TestLimiter_KeysHaveSeparateBuckets(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), "test", PerMinute(2))
+
+	for i := 0; i < 2; i++ {
+		res, err := limiter.Allow(ctx, "a")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+	res, err := limiter.Allow(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.InDelta(t, 30*time.Second, res.RetryAfter, float64(time.Second))
+
+	res, err = limiter.Allow(ctx, "b")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+func TestBackoff(t *testing.T) {
+	ctx := context.Background()
+	b := NewBackoff(NewMemoryStore(), "test", 2, time.Second, 3*time.Second, time.Minute)
+
+	for i, want := range []time.Duration{0, 0, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		got, err := b.Fail(ctx, "k")
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "failure %d", i+1)
+	}
+
+	wait, err := b.Check(ctx, "k")
+	require.NoError(t, err)
+	assert.InDelta(t, 3*time.Second, wait, float64(100*time.Millisecond))
+
+	require.NoError(t, b.Reset(ctx, "k"))
+	wait, err = b.Check(ctx, "k")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestMemoryStore_FailuresExpireAfterWindow(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	_, err := store.RecordFailure(ctx, "k", time.Minute)
+	require.NoError(t, err)
+	f, err := store.RecordFailure(ctx, "k", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 2, f.Count)
+
+	// A zero window forgets them immediately
+	f, err = store.Failures(ctx, "k", 0)
+	require.NoError(t, err)
+	assert.Zero(t, f.Count)
+	f, err = store.RecordFailure(ctx, "k", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, f.Count)
+}